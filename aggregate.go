@@ -0,0 +1,215 @@
+package spf
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAggregateWindow is the window of an Aggregator whose Window is zero.
+const DefaultAggregateWindow = time.Hour
+
+// AggregateRow counts the results of one sender domain and client address
+// within a window.
+type AggregateRow struct {
+	Domain  string         `json:"domain"`
+	IP      string         `json:"ip"`
+	Total   int            `json:"total"`
+	Results map[Result]int `json:"results"`
+}
+
+// Failures returns the number of Fail and SoftFail results of r.
+func (r AggregateRow) Failures() int {
+	return r.Results[Fail] + r.Results[SoftFail]
+}
+
+// AggregateSummary is the report of one window of an Aggregator.
+type AggregateSummary struct {
+	Start   time.Time      `json:"start"`
+	End     time.Time      `json:"end"`
+	Total   int            `json:"total"`
+	Results map[Result]int `json:"results"`
+	// Rows holds the counts per sender domain and client address, the most
+	// failing first, then by total and in lexical order.
+	Rows []AggregateRow `json:"rows"`
+}
+
+// WriteJSON writes s to w as one line of JSON.
+func (s AggregateSummary) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// aggregateKey identifies a row of a window.
+type aggregateKey struct {
+	domain, ip string
+}
+
+// aggregateWindow holds the counts of one window.
+type aggregateWindow struct {
+	rows map[aggregateKey]map[Result]int
+}
+
+// Aggregator accumulates the results of a receiver per sender domain and
+// client address over fixed time windows, so that postmasters see who fails
+// SPF against them without a separate analytics stack.  Feed it the events
+// of the checks and collect the summaries of the windows that ended with
+// Flush, or periodically with Run.  An Aggregator is safe for concurrent
+// use.
+type Aggregator struct {
+	// Window is the length of the windows, aligned on multiples of it since
+	// the zero time; DefaultAggregateWindow when 0.
+	Window time.Duration
+	// Clock dates events without Time and decides which windows ended; nil
+	// means time.Now.
+	Clock func() time.Time
+
+	mu      sync.Mutex
+	windows map[time.Time]*aggregateWindow
+}
+
+// NewAggregator returns an Aggregator with windows of the given length.
+func NewAggregator(window time.Duration) *Aggregator {
+	return &Aggregator{Window: window}
+}
+
+// Add counts the result of ev in the window of ev.Time.  The sender domain
+// is the domain check_host() was evaluated for, or else the domain of MAIL
+// FROM or the HELO name.  Events without a result are ignored.
+func (a *Aggregator) Add(ev SIEMEvent) {
+	if ev.Result.Code == "" {
+		return
+	}
+	t := ev.Time
+	if t.IsZero() {
+		t = a.now()
+	}
+	domain := ev.Result.Domain
+	if domain == "" {
+		if d, ok := getSenderDomain(ev.MailFrom); ok {
+			domain = d
+		} else {
+			domain = ev.HELO
+		}
+	}
+	key := aggregateKey{domain: strings.ToLower(strings.TrimSuffix(domain, "."))}
+	if ev.IP != nil {
+		key.ip = ev.IP.String()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.windows == nil {
+		a.windows = make(map[time.Time]*aggregateWindow)
+	}
+	start := t.Truncate(a.window())
+	w := a.windows[start]
+	if w == nil {
+		w = &aggregateWindow{rows: make(map[aggregateKey]map[Result]int)}
+		a.windows[start] = w
+	}
+	counts := w.rows[key]
+	if counts == nil {
+		counts = make(map[Result]int)
+		w.rows[key] = counts
+	}
+	counts[ev.Result.Code]++
+}
+
+// Flush removes the windows that ended and returns their summaries, oldest
+// first.  Events added later for a flushed window start it anew, so that
+// they are reported by a later flush instead of being lost.
+func (a *Aggregator) Flush() []AggregateSummary {
+	return a.flush(false)
+}
+
+// Run flushes the windows that ended every Window, passing each summary to
+// fn, until ctx is done.  It then passes the summaries of all windows,
+// including the current one, and returns the context error.
+func (a *Aggregator) Run(ctx context.Context, fn func(AggregateSummary)) error {
+	ticker := time.NewTicker(a.window())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			for _, s := range a.flush(true) {
+				fn(s)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			for _, s := range a.Flush() {
+				fn(s)
+			}
+		}
+	}
+}
+
+// flush removes and summarises the windows that ended, or all of them.
+func (a *Aggregator) flush(all bool) []AggregateSummary {
+	now := a.now()
+	window := a.window()
+
+	a.mu.Lock()
+	var out []AggregateSummary
+	for start, w := range a.windows {
+		end := start.Add(window)
+		if !all && end.After(now) {
+			continue
+		}
+		out = append(out, w.summary(start, end))
+		delete(a.windows, start)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+
+	return out
+}
+
+// summary returns the report of w.
+func (w *aggregateWindow) summary(start, end time.Time) AggregateSummary {
+	s := AggregateSummary{Start: start, End: end, Results: make(map[Result]int)}
+	for key, counts := range w.rows {
+		row := AggregateRow{Domain: key.domain, IP: key.ip, Results: counts}
+		for code, n := range counts {
+			row.Total += n
+			s.Results[code] += n
+		}
+		s.Total += row.Total
+		s.Rows = append(s.Rows, row)
+	}
+	sort.Slice(s.Rows, func(i, j int) bool {
+		a, b := s.Rows[i], s.Rows[j]
+		switch {
+		case a.Failures() != b.Failures():
+			return a.Failures() > b.Failures()
+		case a.Total != b.Total:
+			return a.Total > b.Total
+		case a.Domain != b.Domain:
+			return a.Domain < b.Domain
+		}
+		return a.IP < b.IP
+	})
+
+	return s
+}
+
+func (a *Aggregator) window() time.Duration {
+	if a.Window <= 0 {
+		return DefaultAggregateWindow
+	}
+
+	return a.Window
+}
+
+func (a *Aggregator) now() time.Time {
+	if a.Clock != nil {
+		return a.Clock()
+	}
+
+	return time.Now()
+}
@@ -0,0 +1,72 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregator(t *testing.T) {
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	now := base.Add(30 * time.Minute)
+	a := &Aggregator{Clock: func() time.Time { return now }}
+
+	ip1, ip2 := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	add := func(at time.Duration, ip net.IP, domain string, code Result) {
+		a.Add(SIEMEvent{Sample: Sample{IP: ip, MailFrom: "a@" + domain}, Time: base.Add(at), Result: CheckHostResult{Code: code}})
+	}
+	add(time.Minute, ip1, "example.com", Pass)
+	add(2*time.Minute, ip1, "Example.com.", Pass)
+	add(3*time.Minute, ip2, "example.com", Fail)
+	add(4*time.Minute, ip2, "example.org", SoftFail)
+	add(5*time.Minute, ip2, "example.org", Fail)
+	a.Add(SIEMEvent{Sample: Sample{IP: ip1, MailFrom: "<>", HELO: "mx.example.net"}, Time: base.Add(6 * time.Minute), Result: CheckHostResult{Code: None}})
+	a.Add(SIEMEvent{Sample: Sample{IP: ip1}, Result: CheckHostResult{Code: Neutral, Domain: "example.net"}}) // dated by Clock
+	a.Add(SIEMEvent{Sample: Sample{IP: ip1}, Result: CheckHostResult{}})                                     // ignored
+	add(-time.Minute, ip1, "example.com", Pass)                                                              // previous window
+
+	prev := a.Flush()
+	require.Len(t, prev, 1, "only the previous window has ended")
+	assert.Equal(t, base.Add(-time.Hour), prev[0].Start)
+	assert.Equal(t, 1, prev[0].Total)
+
+	now = base.Add(time.Hour)
+	got := a.Flush()
+	require.Len(t, got, 1)
+	s := got[0]
+	assert.Equal(t, base, s.Start)
+	assert.Equal(t, base.Add(time.Hour), s.End)
+	assert.Equal(t, 7, s.Total)
+	assert.Equal(t, map[Result]int{Pass: 2, Fail: 2, SoftFail: 1, None: 1, Neutral: 1}, s.Results)
+	assert.Equal(t, []AggregateRow{
+		{Domain: "example.org", IP: "192.0.2.2", Total: 2, Results: map[Result]int{SoftFail: 1, Fail: 1}},
+		{Domain: "example.com", IP: "192.0.2.2", Total: 1, Results: map[Result]int{Fail: 1}},
+		{Domain: "example.com", IP: "192.0.2.1", Total: 2, Results: map[Result]int{Pass: 2}},
+		{Domain: "example.net", IP: "192.0.2.1", Total: 1, Results: map[Result]int{Neutral: 1}},
+		{Domain: "mx.example.net", IP: "192.0.2.1", Total: 1, Results: map[Result]int{None: 1}},
+	}, s.Rows)
+	assert.Empty(t, a.Flush(), "flushed windows are removed")
+
+	var buf bytes.Buffer
+	require.NoError(t, prev[0].WriteJSON(&buf))
+	assert.Equal(t, `{"start":"2024-05-01T09:00:00Z","end":"2024-05-01T10:00:00Z","total":1,"results":{"pass":1},`+
+		`"rows":[{"domain":"example.com","ip":"192.0.2.1","total":1,"results":{"pass":1}}]}`+"\n", buf.String())
+}
+
+func TestAggregatorRun(t *testing.T) {
+	a := NewAggregator(time.Hour)
+	a.Add(SIEMEvent{Sample: Sample{IP: net.ParseIP("192.0.2.1")}, Result: CheckHostResult{Code: Fail, Domain: "example.com"}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var got []AggregateSummary
+	err := a.Run(ctx, func(s AggregateSummary) { got = append(got, s) })
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, got, 1, "the current window is flushed on return")
+	assert.Equal(t, 1, got[0].Results[Fail])
+}
@@ -0,0 +1,250 @@
+package spf
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// Alert is raised by a Watcher when an AlertRule starts to report a finding
+// for a watched domain.
+type Alert struct {
+	Rule    string
+	Domain  string // watched domain
+	Message string
+	Time    time.Time
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s [%s] %s: %s", a.Time.UTC().Format(time.RFC3339), a.Rule, a.Domain, a.Message)
+}
+
+// AlertFinding is one message reported by an AlertRule.
+type AlertFinding struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// AlertRule is a condition checked against every snapshot of a watched
+// domain.  Check returns one message per finding; an alert is raised when a
+// message appears that the previous snapshot did not produce.
+type AlertRule struct {
+	Name  string
+	Check func(ctx context.Context, r TXTResolver, g *Graph) ([]string, error)
+}
+
+// AlertSink receives alerts, e.g. to forward them to a pager or chat.
+type AlertSink interface {
+	Alert(ctx context.Context, a Alert) error
+}
+
+// AlertSinkFunc adapts a function to AlertSink.
+type AlertSinkFunc func(ctx context.Context, a Alert) error
+
+// Alert calls f(ctx, a).
+func (f AlertSinkFunc) Alert(ctx context.Context, a Alert) error {
+	return f(ctx, a)
+}
+
+// WriterSink returns an AlertSink writing one line per alert to w.
+func WriterSink(w io.Writer) AlertSink {
+	return AlertSinkFunc(func(_ context.Context, a Alert) error {
+		_, err := fmt.Fprintln(w, a.String())
+		return err
+	})
+}
+
+// RecordMissingRule reports records of the tree that no longer exist.
+func RecordMissingRule() AlertRule {
+	return graphRule("record-missing", func(g *Graph, n *Node) string {
+		if errors.Is(n.Err, ErrNoDNSrecord) || errors.Is(n.Err, ErrMissingRecord) {
+			return n.Domain + " has no SPF record"
+		}
+		return ""
+	})
+}
+
+// RecordInvalidRule reports records that cannot be selected or parsed, e.g.
+// after a syntax error was published.
+func RecordInvalidRule() AlertRule {
+	return graphRule("record-invalid", func(g *Graph, n *Node) string {
+		if n.Err == nil || errors.Is(n.Err, ErrNoDNSrecord) || errors.Is(n.Err, ErrMissingRecord) || errors.Is(n.Err, ErrTempfail) {
+			return ""
+		}
+		return fmt.Sprintf("%s: %v", n.Domain, n.Err)
+	})
+}
+
+// PlusAllRule reports records that pass every client with "+all".
+func PlusAllRule() AlertRule {
+	return graphRule("plus-all", func(g *Graph, n *Node) string {
+		if n.Record == nil {
+			return ""
+		}
+		for _, m := range n.Record.Mechs {
+			if m.Kind == "all" && m.Qual == parser.QPlus {
+				return n.Domain + " authorizes every host with +all"
+			}
+		}
+		return ""
+	})
+}
+
+// graphRule builds an AlertRule checking every node of the graph.
+func graphRule(name string, check func(g *Graph, n *Node) string) AlertRule {
+	return AlertRule{
+		Name: name,
+		Check: func(_ context.Context, _ TXTResolver, g *Graph) ([]string, error) {
+			var msgs []string
+			for _, d := range g.Domains() {
+				if msg := check(g, g.Nodes[d]); msg != "" {
+					msgs = append(msgs, msg)
+				}
+			}
+			return msgs, nil
+		},
+	}
+}
+
+// TooManyLookupsRule reports trees whose evaluation needs more than limit DNS
+// lookups; limit is usually MaxDNSLookups.
+func TooManyLookupsRule(limit int) AlertRule {
+	return AlertRule{
+		Name: "too-many-lookups",
+		Check: func(_ context.Context, _ TXTResolver, g *Graph) ([]string, error) {
+			if n := g.TotalLookups(); n > limit {
+				return []string{fmt.Sprintf("%d DNS lookups exceed the limit of %d", n, limit)}, nil
+			}
+			return nil, nil
+		},
+	}
+}
+
+// AuthorizedSpaceRule reports policies that authorize more than max IPv4
+// addresses, counted over the prefixes returned by ListAuthorizedNetworks.
+// The check costs the a and mx lookups of the whole tree.
+func AuthorizedSpaceRule(max uint64) AlertRule {
+	return AlertRule{
+		Name: "authorized-space",
+		Check: func(ctx context.Context, r TXTResolver, g *Graph) ([]string, error) {
+			inv, err := ListAuthorizedNetworks(ctx, r, g.Root)
+			if err != nil {
+				return nil, err
+			}
+			if n := ipv4Space(inv); n.Cmp(new(big.Int).SetUint64(max)) > 0 {
+				return []string{fmt.Sprintf("%s IPv4 addresses authorized, threshold is %d", n, max)}, nil
+			}
+			return nil, nil
+		},
+	}
+}
+
+// ipv4Space counts the IPv4 addresses covered by inv, not counting prefixes
+// nested in other listed prefixes twice.
+func ipv4Space(inv *NetworkInventory) *big.Int {
+	var prefixes []netip.Prefix
+	for p := range prefixSet(inv) {
+		if p.Addr().Is4() {
+			prefixes = append(prefixes, p)
+		}
+	}
+	sortPrefixes(prefixes)
+
+	total := new(big.Int)
+	var last netip.Prefix
+	for _, p := range prefixes {
+		if last.IsValid() && last.Bits() <= p.Bits() && last.Contains(p.Addr()) {
+			continue
+		}
+		last = p
+		total.Add(total, new(big.Int).Lsh(big.NewInt(1), uint(32-p.Bits())))
+	}
+
+	return total
+}
+
+// DefaultAlertRules returns the rules suited for most monitored domains.
+func DefaultAlertRules() []AlertRule {
+	return []AlertRule{
+		RecordMissingRule(),
+		RecordInvalidRule(),
+		TooManyLookupsRule(MaxDNSLookups),
+		PlusAllRule(),
+	}
+}
+
+// alert runs the rules of w against g and delivers new findings to the
+// sinks.  Findings are remembered per domain so that a condition persisting
+// across polls alerts only once; changed reports whether they differ from
+// those of the previous poll.
+func (w *Watcher) alert(ctx context.Context, domain string, g *Graph) (alerts []Alert, changed bool, err error) {
+	if len(w.Rules) == 0 {
+		return nil, false, nil
+	}
+	if w.findings == nil {
+		w.findings = make(map[string]map[AlertFinding]bool)
+	}
+
+	prev := w.findings[domain]
+	cur := make(map[AlertFinding]bool)
+	var errs []error
+	for _, rule := range w.Rules {
+		msgs, err := rule.Check(ctx, w.Resolver, g)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.Name, err))
+			continue
+		}
+		for _, msg := range msgs {
+			f := AlertFinding{Rule: rule.Name, Message: msg}
+			cur[f] = true
+			if !prev[f] {
+				alerts = append(alerts, Alert{Rule: rule.Name, Domain: domain, Message: msg, Time: w.now()})
+			}
+		}
+	}
+	w.findings[domain] = cur
+
+	for _, a := range alerts {
+		for _, sink := range w.Sinks {
+			if err := sink.Alert(ctx, a); err != nil {
+				errs = append(errs, fmt.Errorf("alert sink: %w", err))
+			}
+		}
+	}
+
+	return alerts, !maps.Equal(prev, cur), errors.Join(errs...)
+}
+
+// storedFindings returns the findings of domain in a stable order, for a
+// Snapshot.
+func (w *Watcher) storedFindings(domain string) []AlertFinding {
+	findings := slices.Collect(maps.Keys(w.findings[domain]))
+	slices.SortFunc(findings, func(a, b AlertFinding) int {
+		return cmp.Or(strings.Compare(a.Rule, b.Rule), strings.Compare(a.Message, b.Message))
+	})
+
+	return findings
+}
+
+// restoreFindings makes the findings of a stored snapshot of domain those of
+// the previous poll.
+func (w *Watcher) restoreFindings(domain string, findings []AlertFinding) {
+	if w.findings == nil {
+		w.findings = make(map[string]map[AlertFinding]bool)
+	}
+	set := make(map[AlertFinding]bool, len(findings))
+	for _, f := range findings {
+		set[f] = true
+	}
+	w.findings[domain] = set
+}
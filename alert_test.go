@@ -0,0 +1,136 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertRules(t *testing.T) {
+	ctx := context.Background()
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":      {"v=spf1 include:_spf.example.com include:gone.example.com include:bad.example.com -all"},
+		"_spf.example.com": {"v=spf1 ip4:192.0.2.0/24 +all"},
+		"bad.example.com":  {"v=spf1 ip4:999.0.0.1 -all"},
+	}}
+	g, err := BuildGraph(ctx, zone, "example.com")
+	require.NoError(t, err)
+
+	cases := []struct {
+		rule AlertRule
+		want []string
+	}{
+		{RecordMissingRule(), []string{"gone.example.com has no SPF record"}},
+		{PlusAllRule(), []string{"_spf.example.com authorizes every host with +all"}},
+		{TooManyLookupsRule(2), []string{"3 DNS lookups exceed the limit of 2"}},
+		{TooManyLookupsRule(MaxDNSLookups), nil},
+		{AuthorizedSpaceRule(1 << 24), []string{"4294967296 IPv4 addresses authorized, threshold is 16777216"}},
+	}
+	for _, tc := range cases {
+		got, err := tc.rule.Check(ctx, zone, g)
+		require.NoError(t, err, tc.rule.Name)
+		assert.Equal(t, tc.want, got, tc.rule.Name)
+	}
+
+	got, err := RecordInvalidRule().Check(ctx, zone, g)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Contains(t, got[0], "bad.example.com")
+}
+
+func TestIPv4Space(t *testing.T) {
+	inv := &NetworkInventory{}
+	for _, p := range []string{"192.0.2.0/24", "192.0.2.128/25", "198.51.100.1/32", "2001:db8::/32"} {
+		inv.Networks = append(inv.Networks, AuthorizedNetwork{Prefix: netip.MustParsePrefix(p)})
+	}
+	assert.Equal(t, "257", ipv4Space(inv).String())
+}
+
+func TestWatcher_Alerts(t *testing.T) {
+	ctx := context.Background()
+	zone := graphZone()
+	var out bytes.Buffer
+	var got []Alert
+	w := NewWatcher(zone, time.Hour, "example.com")
+	w.Clock = func() time.Time { return time.Unix(0, 0) }
+	w.Rules = DefaultAlertRules()
+	w.Sinks = []AlertSink{
+		WriterSink(&out),
+		AlertSinkFunc(func(_ context.Context, a Alert) error {
+			got = append(got, a)
+			return nil
+		}),
+	}
+
+	// graphZone needs 11 lookups, reported on the baseline poll
+	events, err := w.Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Len(t, events[0].Alerts, 1)
+	assert.Equal(t, "too-many-lookups", events[0].Alerts[0].Rule)
+	assert.Equal(t, "1970-01-01T00:00:00Z [too-many-lookups] example.com: 11 DNS lookups exceed the limit of 10\n", out.String())
+
+	// a persisting condition does not alert again
+	events, err = w.Poll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	zone.txt["vendor.example.net"] = []string{"v=spf1 +all"}
+	events, err = w.Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Len(t, events[0].Alerts, 1)
+	assert.Equal(t, "plus-all", events[0].Alerts[0].Rule)
+	assert.Len(t, got, 2)
+
+	w.Sinks = []AlertSink{AlertSinkFunc(func(context.Context, Alert) error { return errors.New("pager down") })}
+	delete(zone.txt, "_spf.example.com")
+	events, err = w.Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.ErrorContains(t, events[0].Err, "pager down")
+	assert.Equal(t, "record-missing", events[0].Alerts[0].Rule)
+}
+
+func TestWatcher_AlertsAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	zone := graphZone()
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	newWatcher := func() *Watcher {
+		w := NewWatcher(zone, time.Hour, "example.com")
+		w.Clock = func() time.Time { return time.Unix(0, 0) }
+		w.Rules = DefaultAlertRules()
+		w.Store = store
+		return w
+	}
+
+	events, err := newWatcher().Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Len(t, events[0].Alerts, 1)
+	s, err := store.At(ctx, "example.com", time.Unix(0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, []AlertFinding{{Rule: "too-many-lookups", Message: "11 DNS lookups exceed the limit of 10"}}, s.Findings)
+
+	// the findings stored with the baseline are not alerted again
+	events, err = newWatcher().Poll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	zone.txt["vendor.example.net"] = []string{"v=spf1 +all"}
+	events, err = newWatcher().Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Len(t, events[0].Alerts, 1)
+	assert.Equal(t, "plus-all", events[0].Alerts[0].Rule)
+	events, err = newWatcher().Poll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
@@ -0,0 +1,161 @@
+package spf
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// AuditReport is the result of Audit.
+type AuditReport struct {
+	Domain   string
+	Graph    *Graph
+	Size     ResponseSize // of the TXT answer for Domain
+	Warnings []Warning
+}
+
+// Audit checks the SPF setup of domain with the default rules, see
+// Linter.Audit.
+func Audit(ctx context.Context, r TXTResolver, domain string) (*AuditReport, error) {
+	return NewLinter().Audit(ctx, r, domain)
+}
+
+// Audit resolves the policy of domain and runs the enabled rules of l against
+// the record of domain and the tree below it.  It also checks every TXT
+// record at domain, which l configures as the rules
+//
+//   - "response-size", the TXT answer likely to be truncated over UDP, see
+//     ResponseSize.Warnings, and
+//   - "sender-id", obsolete Sender ID records (RFC 4406) and conflicts
+//     between their MAIL FROM scope and the SPF policy, and
+//   - "legacy-spf-type", records of the retired SPF RR type, checked when r
+//     implements SPFTypeResolver.
+//
+// Only an invalid domain or a DNS or context error fetching the TXT records
+// of domain is returned as error; failed queries for the SPF RR type, which
+// some servers mishandle, are ignored.
+func (l *Linter) Audit(ctx context.Context, r TXTResolver, domain string) (*AuditReport, error) {
+	g, err := BuildGraph(ctx, r, domain)
+	if err != nil {
+		return nil, err
+	}
+	txts, err := lookupTXTs(ctx, r, g.Root)
+	if err != nil {
+		return nil, err
+	}
+	size, err := EstimateResponseSize(g.Root, txts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditReport{Domain: g.Root, Graph: g, Size: size}
+	root := g.Nodes[g.Root].Record
+	if root != nil {
+		report.Warnings = l.Lint(root)
+	}
+	report.Warnings = append(report.Warnings, l.LintGraph(g)...)
+	report.Warnings = append(report.Warnings, l.check(responseSizeRule, size.Warnings())...)
+	report.Warnings = append(report.Warnings, l.check(senderIDRule, senderIDWarnings(root, txts))...)
+	if sr, ok := r.(SPFTypeResolver); ok && !l.Disabled[legacySPFTypeRule.ID] {
+		records, err := sr.LookupSPFType(ctx, queryName(g.Root))
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err == nil {
+			report.Warnings = append(report.Warnings, l.check(legacySPFTypeRule, spfTypeWarnings(g.Nodes[g.Root].Raw, records))...)
+		}
+	}
+
+	return report, nil
+}
+
+// The checks built into Linter.Audit.  They have no Record or Graph function
+// since they need the TXT records of the audited domain.
+var (
+	responseSizeRule = LintRule{
+		ID:          "response-size",
+		Severity:    SeverityWarning,
+		Description: "The TXT answer is likely to be truncated over UDP.",
+	}
+	senderIDRule = LintRule{
+		ID:          "sender-id",
+		Severity:    SeverityWarning,
+		Description: "Sender ID records (RFC 4406) are obsolete and may conflict with the SPF policy.",
+	}
+	legacySPFTypeRule = LintRule{
+		ID:          "legacy-spf-type",
+		Severity:    SeverityWarning,
+		Description: "The SPF RR type is retired (RFC 7208 section 3.1) and ignored by receivers.",
+	}
+)
+
+// AuditRules returns the checks built into Linter.Audit, for describing
+// findings, e.g. in WriteSARIF.  They can be disabled and given other
+// severities like any rule.
+func AuditRules() []LintRule {
+	return []LintRule{responseSizeRule, senderIDRule, legacySPFTypeRule}
+}
+
+// check labels the warnings of the built-in audit rule unless it is
+// disabled.
+func (l *Linter) check(rule LintRule, warnings []Warning) []Warning {
+	if l.Disabled[rule.ID] {
+		return nil
+	}
+
+	return l.label(rule, warnings)
+}
+
+// senderIDWarnings reports the Sender ID records among txts, comparing those
+// with the mfrom scope to spf1, the SPF record of the same name or nil.
+// Receivers still implementing Sender ID prefer such a record to the SPF
+// record (RFC 4406 section 3.4).
+func senderIDWarnings(spf1 *parser.Record, txts []string) []Warning {
+	var warnings []Warning
+	for _, txt := range txts {
+		fields := strings.Fields(txt)
+		if len(fields) == 0 || !strings.HasPrefix(strings.ToLower(fields[0]), "spf2.0/") {
+			continue
+		}
+		version := fields[0]
+		scopes := strings.Split(strings.ToLower(version[len("spf2.0/"):]), ",")
+		rec, err := parser.Parse(strings.ToLower(strings.Join(append([]string{"v=spf1"}, fields[1:]...), " ")))
+		var msg string
+		switch {
+		case err != nil:
+			msg = "the Sender ID record is invalid: " + err.Error()
+		case spf1 == nil:
+			msg = "the Sender ID record is obsolete (RFC 4406) and ignored by SPF receivers; publish a v=spf1 record instead"
+		case slices.Contains(scopes, "mfrom") && rec.String() != spf1.String():
+			msg = "the Sender ID record differs from the v=spf1 policy, and receivers still implementing Sender ID may apply it to MAIL FROM; remove it or make it match"
+		default:
+			msg = "the Sender ID record is obsolete (RFC 4406); remove it"
+		}
+		warnings = append(warnings, Warning{Term: version, Message: msg})
+	}
+
+	return warnings
+}
+
+// spfTypeWarnings reports the SPF records among records of the SPF RR type,
+// comparing them to txt, the SPF record published as TXT or "".  Receivers
+// only query TXT (RFC 7208 section 3.1), so a differing record misleads
+// whoever reads the zone.
+func spfTypeWarnings(txt string, records []string) []Warning {
+	var warnings []Warning
+	for _, rec := range records {
+		fields := strings.Fields(rec)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], "v=spf1") {
+			continue
+		}
+		msg := "the record of the SPF RR type is obsolete (RFC 7208 section 3.1); remove it"
+		if !strings.EqualFold(strings.Join(fields, " "), strings.Join(strings.Fields(txt), " ")) {
+			msg = "the record of the SPF RR type differs from the TXT record and is ignored by receivers (RFC 7208 section 3.1); remove it"
+		}
+		warnings = append(warnings, Warning{Message: msg})
+	}
+
+	return warnings
+}
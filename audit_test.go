@@ -0,0 +1,101 @@
+package spf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func auditZone() *zoneResolver {
+	return &zoneResolver{txt: map[string][]string{
+		"example.com":      {"v=spf1 ip4:192.0.2.0/24 include:gone.example.net -all", "spf2.0/pra ip4:192.0.2.0/24 -all"},
+		"conflict.example": {"v=spf1 ip4:192.0.2.0/24 -all", "spf2.0/mfrom,pra ip4:198.51.100.0/24 -all"},
+		"same.example":     {"v=spf1 ip4:192.0.2.0/24 -all", "spf2.0/mfrom,pra IP4:192.0.2.0/24 -all"},
+		"only.example":     {"spf2.0/pra ip4:192.0.2.0/24 -all"},
+		"bad.example":      {"v=spf1 -all", "spf2.0/pra bogus"},
+	}}
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+
+	report, err := Audit(ctx, auditZone(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Size.Records)
+	require.Len(t, report.Warnings, 2)
+	assert.Equal(t, "target-missing", report.Warnings[0].Rule)
+	assert.Equal(t, Warning{
+		Rule:     "sender-id",
+		Severity: SeverityWarning,
+		Term:     "spf2.0/pra",
+		Message:  "the Sender ID record is obsolete (RFC 4406); remove it",
+	}, report.Warnings[1])
+
+	l := NewLinter()
+	l.Disabled["target-missing"] = true
+	l.Disabled["sender-id"] = true
+	report, err = l.Audit(ctx, auditZone(), "example.com")
+	require.NoError(t, err)
+	assert.Empty(t, report.Warnings)
+}
+
+func TestAudit_SenderID(t *testing.T) {
+	ctx := context.Background()
+	message := func(domain string) string {
+		report, err := Audit(ctx, auditZone(), domain)
+		require.NoError(t, err)
+		require.Len(t, report.Warnings, 1, domain)
+		assert.Equal(t, "sender-id", report.Warnings[0].Rule)
+		return report.Warnings[0].Message
+	}
+
+	assert.Contains(t, message("conflict.example"), "differs from the v=spf1 policy")
+	assert.Contains(t, message("same.example"), "obsolete (RFC 4406); remove it")
+	assert.Contains(t, message("only.example"), "publish a v=spf1 record instead")
+	assert.Contains(t, message("bad.example"), "invalid")
+}
+
+// spfTypeZone adds records of the SPF RR type to a zoneResolver.
+type spfTypeZone struct {
+	*zoneResolver
+	spf map[string][]string
+}
+
+func (z spfTypeZone) LookupSPFType(ctx context.Context, domain string) ([]string, error) {
+	records, ok := z.spf[domain]
+	if !ok {
+		return nil, notFound(domain)
+	}
+	return records, nil
+}
+
+func TestAudit_LegacySPFType(t *testing.T) {
+	zone := spfTypeZone{zoneResolver: auditZone(), spf: map[string][]string{
+		"conflict.example": {"v=spf1 ip4:192.0.2.0/24 ~all"},
+		"same.example":     {"V=SPF1  ip4:192.0.2.0/24 -all"},
+	}}
+	ctx := context.Background()
+	l := NewLinter()
+	l.Disabled["sender-id"] = true
+
+	report, err := l.Audit(ctx, zone, "conflict.example")
+	require.NoError(t, err)
+	require.Len(t, report.Warnings, 1)
+	assert.Equal(t, "legacy-spf-type", report.Warnings[0].Rule)
+	assert.Contains(t, report.Warnings[0].Message, "differs from the TXT record")
+
+	report, err = l.Audit(ctx, zone, "same.example")
+	require.NoError(t, err)
+	require.Len(t, report.Warnings, 1)
+	assert.Contains(t, report.Warnings[0].Message, "is obsolete")
+
+	report, err = l.Audit(ctx, zone, "example.com")
+	require.NoError(t, err)
+	assert.Len(t, report.Warnings, 1, "only target-missing")
+
+	report, err = l.Audit(ctx, NewCustomDNSResolver(auditZone()), "same.example")
+	require.NoError(t, err)
+	assert.Empty(t, report.Warnings, "ErrUnsupported is ignored")
+}
@@ -0,0 +1,231 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one check for the audit log of a receiver: who was
+// checked, what the decision rested on and the result acted upon.  Unlike
+// the debug output of WithLogger and WithTrace it is one entry per check,
+// meant to be kept.
+type AuditEntry struct {
+	Time          time.Time `json:"time"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	IP            string    `json:"ip"`
+	Domain        string    `json:"domain"`
+	Sender        string    `json:"sender,omitempty"`
+	HELO          string    `json:"helo,omitempty"`
+	Scope         Scope     `json:"scope,omitempty"`
+	Result        Result    `json:"result,omitempty"`
+	// Unmapped is the evaluated result when a Policy mapped it to Result.
+	Unmapped Result `json:"unmapped,omitempty"`
+	// Records is the chain of records evaluated, in the order fetched.
+	Records []AuditRecord `json:"records,omitempty"`
+	// Match and MatchDomain are the mechanism deciding the result and the
+	// domain whose record holds it.
+	Match       string `json:"match,omitempty"`
+	MatchDomain string `json:"match_domain,omitempty"`
+	// Overrides lists the domains whose record came from
+	// WithRecordOverrides or Simulate instead of DNS.
+	Overrides []string `json:"overrides,omitempty"`
+	// Bypass is the address list and network that decided the result
+	// without evaluation, e.g. "allowlist 10.0.0.0/8".
+	Bypass string `json:"bypass,omitempty"`
+	Cause  string `json:"cause,omitempty"`
+	// Error is set for checks failing without result, e.g. for an invalid
+	// client address.
+	Error      string            `json:"error,omitempty"`
+	Lookups    int               `json:"lookups"`
+	Duration   time.Duration     `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// AuditRecord is a record of the chain of an AuditEntry.
+type AuditRecord struct {
+	Domain   string `json:"domain"`
+	Text     string `json:"text"`
+	Override bool   `json:"override,omitempty"`
+}
+
+// AuditSink receives the audit entries of a Checker, e.g. to append them to
+// a file or forward them to a collector.
+type AuditSink interface {
+	Audit(ctx context.Context, e AuditEntry) error
+}
+
+// AuditSinkFunc adapts a function to AuditSink.
+type AuditSinkFunc func(ctx context.Context, e AuditEntry) error
+
+// Audit calls f(ctx, e).
+func (f AuditSinkFunc) Audit(ctx context.Context, e AuditEntry) error {
+	return f(ctx, e)
+}
+
+// auditSink is a sink of WithAuditSink with its error handler.
+type auditSink struct {
+	sink    AuditSink
+	onError func(error)
+}
+
+// WithAuditSink sends an AuditEntry to sink for every check of the MAIL
+// FROM or HELO identity, including checks failing without result.  Entries
+// are sent synchronously once the result is known, with a context that
+// outlives the caller's, so a slow sink slows checks down.  Errors of sink
+// are passed to onError, when not nil, and never change the result.  The
+// Checker owns sink and closes it in Close if it implements io.Closer.
+func WithAuditSink(sink AuditSink, onError func(error)) Option {
+	return func(c *Checker) {
+		c.auditSinks = append(c.auditSinks, auditSink{sink: sink, onError: onError})
+		if closer, ok := sink.(io.Closer); ok {
+			c.closers = append(c.closers, closer)
+		}
+	}
+}
+
+// audit sends the entry of the check of domain by e to the audit sinks.
+func (c *Checker) audit(ctx context.Context, e *evaluation, domain string, res CheckHostResult, err error) {
+	if len(c.auditSinks) == 0 || errors.Is(err, ErrClosed) {
+		return
+	}
+	entry := AuditEntry{
+		Time:          c.now(),
+		CorrelationID: CorrelationID(ctx),
+		Domain:        domain,
+		Sender:        e.sender,
+		HELO:          e.helo,
+		Scope:         res.Scope,
+		Result:        res.Code,
+		Unmapped:      res.Unmapped,
+		Lookups:       res.Stats.Lookups,
+		Duration:      res.Stats.Duration,
+		Attributes:    res.Attributes,
+	}
+	if e.ip != nil {
+		entry.IP = e.ip.String()
+	}
+	if res.Domain != "" {
+		entry.Domain = res.Domain
+	}
+	for _, rec := range res.Records {
+		entry.Records = append(entry.Records, AuditRecord{Domain: rec.Domain, Text: rec.Text, Override: rec.Override})
+		if rec.Override {
+			entry.Overrides = append(entry.Overrides, rec.Domain)
+		}
+	}
+	if res.Match != nil {
+		entry.Match, entry.MatchDomain = res.Match.Term, res.Match.Domain
+	}
+	if res.Bypass != nil {
+		entry.Bypass = res.Bypass.String()
+	}
+	if res.Cause != nil {
+		entry.Cause = res.Cause.Error()
+	}
+	if res.Code == "" && err != nil {
+		entry.Error = err.Error()
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	for _, s := range c.auditSinks {
+		if err := s.sink.Audit(ctx, entry); err != nil && s.onError != nil {
+			s.onError(err)
+		}
+	}
+}
+
+// JSONAuditSink returns an AuditSink writing each entry to w as one line of
+// JSON.  Writes are serialised, so w need not be safe for concurrent use.
+func JSONAuditSink(w io.Writer) AuditSink {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return AuditSinkFunc(func(_ context.Context, e AuditEntry) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return enc.Encode(e)
+	})
+}
+
+// AuditFile is an AuditSink appending JSON lines to a file, see
+// JSONAuditSink.
+type AuditFile struct {
+	AuditSink
+	f *os.File
+}
+
+// OpenAuditFile opens the file at path for appending audit entries,
+// creating it with mode 0600 when missing.
+func OpenAuditFile(path string) (*AuditFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuditFile{AuditSink: JSONAuditSink(f), f: f}, nil
+}
+
+// Close closes the file.
+func (a *AuditFile) Close() error {
+	return a.f.Close()
+}
+
+// AuditWebhook is an AuditSink posting each entry as a JSON object to an
+// HTTP endpoint.  Responses other than 2xx are errors.
+type AuditWebhook struct {
+	URL    string
+	Client *http.Client // http.DefaultClient when nil
+	Header http.Header  // added to every request, e.g. for authorization
+	// Timeout bounds each request, DefaultDialTimeout when zero.
+	Timeout time.Duration
+}
+
+// NewAuditWebhook returns an AuditWebhook posting to url.
+func NewAuditWebhook(url string) *AuditWebhook {
+	return &AuditWebhook{URL: url}
+}
+
+// Audit posts e.
+func (w *AuditWebhook) Audit(ctx context.Context, e AuditEntry) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("audit webhook %s: %s", w.URL, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package spf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithAuditSink(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":      {"v=spf1 include:_spf.example.com ~all"},
+		"_spf.example.com": {"v=spf1 ip4:192.0.2.0/24 -all"},
+	}}
+	var entries []AuditEntry
+	clock := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	ch := NewChecker(NewCustomDNSResolver(zone),
+		WithAuditSink(AuditSinkFunc(func(_ context.Context, e AuditEntry) error {
+			entries = append(entries, e)
+			return nil
+		}), nil),
+		WithClock(func() time.Time { return clock }),
+		WithPolicy(StrictPolicy),
+		WithRecordOverrides(map[string]string{"partner.example": "v=spf1 ip4:198.51.100.0/24 -all"}))
+	ctx := ContextWithCorrelationID(context.Background(), "msg-1")
+
+	_, err := ch.CheckHostWithHELO(ctx, net.ParseIP("192.0.2.1"), "example.com", "alice@example.com", "mail.example.com")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	e := entries[0]
+	e.Duration = 0
+	assert.Equal(t, AuditEntry{
+		Time:          clock,
+		CorrelationID: "msg-1",
+		IP:            "192.0.2.1",
+		Domain:        "example.com",
+		Sender:        "alice@example.com",
+		HELO:          "mail.example.com",
+		Scope:         ScopeMailFrom,
+		Result:        Pass,
+		Records: []AuditRecord{
+			{Domain: "example.com", Text: "v=spf1 include:_spf.example.com ~all"},
+			{Domain: "_spf.example.com", Text: "v=spf1 ip4:192.0.2.0/24 -all"},
+		},
+		Match:       "ip4:192.0.2.0/24",
+		MatchDomain: "_spf.example.com",
+		Lookups:     1,
+	}, e)
+
+	_, _ = ch.CheckHost(ctx, net.ParseIP("203.0.113.1"), "example.com", "")
+	require.Len(t, entries, 2)
+	assert.Equal(t, Fail, entries[1].Result)
+	assert.Equal(t, SoftFail, entries[1].Unmapped)
+	assert.Empty(t, entries[1].Scope)
+
+	_, _ = ch.CheckHELO(ctx, net.ParseIP("198.51.100.1"), "partner.example")
+	require.Len(t, entries, 3)
+	assert.Equal(t, ScopeHELO, entries[2].Scope)
+	assert.Equal(t, Pass, entries[2].Result)
+	assert.Equal(t, []string{"partner.example"}, entries[2].Overrides)
+	assert.True(t, entries[2].Records[0].Override)
+
+	_, err = ch.CheckHost(ctx, nil, "example.com", "")
+	require.ErrorIs(t, err, ErrInvalidIP)
+	require.Len(t, entries, 4)
+	assert.Equal(t, ErrInvalidIP.Error(), entries[3].Error)
+	assert.Empty(t, entries[3].Result)
+
+	require.NoError(t, ch.Close())
+	_, _ = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	assert.Len(t, entries, 4, "no entries once closed")
+}
+
+func TestWithAuditSink_Errors(t *testing.T) {
+	var errs []error
+	failing := AuditSinkFunc(func(context.Context, AuditEntry) error { return errors.New("disk full") })
+	ch := NewChecker(NewCustomDNSResolver(&zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 -all"}}}),
+		WithAuditSink(failing, func(err error) { errs = append(errs, err) }))
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code, "sink errors leave the result alone")
+	assert.Len(t, errs, 1)
+}
+
+func TestOpenAuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 -all"}}}
+	for range 2 {
+		f, err := OpenAuditFile(path)
+		require.NoError(t, err)
+		ch := NewChecker(NewCustomDNSResolver(zone), WithAuditSink(f, nil))
+		_, _ = ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+		require.NoError(t, ch.Close(), "closes the file")
+	}
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	lines := 0
+	for sc.Scan() {
+		var e AuditEntry
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+		assert.Equal(t, Fail, e.Result)
+		lines++
+	}
+	assert.Equal(t, 2, lines, "entries are appended")
+}
+
+func TestAuditWebhook(t *testing.T) {
+	var got AuditEntry
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	hook := NewAuditWebhook(srv.URL)
+	entry := AuditEntry{IP: "192.0.2.1", Domain: "example.com", Result: Fail}
+	err := hook.Audit(context.Background(), entry)
+	assert.ErrorContains(t, err, "401 Unauthorized")
+
+	hook.Header = http.Header{"Authorization": {"Bearer token"}}
+	require.NoError(t, hook.Audit(context.Background(), entry))
+	assert.Equal(t, entry, got)
+}
@@ -0,0 +1,82 @@
+package spf
+
+import (
+	"context"
+	"sync"
+)
+
+// CheckRequest is one check submitted to a BulkChecker: the identity SPF
+// applies to for the transaction of the Sample, as in Replay.
+type CheckRequest struct {
+	Sample
+	// ID identifies the request in its result and is set as the correlation
+	// ID of the evaluation, see ContextWithCorrelationID.
+	ID string
+}
+
+// CheckResponse is the outcome of a CheckRequest.
+type CheckResponse struct {
+	Request CheckRequest
+	Result  CheckHostResult
+	Err     error // an error returned without a result, e.g. ErrInvalidIP
+}
+
+// BulkChecker evaluates a stream of requests with a bounded number of
+// workers sharing one Checker, and so its resolver and cache, for log
+// replays and mass verification.
+type BulkChecker struct {
+	Checker     *Checker
+	Concurrency int // parallel evaluations, at least 1
+}
+
+// NewBulkChecker returns a BulkChecker evaluating with ch on 16 workers.
+func NewBulkChecker(ch *Checker) *BulkChecker {
+	return &BulkChecker{Checker: ch, Concurrency: 16}
+}
+
+// Run reads requests until the channel is closed and emits one response per
+// request, in completion order.  The returned channel is closed after the
+// last response or once ctx is done; requests still queued are then dropped.
+func (b *BulkChecker) Run(ctx context.Context, requests <-chan CheckRequest) <-chan CheckResponse {
+	workers := max(b.Concurrency, 1)
+	out := make(chan CheckResponse)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for {
+				var req CheckRequest
+				var ok bool
+				select {
+				case <-ctx.Done():
+					return
+				case req, ok = <-requests:
+					if !ok {
+						return
+					}
+				}
+				resp := CheckResponse{Request: req}
+				checkCtx := ctx
+				if req.ID != "" {
+					checkCtx = ContextWithCorrelationID(ctx, req.ID)
+				}
+				resp.Result, resp.Err = replaySample(checkCtx, b.Checker, req.Sample)
+				if ctx.Err() != nil {
+					return
+				}
+				select {
+				case out <- resp:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
@@ -0,0 +1,106 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkChecker(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":      {"v=spf1 include:_spf.example.com -all"},
+		"_spf.example.com": {"v=spf1 ip4:192.0.2.0/24"},
+		"mx.example.org":   {"v=spf1 a -all"},
+	}, ip: map[string][]string{"mx.example.org": {"198.51.100.1"}}}
+	counting := newCountingResolver(zone)
+	var ids idSet
+	ch := NewChecker(NewCustomDNSResolver(counting), WithCache(NewMemoryCache(time.Minute)),
+		WithTrace(func(ev TraceEvent) { ids.add(ev.CorrelationID) }))
+	b := NewBulkChecker(ch)
+	b.Concurrency = 4
+
+	in := make(chan CheckRequest)
+	go func() {
+		defer close(in)
+		for i := range 40 {
+			req := CheckRequest{ID: fmt.Sprint(i), Sample: Sample{IP: net.ParseIP("192.0.2.1"), MailFrom: "alice@example.com"}}
+			switch i % 4 {
+			case 1:
+				req.IP = net.ParseIP("203.0.113.1")
+			case 2:
+				req.Sample = Sample{IP: net.ParseIP("198.51.100.1"), MailFrom: "<>", HELO: "mx.example.org"}
+			case 3:
+				req.IP = nil
+			}
+			in <- req
+		}
+	}()
+
+	got := make(map[string]CheckResponse)
+	for resp := range b.Run(context.Background(), in) {
+		got[resp.Request.ID] = resp
+	}
+	require.Len(t, got, 40)
+	for id, resp := range got {
+		var i int
+		fmt.Sscan(id, &i)
+		switch i % 4 {
+		case 0, 2:
+			assert.Equal(t, Pass, resp.Result.Code, id)
+		case 1:
+			assert.Equal(t, Fail, resp.Result.Code, id)
+		case 3:
+			require.ErrorIs(t, resp.Err, ErrInvalidIP, id)
+		}
+	}
+	// the workers share the Checker's cache
+	assert.LessOrEqual(t, counting.queries["TXT example.com"], 4)
+	assert.True(t, ids.has("0") && ids.has("38"))
+}
+
+func TestBulkChecker_Canceled(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(delayedResolver{zoneResolver: optionsZone(), delay: 20 * time.Millisecond}))
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan CheckRequest, 100)
+	for range 100 {
+		in <- CheckRequest{Sample: Sample{IP: net.ParseIP("192.0.2.1"), MailFrom: "alice@example.com"}}
+	}
+	close(in)
+
+	out := NewBulkChecker(ch).Run(ctx, in)
+	<-out
+	cancel()
+	n := 0
+	for range out {
+		n++
+	}
+	assert.Less(t, n, 99, "queued requests are dropped")
+}
+
+// idSet is a set of strings safe for concurrent use.
+type idSet struct {
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func (s *idSet) add(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids == nil {
+		s.ids = make(map[string]bool)
+	}
+	s.ids[id] = true
+}
+
+func (s *idSet) has(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ids[id]
+}
@@ -0,0 +1,89 @@
+package spf
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// Causes of the results decided from the client address alone.
+var (
+	ErrAllowlisted = errors.New("client is allowlisted")
+	ErrDenylisted  = errors.New("client is denylisted")
+)
+
+// BypassList names the address list that decided a result without
+// evaluation.
+type BypassList string
+
+const (
+	BypassDenylist         BypassList = "denylist"          // WithDenylist
+	BypassAllowlist        BypassList = "allowlist"         // WithAllowlist
+	BypassTrustedForwarder BypassList = "trusted_forwarder" // LocalPolicy.TrustedForwarders
+)
+
+// Bypass reports that a result was decided before any DNS query because the
+// client address is listed.
+type Bypass struct {
+	List   BypassList
+	Prefix netip.Prefix // the listed network containing the client
+}
+
+func (b Bypass) String() string {
+	return string(b.List) + " " + b.Prefix.String()
+}
+
+// WithAllowlist makes clients within prefixes, such as internal relays,
+// Pass without evaluation.  The result has ErrAllowlisted as Cause and
+// reports the listed network in Bypass.  Several calls add up.
+func WithAllowlist(prefixes ...netip.Prefix) Option {
+	return func(c *Checker) { c.allowlist = append(c.allowlist, prefixes...) }
+}
+
+// WithDenylist makes clients within prefixes, such as known-bad ranges,
+// Fail without evaluation, leaving the decision to the Policy of the
+// Checker.  The result has ErrDenylisted as Cause and reports the listed
+// network in Bypass.  The denylist takes precedence over the allowlist and
+// the trusted forwarders of the LocalPolicy.  Several calls add up.
+func WithDenylist(prefixes ...netip.Prefix) Option {
+	return func(c *Checker) { c.denylist = append(c.denylist, prefixes...) }
+}
+
+// bypass returns the result of e decided by the address lists, if any.
+func (c *Checker) bypass(e *evaluation) (CheckHostResult, bool) {
+	addr, ok := netip.AddrFromSlice(e.ip)
+	if !ok {
+		return CheckHostResult{}, false
+	}
+	addr = addr.Unmap()
+	var trusted []netip.Prefix
+	if e.local != nil {
+		trusted = e.local.TrustedForwarders
+	}
+	for _, l := range []struct {
+		list     BypassList
+		prefixes []netip.Prefix
+		res      CheckHostResult
+	}{
+		{BypassDenylist, c.denylist, CheckHostResult{Code: Fail, Cause: ErrDenylisted}},
+		{BypassAllowlist, c.allowlist, CheckHostResult{Code: Pass, Cause: ErrAllowlisted}},
+		{BypassTrustedForwarder, trusted, CheckHostResult{Code: Pass, Cause: ErrTrustedForwarder}},
+	} {
+		if p, ok := containing(l.prefixes, addr); ok {
+			l.res.Bypass = &Bypass{List: l.list, Prefix: p}
+			return l.res, true
+		}
+	}
+
+	return CheckHostResult{}, false
+}
+
+// containing returns the first of prefixes that contains addr.
+func containing(prefixes []netip.Prefix, addr netip.Addr) (netip.Prefix, bool) {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return p, true
+		}
+	}
+
+	return netip.Prefix{}, false
+}
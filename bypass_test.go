@@ -0,0 +1,88 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker_Bypass(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24 -all"}}}
+	ch := NewChecker(NewCustomDNSResolver(zone),
+		WithAllowlist(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")),
+		WithDenylist(netip.MustParsePrefix("10.66.0.0/16")),
+		WithDenylist(netip.MustParsePrefix("192.0.2.128/25")),
+		WithLocalPolicy(LocalPolicy{TrustedForwarders: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}}),
+		WithPolicy(Policy{Fail: SoftFail}),
+	)
+	ctx := context.Background()
+
+	tests := []struct {
+		ip     string
+		want   Result
+		cause  error
+		bypass *Bypass
+	}{
+		{"10.1.2.3", Pass, ErrAllowlisted, &Bypass{BypassAllowlist, netip.MustParsePrefix("10.0.0.0/8")}},
+		{"::ffff:10.1.2.3", Pass, ErrAllowlisted, &Bypass{BypassAllowlist, netip.MustParsePrefix("10.0.0.0/8")}},
+		{"2001:db8::1", Pass, ErrAllowlisted, &Bypass{BypassAllowlist, netip.MustParsePrefix("2001:db8::/32")}},
+		{"10.66.0.1", SoftFail, ErrDenylisted, &Bypass{BypassDenylist, netip.MustParsePrefix("10.66.0.0/16")}},
+		{"192.0.2.200", SoftFail, ErrDenylisted, &Bypass{BypassDenylist, netip.MustParsePrefix("192.0.2.128/25")}},
+		{"203.0.113.9", Pass, ErrTrustedForwarder, &Bypass{BypassTrustedForwarder, netip.MustParsePrefix("203.0.113.0/24")}},
+		{"192.0.2.1", Pass, nil, nil},
+	}
+	for _, tt := range tests {
+		res, err := ch.CheckHost(ctx, net.ParseIP(tt.ip), "example.com", "")
+		require.NoError(t, err, tt.ip)
+		assert.Equal(t, tt.want, res.Code, tt.ip)
+		assert.Equal(t, tt.bypass, res.Bypass, tt.ip)
+		assert.Equal(t, "example.com", res.Domain, tt.ip)
+		if tt.cause == nil {
+			assert.NoError(t, res.Cause, tt.ip)
+			assert.Equal(t, 1, res.Stats.Queries, tt.ip)
+			continue
+		}
+		assert.ErrorIs(t, res.Cause, tt.cause, tt.ip)
+		assert.Zero(t, res.Stats.Queries, "no DNS for %s", tt.ip)
+	}
+
+	// a malformed domain is still None
+	res, err := ch.CheckHost(ctx, net.ParseIP("10.1.2.3"), "invalid..example", "")
+	require.NoError(t, err)
+	assert.Equal(t, None, res.Code)
+	assert.Nil(t, res.Bypass)
+}
+
+func TestChecker_BypassAudit(t *testing.T) {
+	var buf bytes.Buffer
+	ch := NewChecker(&zoneResolver{},
+		WithAllowlist(netip.MustParsePrefix("10.0.0.0/8")),
+		WithAuditSink(JSONAuditSink(&buf), nil),
+	)
+	_, err := ch.CheckHost(context.Background(), net.ParseIP("10.0.0.1"), "example.com", "")
+	require.NoError(t, err)
+
+	var entry AuditEntry
+	require.NoError(t, json.NewDecoder(&buf).Decode(&entry))
+	assert.Equal(t, Pass, entry.Result)
+	assert.Equal(t, "allowlist 10.0.0.0/8", entry.Bypass)
+	assert.Equal(t, ErrAllowlisted.Error(), entry.Cause)
+}
+
+func TestConfig_Bypass(t *testing.T) {
+	cfg, err := LoadConfig(strings.NewReader("allowlist: [10.0.0.0/8]\ndenylist: [\"2001:db8:bad::/48\"]"))
+	require.NoError(t, err)
+	ch := cfg.NewChecker()
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, ch.allowlist)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("2001:db8:bad::/48")}, ch.denylist)
+
+	_, err = LoadConfig(strings.NewReader("allowlist: [10.0.0.1]"))
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
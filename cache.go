@@ -0,0 +1,185 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"net"
+	"sync"
+	"time"
+)
+
+// Cache stores DNS answers between evaluations.  Implementations must be
+// safe for concurrent use and should expire entries.
+type Cache interface {
+	Get(key string) (any, bool)
+	Set(key string, value any)
+}
+
+// MemoryCache is a Cache keeping every entry in memory for a fixed TTL.
+// Entries are spread over shards with a lock each, so that concurrent
+// evaluations rarely wait for one another.
+type MemoryCache struct {
+	ttl   time.Duration
+	clock func() time.Time
+
+	seed   maphash.Seed
+	shards [memoryCacheShards]memoryShard
+}
+
+// memoryCacheShards is the number of shards of a MemoryCache.  Contention
+// falls off quickly with more shards than cores running evaluations.
+const memoryCacheShards = 32
+
+// memoryShard holds the entries of a MemoryCache whose keys hash to it.
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	stats   CacheStats
+	// keep shards on separate cache lines
+	_ [64]byte
+}
+
+// CacheStats counts the activity of a MemoryCache since its creation, to
+// size caches from observed hit rates instead of guessing.
+type CacheStats struct {
+	Hits      uint64 // Get calls answered from the cache
+	Misses    uint64 // Get calls finding no entry or an expired one
+	Evictions uint64 // expired entries removed
+	Entries   int    // entries held, including expired ones not yet evicted
+}
+
+type memoryEntry struct {
+	value   any
+	expires time.Time
+}
+
+// NewMemoryCache returns a MemoryCache keeping entries for ttl.  The Go
+// resolver does not report record TTLs, so one TTL applies to all answers.
+func NewMemoryCache(ttl time.Duration) *MemoryCache {
+	m := &MemoryCache{ttl: ttl, clock: time.Now, seed: maphash.MakeSeed()}
+	for i := range m.shards {
+		m.shards[i].entries = make(map[string]memoryEntry)
+	}
+
+	return m
+}
+
+// shard returns the shard holding key.
+func (m *MemoryCache) shard(key string) *memoryShard {
+	return &m.shards[maphash.String(m.seed, key)%memoryCacheShards]
+}
+
+// Get returns the value stored for key unless it expired.
+func (m *MemoryCache) Get(key string) (any, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		s.stats.Misses++
+		return nil, false
+	}
+	if !m.clock().Before(e.expires) {
+		delete(s.entries, key)
+		s.stats.Misses++
+		s.stats.Evictions++
+		return nil, false
+	}
+	s.stats.Hits++
+
+	return e.value, true
+}
+
+// Stats returns the statistics of m, summed over its shards.
+func (m *MemoryCache) Stats() CacheStats {
+	var stats CacheStats
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		stats.Hits += s.stats.Hits
+		stats.Misses += s.stats.Misses
+		stats.Evictions += s.stats.Evictions
+		stats.Entries += len(s.entries)
+		s.mu.Unlock()
+	}
+
+	return stats
+}
+
+// Set stores value for key.
+func (m *MemoryCache) Set(key string, value any) {
+	expires := m.clock().Add(m.ttl)
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryEntry{value: value, expires: expires}
+}
+
+// Len returns the number of entries, including expired ones not yet
+// evicted.
+func (m *MemoryCache) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		n += len(s.entries)
+		s.mu.Unlock()
+	}
+
+	return n
+}
+
+// cachedAnswer is the value stored in a Cache for one query.
+type cachedAnswer struct {
+	value any
+	err   error // nil or an NXDOMAIN error
+	// ttl is the TTL reported for the answer, counted from stored.
+	ttl    ttlRecorder
+	stored time.Time
+}
+
+// cacheMiddleware answers queries from cache.  Answers and NXDOMAIN errors are
+// cached; other errors, including temporary ones, are not.  A TTL reported for
+// an answer is reported again, less its age, when it is served from cache.
+func cacheMiddleware(cache Cache) queryMiddleware {
+	return func(ctx context.Context, q query, next queryFunc) (any, error) {
+		key := q.Type + " " + q.Name
+		if v, ok := cache.Get(key); ok {
+			if a, ok := v.(cachedAnswer); ok {
+				if stats := statsFrom(ctx); stats != nil {
+					stats.CacheHits++
+				}
+				if a.ttl.known {
+					ReportTTL(ctx, a.ttl.ttl-time.Since(a.stored))
+				}
+				return a.value, a.err
+			}
+		}
+
+		var ttl ttlRecorder
+		v, err := next(withTTL(ctx, &ttl))
+		if ttl.known {
+			ReportTTL(ctx, ttl.ttl)
+		}
+		var dnsErr *net.DNSError
+		if err == nil || errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			cache.Set(key, cachedAnswer{value: v, err: err, ttl: ttl, stored: time.Now()})
+		}
+
+		return v, err
+	}
+}
+
+// statsKey is the context key under which an evaluation publishes its
+// EvalStats to the query middleware.
+type statsKey struct{}
+
+func withStats(ctx context.Context, stats *EvalStats) context.Context {
+	return context.WithValue(ctx, statsKey{}, stats)
+}
+
+func statsFrom(ctx context.Context) *EvalStats {
+	stats, _ := ctx.Value(statsKey{}).(*EvalStats)
+	return stats
+}
@@ -0,0 +1,78 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Canary runs the same checks through the Checker in service (Current) and
+// a reconfigured one (Candidate), e.g. with a new resolver or cache, to
+// roll the change out only when both agree.
+type Canary struct {
+	Current, Candidate *Checker
+	// LatencyTolerance is how much longer than Current the Candidate may
+	// take for a sample before the sample is reported as slower.  Zero
+	// reports every sample the Candidate takes longer for.
+	LatencyTolerance time.Duration
+}
+
+// CanaryOutcome is the result of one sample with both Checkers.
+type CanaryOutcome struct {
+	Sample             Sample
+	Current, Candidate CheckHostResult
+}
+
+// CanaryReport summarizes a canary run.
+type CanaryReport struct {
+	Samples int
+	// Transitions counts the samples per pair of results, from Current to
+	// Candidate, unchanged ones included.
+	Transitions map[Transition]int
+	// Divergences lists the samples with different results, and Slower
+	// those the Candidate took longer for than LatencyTolerance allows, in
+	// the order given.
+	Divergences []CanaryOutcome
+	Slower      []CanaryOutcome
+	// CurrentTime and CandidateTime are the evaluation times of all samples.
+	CurrentTime, CandidateTime time.Duration
+}
+
+func (r CanaryReport) String() string {
+	return fmt.Sprintf("%d of %d samples diverge, %d slower (%s current, %s candidate)",
+		len(r.Divergences), r.Samples, len(r.Slower), r.CurrentTime, r.CandidateTime)
+}
+
+// Compare checks every sample with both Checkers and reports where the
+// Candidate differs in result or is slower.  Identities are checked as by
+// Replay.  Samples are evaluated one after another, by Current first; the
+// Checkers should not share a cache, or the Candidate would be measured
+// with answers Current just fetched.  Compare stops at the first context
+// error or invalid sample.
+func (c *Canary) Compare(ctx context.Context, samples []Sample) (CanaryReport, error) {
+	report := CanaryReport{Transitions: make(map[Transition]int)}
+	for i, s := range samples {
+		current, err := replaySample(ctx, c.Current, s)
+		if err != nil {
+			return report, fmt.Errorf("sample %d: %w", i, err)
+		}
+		candidate, err := replaySample(ctx, c.Candidate, s)
+		if err != nil {
+			return report, fmt.Errorf("sample %d: %w", i, err)
+		}
+
+		report.Samples++
+		report.Transitions[Transition{Before: current.Code, After: candidate.Code}]++
+		report.CurrentTime += current.Stats.Duration
+		report.CandidateTime += candidate.Stats.Duration
+		outcome := CanaryOutcome{Sample: s, Current: current, Candidate: candidate}
+		if current.Code != candidate.Code {
+			report.Divergences = append(report.Divergences, outcome)
+		}
+		if candidate.Stats.Duration > current.Stats.Duration+c.LatencyTolerance {
+			report.Slower = append(report.Slower, outcome)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,61 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// delayedResolver answers TXT queries from a zone after delay.
+type delayedResolver struct {
+	*zoneResolver
+	delay time.Duration
+}
+
+func (d delayedResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	time.Sleep(d.delay)
+	return d.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func TestCanary(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"corp.example":      {"v=spf1 include:_spf.corp.example ~all"},
+		"_spf.corp.example": {"v=spf1 ip4:192.0.2.0/24"},
+		"vendor.example":    {"v=spf1 ip4:198.51.100.0/24 -all"},
+	}}
+	samples := []Sample{
+		{IP: net.ParseIP("192.0.2.10"), MailFrom: "alice@corp.example"},
+		{IP: net.ParseIP("203.0.113.5"), MailFrom: "bob@corp.example"},
+		{IP: net.ParseIP("198.51.100.7"), MailFrom: "<>", HELO: "vendor.example"},
+	}
+	canary := Canary{
+		Current: NewChecker(NewCustomDNSResolver(zone)),
+		// a stale override and a slow resolver
+		Candidate: NewChecker(NewCustomDNSResolver(delayedResolver{zoneResolver: zone, delay: 30 * time.Millisecond}),
+			WithRecordOverrides(map[string]string{"corp.example": "v=spf1 include:_spf.corp.example -all"})),
+		LatencyTolerance: 20 * time.Millisecond,
+	}
+
+	report, err := canary.Compare(context.Background(), samples)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Samples)
+	assert.Equal(t, map[Transition]int{
+		{Before: Pass, After: Pass}:     2,
+		{Before: SoftFail, After: Fail}: 1,
+	}, report.Transitions)
+	require.Len(t, report.Divergences, 1)
+	assert.Equal(t, samples[1], report.Divergences[0].Sample)
+	assert.Len(t, report.Slower, 3)
+	assert.Greater(t, report.CandidateTime, report.CurrentTime)
+	assert.Contains(t, report.String(), "1 of 3 samples diverge, 3 slower")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err = canary.Compare(ctx, samples)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Zero(t, report.Samples)
+}
@@ -0,0 +1,93 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// TermError is the Cause of a TempError or PermError raised by a term of a
+// published record: a syntax error, a lookup limit exceeded, a failed DNS
+// query or a missing include target.  When the term is in a record reached
+// through include or redirect, Domain is the domain publishing that record
+// rather than the queried domain.
+type TermError struct {
+	Domain string // domain whose record contains the term
+	// Index is the position of the term among the terms after "v=spf1",
+	// from 0, as in parser.Record.Terms.
+	Index int
+	Term  string // the term as published
+	Err   error
+}
+
+func (e *TermError) Error() string {
+	return fmt.Sprintf("%s term %d %q: %v", e.Domain, e.Index, e.Term, e.Err)
+}
+
+func (e *TermError) Unwrap() error {
+	return e.Err
+}
+
+// termError wraps err, raised by the term of rec at index, in a TermError.
+// Errors already carrying the provenance of a more deeply nested term, and
+// context errors, are returned unchanged.
+func termError(domain string, rec *parser.Record, index int, err error) error {
+	var te *TermError
+	if index < 0 || index >= len(rec.Terms) || errors.As(err, &te) || contextError(err) != nil {
+		return err
+	}
+
+	return &TermError{Domain: domain, Index: index, Term: rec.Terms[index], Err: err}
+}
+
+// mechanismIndex returns the position in rec.Terms of rec.Mechs[i]:
+// mechanisms are the terms without "=" (RFC 7208 section 4.6.1).
+func mechanismIndex(rec *parser.Record, i int) int {
+	for j, term := range rec.Terms {
+		if strings.Contains(term, "=") {
+			continue
+		}
+		if i == 0 {
+			return j
+		}
+		i--
+	}
+
+	return -1
+}
+
+// modifierIndex returns the position in rec.Terms of the modifier name.
+func modifierIndex(rec *parser.Record, name string) int {
+	for j, term := range rec.Terms {
+		if key, _, ok := strings.Cut(term, "="); ok && strings.EqualFold(key, name) {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// syntaxError wraps err, returned by parser.ParseWith for the record text of
+// domain, in a TermError for the first term whose addition makes the record
+// invalid.  Errors about the record as a whole, such as a missing version or
+// too many terms, are returned unchanged.
+func (c *Checker) syntaxError(domain, text string, err error) error {
+	l := c.parseLimits
+	if l.MaxLength > 0 && len(text) > l.MaxLength {
+		return err
+	}
+	fields := strings.Fields(text)
+	if len(fields) < 2 || l.MaxTerms > 0 && len(fields)-1 > l.MaxTerms {
+		return err
+	}
+	terms := fields[1:]
+	for i := range terms {
+		if _, perr := c.parse("v=spf1 " + strings.Join(terms[:i+1], " ")); perr != nil {
+			return &TermError{Domain: domain, Index: i, Term: terms[i], Err: err}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,89 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailspire/spf/parser"
+)
+
+func TestTermError(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":         {"v=spf1 ip4:192.0.2.0/24 include:_spf.example.com -all"},
+		"_spf.example.com":    {"v=spf1 exp=explain.example.com a:mail.example.com ipv4:198.51.100.1 -all"},
+		"missing.example.com": {"v=spf1 mx include:nowhere.example.com ~all"},
+		"moved.example.com":   {"v=spf1 exp=explain.example.com redirect=nowhere.example.com"},
+		"dup.example.com":     {"v=spf1 redirect=a.example.com mx redirect=b.example.com"},
+		"version.example.com": {"v=spf1"},
+	}}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ip := net.ParseIP("203.0.113.1")
+	ctx := context.Background()
+
+	var te *TermError
+	for domain, want := range map[string]TermError{
+		// the term of the included record, not the include
+		"example.com":         {Domain: "_spf.example.com", Index: 2, Term: "ipv4:198.51.100.1", Err: parser.ErrUnknownMechanism},
+		"missing.example.com": {Domain: "missing.example.com", Index: 1, Term: "include:nowhere.example.com", Err: ErrMissingRecord},
+		"moved.example.com":   {Domain: "moved.example.com", Index: 1, Term: "redirect=nowhere.example.com", Err: ErrMissingRecord},
+		"dup.example.com":     {Domain: "dup.example.com", Index: 2, Term: "redirect=b.example.com", Err: parser.ErrDuplicateModifier},
+	} {
+		res, err := ch.CheckHost(ctx, ip, domain, "")
+		require.NoError(t, err, domain)
+		assert.Equal(t, PermError, res.Code, domain)
+		require.ErrorAs(t, res.Cause, &te, domain)
+		assert.Equal(t, want.Domain, te.Domain, domain)
+		assert.Equal(t, want.Index, te.Index, domain)
+		assert.Equal(t, want.Term, te.Term, domain)
+		require.ErrorIs(t, res.Cause, want.Err, domain)
+	}
+
+	res, err := ch.CheckHost(ctx, ip, "version.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	assert.False(t, errors.As(res.Cause, &te), "no term to blame")
+
+	res, err = ch.CheckHost(ctx, ip, "missing.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, `missing.example.com term 1 "include:nowhere.example.com": include:nowhere.example.com: `+ErrMissingRecord.Error(), res.Cause.Error())
+}
+
+func TestTermError_Limits(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com": {"v=spf1 a:a.example.com a:b.example.com a:c.example.com -all"},
+	}}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithMaxLookups(2))
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("203.0.113.1"), "example.com", "")
+	require.NoError(t, err)
+	require.ErrorIs(t, res.Cause, ErrTooManyLookups)
+	var te *TermError
+	require.ErrorAs(t, res.Cause, &te)
+	assert.Equal(t, TermError{Domain: "example.com", Index: 2, Term: "a:c.example.com", Err: te.Err}, *te)
+}
+
+func TestChecker_ParseLimits(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":     {"v=spf1 mx include:a.b.c.d.example.com -all"},
+		"big.example.com": {"v=spf1 mx a ptr -all"},
+	}}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithParseLimits(parser.Limits{MaxTerms: 3, MaxLabels: 4}))
+	ip := net.ParseIP("203.0.113.1")
+
+	res, err := ch.CheckHost(context.Background(), ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	require.ErrorIs(t, res.Cause, parser.ErrLimitExceeded)
+	var te *TermError
+	require.ErrorAs(t, res.Cause, &te)
+	assert.Equal(t, "include:a.b.c.d.example.com", te.Term)
+
+	res, err = ch.CheckHost(context.Background(), ip, "big.example.com", "")
+	require.NoError(t, err)
+	require.ErrorIs(t, res.Cause, parser.ErrLimitExceeded)
+	assert.False(t, errors.As(res.Cause, &te), "the record as a whole is too long")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/mailspire/spf"
+)
+
+// configEnv names the environment variable holding the path of a
+// configuration file, as read by spf.LoadConfigFile, for the Checker.
+const configEnv = "SPF_CONFIG"
+
+// checker returns the Checker configured by the file named in configEnv, or
+// spf.Default when it is unset.  The file is read once.
+var checker = sync.OnceValues(func() (*spf.Checker, error) {
+	return loadChecker(os.Getenv(configEnv))
+})
+
+// loadChecker returns the Checker configured by the file at path, or
+// spf.Default for an empty path.
+func loadChecker(path string) (*spf.Checker, error) {
+	if path == "" {
+		return spf.Default(), nil
+	}
+	cfg, err := spf.LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg.NewChecker(), nil
+}
+
+// errorJSON encodes a check_host outcome without result.
+func errorJSON(err error) []byte {
+	b, _ := json.Marshal(checkResponse{Error: err.Error()})
+
+	return b
+}
+
+// checkResponse is the JSON object returned by check_host.
+type checkResponse struct {
+	Result      spf.Result `json:"result"`
+	Domain      string     `json:"domain,omitempty"`
+	Scope       spf.Scope  `json:"scope,omitempty"`
+	Mechanism   string     `json:"mechanism,omitempty"`
+	Explanation string     `json:"explanation,omitempty"`
+	Cause       string     `json:"cause,omitempty"`
+	Lookups     int        `json:"lookups"`
+	TTL         int64      `json:"ttl,omitempty"` // seconds
+	Error       string     `json:"error,omitempty"`
+}
+
+// checkJSON evaluates the arguments of check_host with ch and encodes the
+// outcome.
+func checkJSON(ctx context.Context, ch *spf.Checker, ip, domain, sender, helo string) []byte {
+	var out checkResponse
+	addr := net.ParseIP(ip)
+	if domain == "" {
+		domain = senderDomain(sender, helo)
+	}
+	res, err := ch.CheckHostWithHELO(ctx, addr, domain, sender, helo)
+	switch {
+	case res.Code == "" && addr == nil:
+		out.Error = spf.ErrInvalidIP.Error() + ": " + ip
+	case res.Code == "" && err != nil:
+		out.Error = err.Error()
+	default:
+		out = checkResponse{
+			Result:      res.Code,
+			Domain:      res.Domain,
+			Scope:       res.Scope,
+			Explanation: res.Explanation,
+			Lookups:     res.Stats.Lookups,
+			TTL:         int64(res.TTL.Seconds()),
+		}
+		if res.Match != nil {
+			out.Mechanism = res.Match.Term
+		}
+		if res.Cause != nil {
+			out.Cause = res.Cause.Error()
+		}
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return errorJSON(err)
+	}
+
+	return b
+}
+
+// senderDomain returns the domain of the MAIL FROM sender, or else helo, as
+// RFC 7208 section 2.4 does for the null reverse-path.
+func senderDomain(sender, helo string) string {
+	if _, domain, err := spf.ParseReversePath(sender); err == nil && domain != "" {
+		return domain
+	}
+
+	return helo
+}
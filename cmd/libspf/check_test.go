@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mailspire/spf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zone answers TXT queries from a map and NXDOMAIN otherwise.
+type zone map[string][]string
+
+func (z zone) LookupTXT(_ context.Context, domain string) ([]string, error) {
+	if txts, ok := z[domain]; ok {
+		return txts, nil
+	}
+
+	return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+}
+
+func TestCheckJSON(t *testing.T) {
+	ch := spf.NewChecker(spf.NewCustomDNSResolver(zone{
+		"example.com":      {"v=spf1 ip4:192.0.2.0/24 -all"},
+		"mail.example.net": {"v=spf1 -all"},
+		"broken.example":   {"v=spf1 bogus -all"},
+	}))
+	ctx := context.Background()
+	decode := func(b []byte) map[string]any {
+		var m map[string]any
+		require.NoError(t, json.Unmarshal(b, &m))
+		return m
+	}
+
+	assert.JSONEq(t, `{"result":"pass","domain":"example.com","scope":"mfrom","mechanism":"ip4:192.0.2.0/24","lookups":0}`,
+		string(checkJSON(ctx, ch, "192.0.2.1", "example.com", "alice@example.com", "mail.example.net")))
+	assert.JSONEq(t, `{"result":"fail","domain":"example.com","scope":"mfrom","mechanism":"-all","lookups":0}`,
+		string(checkJSON(ctx, ch, "198.51.100.1", "", "<alice@example.com>", "")), "domain taken from the sender")
+
+	m := decode(checkJSON(ctx, ch, "198.51.100.1", "", "<>", "mail.example.net"))
+	assert.Equal(t, "mail.example.net", m["domain"], "domain taken from HELO for bounces")
+	assert.Equal(t, "fail", m["result"])
+
+	m = decode(checkJSON(ctx, ch, "192.0.2.1", "broken.example", "", ""))
+	assert.Equal(t, "permerror", m["result"])
+	assert.NotEmpty(t, m["cause"])
+
+	m = decode(checkJSON(ctx, ch, "not-an-ip", "example.com", "", ""))
+	assert.Equal(t, "", m["result"])
+	assert.Contains(t, m["error"], "not-an-ip")
+}
+
+func TestCheckJSON_Errors(t *testing.T) {
+	ch := spf.NewChecker(spf.NewCustomDNSResolver(zone{}))
+	ch.Close()
+
+	m := map[string]any{}
+	require.NoError(t, json.Unmarshal(checkJSON(context.Background(), ch, "192.0.2.1", "example.com", "", ""), &m))
+	assert.Equal(t, "", m["result"])
+	assert.Equal(t, spf.ErrClosed.Error(), m["error"])
+}
+
+func TestLoadChecker(t *testing.T) {
+	ch, err := loadChecker("")
+	require.NoError(t, err)
+	assert.Same(t, spf.Default(), ch)
+
+	path := filepath.Join(t.TempDir(), "spf.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("overrides: {example.com: \"v=spf1 -all\"}\n"), 0o600))
+	ch, err = loadChecker(path)
+	require.NoError(t, err)
+	defer ch.Close()
+	assert.JSONEq(t, `{"result":"fail","domain":"example.com","scope":"mfrom","mechanism":"-all","lookups":0}`,
+		string(checkJSON(context.Background(), ch, "192.0.2.1", "example.com", "alice@example.com", "")))
+
+	_, err = loadChecker(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.Error(t, err)
+	assert.Contains(t, string(errorJSON(err)), `"result":"","lookups":0,"error":`)
+}
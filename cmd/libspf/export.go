@@ -0,0 +1,32 @@
+package main
+
+// #include <stdlib.h>
+import "C"
+
+import (
+	"context"
+	"time"
+	"unsafe"
+)
+
+// checkTimeout bounds each call, the 20 seconds RFC 7208 section 4.6.4
+// suggests as the least limit, since C callers cannot cancel.
+const checkTimeout = 20 * time.Second
+
+//export check_host
+func check_host(ip, domain, sender, helo *C.char) *C.char {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	ch, err := checker()
+	if err != nil {
+		return C.CString(string(errorJSON(err)))
+	}
+	out := checkJSON(ctx, ch, C.GoString(ip), C.GoString(domain), C.GoString(sender), C.GoString(helo))
+
+	return C.CString(string(out))
+}
+
+//export spf_free
+func spf_free(p *C.char) {
+	C.free(unsafe.Pointer(p))
+}
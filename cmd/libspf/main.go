@@ -0,0 +1,25 @@
+// Command libspf builds the checker as a C shared library, so that MTAs and
+// filters not written in Go, such as Exim or rspamd plugins, can embed it:
+//
+//	go build -buildmode=c-shared -o libspf.so ./cmd/libspf
+//
+// The build also writes libspf.h, declaring:
+//
+//	char *check_host(char *ip, char *domain, char *sender, char *helo);
+//	void spf_free(char *p);
+//
+// check_host runs check_host() of RFC 7208 and returns a JSON object the
+// caller releases with spf_free:
+//
+//	{"result":"pass","domain":"example.com","scope":"mfrom",
+//	 "mechanism":"ip4:192.0.2.0/24","lookups":1,"ttl":300}
+//
+// The Checker is the default one, using the system resolver, unless the
+// environment variable SPF_CONFIG names a configuration file as read by
+// spf.LoadConfigFile.  Failures without a result, such as a malformed
+// address or configuration file, set "error" and leave "result" empty.
+// sender and helo may be empty; when domain is, it is taken from sender and
+// then from helo.  Calls are safe from several threads.
+package main
+
+func main() {}
@@ -0,0 +1,21 @@
+// Command spf-lsp is a Language Server Protocol server for SPF records.  It
+// finds "v=spf1" TXT values in any document, such as zone files or
+// DNS-as-code configuration, and offers parse and lint diagnostics, hover
+// documentation of mechanisms and modifiers, and term completion.
+//
+// The server speaks JSON-RPC over standard input and output:
+//
+//	spf-lsp
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	if err := newServer(os.Stdin, os.Stdout).run(); err != nil {
+		fmt.Fprintln(os.Stderr, "spf-lsp:", err)
+		os.Exit(1)
+	}
+}
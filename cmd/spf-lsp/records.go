@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/mailspire/spf"
+	"github.com/mailspire/spf/parser"
+)
+
+// LSP diagnostic severities.
+const (
+	severityError   = 1
+	severityWarning = 2
+)
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // UTF-16 code units
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+// record is an SPF record found in a document, within one line.
+type record struct {
+	line       string
+	lineNo     int
+	start, end int // byte offsets of the record in line
+}
+
+func (r record) text() string { return r.line[r.start:r.end] }
+
+// rangeOf returns the LSP range of the bytes [from, to) of the record.
+func (r record) rangeOf(from, to int) lspRange {
+	return lspRange{
+		Start: position{Line: r.lineNo, Character: utf16Len(r.line[:r.start+from])},
+		End:   position{Line: r.lineNo, Character: utf16Len(r.line[:r.start+to])},
+	}
+}
+
+// term is one whitespace-separated term of a record.
+type term struct {
+	text       string
+	start, end int // byte offsets in the record
+}
+
+// terms returns the terms of r after the version.
+func (r record) terms() []term {
+	var out []term
+	text := r.text()
+	for i := 0; i < len(text); {
+		for i < len(text) && text[i] == ' ' {
+			i++
+		}
+		j := i
+		for j < len(text) && text[j] != ' ' {
+			j++
+		}
+		if j > i {
+			out = append(out, term{text: text[i:j], start: i, end: j})
+		}
+		i = j
+	}
+	if len(out) > 0 {
+		out = out[1:]
+	}
+
+	return out
+}
+
+// findRecords returns the SPF records in text: each runs from "v=spf1" to
+// the closing quote or the end of its line.
+func findRecords(text string) []record {
+	var out []record
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		lower := strings.ToLower(line)
+		for from := 0; ; {
+			i := strings.Index(lower[from:], "v=spf1")
+			if i < 0 {
+				break
+			}
+			start := from + i
+			end := len(line)
+			if j := strings.IndexAny(line[start:], "\"'`"); j >= 0 {
+				end = start + j
+			}
+			end = start + len(strings.TrimRight(line[start:end], " \t"))
+			out = append(out, record{line: line, lineNo: n, start: start, end: end})
+			from = max(end, start+1)
+		}
+	}
+
+	return out
+}
+
+// diagnostics returns the parse errors and lint warnings of every record in
+// text.
+func diagnostics(text string) []diagnostic {
+	diags := []diagnostic{}
+	for _, r := range findRecords(text) {
+		diags = append(diags, recordDiagnostics(r)...)
+	}
+
+	return diags
+}
+
+func recordDiagnostics(r record) []diagnostic {
+	var diags []diagnostic
+	add := func(from, to, severity int, msg string) {
+		diags = append(diags, diagnostic{Range: r.rangeOf(from, to), Severity: severity, Source: "spf", Message: msg})
+	}
+
+	terms := r.terms()
+	for _, t := range terms {
+		if _, err := parser.Parse("v=spf1 " + t.text); err != nil {
+			add(t.start, t.end, severityError, err.Error())
+		}
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+	rec, err := parser.Parse(r.text())
+	if err != nil {
+		add(0, len(r.text()), severityError, err.Error())
+		return diags
+	}
+	for _, w := range spf.Lint(rec) {
+		from, to := 0, len(r.text())
+		for _, t := range terms {
+			if w.Term != "" && strings.EqualFold(t.text, w.Term) {
+				from, to = t.start, t.end
+				break
+			}
+		}
+		severity := severityWarning
+		if w.Severity == spf.SeverityError {
+			severity = severityError
+		}
+		add(from, to, severity, w.Message)
+	}
+
+	return diags
+}
+
+// at returns the record of text containing pos and the byte offset of pos
+// within it.
+func at(text string, pos position) (record, int, bool) {
+	for _, r := range findRecords(text) {
+		if r.lineNo != pos.Line {
+			continue
+		}
+		offset := byteOffset(r.line, pos.Character) - r.start
+		if offset >= 0 && offset <= r.end-r.start {
+			return r, offset, true
+		}
+	}
+
+	return record{}, 0, false
+}
+
+// hover documents the mechanism or modifier under pos.
+func hover(text string, pos position) any {
+	r, offset, ok := at(text, pos)
+	if !ok {
+		return nil
+	}
+	for _, t := range r.terms() {
+		if offset < t.start || offset > t.end {
+			continue
+		}
+		doc, ok := termDoc(t.text)
+		if !ok {
+			return nil
+		}
+		kind := "mechanism"
+		if doc.Modifier {
+			kind = "modifier"
+		}
+		return map[string]any{
+			"contents": map[string]string{
+				"kind":  "markdown",
+				"value": fmt.Sprintf("**%s** %s: %s\n\n`%s`\n\nRFC 7208 section %s", doc.Name, kind, doc.Detail, doc.Template, doc.Section),
+			},
+			"range": r.rangeOf(t.start, t.end),
+		}
+	}
+
+	return nil
+}
+
+// termDoc returns the documentation of the mechanism or modifier of tok.
+func termDoc(tok string) (parser.TermDoc, bool) {
+	name := strings.TrimLeft(tok, "+-~?")
+	if end := strings.IndexAny(name, ":=/"); end >= 0 {
+		name = name[:end]
+	}
+	for _, doc := range parser.TermDocs {
+		if strings.EqualFold(doc.Name, name) {
+			return doc, true
+		}
+	}
+
+	return parser.TermDoc{}, false
+}
+
+// complete returns the completion items for pos.
+func complete(text string, pos position) any {
+	items := []map[string]any{}
+	r, offset, ok := at(text, pos)
+	if !ok {
+		return items
+	}
+	start, completions := parser.Complete(r.text(), offset)
+	edit := r.rangeOf(start, offset)
+	for _, c := range completions {
+		items = append(items, map[string]any{
+			"label":         c.Label,
+			"detail":        c.Template,
+			"textEdit":      map[string]any{"range": edit, "newText": c.Label},
+			"documentation": c.Detail,
+		})
+	}
+
+	return items
+}
+
+// utf16Len returns the length of s in UTF-16 code units.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += max(utf16.RuneLen(r), 1)
+	}
+
+	return n
+}
+
+// byteOffset converts a UTF-16 column of line into a byte offset.
+func byteOffset(line string, column int) int {
+	units := 0
+	for i, r := range line {
+		if units >= column {
+			return i
+		}
+		units += max(utf16.RuneLen(r), 1)
+	}
+
+	return len(line)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindRecords(t *testing.T) {
+	text := "example.com. IN TXT \"v=spf1 mx -all\"\r\nvalue: 'V=SPF1 a ~all'  # note\nother: x\nspf: v=spf1 -all   "
+	var got []string
+	for _, r := range findRecords(text) {
+		got = append(got, r.text())
+	}
+	assert.Equal(t, []string{"v=spf1 mx -all", "V=SPF1 a ~all", "v=spf1 -all"}, got)
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		severity []int
+		start    []int
+	}{
+		{"valid", `"v=spf1 mx -all"`, nil, nil},
+		{"unknown terms", `"v=spf1 includ:x.example.com hello -all"`, []int{severityError, severityError}, []int{8, 29}},
+		{"duplicate modifier", `"v=spf1 redirect=a.example.com redirect=b.example.com"`, []int{severityError}, []int{1}},
+		{"lint", `"v=spf1 -all redirect=a.example.com"`, []int{severityWarning}, []int{13}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diags := diagnostics(tt.text)
+			var severity, start []int
+			for _, d := range diags {
+				severity = append(severity, d.Severity)
+				start = append(start, d.Range.Start.Character)
+			}
+			assert.Equal(t, tt.severity, severity)
+			assert.Equal(t, tt.start, start)
+		})
+	}
+}
+
+func TestHover(t *testing.T) {
+	text := "a TXT \"v=spf1 ip4:192.0.2.0/24 ~all\""
+	assert.Nil(t, hover(text, position{Line: 0, Character: 2}))
+	assert.Nil(t, hover(text, position{Line: 1, Character: 10}))
+
+	h, ok := hover(text, position{Line: 0, Character: 16}).(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, lspRange{Start: position{0, 14}, End: position{0, 30}}, h["range"])
+	assert.Contains(t, h["contents"].(map[string]string)["value"], "RFC 7208 section 5.6")
+}
+
+func TestComplete(t *testing.T) {
+	// the UTF-16 column counts "é" as one unit although it is two bytes
+	text := "é \"v=spf1 in"
+	items := complete(text, position{Line: 0, Character: 12}).([]map[string]any)
+	require.Len(t, items, 1)
+	assert.Equal(t, "include:", items[0]["label"])
+	edit := items[0]["textEdit"].(map[string]any)
+	assert.Equal(t, lspRange{Start: position{0, 10}, End: position{0, 12}}, edit["range"])
+
+	assert.Empty(t, complete(text, position{Line: 3, Character: 0}))
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC error codes used by the server.
+const (
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// message is a JSON-RPC request, notification or response.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// server holds the open documents of one client connection.
+type server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]string // text per document URI
+}
+
+func newServer(in io.Reader, out io.Writer) *server {
+	return &server{in: bufio.NewReader(in), out: out, docs: make(map[string]string)}
+}
+
+// run serves requests until the client sends exit or closes the input.
+func (s *server) run() error {
+	for {
+		msg, err := s.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads one message framed by a Content-Length header.
+func (s *server) read() (*message, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+func (s *server) write(msg message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+
+	return err
+}
+
+func (s *server) notify(method string, params any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return s.write(message{Method: method, Params: raw})
+}
+
+// handle dispatches one message and answers requests.
+func (s *server) handle(msg *message) error {
+	result, rpcErr := s.dispatch(msg)
+	if msg.ID == nil {
+		return nil
+	}
+	if rpcErr != nil {
+		return s.write(message{ID: msg.ID, Error: rpcErr})
+	}
+	if result == nil {
+		// a null result must still be sent
+		result = json.RawMessage("null")
+	}
+
+	return s.write(message{ID: msg.ID, Result: result})
+}
+
+func (s *server) dispatch(msg *message) (any, *rpcError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // full
+				"hoverProvider":      true,
+				"completionProvider": map[string]any{"triggerCharacters": []string{" ", "-", "~", "?", "+"}},
+			},
+			"serverInfo": map[string]string{"name": "spf-lsp"},
+		}, nil
+	case "initialized", "shutdown", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument   struct{ URI string }    `json:"textDocument"`
+			ContentChanges []struct{ Text string } `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			return nil, s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p struct {
+			TextDocument struct{ URI string } `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.docs, p.TextDocument.URI)
+		if err := s.publish(p.TextDocument.URI, []diagnostic{}); err != nil {
+			return nil, internalError(err)
+		}
+		return nil, nil
+	case "textDocument/hover", "textDocument/completion":
+		var p struct {
+			TextDocument struct{ URI string } `json:"textDocument"`
+			Position     position             `json:"position"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, invalidParams(err)
+		}
+		text := s.docs[p.TextDocument.URI]
+		if msg.Method == "textDocument/hover" {
+			return hover(text, p.Position), nil
+		}
+		return complete(text, p.Position), nil
+	}
+	if msg.ID == nil {
+		return nil, nil // unknown notifications are ignored
+	}
+
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+}
+
+// update stores the new text of a document and publishes its diagnostics.
+func (s *server) update(uri, text string) *rpcError {
+	s.docs[uri] = text
+	if err := s.publish(uri, diagnostics(text)); err != nil {
+		return internalError(err)
+	}
+
+	return nil
+}
+
+func (s *server) publish(uri string, diags []diagnostic) error {
+	return s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": diags})
+}
+
+func invalidParams(err error) *rpcError {
+	return &rpcError{Code: codeInvalidParams, Message: strings.TrimSpace(err.Error())}
+}
+
+func internalError(err error) *rpcError {
+	return &rpcError{Code: codeInternalError, Message: err.Error()}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// frame encodes messages as a client would send them.
+func frame(t *testing.T, msgs ...string) *bytes.Buffer {
+	var b bytes.Buffer
+	for _, m := range msgs {
+		require.True(t, json.Valid([]byte(m)), m)
+		fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	return &b
+}
+
+// responses decodes the messages written by the server.
+func responses(t *testing.T, out *bytes.Buffer) []map[string]any {
+	var msgs []map[string]any
+	s := newServer(out, nil)
+	for {
+		msg, err := s.read()
+		if err != nil {
+			break
+		}
+		raw, err := json.Marshal(msg)
+		require.NoError(t, err)
+		var m map[string]any
+		require.NoError(t, json.Unmarshal(raw, &m))
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+func TestServer_Session(t *testing.T) {
+	in := frame(t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///zone","text":"@ IN TXT \"v=spf1 ipv4:192.0.2.1 -all\""}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///zone"},"position":{"line":0,"character":36}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/completion","params":{"textDocument":{"uri":"file:///zone"},"position":{"line":0,"character":35}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"workspace/symbol","params":{}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+	)
+	var out bytes.Buffer
+	require.NoError(t, newServer(in, &out).run())
+
+	msgs := responses(t, &out)
+	require.Len(t, msgs, 6, "nothing is answered after exit")
+
+	assert.EqualValues(t, 1, msgs[0]["id"])
+	caps := msgs[0]["result"].(map[string]any)["capabilities"].(map[string]any)
+	assert.Equal(t, true, caps["hoverProvider"])
+
+	assert.Equal(t, "textDocument/publishDiagnostics", msgs[1]["method"])
+	diags := msgs[1]["params"].(map[string]any)["diagnostics"].([]any)
+	require.Len(t, diags, 1)
+	assert.Contains(t, diags[0].(map[string]any)["message"], `did you mean "ip4:192.0.2.1"?`)
+
+	hover := msgs[2]["result"].(map[string]any)["contents"].(map[string]any)["value"]
+	assert.True(t, strings.HasPrefix(hover.(string), "**all** mechanism"), hover)
+
+	items := msgs[3]["result"].([]any)
+	require.NotEmpty(t, items)
+	assert.Equal(t, "-all", items[0].(map[string]any)["label"])
+
+	assert.EqualValues(t, codeMethodNotFound, msgs[4]["error"].(map[string]any)["code"])
+	assert.EqualValues(t, 5, msgs[5]["id"])
+	assert.Nil(t, msgs[5]["result"])
+}
+
+func TestServer_InvalidFrame(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("Content-Length: x\r\n\r\n{}"))
+	s := &server{in: in, docs: map[string]string{}}
+	require.Error(t, s.run())
+}
@@ -0,0 +1,78 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooComplex is matched by the ComplexityError of an evaluation beyond
+// the ComplexityLimits of its Checker.
+var ErrTooComplex = errors.New("permerror: record too complex")
+
+// ComplexityLimit names a limit of ComplexityLimits.
+type ComplexityLimit string
+
+// Limits of ComplexityLimits, as reported by ComplexityError.
+const (
+	LimitTerms           ComplexityLimit = "terms"
+	LimitMacroExpansions ComplexityLimit = "macro expansions"
+	LimitExists          ComplexityLimit = "exists mechanisms"
+)
+
+// ComplexityLimits bounds the work of one evaluation that the DNS lookup
+// limits of RFC 7208 section 4.6.4 leave unbounded: a record of thousands
+// of ip4 terms, or of macros expanded against a cache, costs CPU without a
+// single query.  The counts span the include and redirect tree.  Zero
+// disables a limit; a Checker has no ComplexityLimits unless
+// WithComplexityLimits is given.
+type ComplexityLimits struct {
+	MaxTerms int // mechanisms evaluated and redirects followed
+	// MaxMacroExpansions bounds the macro-strings expanded, each distinct
+	// one once per domain.
+	MaxMacroExpansions int
+	MaxExists          int // exists mechanisms evaluated, cached or not
+}
+
+// ComplexityError is the Cause of the PermError of an evaluation beyond a
+// ComplexityLimit.
+type ComplexityError struct {
+	Limit ComplexityLimit
+	Max   int
+}
+
+func (e *ComplexityError) Error() string {
+	return fmt.Sprintf("%v: too many %s, limit is %d", ErrTooComplex, e.Limit, e.Max)
+}
+
+// Is makes ComplexityError match ErrTooComplex.
+func (e *ComplexityError) Is(target error) bool {
+	return target == ErrTooComplex
+}
+
+// WithComplexityLimits makes evaluations beyond l a PermError whose Cause
+// is a ComplexityError, so that hostile records cannot consume
+// disproportionate CPU even when every answer is cached.
+func WithComplexityLimits(l ComplexityLimits) Option {
+	return func(c *Checker) { c.complexity = l }
+}
+
+// countComplexity charges one unit of limit to e.
+func (e *evaluation) countComplexity(limit ComplexityLimit) error {
+	var n *int
+	var maxN int
+	l := e.checker.complexity
+	switch limit {
+	case LimitTerms:
+		n, maxN = &e.terms, l.MaxTerms
+	case LimitMacroExpansions:
+		n, maxN = &e.expansions, l.MaxMacroExpansions
+	case LimitExists:
+		n, maxN = &e.exists, l.MaxExists
+	}
+	*n++
+	if maxN > 0 && *n > maxN {
+		return &ComplexityError{Limit: limit, Max: maxN}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithComplexityLimits(t *testing.T) {
+	var ip4s []string
+	for i := range 200 {
+		ip4s = append(ip4s, "ip4:10.0.1."+strconv.Itoa(i))
+	}
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"wide.example":     {"v=spf1 " + strings.Join(ip4s, " ") + " -all"},
+			"nested.example":   {"v=spf1 include:wide.example -all"},
+			"redirect.example": {"v=spf1 ip4:10.9.0.0/16 redirect=wide.example"},
+			"macros.example":   {"v=spf1 a:%{i}.a.example a:%{l}.b.example a:%{d}.c.example -all"},
+			"exists.example":   {"v=spf1 exists:b.example exists:c.example exists:a.example -all"},
+		},
+		ip: map[string][]string{
+			"a.example":                {"127.0.0.2"},
+			"192.0.2.1.a.example":      {"127.0.0.2"},
+			"postmaster.b.example":     {"127.0.0.2"},
+			"macros.example.c.example": {"127.0.0.2"},
+		},
+	}
+	ctx := context.Background()
+	ip := net.ParseIP("192.0.2.1")
+
+	tests := []struct {
+		domain string
+		limits ComplexityLimits
+		want   Result
+		limit  ComplexityLimit
+	}{
+		{"wide.example", ComplexityLimits{}, Fail, ""},
+		{"wide.example", ComplexityLimits{MaxTerms: 201}, Fail, ""},
+		{"wide.example", ComplexityLimits{MaxTerms: 200}, PermError, LimitTerms},
+		{"nested.example", ComplexityLimits{MaxTerms: 100}, PermError, LimitTerms},
+		{"redirect.example", ComplexityLimits{MaxTerms: 2}, PermError, LimitTerms},
+		{"macros.example", ComplexityLimits{MaxMacroExpansions: 3}, Fail, ""},
+		{"macros.example", ComplexityLimits{MaxMacroExpansions: 2}, PermError, LimitMacroExpansions},
+		{"exists.example", ComplexityLimits{MaxExists: 3}, Pass, ""},
+		{"exists.example", ComplexityLimits{MaxExists: 2}, PermError, LimitExists},
+	}
+	for _, tt := range tests {
+		ch := NewChecker(NewCustomDNSResolver(zone), WithComplexityLimits(tt.limits))
+		res, _ := ch.CheckHost(ctx, ip, tt.domain, "")
+		assert.Equal(t, tt.want, res.Code, "%s %+v", tt.domain, tt.limits)
+		if tt.limit == "" {
+			continue
+		}
+		require.ErrorIs(t, res.Cause, ErrTooComplex)
+		var ce *ComplexityError
+		require.ErrorAs(t, res.Cause, &ce)
+		assert.Equal(t, tt.limit, ce.Limit)
+	}
+}
+
+func TestComplexityError(t *testing.T) {
+	err := error(&ComplexityError{Limit: LimitExists, Max: 4})
+	assert.EqualError(t, err, "permerror: record too complex: too many exists mechanisms, limit is 4")
+	assert.True(t, errors.Is(err, ErrTooComplex))
+	assert.False(t, errors.Is(err, ErrTooManyLookups))
+}
@@ -0,0 +1,268 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// ErrInvalidConfig is matched by the errors of Config.Validate, LoadConfig
+// and LoadConfigFile.
+var ErrInvalidConfig = errors.New("invalid checker configuration")
+
+// Config describes a Checker in a file, so that every front end embedding
+// the package, such as cmd/libspf, is configured the same way.  Zero fields
+// keep the defaults of NewChecker.
+type Config struct {
+	Resolver ResolverConfig `json:"resolver" yaml:"resolver"`
+	// CacheTTL caches DNS answers in a MemoryCache for that long; zero
+	// disables caching.
+	CacheTTL time.Duration `json:"cache_ttl,omitempty" yaml:"cache_ttl,omitempty"`
+	// Timeout and QueryTimeout are those of WithTimeout and
+	// WithQueryTimeout.
+	Timeout      time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	QueryTimeout time.Duration `json:"query_timeout,omitempty" yaml:"query_timeout,omitempty"`
+	Limits       LimitsConfig  `json:"limits" yaml:"limits"`
+	// Receiver is the domain of WithReceiver.
+	Receiver    string `json:"receiver,omitempty" yaml:"receiver,omitempty"`
+	SingleLabel bool   `json:"single_label,omitempty" yaml:"single_label,omitempty"`
+	// Policy maps results as WithPolicy, e.g. {softfail: fail}.
+	Policy         Policy `json:"policy,omitempty" yaml:"policy,omitempty"`
+	NoRecordResult Result `json:"no_record_result,omitempty" yaml:"no_record_result,omitempty"`
+	// MultipleRecords is the policy of WithMultipleRecords.
+	MultipleRecords MultipleRecordPolicy `json:"multiple_records,omitempty" yaml:"multiple_records,omitempty"`
+	// Allowlist and Denylist are the networks of WithAllowlist and
+	// WithDenylist.
+	Allowlist []netip.Prefix `json:"allowlist,omitempty" yaml:"allowlist,omitempty"`
+	Denylist  []netip.Prefix `json:"denylist,omitempty" yaml:"denylist,omitempty"`
+	// LocalPolicy holds the overrides, trusted forwarders and local
+	// mechanisms of WithLocalPolicy, at the top level of the file.
+	LocalPolicy `yaml:",inline"`
+}
+
+// ResolverConfig selects the resolver of a Config.  Without Servers or DoH
+// it is that of NewDNSResolver.
+type ResolverConfig struct {
+	// Servers are the servers of an UpstreamResolver, as accepted by
+	// NewUpstreamResolver, reached over Transport.
+	Servers   []string  `json:"servers,omitempty" yaml:"servers,omitempty"`
+	Transport Transport `json:"transport,omitempty" yaml:"transport,omitempty"`
+	// DoH is the endpoint of a DoHResolver, exclusive of Servers.
+	DoH string `json:"doh,omitempty" yaml:"doh,omitempty"`
+	// Routes sends the queries for names under each suffix to their own
+	// servers, as NewRoutingResolver.  Routes cannot be nested.
+	Routes map[string]ResolverConfig `json:"routes,omitempty" yaml:"routes,omitempty"`
+}
+
+// LimitsConfig holds the limits of a Config.  Zero keeps a default:
+// MaxDNSLookups, MaxVoidLookups, those of parser.DefaultLimits and no
+// ComplexityLimits.
+type LimitsConfig struct {
+	Lookups     int `json:"lookups,omitempty" yaml:"lookups,omitempty"`
+	VoidLookups int `json:"void_lookups,omitempty" yaml:"void_lookups,omitempty"`
+	// RecordLength, Terms, MacroLength and Labels are the fields of
+	// parser.Limits.
+	RecordLength int `json:"record_length,omitempty" yaml:"record_length,omitempty"`
+	Terms        int `json:"terms,omitempty" yaml:"terms,omitempty"`
+	MacroLength  int `json:"macro_length,omitempty" yaml:"macro_length,omitempty"`
+	Labels       int `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// EvaluatedTerms, MacroExpansions and Exists are the fields of
+	// ComplexityLimits, unlimited when zero.
+	EvaluatedTerms  int `json:"evaluated_terms,omitempty" yaml:"evaluated_terms,omitempty"`
+	MacroExpansions int `json:"macro_expansions,omitempty" yaml:"macro_expansions,omitempty"`
+	Exists          int `json:"exists,omitempty" yaml:"exists,omitempty"`
+}
+
+// LoadConfig reads a Config from YAML, or JSON, and validates it:
+//
+//	resolver:
+//	  servers: [192.0.2.53, 198.51.100.53]
+//	  routes:
+//	    corp.internal: {servers: [10.0.0.53]}
+//	cache_ttl: 5m
+//	timeout: 20s
+//	query_timeout: 3s
+//	limits: {void_lookups: 3}
+//	policy: {softfail: fail}
+//	overrides:
+//	  partner.example: "v=spf1 include:_spf.partner.example ~all"
+//	trusted_forwarders: [192.0.2.0/24]
+func LoadConfig(r io.Reader) (*Config, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// LoadConfigFile is LoadConfig reading the file at path.
+func LoadConfigFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := LoadConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Validate reports negative durations and limits, and resolvers that
+// cannot be built.
+func (cfg *Config) Validate() error {
+	if cfg.CacheTTL < 0 || cfg.Timeout < 0 || cfg.QueryTimeout < 0 {
+		return fmt.Errorf("%w: negative duration", ErrInvalidConfig)
+	}
+	l := cfg.Limits
+	if min(l.Lookups, l.VoidLookups, l.RecordLength, l.Terms, l.MacroLength, l.Labels, l.EvaluatedTerms, l.MacroExpansions, l.Exists) < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidConfig)
+	}
+	if err := cfg.Resolver.validate(true); err != nil {
+		return fmt.Errorf("%w: resolver: %w", ErrInvalidConfig, err)
+	}
+	for suffix, route := range cfg.Resolver.Routes {
+		if err := route.validate(false); err != nil {
+			return fmt.Errorf("%w: route %q: %w", ErrInvalidConfig, suffix, err)
+		}
+	}
+
+	return nil
+}
+
+// validate checks r, a route unless top.
+func (r ResolverConfig) validate(top bool) error {
+	switch {
+	case len(r.Servers) > 0 && r.DoH != "":
+		return errors.New("servers and doh are exclusive")
+	case !top && len(r.Routes) > 0:
+		return errors.New("routes cannot be nested")
+	case !top && len(r.Servers) == 0 && r.DoH == "":
+		return errors.New("no servers")
+	}
+
+	return nil
+}
+
+// NewChecker returns a Checker configured by cfg, with opts applied after
+// the options of cfg.  The Checker owns the resolver and cache it builds.
+func (cfg *Config) NewChecker(opts ...Option) *Checker {
+	return NewChecker(cfg.Resolver.resolver(), append(cfg.Options(), opts...)...)
+}
+
+// Options returns the options of cfg, for callers building their own
+// resolver.
+func (cfg *Config) Options() []Option {
+	var opts []Option
+	if cfg.CacheTTL > 0 {
+		opts = append(opts, WithCache(NewMemoryCache(cfg.CacheTTL)))
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, WithTimeout(cfg.Timeout))
+	}
+	if cfg.QueryTimeout > 0 {
+		opts = append(opts, WithQueryTimeout(cfg.QueryTimeout))
+	}
+	if cfg.Limits.Lookups > 0 {
+		opts = append(opts, WithMaxLookups(cfg.Limits.Lookups))
+	}
+	if cfg.Limits.VoidLookups > 0 {
+		opts = append(opts, WithMaxVoidLookups(cfg.Limits.VoidLookups))
+	}
+	if pl := cfg.Limits.parseLimits(); pl != parser.DefaultLimits {
+		opts = append(opts, WithParseLimits(pl))
+	}
+	if cl := cfg.Limits.complexityLimits(); cl != (ComplexityLimits{}) {
+		opts = append(opts, WithComplexityLimits(cl))
+	}
+	if cfg.Receiver != "" {
+		opts = append(opts, WithReceiver(cfg.Receiver))
+	}
+	if cfg.SingleLabel {
+		opts = append(opts, WithSingleLabel())
+	}
+	if len(cfg.Policy) > 0 {
+		opts = append(opts, WithPolicy(cfg.Policy))
+	}
+	if cfg.NoRecordResult != "" {
+		opts = append(opts, WithNoRecordResult(cfg.NoRecordResult))
+	}
+	if cfg.MultipleRecords != MultipleRecordsPermError {
+		opts = append(opts, WithMultipleRecords(cfg.MultipleRecords))
+	}
+	if len(cfg.Allowlist) > 0 {
+		opts = append(opts, WithAllowlist(cfg.Allowlist...))
+	}
+	if len(cfg.Denylist) > 0 {
+		opts = append(opts, WithDenylist(cfg.Denylist...))
+	}
+	if lp := cfg.LocalPolicy; len(lp.Overrides) > 0 || len(lp.TrustedForwarders) > 0 || lp.Mechanisms != "" {
+		opts = append(opts, WithLocalPolicy(lp))
+	}
+
+	return opts
+}
+
+// parseLimits returns parser.DefaultLimits with the limits set in l.
+func (l LimitsConfig) parseLimits() parser.Limits {
+	pl := parser.DefaultLimits
+	for _, f := range []struct {
+		set int
+		dst *int
+	}{
+		{l.RecordLength, &pl.MaxLength},
+		{l.Terms, &pl.MaxTerms},
+		{l.MacroLength, &pl.MaxMacroLength},
+		{l.Labels, &pl.MaxLabels},
+	} {
+		if f.set > 0 {
+			*f.dst = f.set
+		}
+	}
+
+	return pl
+}
+
+// complexityLimits returns the ComplexityLimits of l.
+func (l LimitsConfig) complexityLimits() ComplexityLimits {
+	return ComplexityLimits{MaxTerms: l.EvaluatedTerms, MaxMacroExpansions: l.MacroExpansions, MaxExists: l.Exists}
+}
+
+// resolver builds the resolver r describes.
+func (r ResolverConfig) resolver() TXTResolver {
+	var res TXTResolver
+	switch {
+	case r.DoH != "":
+		res = NewDoHResolver(r.DoH)
+	case len(r.Servers) > 0:
+		u := NewUpstreamResolver(r.Servers...)
+		u.Transport = r.Transport
+		res = u
+	default:
+		res = NewDNSResolver()
+	}
+	if len(r.Routes) == 0 {
+		return res
+	}
+	routes := make(map[string]TXTResolver, len(r.Routes))
+	for suffix, route := range r.Routes {
+		routes[suffix] = route.resolver()
+	}
+
+	return NewRoutingResolver(res, routes)
+}
@@ -0,0 +1,118 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailspire/spf/parser"
+)
+
+const testConfig = `
+resolver:
+  servers: [192.0.2.53]
+  transport: TCP
+  routes:
+    corp.internal: {servers: ["10.0.0.53:5353"]}
+cache_ttl: 5m
+timeout: 20s
+query_timeout: 3s
+limits: {lookups: 20, void_lookups: 3, terms: 64, exists: 5}
+receiver: mx.example.net
+policy: {softfail: fail}
+no_record_result: none
+multiple_records: longest
+overrides:
+  partner.example: "v=spf1 ip4:192.0.2.0/24 ~all"
+`
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig(strings.NewReader(testConfig))
+	require.NoError(t, err)
+	assert.Equal(t, ResolverConfig{
+		Servers:   []string{"192.0.2.53"},
+		Transport: TransportTCP,
+		Routes:    map[string]ResolverConfig{"corp.internal": {Servers: []string{"10.0.0.53:5353"}}},
+	}, cfg.Resolver)
+	assert.Equal(t, 5*time.Minute, cfg.CacheTTL)
+	assert.Equal(t, 20*time.Second, cfg.Timeout)
+	assert.Equal(t, Policy{SoftFail: Fail}, cfg.Policy)
+	assert.Equal(t, None, cfg.NoRecordResult)
+	assert.Equal(t, MultipleRecordsLongest, cfg.MultipleRecords)
+
+	ch := cfg.NewChecker()
+	defer ch.Close()
+	assert.Equal(t, 20, ch.maxLookups)
+	assert.Equal(t, 3, ch.maxVoidLookups)
+	assert.Equal(t, 64, ch.parseLimits.MaxTerms)
+	assert.Equal(t, parser.DefaultLimits.MaxLength, ch.parseLimits.MaxLength)
+	assert.Equal(t, ComplexityLimits{MaxExists: 5}, ch.complexity)
+	assert.Equal(t, "mx.example.net", ch.receiver)
+	assert.Len(t, ch.middleware, 2, "cache and query timeout")
+	routing, ok := ch.resolver.(*middlewareResolver).r.(*RoutingResolver)
+	require.True(t, ok)
+	assert.Equal(t, []string{"192.0.2.53:53"}, routing.fallback.(*UpstreamResolver).Servers)
+	assert.Equal(t, TransportTCP, routing.fallback.(*UpstreamResolver).Transport)
+	assert.Equal(t, []string{"10.0.0.53:5353"}, routing.routes["corp.internal"].(*UpstreamResolver).Servers)
+
+	// the override answers without DNS, softfail mapped to fail
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("198.51.100.1"), "partner.example", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.Equal(t, SoftFail, res.Unmapped)
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := LoadConfig(strings.NewReader(""))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Options())
+	ch := cfg.NewChecker()
+	assert.IsType(t, &DNSResolver{}, ch.resolver)
+	assert.Equal(t, MaxDNSLookups, ch.maxLookups)
+
+	cfg, err = LoadConfig(strings.NewReader(`{"resolver": {"doh": "https://dns.example/dns-query"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "https://dns.example/dns-query", cfg.NewChecker().resolver.(*DoHResolver).URL)
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	for name, text := range map[string]string{
+		"unknown field":      "cache: 5m",
+		"bad duration":       "timeout: soon",
+		"negative duration":  "timeout: -1s",
+		"negative limit":     "limits: {lookups: -1}",
+		"negative exists":    "limits: {exists: -1}",
+		"unknown result":     "policy: {softfail: reject}",
+		"unknown transport":  "resolver: {servers: [192.0.2.53], transport: quic}",
+		"unknown policy":     "multiple_records: all",
+		"servers and doh":    "resolver: {servers: [192.0.2.53], doh: https://dns.example/dns-query}",
+		"nested routes":      "resolver: {routes: {a.example: {servers: [192.0.2.53], routes: {b.a.example: {servers: [192.0.2.54]}}}}}",
+		"route without host": "resolver: {routes: {a.example: {}}}",
+	} {
+		_, err := LoadConfig(strings.NewReader(text))
+		assert.ErrorIs(t, err, ErrInvalidConfig, name)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spf.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("timeout: 5s\n"), 0o600))
+	cfg, err := LoadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, cfg.Timeout)
+
+	require.NoError(t, os.WriteFile(path, []byte("timeout: [\n"), 0o600))
+	_, err = LoadConfigFile(path)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.ErrorContains(t, err, path)
+
+	_, err = LoadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
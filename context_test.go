@@ -0,0 +1,110 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelingResolver cancels the evaluation's context when it sees the query
+// on, like an SMTP client disconnecting while that query is in flight, and
+// counts the queries it receives afterwards.
+type cancelingResolver struct {
+	*zoneResolver
+	on     string // "TXT name", "ip4 host", "MX name" or "PTR addr"
+	cancel context.CancelFunc
+	after  int
+}
+
+func (c *cancelingResolver) see(ctx context.Context, key string) {
+	if ctx.Err() != nil {
+		c.after++
+	}
+	if key == c.on {
+		c.cancel()
+	}
+}
+
+func (c *cancelingResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	c.see(ctx, "TXT "+domain)
+	return c.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func (c *cancelingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	c.see(ctx, network+" "+host)
+	return c.zoneResolver.LookupIP(ctx, network, host)
+}
+
+func (c *cancelingResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	c.see(ctx, "MX "+name)
+	return c.zoneResolver.LookupMX(ctx, name)
+}
+
+func (c *cancelingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	c.see(ctx, "PTR "+addr)
+	return c.zoneResolver.LookupAddr(ctx, addr)
+}
+
+func TestChecker_ContextCanceledAtEachStage(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":       {"v=spf1 a:a.example.com mx:mx.example.com ptr:ptr.example.com exists:e.example.com include:inc.example.com redirect=redir.example.com"},
+			"inc.example.com":   {"v=spf1 ip4:203.0.113.0/24"},
+			"redir.example.com": {"v=spf1 -all exp=exp.example.com"},
+			"exp.example.com":   {"%{p} is not allowed"},
+		},
+		ip: map[string][]string{
+			"a.example.com":     {"198.51.100.1"},
+			"mx1.example.com":   {"198.51.100.2"},
+			"mx2.example.com":   {"198.51.100.3"},
+			"host.example.org":  {"192.0.2.1"},
+			"other.example.org": {"192.0.2.1"},
+		},
+		mx: map[string][]string{"mx.example.com": {"mx1.example.com.", "mx2.example.com."}},
+		ptr: map[string][]string{
+			"192.0.2.1": {"host.example.org.", "other.example.org."},
+		},
+	}
+
+	stages := []string{
+		"TXT example.com",
+		"ip4 a.example.com",
+		"MX mx.example.com",
+		"ip4 mx1.example.com",
+		"PTR 192.0.2.1",
+		"ip4 host.example.org",
+		"ip4 e.example.com",
+		"TXT inc.example.com",
+		"TXT redir.example.com",
+		"TXT exp.example.com",
+	}
+	for _, stage := range stages {
+		t.Run(stage, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := &cancelingResolver{zoneResolver: zone, on: stage, cancel: cancel}
+			ch := NewChecker(r)
+
+			_, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+			require.ErrorIs(t, err, context.Canceled)
+			assert.Zero(t, r.after, "no queries after cancellation")
+		})
+	}
+
+	// sanity check: the full walk reaches the explanation
+	res, err := NewChecker(zone).CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.Equal(t, "host.example.org is not allowed", res.Explanation)
+}
+
+func TestChecker_ContextCanceledBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 -all"}}}
+	_, err := NewChecker(zone).CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,87 @@
+package spf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ednsCookie is the EDNS0 option code of RFC 7873.
+const ednsCookie = 10
+
+// rcodeBadCookie is the extended response code a server answers with when
+// it rejects the server cookie of a query (RFC 7873 section 8).
+const rcodeBadCookie dnsmessage.RCode = 23
+
+// cookieJar keeps the DNS cookies exchanged with each upstream server.  The
+// client cookie is random per server, so that servers cannot link the
+// queries sent to one with those sent to another (RFC 7873 section 4.1).
+type cookieJar struct {
+	mu      sync.Mutex
+	servers map[string]*serverCookies
+}
+
+type serverCookies struct {
+	client [8]byte
+	server []byte // last learned, nil before the first answer
+}
+
+// cookies returns the cookies of server, creating its client cookie on
+// first use.  The caller holds j.mu.
+func (j *cookieJar) cookies(server string) *serverCookies {
+	c := j.servers[server]
+	if c == nil {
+		c = &serverCookies{}
+		rand.Read(c.client[:])
+		if j.servers == nil {
+			j.servers = make(map[string]*serverCookies)
+		}
+		j.servers[server] = c
+	}
+
+	return c
+}
+
+// option returns the COOKIE option for a query to server: the client
+// cookie followed by the server cookie when one was learned (RFC 7873
+// section 5.2).
+func (j *cookieJar) option(server string) dnsmessage.Option {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	c := j.cookies(server)
+
+	return dnsmessage.Option{Code: ednsCookie, Data: append(bytes.Clone(c.client[:]), c.server...)}
+}
+
+// update checks the COOKIE option of the answer msg from server and learns
+// its server cookie (RFC 7873 section 5.3).  An answer echoing another client
+// cookie did not come from the server and is an error; answers without a
+// cookie come from servers without support and are accepted.  badCookie
+// reports a BADCOOKIE answer, to be retried with the learned server cookie.
+func (j *cookieJar) update(server string, msg *dnsmessage.Message) (badCookie bool, err error) {
+	for _, rr := range msg.Additionals {
+		opt, ok := rr.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		badCookie = rr.Header.ExtendedRCode(msg.RCode) == rcodeBadCookie
+		for _, o := range opt.Options {
+			if o.Code != ednsCookie {
+				continue
+			}
+			j.mu.Lock()
+			c := j.cookies(server)
+			if len(o.Data) < 16 || len(o.Data) > 40 || !bytes.Equal(o.Data[:8], c.client[:]) {
+				j.mu.Unlock()
+				return false, fmt.Errorf("mismatched cookie from %s", server)
+			}
+			c.server = bytes.Clone(o.Data[8:])
+			j.mu.Unlock()
+		}
+	}
+
+	return badCookie, nil
+}
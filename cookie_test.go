@@ -0,0 +1,121 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// queryCookie returns the COOKIE option data of q, nil without one.
+func queryCookie(q dnsmessage.Message) []byte {
+	for _, rr := range q.Additionals {
+		if opt, ok := rr.Body.(*dnsmessage.OPTResource); ok {
+			for _, o := range opt.Options {
+				if o.Code == ednsCookie {
+					return o.Data
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// cookieAnswer adds an OPT record with cookie and the extended rcode to m.
+func cookieAnswer(t *testing.T, m dnsmessage.Message, rcode dnsmessage.RCode, cookie []byte) dnsmessage.Message {
+	var h dnsmessage.ResourceHeader
+	require.NoError(t, h.SetEDNS0(1232, rcode, false))
+	m.RCode = rcode & 0xf
+	m.Additionals = append(m.Additionals, dnsmessage.Resource{
+		Header: h,
+		Body:   &dnsmessage.OPTResource{Options: []dnsmessage.Option{{Code: ednsCookie, Data: cookie}}},
+	})
+
+	return m
+}
+
+func TestUpstreamResolver_Cookies(t *testing.T) {
+	var mu sync.Mutex
+	serverCookie := []byte("cookie-1")
+	rejectNext := false
+	srv := newDNSServer(t, func(q dnsmessage.Message, tcp bool) dnsmessage.Message {
+		mu.Lock()
+		defer mu.Unlock()
+		answer := dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 -all")}}
+		cookie := queryCookie(q)
+		if cookie == nil {
+			return answer
+		}
+		client := cookie[:8]
+		if rejectNext {
+			rejectNext = false
+			return cookieAnswer(t, dnsmessage.Message{}, rcodeBadCookie, append(bytes.Clone(client), serverCookie...))
+		}
+		if q.Questions[0].Name.String() == "spoofed.example.com." {
+			client = []byte("12345678")
+		}
+
+		return cookieAnswer(t, answer, dnsmessage.RCodeSuccess, append(bytes.Clone(client), serverCookie...))
+	})
+	u := NewUpstreamResolver(srv.addr)
+	ctx := context.Background()
+
+	for range 2 {
+		txts, err := u.LookupTXT(ctx, "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"v=spf1 -all"}, txts)
+	}
+	queries := srv.received()
+	require.Len(t, queries, 2)
+	first, second := queryCookie(queries[0]), queryCookie(queries[1])
+	assert.Len(t, first, 8, "client cookie only")
+	assert.Equal(t, append(bytes.Clone(first), "cookie-1"...), second, "learned server cookie")
+
+	// BADCOOKIE is retried once with the new server cookie
+	mu.Lock()
+	serverCookie, rejectNext = []byte("cookie-2"), true
+	mu.Unlock()
+	_, err := u.LookupTXT(ctx, "example.com")
+	require.NoError(t, err)
+	queries = srv.received()
+	require.Len(t, queries, 4)
+	assert.Equal(t, append(bytes.Clone(first), "cookie-2"...), queryCookie(queries[3]))
+
+	// answers echoing another client cookie are discarded
+	_, err = u.LookupTXT(ctx, "spoofed.example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.Temporary())
+	assert.Contains(t, dnsErr.Err, "mismatched cookie")
+
+	u = NewUpstreamResolver(srv.addr)
+	u.NoCookies = true
+	_, err = u.LookupTXT(ctx, "spoofed.example.com")
+	require.NoError(t, err)
+	assert.Nil(t, queryCookie(srv.received()[5]))
+}
+
+func TestCookieJar(t *testing.T) {
+	var j cookieJar
+	a, b := j.option("192.0.2.53:53"), j.option("198.51.100.53:53")
+	assert.Len(t, a.Data, 8)
+	assert.NotEqual(t, a.Data, b.Data, "client cookies differ per server")
+	assert.Equal(t, a, j.option("192.0.2.53:53"))
+
+	// answers without a cookie come from servers without support
+	badCookie, err := j.update("192.0.2.53:53", &dnsmessage.Message{})
+	require.NoError(t, err)
+	assert.False(t, badCookie)
+
+	// server cookies must be 8 to 32 bytes
+	_, err = j.update("192.0.2.53:53", &dnsmessage.Message{Additionals: []dnsmessage.Resource{{
+		Body: &dnsmessage.OPTResource{Options: []dnsmessage.Option{{Code: ednsCookie, Data: a.Data}}},
+	}}})
+	require.Error(t, err)
+}
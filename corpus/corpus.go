@@ -0,0 +1,110 @@
+// Package corpus ships SPF records that trip up implementations, drawn from
+// real-world deployments and anonymized with the documentation domains and
+// address ranges (RFC 2606, RFC 5737, RFC 3849).  Downstream projects can
+// regression-test their parsers and evaluators against realistic inputs:
+//
+//	for e := range corpus.All() {
+//		_, err := parser.Parse(e.Text)
+//		if (err == nil) != e.Valid {
+//			t.Errorf("%s: %v", e.Name, err)
+//		}
+//	}
+package corpus
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+)
+
+// Categories of the entries.
+const (
+	// CategoryFlattened holds the huge records produced by flattening
+	// services and the split chains they publish.
+	CategoryFlattened = "flattened"
+	// CategoryMacro holds records relying on macro expansion (RFC 7208
+	// section 7).
+	CategoryMacro = "macro"
+	// CategoryBroken holds the mistakes found again and again in published
+	// records, valid or not.
+	CategoryBroken = "broken"
+	// CategoryLookups holds records stressing the DNS lookup limits of RFC
+	// 7208 section 4.6.4.
+	CategoryLookups = "lookups"
+)
+
+// Entry is one record of the corpus.
+type Entry struct {
+	Name     string `json:"name"` // unique, e.g. "broken-commas"
+	Category string `json:"category"`
+	Text     string `json:"text"` // the TXT record, its strings concatenated
+	// Valid reports whether the record conforms to the grammar of RFC 7208
+	// section 12, i.e. whether check_host() gets past parsing it without
+	// a PermError.  A valid record may still fail at evaluation, e.g. by
+	// exceeding the lookup limits.
+	Valid bool   `json:"valid"`
+	Note  string `json:"note"` // what makes the record tricky
+}
+
+//go:embed records.jsonl
+var data []byte
+
+// entries decodes the embedded corpus once.
+var entries = sync.OnceValue(func() []Entry {
+	var out []Entry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			panic(fmt.Sprintf("corpus: records.jsonl:%d: %v", line, err))
+		}
+		out = append(out, e)
+	}
+
+	return out
+})
+
+// All returns the entries in a stable order, grouped by category.
+func All() iter.Seq[Entry] {
+	return slices.Values(entries())
+}
+
+// ByCategory returns the entries of category, such as CategoryMacro.
+func ByCategory(category string) iter.Seq[Entry] {
+	return func(yield func(Entry) bool) {
+		for _, e := range entries() {
+			if e.Category == category && !yield(e) {
+				return
+			}
+		}
+	}
+}
+
+// Lookup returns the entry named name.
+func Lookup(name string) (Entry, bool) {
+	i := slices.IndexFunc(entries(), func(e Entry) bool { return e.Name == name })
+	if i < 0 {
+		return Entry{}, false
+	}
+
+	return entries()[i], true
+}
+
+// Categories returns the categories with at least one entry, sorted.
+func Categories() []string {
+	var out []string
+	for _, e := range entries() {
+		if !slices.Contains(out, e.Category) {
+			out = append(out, e.Category)
+		}
+	}
+	slices.Sort(out)
+
+	return out
+}
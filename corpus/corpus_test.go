@@ -0,0 +1,53 @@
+package corpus
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mailspire/spf/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorpus(t *testing.T) {
+	names := map[string]bool{}
+	for e := range All() {
+		assert.NotEmpty(t, e.Name)
+		assert.False(t, names[e.Name], "duplicate entry %s", e.Name)
+		names[e.Name] = true
+		assert.Contains(t, []string{CategoryFlattened, CategoryMacro, CategoryBroken, CategoryLookups}, e.Category, e.Name)
+		assert.NotEmpty(t, e.Note, e.Name)
+
+		_, err := parser.Parse(e.Text)
+		assert.Equal(t, e.Valid, err == nil, "%s: %v", e.Name, err)
+	}
+	assert.Greater(t, len(names), 30)
+	assert.Equal(t, []string{CategoryBroken, CategoryFlattened, CategoryLookups, CategoryMacro}, Categories())
+}
+
+func TestByCategory(t *testing.T) {
+	macros := slices.Collect(ByCategory(CategoryMacro))
+	require.NotEmpty(t, macros)
+	for _, e := range macros {
+		assert.Equal(t, CategoryMacro, e.Category)
+		assert.Contains(t, e.Text, "%")
+	}
+	assert.Empty(t, slices.Collect(ByCategory("nope")))
+
+	var first []Entry
+	for e := range ByCategory(CategoryBroken) {
+		first = append(first, e)
+		break
+	}
+	assert.Len(t, first, 1)
+}
+
+func TestLookup(t *testing.T) {
+	e, ok := Lookup("flattened-ip4")
+	require.True(t, ok)
+	assert.Greater(t, len(e.Text), 512)
+	assert.True(t, e.Valid)
+
+	_, ok = Lookup("nope")
+	assert.False(t, ok)
+}
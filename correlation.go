@@ -0,0 +1,21 @@
+package spf
+
+import "context"
+
+// correlationKey is the context key of the correlation ID.
+type correlationKey struct{}
+
+// ContextWithCorrelationID returns a context carrying id, e.g. the queue ID
+// of the message being checked.  Evaluations under it add the ID to the DNS
+// queries logged by WithLogger and to the TraceEvents of WithTrace, and
+// resolvers can read it with CorrelationID, so that the DNS activity of one
+// message can be stitched together in centralized logging.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation ID of ctx, or "" when it has none.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
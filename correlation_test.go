@@ -0,0 +1,55 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// correlatingResolver records the correlation IDs its lookups see.
+type correlatingResolver struct {
+	*zoneResolver
+	ids []string
+}
+
+func (c *correlatingResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	c.ids = append(c.ids, CorrelationID(ctx))
+	return c.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func TestCorrelationID(t *testing.T) {
+	var buf bytes.Buffer
+	var events []TraceEvent
+	r := &correlatingResolver{zoneResolver: optionsZone()}
+	ch := NewChecker(NewCustomDNSResolver(r),
+		WithLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+		WithTrace(func(ev TraceEvent) { events = append(events, ev) }),
+	)
+
+	ctx := ContextWithCorrelationID(context.Background(), "4XyZ1q2")
+	_, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"4XyZ1q2", "4XyZ1q2"}, r.ids)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	for _, line := range lines {
+		assert.Contains(t, line, "correlation_id=4XyZ1q2")
+	}
+	require.Len(t, events, 3)
+	for _, ev := range events {
+		assert.Equal(t, "4XyZ1q2", ev.CorrelationID)
+	}
+
+	assert.Empty(t, CorrelationID(context.Background()))
+	buf.Reset()
+	_, err = ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "correlation_id")
+}
@@ -0,0 +1,176 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidDecision is matched by the errors of DecisionEngine.Validate and
+// LoadDecisionEngine.
+var ErrInvalidDecision = errors.New("invalid decision configuration")
+
+// Action is what a receiver does with a message, as decided by a
+// DecisionEngine.
+type Action string
+
+// Actions of a DecisionEngine.
+const (
+	ActionAccept     Action = "accept"
+	ActionReject     Action = "reject"
+	ActionQuarantine Action = "quarantine" // accept, adding a quarantine header
+	ActionTag        Action = "tag"        // accept, tagging e.g. the subject
+)
+
+var actions = []Action{ActionAccept, ActionReject, ActionQuarantine, ActionTag}
+
+// UnmarshalText decodes the name of an action, rejecting unknown ones.
+func (a *Action) UnmarshalText(text []byte) error {
+	name := Action(strings.ToLower(string(text)))
+	if !slices.Contains(actions, name) {
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidDecision, text)
+	}
+	*a = name
+
+	return nil
+}
+
+// DecisionRule selects an action for the results meeting all of its
+// conditions; a condition left empty holds for every result.
+type DecisionRule struct {
+	Name string `json:"name" yaml:"name"`
+	// Results lists the codes the rule applies to, after any Policy.
+	Results []Result `json:"results,omitempty" yaml:"results,omitempty"`
+	// Scopes lists the identities the rule applies to, e.g. ScopeHELO.
+	Scopes []Scope `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	// Mechanisms lists matched mechanisms as in MatchInfo.Term, e.g. "-all"
+	// or "include:_spf.vendor.example".
+	Mechanisms []string `json:"mechanisms,omitempty" yaml:"mechanisms,omitempty"`
+	// Domains and the domains of the DecisionEngine's Lists named in
+	// DomainLists match the checked domain and its subdomains.
+	Domains     []string `json:"domains,omitempty" yaml:"domains,omitempty"`
+	DomainLists []string `json:"domain_lists,omitempty" yaml:"domain_lists,omitempty"`
+	// Attributes must equal those attached by the enrichers of WithEnricher.
+	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+
+	Action Action `json:"action" yaml:"action"`
+	Tag    string `json:"tag,omitempty" yaml:"tag,omitempty"` // header or tag text for quarantine and tag
+}
+
+// Decision is the outcome of DecisionEngine.Decide.
+type Decision struct {
+	Action Action
+	Rule   string // name of the rule deciding, "" for the default
+	Tag    string
+}
+
+// DecisionEngine maps results to actions with the first matching rule, so
+// that MTAs embedding the package need not each code the result → action
+// logic.  The zero value accepts everything.
+//
+//	engine := &spf.DecisionEngine{Rules: []spf.DecisionRule{
+//		{Name: "partners", DomainLists: []string{"partners"}, Action: spf.ActionAccept},
+//		{Name: "fail", Results: []spf.Result{spf.Fail}, Action: spf.ActionReject},
+//		{Name: "softfail", Results: []spf.Result{spf.SoftFail}, Action: spf.ActionTag, Tag: "[SPF]"},
+//	}, Lists: map[string][]string{"partners": {"partner.example"}}}
+type DecisionEngine struct {
+	Lists   map[string][]string `json:"lists,omitempty" yaml:"lists,omitempty"`
+	Rules   []DecisionRule      `json:"rules" yaml:"rules"`
+	Default Action              `json:"default,omitempty" yaml:"default,omitempty"` // ActionAccept when empty
+}
+
+// LoadDecisionEngine reads a DecisionEngine from YAML, or JSON, and
+// validates it:
+//
+//	lists:
+//	  partners: [partner.example]
+//	rules:
+//	  - {name: partners, domain_lists: [partners], action: accept}
+//	  - {name: fail, results: [fail], action: reject}
+//	  - {name: softfail, results: [softfail], action: tag, tag: "[SPF]"}
+//	default: accept
+func LoadDecisionEngine(r io.Reader) (*DecisionEngine, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	var d DecisionEngine
+	if err := dec.Decode(&d); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDecision, err)
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// Validate reports rules without a known action and references to lists
+// that do not exist.
+func (d *DecisionEngine) Validate() error {
+	if d.Default != "" && !slices.Contains(actions, d.Default) {
+		return fmt.Errorf("%w: unknown default action %q", ErrInvalidDecision, d.Default)
+	}
+	for i, rule := range d.Rules {
+		if !slices.Contains(actions, rule.Action) {
+			return fmt.Errorf("%w: rule %d %q: unknown action %q", ErrInvalidDecision, i+1, rule.Name, rule.Action)
+		}
+		for _, name := range rule.DomainLists {
+			if _, ok := d.Lists[name]; !ok {
+				return fmt.Errorf("%w: rule %d %q: unknown domain list %q", ErrInvalidDecision, i+1, rule.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Decide returns the action of the first rule matching res, or the default.
+func (d *DecisionEngine) Decide(res CheckHostResult) Decision {
+	for _, rule := range d.Rules {
+		if d.matches(rule, res) {
+			return Decision{Action: rule.Action, Rule: rule.Name, Tag: rule.Tag}
+		}
+	}
+	if d.Default == "" {
+		return Decision{Action: ActionAccept}
+	}
+
+	return Decision{Action: d.Default}
+}
+
+// matches reports whether res meets every condition of rule.
+func (d *DecisionEngine) matches(rule DecisionRule, res CheckHostResult) bool {
+	if len(rule.Results) > 0 && !slices.Contains(rule.Results, res.Code) {
+		return false
+	}
+	if len(rule.Scopes) > 0 && !slices.Contains(rule.Scopes, res.Scope) {
+		return false
+	}
+	if len(rule.Mechanisms) > 0 && (res.Match == nil || !slices.ContainsFunc(rule.Mechanisms, func(m string) bool {
+		return strings.EqualFold(strings.TrimPrefix(m, "+"), res.Match.Term)
+	})) {
+		return false
+	}
+	if len(rule.Domains) > 0 || len(rule.DomainLists) > 0 {
+		domains := slices.Clone(rule.Domains)
+		for _, name := range rule.DomainLists {
+			domains = append(domains, d.Lists[name]...)
+		}
+		checked := strings.ToLower(strings.TrimSuffix(res.Domain, "."))
+		if !slices.ContainsFunc(domains, func(domain string) bool {
+			return isSubdomain(checked, strings.ToLower(strings.TrimSuffix(domain, ".")))
+		}) {
+			return false
+		}
+	}
+	for k, v := range rule.Attributes {
+		if res.Attributes[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,67 @@
+package spf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionEngine(t *testing.T) {
+	const config = `
+lists:
+  partners: [Partner.Example.]
+rules:
+  - {name: partners, domain_lists: [partners], results: [fail, softfail], action: quarantine, tag: "X-Spam: partner"}
+  - {name: helo-fail, scopes: [helo], results: [fail], action: reject}
+  - {name: plus-all, mechanisms: ["+all"], action: tag, tag: "[+all]"}
+  - {name: bad-asn, attributes: {asn: "64511"}, action: reject}
+  - {name: fail, results: [FAIL], action: reject}
+default: accept
+`
+	d, err := LoadDecisionEngine(strings.NewReader(config))
+	require.NoError(t, err)
+
+	cases := []struct {
+		res  CheckHostResult
+		want Decision
+	}{
+		{CheckHostResult{Code: Fail, Domain: "mail.partner.example", Scope: ScopeMailFrom},
+			Decision{Action: ActionQuarantine, Rule: "partners", Tag: "X-Spam: partner"}},
+		{CheckHostResult{Code: Fail, Domain: "notpartner.example", Scope: ScopeMailFrom},
+			Decision{Action: ActionReject, Rule: "fail"}},
+		{CheckHostResult{Code: Fail, Domain: "partner.example", Scope: ScopeHELO},
+			Decision{Action: ActionQuarantine, Rule: "partners", Tag: "X-Spam: partner"}},
+		{CheckHostResult{Code: Fail, Domain: "mx.example", Scope: ScopeHELO},
+			Decision{Action: ActionReject, Rule: "helo-fail"}},
+		{CheckHostResult{Code: Pass, Domain: "example.com", Match: &MatchInfo{Term: "all"}},
+			Decision{Action: ActionTag, Rule: "plus-all", Tag: "[+all]"}},
+		{CheckHostResult{Code: Pass, Domain: "example.com", Attributes: map[string]string{"asn": "64511"}},
+			Decision{Action: ActionReject, Rule: "bad-asn"}},
+		{CheckHostResult{Code: SoftFail, Domain: "example.com"}, Decision{Action: ActionAccept}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, d.Decide(c.res), "%+v", c.res)
+	}
+
+	assert.Equal(t, Decision{Action: ActionAccept}, (&DecisionEngine{}).Decide(CheckHostResult{Code: Fail}))
+	assert.Equal(t, Decision{Action: ActionTag}, (&DecisionEngine{Default: ActionTag}).Decide(CheckHostResult{Code: Fail}))
+}
+
+func TestLoadDecisionEngine_Invalid(t *testing.T) {
+	for _, config := range []string{
+		`rules: [{name: x, action: drop}]`,
+		`rules: [{name: x, action: accept, domain_lists: [missing]}]`,
+		`rules: [{name: x, results: [maybe], action: accept}]`,
+		`rules: [{name: x, action: accept, unknown: 1}]`,
+		`default: drop`,
+	} {
+		_, err := LoadDecisionEngine(strings.NewReader(config))
+		require.ErrorIs(t, err, ErrInvalidDecision, config)
+	}
+
+	d, err := LoadDecisionEngine(strings.NewReader(`{"rules": [{"name": "fail", "results": ["fail"], "action": "reject"}]}`))
+	require.NoError(t, err, "JSON is accepted")
+	assert.Equal(t, ActionReject, d.Decide(CheckHostResult{Code: Fail}).Action)
+}
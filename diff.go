@@ -0,0 +1,184 @@
+package spf
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// ChangeKind classifies a Change.
+type ChangeKind string
+
+const (
+	Added            ChangeKind = "added"
+	Removed          ChangeKind = "removed"
+	QualifierChanged ChangeKind = "qualifier"
+)
+
+// Change is one semantic difference between two SPF policies.
+type Change struct {
+	Kind ChangeKind
+	// Domain is the record the change applies to; it is empty for
+	// DiffRecords.
+	Domain string
+	// Term is the term without its qualifier, e.g. "include:_spf.example.com"
+	// or "redirect=_spf.example.com".  It is empty when a whole record was
+	// added to or removed from a graph.
+	Term     string
+	Old, New parser.Qualifier // set for QualifierChanged
+}
+
+func (c Change) String() string {
+	prefix := ""
+	if c.Domain != "" {
+		prefix = c.Domain + ": "
+	}
+	switch {
+	case c.Kind == QualifierChanged:
+		return fmt.Sprintf("%s%s %c%s → %c%s", prefix, c.Kind, c.Old, c.Term, c.New, c.Term)
+	case c.Term == "":
+		return fmt.Sprintf("%s%s record", prefix, c.Kind)
+	default:
+		return fmt.Sprintf("%s%s %s", prefix, c.Kind, c.Term)
+	}
+}
+
+// DiffRecords compares two parsed records term by term, ignoring order and
+// formatting.  Terms are reported in the order of before (removals and
+// qualifier changes) followed by after (additions).  A nil record has no terms.
+func DiffRecords(before, after *parser.Record) []Change {
+	oldTerms, newTerms := recordTerms(before), recordTerms(after)
+	newQuals := make(map[string]parser.Qualifier, len(newTerms))
+	for _, t := range newTerms {
+		newQuals[t.term] = t.qual
+	}
+	oldQuals := make(map[string]parser.Qualifier, len(oldTerms))
+	for _, t := range oldTerms {
+		oldQuals[t.term] = t.qual
+	}
+
+	var changes []Change
+	for _, t := range oldTerms {
+		q, ok := newQuals[t.term]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Kind: Removed, Term: t.term})
+		case q != t.qual:
+			changes = append(changes, Change{Kind: QualifierChanged, Term: t.term, Old: t.qual, New: q})
+		}
+	}
+	for _, t := range newTerms {
+		if _, ok := oldQuals[t.term]; !ok {
+			changes = append(changes, Change{Kind: Added, Term: t.term})
+		}
+	}
+
+	return changes
+}
+
+// qualifiedTerm is a term of a record split from its qualifier.
+type qualifiedTerm struct {
+	term string
+	qual parser.Qualifier
+}
+
+// recordTerms lists the mechanisms and the redirect and exp modifiers of rec.
+func recordTerms(rec *parser.Record) []qualifiedTerm {
+	if rec == nil {
+		return nil
+	}
+	terms := make([]qualifiedTerm, 0, len(rec.Mechs)+2)
+	for _, m := range rec.Mechs {
+		q := m.Qual
+		m.Qual = parser.QPlus
+		terms = append(terms, qualifiedTerm{term: m.String(), qual: q})
+	}
+	for _, mod := range []*parser.Modifier{rec.Redirect, rec.Exp} {
+		if mod != nil {
+			terms = append(terms, qualifiedTerm{term: mod.Name + "=" + mod.Value, qual: parser.QPlus})
+		}
+	}
+
+	return terms
+}
+
+// DiffGraphs compares two snapshots of a domain's resolved policy record by
+// record.  Records that only exist in one graph are reported as a whole.
+// Changes are ordered by domain.
+func DiffGraphs(before, after *Graph) []Change {
+	domains := make(map[string]bool)
+	for d := range before.Nodes {
+		domains[d] = true
+	}
+	for d := range after.Nodes {
+		domains[d] = true
+	}
+	sorted := make([]string, 0, len(domains))
+	for d := range domains {
+		sorted = append(sorted, d)
+	}
+	sort.Strings(sorted)
+
+	var changes []Change
+	for _, d := range sorted {
+		o, n := before.Nodes[d], after.Nodes[d]
+		switch {
+		case o == nil:
+			changes = append(changes, Change{Kind: Added, Domain: d})
+		case n == nil:
+			changes = append(changes, Change{Kind: Removed, Domain: d})
+		case o.Record.Equal(n.Record):
+			continue
+		default:
+			for _, c := range DiffRecords(o.Record, n.Record) {
+				c.Domain = d
+				changes = append(changes, c)
+			}
+		}
+	}
+
+	return changes
+}
+
+// DiffNetworks compares the prefixes of two inventories and returns those
+// only authorized by after and those only authorized by before, each sorted.
+func DiffNetworks(before, after *NetworkInventory) (added, removed []netip.Prefix) {
+	oldSet, newSet := prefixSet(before), prefixSet(after)
+	for p := range newSet {
+		if !oldSet[p] {
+			added = append(added, p)
+		}
+	}
+	for p := range oldSet {
+		if !newSet[p] {
+			removed = append(removed, p)
+		}
+	}
+	sortPrefixes(added)
+	sortPrefixes(removed)
+
+	return added, removed
+}
+
+func prefixSet(inv *NetworkInventory) map[netip.Prefix]bool {
+	set := make(map[netip.Prefix]bool)
+	if inv == nil {
+		return set
+	}
+	for _, n := range inv.Networks {
+		set[n.Prefix] = true
+	}
+
+	return set
+}
+
+func sortPrefixes(ps []netip.Prefix) {
+	sort.Slice(ps, func(i, j int) bool {
+		if c := ps[i].Addr().Compare(ps[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return ps[i].Bits() < ps[j].Bits()
+	})
+}
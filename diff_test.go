@@ -0,0 +1,75 @@
+package spf
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/mailspire/spf/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffRecords(t *testing.T) {
+	old, err := parser.Parse("v=spf1 ip4:192.0.2.0/24 include:_spf.example.com ~all")
+	require.NoError(t, err)
+	new, err := parser.Parse("v=spf1 include:_spf.example.com  ip4:198.51.100.0/24 -all redirect=_spf.example.net")
+	require.NoError(t, err)
+
+	changes := DiffRecords(old, new)
+	assert.Equal(t, []Change{
+		{Kind: Removed, Term: "ip4:192.0.2.0/24"},
+		{Kind: QualifierChanged, Term: "all", Old: parser.QTilde, New: parser.QMinus},
+		{Kind: Added, Term: "ip4:198.51.100.0/24"},
+		{Kind: Added, Term: "redirect=_spf.example.net"},
+	}, changes)
+	assert.Equal(t, "qualifier ~all → -all", changes[1].String())
+
+	assert.Empty(t, DiffRecords(old, old))
+	assert.Len(t, DiffRecords(nil, new), 4)
+}
+
+func TestDiffGraphs(t *testing.T) {
+	ctx := context.Background()
+	before := graphZone()
+	old, err := BuildGraph(ctx, before, "example.com")
+	require.NoError(t, err)
+
+	after := graphZone()
+	after.txt["_spf.example.com"] = []string{"v=spf1 a ip4:192.0.2.0/24 include:new.example.org ?all"}
+	after.txt["new.example.org"] = []string{"v=spf1 ip4:203.0.113.0/24 -all"}
+	new, err := BuildGraph(ctx, after, "example.com")
+	require.NoError(t, err)
+
+	changes := DiffGraphs(old, new)
+	assert.Equal(t, []Change{
+		{Kind: Removed, Domain: "_spf.example.com", Term: "redirect=_spf2.example.com"},
+		{Kind: Added, Domain: "_spf.example.com", Term: "include:new.example.org"},
+		{Kind: Added, Domain: "_spf.example.com", Term: "all"},
+		{Kind: Removed, Domain: "_spf2.example.com"},
+		{Kind: Added, Domain: "new.example.org"},
+	}, changes)
+	assert.Equal(t, "new.example.org: added record", changes[4].String())
+	assert.Equal(t, "_spf.example.com: added all", changes[2].String())
+}
+
+func TestDiffNetworks(t *testing.T) {
+	inv := func(prefixes ...string) *NetworkInventory {
+		out := &NetworkInventory{}
+		for _, p := range prefixes {
+			out.Networks = append(out.Networks, AuthorizedNetwork{Prefix: netip.MustParsePrefix(p)})
+		}
+		return out
+	}
+
+	added, removed := DiffNetworks(
+		inv("192.0.2.0/24", "2001:db8::/32", "198.51.100.0/24"),
+		inv("2001:db8::/32", "203.0.113.0/24", "192.0.2.0/25", "192.0.2.0/24"),
+	)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/25"), netip.MustParsePrefix("203.0.113.0/24")}, added)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}, removed)
+
+	added, removed = DiffNetworks(nil, inv("192.0.2.0/24"))
+	assert.Len(t, added, 1)
+	assert.Empty(t, removed)
+}
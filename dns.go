@@ -20,6 +20,7 @@ var (
 	ErrNoDNSrecord = errors.New("DNS record not found (NXDOMAIN)")
 	ErrTempfail    = errors.New("temperror: temporary DNS lookup failure")
 	ErrPermfail    = errors.New("permerror: permanent DNS lookup failure")
+	ErrUnsupported = errors.New("resolver does not support this lookup type")
 )
 
 // DefaultDialTimeout is the fallback time out if the caller does not pass a deadline/cancellation.
@@ -32,28 +33,41 @@ type TXTResolver interface {
 	LookupTXT(ctx context.Context, domain string) ([]string, error)
 }
 
-// DNSResolver uses Go's stdlib to implement TXTResolver.
-type DNSResolver struct {
-	resolver TXTResolver
+// IPResolver abstracts the A/AAAA lookups performed by the "a", "mx" and
+// "exists" mechanisms (RFC 7208 sections 5.3, 5.4 and 5.7).  The network
+// argument is "ip4" or "ip6" as accepted by net.Resolver.LookupIP.
+type IPResolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
 }
 
-// NewDNSResolver returns a DNSResolver that performs TXT lookups using the
-// Go standard library.  Lookups respect context timeouts and cancellations so
-// callers can enforce the limits from RFC 7208 section 11.
-func NewDNSResolver() *DNSResolver {
-	r := &net.Resolver{
-		StrictErrors: true,
-		PreferGo:     true, // force pure-Go DNS implementation
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := &net.Dialer{ //nolint:exhaustruct
-				Timeout: DefaultDialTimeout,
-			}
+// MXResolver abstracts the MX lookup performed by the "mx" mechanism
+// (RFC 7208 section 5.4).
+type MXResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
 
-			return d.DialContext(ctx, network, address)
-		},
-	}
+// PTRResolver abstracts the reverse lookup performed by the "ptr" mechanism
+// and the "p" macro (RFC 7208 sections 5.5 and 7.3).
+type PTRResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
 
-	return &DNSResolver{resolver: r}
+// SPFTypeResolver looks up records of the SPF RR type (99), which RFC 7208
+// section 3.1 retired in favour of TXT.  Evaluation never uses it; Audit
+// reports records of the type when the resolver implements it.
+type SPFTypeResolver interface {
+	LookupSPFType(ctx context.Context, domain string) ([]string, error)
+}
+
+// DNSResolver uses Go's stdlib to implement TXTResolver.  It also implements
+// IPResolver, MXResolver and PTRResolver whenever the wrapped resolver does.
+type DNSResolver struct {
+	resolver TXTResolver
+}
+
+// unixSocket returns the path of a "unix:" server.
+func unixSocket(server string) (string, bool) {
+	return strings.CutPrefix(server, "unix:")
 }
 
 // NewCustomDNSResolver builds a DNSResolver that delegates TXT lookups to the
@@ -70,6 +84,51 @@ func (d *DNSResolver) LookupTXT(ctx context.Context, domain string) ([]string, e
 	return d.resolver.LookupTXT(ctx, domain)
 }
 
+// LookupIP forwards A/AAAA lookups to the underlying resolver.  It returns
+// ErrUnsupported when the wrapped resolver only knows about TXT records.
+func (d *DNSResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	r, ok := d.resolver.(IPResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return r.LookupIP(ctx, network, host)
+}
+
+// LookupMX forwards MX lookups to the underlying resolver.  It returns
+// ErrUnsupported when the wrapped resolver only knows about TXT records.
+func (d *DNSResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r, ok := d.resolver.(MXResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return r.LookupMX(ctx, name)
+}
+
+// LookupAddr forwards PTR lookups to the underlying resolver.  It returns
+// ErrUnsupported when the wrapped resolver only knows about TXT records.
+func (d *DNSResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r, ok := d.resolver.(PTRResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return r.LookupAddr(ctx, addr)
+}
+
+// LookupSPFType forwards lookups of the SPF RR type to the underlying
+// resolver.  It returns ErrUnsupported when the wrapped resolver, like the
+// Go standard library, cannot query the type.
+func (d *DNSResolver) LookupSPFType(ctx context.Context, domain string) ([]string, error) {
+	r, ok := d.resolver.(SPFTypeResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return r.LookupSPFType(ctx, domain)
+}
+
 // getSPFRecord retrieves the TXT records for domain and selects the single
 // valid SPF record.  The behaviour mirrors the DNS processing rules from
 // RFC 7208 section 4.5.
@@ -78,35 +137,109 @@ func (d *DNSResolver) LookupTXT(ctx context.Context, domain string) ([]string, e
 //   - any other error → ErrPermfail
 //   - then filters for exactly one "v=spf1" record.
 func getSPFRecord(ctx context.Context, domain string, r TXTResolver) (string, error) {
-	txts, err := r.LookupTXT(ctx, domain)
+	txts, err := r.LookupTXT(ctx, queryName(domain))
 	if err != nil {
+		return "", classifyDNSError(err)
+	}
 
-		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-			return "", err // propagate – let the caller decide
-		}
+	rec, err := filterSPF(txts)
+	var multi *MultipleSPFError
+	if errors.As(err, &multi) {
+		multi.Domain = domain
+	}
 
-		var dnsErr *net.DNSError
-		if errors.As(err, &dnsErr) {
-			switch {
-			case dnsErr.IsNotFound:
-				return "", ErrNoDNSrecord
-			case dnsErr.Temporary():
-				return "", fmt.Errorf("%w: %w", ErrTempfail, err)
-			}
-		}
+	return rec, err
+}
 
-		return "", fmt.Errorf("%w: %w", ErrPermfail, err)
+// queryName returns the name sent to the resolver.  Single-label names are
+// rooted so that the system resolver's search list cannot rewrite them.
+func queryName(name string) string {
+	if !strings.Contains(name, ".") {
+		return name + "."
 	}
 
-	return filterSPF(txts)
+	return name
+}
+
+// classifyDNSError maps a resolver error onto the sentinel errors above so
+// that every lookup performed during evaluation is treated alike.
+//   - context cancellation → returned unchanged
+//   - NXDOMAIN / no data → ErrNoDNSrecord
+//   - SERVFAIL/timeout → ErrTempfail
+//   - any other error → ErrPermfail
+func classifyDNSError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err // propagate – let the caller decide
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsNotFound:
+			return ErrNoDNSrecord
+		case dnsErr.Temporary():
+			return fmt.Errorf("%w: %w", ErrTempfail, err)
+		}
+	}
+
+	return fmt.Errorf("%w: %w", ErrPermfail, err)
 }
 
 // filterSPF selects exactly one "v=spf1" string from the provided TXT records.
 // The selection logic implements RFC 7208 section 4.5:
 //   - 0 records → ("", nil)
 //   - 1 record → (that record, nil)
-//   - more than 1 → ("", ErrMultipleSPF)
+//   - more than 1 → ("", *MultipleSPFError)
 func filterSPF(txts []string) (string, error) {
+	found := spfCandidates(txts)
+
+	// section 4.5: 0 → none; 1 → ok; >1 → permerror
+	switch len(found) {
+	case 0:
+		return "", nil // allowed
+
+	case 1:
+		return found[0], nil
+
+	default:
+		return "", &MultipleSPFError{Records: found}
+	}
+}
+
+// MultipleSPFError is returned when a domain publishes more than one SPF
+// record, which makes check_host a permerror (RFC 7208 section 4.5).  It
+// matches ErrMultipleSPF and lists the records, so that the publisher can
+// tell which ones to delete.
+type MultipleSPFError struct {
+	Domain  string   // "" when unknown
+	Records []string // the "v=spf1" records as published
+}
+
+func (e *MultipleSPFError) Error() string {
+	var b strings.Builder
+	b.WriteString(ErrMultipleSPF.Error())
+	if e.Domain != "" {
+		b.WriteString(" for " + e.Domain)
+	}
+	for i, rec := range e.Records {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%q", rec)
+	}
+
+	return b.String()
+}
+
+func (e *MultipleSPFError) Unwrap() error {
+	return ErrMultipleSPF
+}
+
+// spfCandidates returns the TXT records starting with the "v=spf1" version
+// section (RFC 7208 section 4.5), trimmed of surrounding space.
+func spfCandidates(txts []string) []string {
 	const spfV1 = "v=spf1"
 	var found []string
 
@@ -118,16 +251,62 @@ func filterSPF(txts []string) (string, error) {
 		}
 	}
 
-	// section 4.5: 0 → none; 1 → ok; >1 → permerror
-	switch len(found) {
-	case 0:
-		return "", nil // allowed
+	return found
+}
 
-	case 1:
-		foundSpf := strings.ToLower(found[0])
-		return foundSpf, nil
+// RecordStatus tells how the SPF record of a domain was selected among its
+// TXT records (RFC 7208 section 4.5).
+type RecordStatus int
+
+const (
+	RecordFound    RecordStatus = iota // exactly one SPF record
+	RecordNone                         // TXT records, if any, but no SPF record: result none
+	RecordNoDomain                     // the domain does not exist: result none
+	RecordMultiple                     // several SPF records: result permerror
+)
+
+var recordStatusNames = []string{"found", "none", "no-domain", "multiple"}
+
+func (s RecordStatus) String() string {
+	if s < 0 || int(s) >= len(recordStatusNames) {
+		return "unknown"
+	}
 
+	return recordStatusNames[s]
+}
+
+// SPFLookup is the outcome of LookupSPF.
+type SPFLookup struct {
+	Domain     string
+	Status     RecordStatus
+	Record     string   // the selected record as published, "" unless Status is RecordFound
+	Candidates []string // the records starting with "v=spf1"
+	TXT        []string // every TXT record of the domain
+}
+
+// LookupSPF looks up the TXT records of domain and selects its SPF record as
+// check_host does (RFC 7208 section 4.5).  A missing record, a nonexistent
+// domain and several records are reported by Status, not as error; only a
+// DNS failure, classified like during evaluation, or a context error is
+// returned as error.  Like the records evaluated, Record keeps the case it
+// was published in.
+func LookupSPF(ctx context.Context, domain string, r TXTResolver) (*SPFLookup, error) {
+	txts, err := r.LookupTXT(ctx, queryName(domain))
+	if err != nil {
+		if err = classifyDNSError(err); !errors.Is(err, ErrNoDNSrecord) {
+			return nil, err
+		}
+		return &SPFLookup{Domain: domain, Status: RecordNoDomain}, nil
+	}
+	l := &SPFLookup{Domain: domain, Candidates: spfCandidates(txts), TXT: txts}
+	switch len(l.Candidates) {
+	case 0:
+		l.Status = RecordNone
+	case 1:
+		l.Status, l.Record = RecordFound, l.Candidates[0]
 	default:
-		return "", ErrMultipleSPF
+		l.Status = RecordMultiple
 	}
+
+	return l, nil
 }
@@ -0,0 +1,19 @@
+//go:build js
+
+package spf
+
+// NewDNSResolver returns a DNSResolver querying DefaultDoHURL through a
+// DoHResolver: browsers offer no DNS API, and the Fetch API under net/http
+// is the only way out of a js/wasm program.  Lookups respect context
+// timeouts and cancellations so callers can enforce the limits from RFC 7208
+// section 11.
+func NewDNSResolver() *DNSResolver {
+	return &DNSResolver{resolver: NewDoHResolver(DefaultDoHURL)}
+}
+
+// NewDNSResolverAt is NewDNSResolver querying server, which in js/wasm builds
+// is the "https://" URL of a DNS-over-HTTPS endpoint: "host:port" servers
+// and unix sockets cannot be reached from a browser.
+func NewDNSResolverAt(server string) *DNSResolver {
+	return &DNSResolver{resolver: NewDoHResolver(server)}
+}
@@ -0,0 +1,54 @@
+//go:build !js
+
+package spf
+
+import (
+	"context"
+	"net"
+)
+
+// NewDNSResolver returns a DNSResolver that performs TXT lookups using the
+// Go standard library.  Lookups respect context timeouts and cancellations so
+// callers can enforce the limits from RFC 7208 section 11.
+func NewDNSResolver() *DNSResolver {
+	r := &net.Resolver{
+		StrictErrors: true,
+		PreferGo:     true, // force pure-Go DNS implementation
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := &net.Dialer{ //nolint:exhaustruct
+				Timeout: DefaultDialTimeout,
+			}
+
+			return d.DialContext(ctx, network, address)
+		},
+	}
+
+	return &DNSResolver{resolver: r}
+}
+
+// NewDNSResolverAt is NewDNSResolver sending every query to server instead
+// of the servers of the system configuration.  Server is "host:port", which
+// allows non-standard ports, or "unix:" followed by the path of a unix stream
+// socket of a local caching daemon.
+func NewDNSResolverAt(server string) *DNSResolver {
+	r := &net.Resolver{
+		StrictErrors: true,
+		PreferGo:     true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := &net.Dialer{Timeout: DefaultDialTimeout}
+			if path, ok := unixSocket(server); ok {
+				conn, err := d.DialContext(ctx, "unix", path)
+				if err != nil {
+					return nil, err
+				}
+				// hide net.PacketConn so that the Go resolver uses the TCP
+				// framing on the stream
+				return struct{ net.Conn }{conn}, nil
+			}
+
+			return d.DialContext(ctx, network, server)
+		},
+	}
+
+	return &DNSResolver{resolver: r}
+}
@@ -92,7 +92,7 @@ func TestFilterSPF(t *testing.T) {
 		wantError bool
 	}{
 		{"valid spf -all", []string{"v=spf1 -all", "v=spf2 a -all", " v=spf10 a ~all "}, "v=spf1 -all", false},
-		{"valid spf -all", []string{"v=SPF1 -all", "v=spf2 a -all", " v=spf10 a ~all "}, "v=spf1 -all", false},
+		{"valid spf -all", []string{"v=SPF1 -all", "v=spf2 a -all", " v=spf10 a ~all "}, "v=SPF1 -all", false},
 		{"valid spf version only", []string{"v=spf1", "v=spf2 ipv4:192.168.0/24"}, "v=spf1", false},
 		{"", []string{"v=spf1 -all", "v=spf1 a -all", " v=spf10 a ~all "}, "v=spf1 -all", true},
 	}
@@ -111,3 +111,133 @@ func TestFilterSPF(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupSPF(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":  {"google-site-verification=abc", " v=spf1 Include:_spf.example.com -all "},
+		"none.example": {"google-site-verification=abc"},
+		"two.example":  {"v=spf1 -all", "v=spf1 a ~all", "v=spf10 -all"},
+	}}
+	ctx := context.Background()
+
+	l, err := LookupSPF(ctx, "example.com", zone)
+	require.NoError(t, err)
+	assert.Equal(t, RecordFound, l.Status)
+	assert.Equal(t, "v=spf1 Include:_spf.example.com -all", l.Record)
+	assert.Equal(t, []string{"v=spf1 Include:_spf.example.com -all"}, l.Candidates)
+	assert.Len(t, l.TXT, 2)
+
+	l, err = LookupSPF(ctx, "none.example", zone)
+	require.NoError(t, err)
+	assert.Equal(t, RecordNone, l.Status)
+	assert.Empty(t, l.Record)
+	assert.Empty(t, l.Candidates)
+
+	l, err = LookupSPF(ctx, "two.example", zone)
+	require.NoError(t, err)
+	assert.Equal(t, RecordMultiple, l.Status)
+	assert.Empty(t, l.Record)
+	assert.Equal(t, []string{"v=spf1 -all", "v=spf1 a ~all"}, l.Candidates)
+	assert.Equal(t, "multiple", l.Status.String())
+
+	l, err = LookupSPF(ctx, "missing.example", zone)
+	require.NoError(t, err)
+	assert.Equal(t, RecordNoDomain, l.Status)
+
+	_, err = LookupSPF(ctx, "example.com", &fakeResolver{err: &net.DNSError{Err: "timeout", IsTemporary: true}})
+	require.ErrorIs(t, err, ErrTempfail)
+}
+
+func TestMultipleSPFError(t *testing.T) {
+	r := &fakeResolver{txts: []string{"v=spf1 a -all", "txt other", " v=spf1 MX ~all"}}
+	_, err := getSPFRecord(context.Background(), "example.com", r)
+	require.ErrorIs(t, err, ErrMultipleSPF)
+	var multi *MultipleSPFError
+	require.ErrorAs(t, err, &multi)
+	assert.Equal(t, "example.com", multi.Domain)
+	assert.Equal(t, []string{"v=spf1 a -all", "v=spf1 MX ~all"}, multi.Records)
+	assert.Equal(t, `filter found multiple spf records (permerror) for example.com: "v=spf1 a -all", "v=spf1 MX ~all"`, err.Error())
+
+	res, err := NewChecker(r).CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	require.ErrorAs(t, res.Cause, &multi)
+	assert.Len(t, multi.Records, 2)
+}
+
+// zoneResolver is a per-name fake implementing TXTResolver, IPResolver and
+// MXResolver.  Names without data return NXDOMAIN.
+type zoneResolver struct {
+	txt map[string][]string
+	ip  map[string][]string
+	mx  map[string][]string
+	ptr map[string][]string
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (z *zoneResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	txts, ok := z.txt[domain]
+	if !ok {
+		return nil, notFound(domain)
+	}
+	return txts, nil
+}
+
+func (z *zoneResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, s := range z.ip[host] {
+		ip := net.ParseIP(s)
+		if (ip.To4() != nil) == (network == "ip4") {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, notFound(host)
+	}
+	return ips, nil
+}
+
+func (z *zoneResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	hosts, ok := z.mx[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	mxs := make([]*net.MX, 0, len(hosts))
+	for i, h := range hosts {
+		mxs = append(mxs, &net.MX{Host: h, Pref: uint16(i * 10)})
+	}
+	return mxs, nil
+}
+
+func (z *zoneResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	names, ok := z.ptr[addr]
+	if !ok {
+		return nil, notFound(addr)
+	}
+	return names, nil
+}
+
+func TestDNSResolver_Unsupported(t *testing.T) {
+	dr := NewCustomDNSResolver(&fakeResolver{})
+	_, err := dr.LookupIP(context.Background(), "ip4", "example.com")
+	require.ErrorIs(t, err, ErrUnsupported)
+	_, err = dr.LookupMX(context.Background(), "example.com")
+	require.ErrorIs(t, err, ErrUnsupported)
+	_, err = dr.LookupAddr(context.Background(), "192.0.2.1")
+	require.ErrorIs(t, err, ErrUnsupported)
+}
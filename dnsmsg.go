@@ -0,0 +1,219 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeSPF is the retired SPF RR type (RFC 7208 section 3.1).
+const typeSPF dnsmessage.Type = 99
+
+// rrLookup returns the answer records of type t for name, reporting NXDOMAIN
+// and empty answers as not found.  Its methods decode the answers for the
+// resolvers speaking DNS messages themselves, UpstreamResolver and
+// DoHResolver.
+type rrLookup func(ctx context.Context, name string, t dnsmessage.Type) ([]dnsmessage.Resource, error)
+
+// txt returns the TXT records of domain, each with its strings joined.
+func (lookup rrLookup) txt(ctx context.Context, domain string) ([]string, error) {
+	answers, err := lookup(ctx, domain, dnsmessage.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var txts []string
+	for _, rr := range answers {
+		if txt, ok := rr.Body.(*dnsmessage.TXTResource); ok {
+			txts = append(txts, strings.Join(txt.TXT, ""))
+		}
+	}
+
+	return txts, nil
+}
+
+// spfType returns the records of the SPF RR type for domain, each joined
+// from its character-strings like TXT records.
+func (lookup rrLookup) spfType(ctx context.Context, domain string) ([]string, error) {
+	answers, err := lookup(ctx, domain, typeSPF)
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, rr := range answers {
+		body, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok {
+			continue
+		}
+		var b strings.Builder
+		for data := body.Data; len(data) > 0; {
+			n := int(data[0])
+			if n >= len(data) {
+				return nil, &net.DNSError{Err: "malformed SPF record", Name: domain}
+			}
+			b.Write(data[1 : 1+n])
+			data = data[1+n:]
+		}
+		records = append(records, b.String())
+	}
+
+	return records, nil
+}
+
+// ip returns the A ("ip4"), AAAA ("ip6") or both ("ip") records of host.
+func (lookup rrLookup) ip(ctx context.Context, network, host string) ([]net.IP, error) {
+	var types []dnsmessage.Type
+	switch network {
+	case "ip4":
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+
+	var ips []net.IP
+	var lastErr error
+	for _, t := range types {
+		answers, err := lookup(ctx, host, t)
+		if err != nil {
+			lastErr = err
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, rr := range answers {
+			switch body := rr.Body.(type) {
+			case *dnsmessage.AResource:
+				ips = append(ips, net.IP(body.A[:]))
+			case *dnsmessage.AAAAResource:
+				ips = append(ips, net.IP(body.AAAA[:]))
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, lastErr
+	}
+
+	return ips, nil
+}
+
+// mx returns the MX records of name, with fully qualified hosts.
+func (lookup rrLookup) mx(ctx context.Context, name string) ([]*net.MX, error) {
+	answers, err := lookup(ctx, name, dnsmessage.TypeMX)
+	if err != nil {
+		return nil, err
+	}
+	var mxs []*net.MX
+	for _, rr := range answers {
+		if mx, ok := rr.Body.(*dnsmessage.MXResource); ok {
+			mxs = append(mxs, &net.MX{Host: mx.MX.String(), Pref: mx.Pref})
+		}
+	}
+
+	return mxs, nil
+}
+
+// addr returns the PTR names of addr, with a trailing dot.
+func (lookup rrLookup) addr(ctx context.Context, addr string) ([]string, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, &net.DNSError{Err: "unrecognized address", Name: addr}
+	}
+	answers, err := lookup(ctx, reverseName(ip), dnsmessage.TypePTR)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, rr := range answers {
+		if ptr, ok := rr.Body.(*dnsmessage.PTRResource); ok {
+			names = append(names, ptr.PTR.String())
+		}
+	}
+
+	return names, nil
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of ip.
+func reverseName(ip netip.Addr) string {
+	ip = ip.Unmap()
+	var b strings.Builder
+	if ip.Is4() {
+		a := ip.As4()
+		fmt.Fprintf(&b, "%d.%d.%d.%d.in-addr.arpa.", a[3], a[2], a[1], a[0])
+		return b.String()
+	}
+	a := ip.As16()
+	const hex = "0123456789abcdef"
+	for i := len(a) - 1; i >= 0; i-- {
+		b.WriteByte(hex[a[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hex[a[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+
+	return b.String()
+}
+
+// answers returns the records of type t in msg, the answer of server to a
+// query for name, and reports their TTL with ReportTTL.  NXDOMAIN and empty
+// answers are reported as not found, like net.Resolver does, with their
+// negative caching TTL.
+func answers(ctx context.Context, msg *dnsmessage.Message, name, server string, t dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	if msg.RCode == dnsmessage.RCodeNameError {
+		reportNegativeTTL(ctx, msg)
+		return nil, &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+	}
+
+	var out []dnsmessage.Resource
+	for _, rr := range msg.Answers {
+		if rr.Header.Type == t {
+			out = append(out, rr)
+		}
+	}
+	if len(out) == 0 {
+		reportNegativeTTL(ctx, msg)
+		return nil, &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+	}
+	for _, rr := range msg.Answers {
+		ReportTTL(ctx, time.Duration(rr.Header.TTL)*time.Second)
+	}
+
+	return out, nil
+}
+
+// rcodeError returns the error for the response codes that make a server
+// unfit to answer, such as SERVFAIL and REFUSED.  NXDOMAIN is an answer.
+func rcodeError(msg *dnsmessage.Message, name, server string) error {
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+		return nil
+	}
+
+	return &net.DNSError{Err: "server misbehaving: " + msg.RCode.String(), Name: name, Server: server, IsTemporary: true}
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+
+	return name + "."
+}
+
+// reportNegativeTTL reports the negative caching TTL of RFC 2308 section 5:
+// the smaller of the SOA TTL and its MINIMUM field.
+func reportNegativeTTL(ctx context.Context, msg *dnsmessage.Message) {
+	for _, rr := range msg.Authorities {
+		if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+			ReportTTL(ctx, time.Duration(min(rr.Header.TTL, soa.MinTTL))*time.Second)
+		}
+	}
+}
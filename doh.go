@@ -0,0 +1,153 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDoHURL is the DNS-over-HTTPS endpoint queried by NewDNSResolver in
+// js/wasm builds, one that allows cross-origin requests from browsers.
+const DefaultDoHURL = "https://cloudflare-dns.com/dns-query"
+
+// dohMediaType is the media type of DNS messages (RFC 8484 section 6).
+const dohMediaType = "application/dns-message"
+
+// maxDNSMessage is the largest DNS message, bounding answer bodies.
+const maxDNSMessage = 65535
+
+// DoHResolver sends queries to a DNS-over-HTTPS server (RFC 8484) with
+// net/http, whose js/wasm transport uses the browser's Fetch API, so that
+// checkers compiled to WebAssembly can resolve names.  Elsewhere it serves
+// networks where only HTTPS leaves.  It implements TXTResolver,
+// SPFTypeResolver, IPResolver, MXResolver and PTRResolver and reports the
+// TTL of every answer with ReportTTL.
+type DoHResolver struct {
+	URL    string       // endpoint, e.g. "https://dns.example/dns-query"
+	Client *http.Client // http.DefaultClient when nil
+	// Timeout bounds each query, DefaultDialTimeout when zero.
+	Timeout time.Duration
+}
+
+// NewDoHResolver returns a DoHResolver querying the endpoint url.
+func NewDoHResolver(url string) *DoHResolver {
+	return &DoHResolver{URL: url}
+}
+
+// LookupTXT returns the TXT records of domain, each with its strings joined.
+func (d *DoHResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	return rrLookup(d.lookup).txt(ctx, domain)
+}
+
+// LookupSPFType returns the records of the SPF RR type for domain.
+func (d *DoHResolver) LookupSPFType(ctx context.Context, domain string) ([]string, error) {
+	return rrLookup(d.lookup).spfType(ctx, domain)
+}
+
+// LookupIP returns the A ("ip4"), AAAA ("ip6") or both ("ip") records of
+// host.
+func (d *DoHResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return rrLookup(d.lookup).ip(ctx, network, host)
+}
+
+// LookupMX returns the MX records of name, with fully qualified hosts.
+func (d *DoHResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return rrLookup(d.lookup).mx(ctx, name)
+}
+
+// LookupAddr returns the PTR names of addr, with a trailing dot.
+func (d *DoHResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return rrLookup(d.lookup).addr(ctx, addr)
+}
+
+// lookup returns the answer records of type t for name.
+func (d *DoHResolver) lookup(ctx context.Context, name string, t dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+	msg, err := d.exchange(ctx, qname, t)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err == nil {
+		err = rcodeError(msg, name, d.URL)
+	}
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return nil, dnsErr
+		}
+		return nil, &net.DNSError{Err: err.Error(), Name: name, Server: d.URL, IsTemporary: true}
+	}
+
+	return answers(ctx, msg, name, d.URL, t)
+}
+
+// exchange POSTs a query for qname to the endpoint and decodes the answer.
+// The query ID is 0, as RFC 8484 section 4.1 recommends for HTTP caching.
+func (d *DoHResolver) exchange(ctx context.Context, qname dnsmessage.Name, t dnsmessage.Type) (*dnsmessage.Message, error) {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: t, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %s from %s", resp.Status, d.URL)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDNSMessage+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDNSMessage {
+		return nil, fmt.Errorf("oversized answer from %s", d.URL)
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(body); err != nil {
+		return nil, err
+	}
+	if len(msg.Questions) != 1 || msg.Questions[0].Type != t || !strings.EqualFold(msg.Questions[0].Name.String(), qname.String()) {
+		return nil, fmt.Errorf("mismatched answer from %s", d.URL)
+	}
+
+	return &msg, nil
+}
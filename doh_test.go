@@ -0,0 +1,116 @@
+package spf
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newDoHServer serves DNS-over-HTTPS answers built by answer.
+func newDoHServer(t *testing.T, answer func(q dnsmessage.Message) dnsmessage.Message) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var q dnsmessage.Message
+		if err := q.Unpack(body); err != nil || q.ID != 0 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		resp := answer(q)
+		resp.Response, resp.Questions = true, q.Questions
+		out, err := resp.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(out)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestDoHResolver(t *testing.T) {
+	srv := newDoHServer(t, func(q dnsmessage.Message) dnsmessage.Message {
+		name := q.Questions[0].Name
+		hdr := dnsmessage.ResourceHeader{Name: name, Type: q.Questions[0].Type, Class: dnsmessage.ClassINET, TTL: 300}
+		switch {
+		case q.Questions[0].Type == dnsmessage.TypeTXT && name.String() == "example.com.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 600, "v=spf1 ", "a -all")}}
+		case q.Questions[0].Type == dnsmessage.TypeA && name.String() == "example.com.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}}}}
+		case q.Questions[0].Type == dnsmessage.TypeMX && name.String() == "example.com.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mail.example.com.")}}}}
+		case q.Questions[0].Type == dnsmessage.TypePTR && name.String() == "1.2.0.192.in-addr.arpa.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("example.com.")}}}}
+		case name.String() == "broken.example.com.":
+			return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}}
+		case name.String() == "missing.example.com.":
+			return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError}}
+		}
+		return dnsmessage.Message{}
+	})
+	d := &DoHResolver{URL: srv.URL, Client: srv.Client()}
+	ctx := context.Background()
+
+	txts, err := d.LookupTXT(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=spf1 a -all"}, txts)
+
+	ips, err := d.LookupIP(ctx, "ip", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1").To4()}, ips)
+
+	mxs, err := d.LookupMX(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []*net.MX{{Host: "mail.example.com.", Pref: 10}}, mxs)
+
+	names, err := d.LookupAddr(ctx, "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com."}, names)
+
+	_, err = d.LookupTXT(ctx, "missing.example.com")
+	assert.True(t, isNotFound(err))
+	_, err = d.LookupSPFType(ctx, "example.com")
+	assert.True(t, isNotFound(err), "empty answer is not found")
+
+	var dnsErr *net.DNSError
+	_, err = d.LookupTXT(ctx, "broken.example.com")
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsTemporary)
+
+	ch := NewChecker(NewCustomDNSResolver(d))
+	res, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Equal(t, 300*time.Second, res.TTL)
+}
+
+func TestDoHResolver_HTTPErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := NewDoHResolver(srv.URL).LookupTXT(context.Background(), "example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsTemporary)
+	assert.Contains(t, dnsErr.Err, "503")
+
+	res, _ := NewChecker(NewDoHResolver(srv.URL)).CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	assert.Equal(t, TempError, res.Code)
+}
@@ -0,0 +1,42 @@
+package spf
+
+import (
+	"context"
+	"maps"
+	"net"
+)
+
+// Enricher returns external data about the client of a final result, such as
+// its ASN or a reputation score, to attach to the result before it is logged
+// or rendered.  The network that authorized or rejected the client, if any,
+// is res.Match.Prefix.  Keys should be identifiers such as "asn", since
+// FormatCEF and FormatLEEF use them as field names.  An Enricher is called
+// synchronously and must be safe for concurrent use when the Checker is.
+type Enricher func(ctx context.Context, ip net.IP, res CheckHostResult) map[string]string
+
+// WithEnricher adds fn to the enrichers called for every result.  The
+// attributes of enrichers added later replace those of earlier ones with the
+// same key.
+func WithEnricher(fn Enricher) Option {
+	return func(c *Checker) { c.enrichers = append(c.enrichers, fn) }
+}
+
+// enrich adds the attributes of the Checker's enrichers to res, the final
+// result for the client ip.
+func (c *Checker) enrich(ctx context.Context, ip net.IP, res CheckHostResult) CheckHostResult {
+	if len(c.enrichers) == 0 || res.Code == "" || ctx.Err() != nil {
+		return res
+	}
+	for _, fn := range c.enrichers {
+		attrs := fn(ctx, ip, res)
+		if len(attrs) == 0 {
+			continue
+		}
+		if res.Attributes == nil {
+			res.Attributes = make(map[string]string, len(attrs))
+		}
+		maps.Copy(res.Attributes, attrs)
+	}
+
+	return res
+}
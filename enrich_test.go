@@ -0,0 +1,48 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithEnricher(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24 -all"}}}
+	var seen []netip.Prefix
+	asn := func(_ context.Context, ip net.IP, res CheckHostResult) map[string]string {
+		if res.Match != nil {
+			seen = append(seen, res.Match.Prefix)
+		}
+		if ip.Equal(net.ParseIP("192.0.2.1")) {
+			return map[string]string{"asn": "64496", "reputation": "unknown"}
+		}
+		return nil
+	}
+	score := func(context.Context, net.IP, CheckHostResult) map[string]string {
+		return map[string]string{"reputation": "good"}
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithEnricher(asn), WithEnricher(score))
+	ctx := context.Background()
+
+	res, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"asn": "64496", "reputation": "good"}, res.Attributes)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, seen)
+
+	res, err = ch.CheckHost(ctx, net.ParseIP("203.0.113.1"), "bad..example", "")
+	require.NoError(t, err)
+	assert.Equal(t, None, res.Code)
+	assert.Equal(t, map[string]string{"reputation": "good"}, res.Attributes)
+
+	res, err = NewChecker(NewCustomDNSResolver(zone)).CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Nil(t, res.Attributes)
+
+	line := FormatCEF(SIEMEvent{Result: CheckHostResult{Code: Pass, Attributes: map[string]string{"reputation": "good", "asn": "64496"}}})
+	assert.True(t, strings.HasSuffix(line, " asn=64496 reputation=good"), line)
+}
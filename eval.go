@@ -0,0 +1,737 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// Errors reported as the Cause of a PermError while walking a record.
+var (
+	ErrTooManyLookups     = errors.New("permerror: too many DNS lookups")
+	ErrTooManyVoidLookups = errors.New("permerror: too many void DNS lookups")
+	ErrTooManyMX          = errors.New("permerror: too many MX records")
+	ErrInvalidDomainSpec  = errors.New("permerror: invalid domain-spec")
+	ErrMissingRecord      = errors.New("permerror: include or redirect target has no SPF record")
+)
+
+// maxMXRecords bounds the address lookups performed for a single "mx"
+// mechanism (RFC 7208 section 4.6.4).
+const maxMXRecords = 10
+
+// evaluation carries the state of one check_host() invocation.  The DNS and
+// void lookup counters are shared with any nested evaluation triggered by
+// include or redirect, as required by RFC 7208 section 4.6.4.
+type evaluation struct {
+	checker *Checker
+	ip      net.IP // 4-byte for IPv4 and IPv4-mapped clients, 16-byte otherwise
+	sender  string
+	helo    string
+	lookups int
+	voids   int
+	macro   *MacroExpander
+	stats   EvalStats // Queries and CacheHits; the rest is filled in by run
+
+	// terms, expansions and exists count against the ComplexityLimits.
+	terms      int
+	expansions int
+	exists     int
+
+	// recordChain enables recording of chain, the terms that led to the
+	// result, for Checker.Explain.
+	recordChain bool
+	chain       []ChainStep
+
+	depth    int          // include and redirect hops of the current record
+	includes int          // include hops only; explanations are skipped
+	prefix   netip.Prefix // network matched by the last address-based match
+	matched  *MatchInfo   // innermost mechanism that determined the result
+	ttl      ttlRecorder  // minimum TTL of the answers used
+
+	simulated map[string]string // records injected with Simulate
+	local     *localPolicy      // LocalPolicy of the Checker at the start
+	evaluated []evaluatedTerm   // terms evaluated so far, if interruptible
+	records   []FetchedRecord   // records evaluated so far
+
+	// ptrNames are the validated names of the client once ptrResolved,
+	// shared by the ptr mechanisms and "p" macros of the evaluation.
+	ptrNames    []string
+	ptrResolved bool
+	// correlationID is the ID of the context, for TraceEvents.
+	correlationID string
+	// interruptible is set when the context can be canceled, so that the
+	// evaluated terms are kept for a partial result.
+	interruptible bool
+
+	// unlimited lifts the lookup limits for CheckLimits, up to
+	// unlimitedLookups which stops include loops.
+	unlimited bool
+}
+
+// newEvaluation prepares the shared state for evaluating ip and sender.
+func (c *Checker) newEvaluation(ip net.IP, sender string) *evaluation {
+	e := &evaluation{
+		checker: c,
+		ip:      normalizeIP(ip),
+		sender:  sender,
+		local:   c.local.Load(),
+	}
+	e.macro = newMacroExpander(e)
+
+	return e
+}
+
+// normalizeIP returns ip in the form used for matching.  IPv4 clients,
+// whether encoded as 4-byte slices or as IPv4-mapped IPv6 addresses
+// (::ffff:192.0.2.1), are reduced to 4 bytes so that only "ip4" mechanisms
+// and A records can match them (RFC 7208 section 5).  Everything else is
+// returned in its 16-byte form.
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+
+	return ip.To16()
+}
+
+// isIPv4 reports whether the client address is handled with the IPv4 rules.
+func (e *evaluation) isIPv4() bool {
+	return len(e.ip) == net.IPv4len
+}
+
+// checkHost fetches and evaluates the SPF record of domain.  It is used for
+// the initial query as well as for include and redirect targets.
+func (e *evaluation) checkHost(ctx context.Context, domain string) (CheckHostResult, error) {
+	spfRecord, err := e.record(ctx, domain)
+
+	// Apply the record-selection logic from RFC 7208 section 4.5.
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// Context errors are outside the scope of RFC 7208.
+		return CheckHostResult{}, err
+	case errors.Is(err, ErrNoDNSrecord):
+		return CheckHostResult{Code: None, Cause: err}, err
+	case errors.Is(err, ErrTempfail):
+		return CheckHostResult{Code: TempError, Cause: err}, nil
+	case errors.Is(err, ErrPermfail), errors.Is(err, ErrMultipleSPF):
+		return CheckHostResult{Code: PermError, Cause: err}, nil
+	case err != nil:
+		return CheckHostResult{}, err
+	}
+
+	if spfRecord == "" {
+		return CheckHostResult{}, err
+	}
+
+	return e.evaluate(ctx, domain, spfRecord)
+}
+
+// record returns the SPF record of domain, from an override or DNS (RFC
+// 7208 section 4.4), and records its provenance.  It returns "" when domain
+// publishes no record and the errors of getSPFRecord, except for several
+// records when the Checker is configured to select one of them.
+func (e *evaluation) record(ctx context.Context, domain string) (string, error) {
+	if text, ok := e.override(domain); ok {
+		if text == "" {
+			return "", ErrNoDNSrecord
+		}
+		e.fetched(domain, text, true)
+		return text, nil
+	}
+
+	e.stats.Queries++
+	text, err := getSPFRecord(ctx, domain, e.checker.resolver)
+	var multi *MultipleSPFError
+	if e.checker.multipleRecords != MultipleRecordsPermError && errors.As(err, &multi) {
+		var discarded []string
+		text, discarded = e.checker.multipleRecords.selectRecord(multi.Records)
+		e.fetched(domain, text, false)
+		e.records[len(e.records)-1].Discarded = discarded
+		return text, nil
+	}
+	if err == nil && text != "" {
+		e.fetched(domain, text, false)
+	}
+
+	return text, err
+}
+
+// fetched records the provenance of a record about to be evaluated.
+func (e *evaluation) fetched(domain, text string, override bool) {
+	e.records = append(e.records, FetchedRecord{Domain: domain, Text: text, Override: override, Time: e.checker.now()})
+}
+
+// override returns the record of domain given to Simulate or, failing
+// that, configured in the LocalPolicy, if any.
+func (e *evaluation) override(domain string) (string, bool) {
+	key := overrideKey(domain)
+	if text, ok := e.simulated[key]; ok {
+		return text, true
+	}
+	if e.local == nil {
+		return "", false
+	}
+	text, ok := e.local.overrides[key]
+
+	return text, ok
+}
+
+// overrideKey normalizes domain for the lookup of record overrides.
+func overrideKey(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// evaluate walks the SPF decision tree for the given record as described in
+// RFC 7208 section 4.6.
+func (e *evaluation) evaluate(ctx context.Context, domain, spf string) (CheckHostResult, error) {
+	rec, err := e.checker.parse(spf)
+	if err != nil {
+		return CheckHostResult{Code: PermError, Cause: e.checker.syntaxError(domain, spf, err)}, nil
+	}
+
+	// Walk mechanisms in order as required by RFC 7208 section 4.6.
+	for i := range rec.Mechs {
+		// stop as soon as the caller gives up, even between terms that
+		// need no DNS
+		if err := ctx.Err(); err != nil {
+			return CheckHostResult{}, err
+		}
+		mech := &rec.Mechs[i]
+		if err := e.countComplexity(LimitTerms); err != nil {
+			return resultFromError(termError(domain, rec, mechanismIndex(rec, i), err))
+		}
+		matched, err := e.match(ctx, mech, domain)
+		e.traceTerm(domain, mech, matched, err)
+		if err != nil {
+			return resultFromError(termError(domain, rec, mechanismIndex(rec, i), err))
+		}
+		if matched {
+			e.recordMatch(domain, mech)
+			res := CheckHostResult{Code: resultFromQualifier(mech.Qual)}
+			// section 6.2: the explanation of an included record is
+			// ignored
+			if res.Code == Fail && rec.Exp != nil && e.includes == 0 {
+				if res.Explanation, err = e.explain(ctx, rec.Exp, domain); err != nil {
+					return CheckHostResult{}, err
+				}
+			}
+			return res, nil
+		}
+	}
+
+	if res, ok, err := e.modifierExtensions(ctx, rec, domain); ok {
+		return res, err
+	}
+
+	// section 6.1: redirect is ignored when the record contains "all"
+	if rec.Redirect != nil && !hasAll(rec) {
+		res, err := e.redirect(ctx, rec, domain)
+		e.recordRedirect(domain, rec.Redirect)
+		return res, err
+	}
+
+	e.chain, e.matched = nil, nil
+	return CheckHostResult{Code: Neutral, Cause: errors.New("policy exists but no assertion")}, nil
+}
+
+// explain computes the explanation string for a Fail result as described in
+// RFC 7208 section 6.2.  Any problem while fetching or expanding it leaves the
+// explanation empty rather than changing the result, as does a record
+// exceeding the Checker's ExplanationLimits; only context errors are returned.
+// The lookup does not count against the DNS lookup limit; it is only made
+// outside includes, whose explanations are ignored.
+func (e *evaluation) explain(ctx context.Context, mod *parser.Modifier, domain string) (string, error) {
+	target, err := e.macro.Expand(ctx, mod.Value, domain)
+	if err != nil {
+		return "", contextError(err)
+	}
+	target, err = validateExpanded(target)
+	if err != nil {
+		return "", nil
+	}
+
+	e.stats.Queries++
+	txts, err := e.checker.resolver.LookupTXT(ctx, queryName(target))
+	if err != nil {
+		err = classifyDNSError(err)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return "", err
+		}
+		return "", nil
+	}
+	if len(txts) != 1 || !e.checker.expLimits.allowRecord(txts[0]) {
+		return "", nil
+	}
+
+	text, err := e.macro.ExpandExplanation(ctx, txts[0], domain)
+	if err != nil {
+		return "", contextError(err)
+	}
+	if max := e.checker.expLimits.MaxLength; max > 0 && len(text) > max {
+		return "", nil
+	}
+
+	return text, nil
+}
+
+// allowRecord reports whether an explanation TXT record is within l and is a
+// syntactically valid explain-string, i.e. visible ASCII and spaces only
+// (RFC 7208 section 6.2).
+func (l ExplanationLimits) allowRecord(txt string) bool {
+	if l.MaxRecordSize > 0 && len(txt) > l.MaxRecordSize {
+		return false
+	}
+	if l.MaxMacros > 0 && strings.Count(txt, "%{") > l.MaxMacros {
+		return false
+	}
+	for i := 0; i < len(txt); i++ {
+		if txt[i] < ' ' || txt[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// contextError returns err if it is a context error and nil otherwise.
+func contextError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	return nil
+}
+
+// hasAll reports whether rec contains an "all" mechanism.
+func hasAll(rec *parser.Record) bool {
+	for _, m := range rec.Mechs {
+		if m.Kind == "all" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redirect evaluates the target of the redirect modifier of rec, whose result
+// becomes the result of the current record (RFC 7208 section 6.1).
+func (e *evaluation) redirect(ctx context.Context, rec *parser.Record, domain string) (CheckHostResult, error) {
+	index := modifierIndex(rec, "redirect")
+	if err := e.countComplexity(LimitTerms); err != nil {
+		return resultFromError(termError(domain, rec, index, err))
+	}
+	target, err := e.expandDomain(ctx, rec.Redirect.Value, domain)
+	if err != nil {
+		return resultFromError(termError(domain, rec, index, err))
+	}
+	if err := e.countLookup(); err != nil {
+		return resultFromError(termError(domain, rec, index, err))
+	}
+
+	res, err := e.nested(ctx, target)
+	if err != nil {
+		return resultFromError(termError(domain, rec, index, fmt.Errorf("redirect=%s: %w", target, err)))
+	}
+
+	return res, nil
+}
+
+// nested runs check_host() for an include or redirect target.  A target
+// without an SPF record is reported as ErrMissingRecord, and TempError or
+// PermError results are returned as errors wrapping their cause.
+func (e *evaluation) nested(ctx context.Context, target string) (CheckHostResult, error) {
+	e.depth++
+	res, err := e.checkHost(ctx, target)
+	e.depth--
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return res, err
+	case errors.Is(err, ErrNoDNSrecord), err == nil && (res.Code == None || res.Code == ""):
+		return res, ErrMissingRecord
+	case err != nil:
+		return res, err
+	}
+
+	switch res.Code {
+	case TempError:
+		if res.Cause == nil {
+			return res, ErrTempfail
+		}
+		return res, res.Cause
+	case PermError:
+		if res.Cause == nil {
+			return res, ErrPermfail
+		}
+		return res, res.Cause
+	}
+
+	return res, nil
+}
+
+// resultFromError converts an error raised while matching a mechanism into the
+// corresponding result.  Context errors are returned to the caller unchanged.
+func resultFromError(err error) (CheckHostResult, error) {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return CheckHostResult{}, err
+	case errors.Is(err, ErrTempfail):
+		return CheckHostResult{Code: TempError, Cause: err}, nil
+	default:
+		return CheckHostResult{Code: PermError, Cause: err}, nil
+	}
+}
+
+// match reports whether mech matches the client.  A non-nil error aborts the
+// evaluation and is converted with resultFromError.
+func (e *evaluation) match(ctx context.Context, mech *parser.Mechanism, domain string) (bool, error) {
+	e.prefix = netip.Prefix{}
+	switch mech.Kind {
+	case "all":
+		e.prefix = netip.PrefixFrom(e.addr(), 0).Masked()
+		return true, nil
+	case "ip4":
+		// section 5.6: ip4 only ever matches IPv4 clients
+		if !e.isIPv4() || !mech.Net.Contains(e.ip) {
+			return false, nil
+		}
+		e.prefix = ipNetPrefix(mech.Net)
+		return true, nil
+	case "ip6":
+		// section 5.6: IPv4-mapped clients are matched with the IPv4 rules
+		if e.isIPv4() || len(e.ip) != net.IPv6len || !mech.Net.Contains(e.ip) {
+			return false, nil
+		}
+		e.prefix = ipNetPrefix(mech.Net)
+		return true, nil
+	case "a":
+		target, err := e.target(ctx, mech, domain)
+		if err != nil {
+			return false, err
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		addrs, err := e.lookupIP(ctx, target, e.network(), true)
+		if err != nil {
+			return false, err
+		}
+		return e.containsClient(addrs, mech), nil
+	case "mx":
+		target, err := e.target(ctx, mech, domain)
+		if err != nil {
+			return false, err
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		return e.matchMX(ctx, target, mech)
+	case "ptr":
+		target, err := e.target(ctx, mech, domain)
+		if err != nil {
+			return false, err
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		return e.matchPTR(ctx, target)
+	case "include":
+		target, err := e.expandDomain(ctx, mech.Domain, domain)
+		if err != nil {
+			return false, err
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		// section 5.2: only a nested Pass is a match, errors propagate
+		e.includes++
+		res, err := e.nested(ctx, target)
+		e.includes--
+		if err != nil {
+			return false, fmt.Errorf("include:%s: %w", target, err)
+		}
+		return res.Code == Pass, nil
+	case "exists":
+		if err := e.countComplexity(LimitExists); err != nil {
+			return false, err
+		}
+		target, err := e.target(ctx, mech, domain)
+		if err != nil {
+			return false, err
+		}
+		if err := e.countLookup(); err != nil {
+			return false, err
+		}
+		// section 5.7: the lookup type is A even when the client is IPv6
+		addrs, err := e.lookupIP(ctx, target, "ip4", e.checker.voidPolicy.Exists)
+		if err != nil {
+			return false, err
+		}
+		return len(addrs) > 0, nil
+	default:
+		if fn, ok := e.checker.mechanisms[mech.Kind]; ok {
+			return e.matchExtension(ctx, fn, mech, domain)
+		}
+		return false, nil
+	}
+}
+
+// matchMX implements the "mx" mechanism (RFC 7208 section 5.4).
+func (e *evaluation) matchMX(ctx context.Context, target string, mech *parser.Mechanism) (bool, error) {
+	resolver, ok := e.checker.resolver.(MXResolver)
+	if !ok {
+		return false, fmt.Errorf("%w: %w", ErrPermfail, ErrUnsupported)
+	}
+	e.stats.Queries++
+	mxs, err := resolver.LookupMX(ctx, queryName(target))
+	if err != nil {
+		if err = classifyDNSError(err); errors.Is(err, ErrNoDNSrecord) {
+			return false, e.countVoid(false)
+		}
+		return false, err
+	}
+	if len(mxs) == 0 {
+		return false, e.countVoid(true)
+	}
+	if len(mxs) > maxMXRecords {
+		return false, fmt.Errorf("%w: %s has %d", ErrTooManyMX, target, len(mxs))
+	}
+
+	for _, mx := range mxs {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		if isNullMX(mx) {
+			continue
+		}
+		host := strings.TrimSuffix(mx.Host, ".")
+		addrs, err := e.resolveIP(ctx, host, e.network())
+		if err != nil {
+			if errors.Is(err, ErrNoDNSrecord) {
+				continue
+			}
+			return false, err
+		}
+		if e.containsClient(addrs, mech) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// isNullMX reports whether mx is the null MX of RFC 7505 section 3, "0 .",
+// by which a domain declares that it accepts no mail.  Its exchange is the
+// root and has no addresses to resolve, so an "mx" mechanism skips it: a
+// domain publishing only a null MX matches no client, and the MX lookup is
+// neither void nor followed by address lookups.
+func isNullMX(mx *net.MX) bool {
+	return mx.Host == "." || mx.Host == ""
+}
+
+// network returns the LookupIP network matching the client's address family:
+// A records for IPv4 clients and AAAA records for IPv6 clients (section 5).
+func (e *evaluation) network() string {
+	if e.isIPv4() {
+		return "ip4"
+	}
+
+	return "ip6"
+}
+
+// containsClient reports whether any of addrs, widened by the dual CIDR
+// lengths of mech, contains the client address (RFC 7208 section 5.6).
+func (e *evaluation) containsClient(addrs []net.IP, mech *parser.Mechanism) bool {
+	bits, ones := net.IPv6len*8, mech.Mask6
+	if e.isIPv4() {
+		bits, ones = net.IPv4len*8, mech.Mask4
+	}
+	if ones < 0 {
+		ones = bits
+	}
+	if ones == 0 {
+		// section 5.6: a zero length matches every address of the family
+		for _, addr := range addrs {
+			if len(normalizeIP(addr)) == len(e.ip) {
+				e.prefix = netip.PrefixFrom(e.addr(), 0).Masked()
+				return true
+			}
+		}
+		return false
+	}
+	mask := net.CIDRMask(ones, bits)
+
+	for _, addr := range addrs {
+		addr = normalizeIP(addr)
+		if len(addr) != len(e.ip) {
+			// never match across address families
+			continue
+		}
+		n := net.IPNet{IP: addr.Mask(mask), Mask: mask}
+		if n.Contains(e.ip) {
+			e.prefix = ipNetPrefix(&n)
+			return true
+		}
+	}
+
+	return false
+}
+
+// addr returns the client address as a netip.Addr.
+func (e *evaluation) addr() netip.Addr {
+	a, _ := netip.AddrFromSlice(e.ip)
+	return a
+}
+
+// ipNetPrefix converts a network of 4-byte or 16-byte form to a Prefix.
+func ipNetPrefix(n *net.IPNet) netip.Prefix {
+	a, _ := netip.AddrFromSlice(normalizeIP(n.IP))
+	ones, _ := n.Mask.Size()
+
+	return netip.PrefixFrom(a, ones)
+}
+
+// target returns the domain a mechanism applies to: its expanded domain-spec
+// or, when none is given, the current domain.
+func (e *evaluation) target(ctx context.Context, mech *parser.Mechanism, domain string) (string, error) {
+	if mech.Domain == "" {
+		return domain, nil
+	}
+
+	return e.expandDomain(ctx, mech.Domain, domain)
+}
+
+// expandDomain expands the macros in a domain-spec and validates the result
+// (RFC 7208 sections 4.8 and 7.3).
+func (e *evaluation) expandDomain(ctx context.Context, spec, domain string) (string, error) {
+	expanded, err := e.macro.Expand(ctx, spec, domain)
+	if err != nil {
+		return "", err
+	}
+	valid, err := validateExpanded(expanded)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q: %w", ErrInvalidDomainSpec, expanded, err)
+	}
+
+	return valid, nil
+}
+
+// validateExpanded truncates and validates an expanded domain-spec (RFC 7208
+// section 7.3).  Upper-case macros URL escape their value and the local part
+// of a sender may hold any printable character, so labels other than the
+// top-level one may contain them, as the DNS allows; they are kept as
+// expanded while the rest of the name is checked by ValidateDomainSpec.
+func validateExpanded(expanded string) (string, error) {
+	name := truncateDomain(expanded)
+	labels := strings.Split(name, ".")
+	var raw []int
+	for i := range len(labels) - 1 {
+		if rawLabel(labels[i]) {
+			raw = append(raw, i)
+		}
+	}
+	if len(raw) == 0 {
+		return parser.ValidateDomainSpec(name)
+	}
+
+	placeholders := slices.Clone(labels)
+	for _, i := range raw {
+		if len(labels[i]) > 63 {
+			return "", parser.ErrLabelTooLong
+		}
+		placeholders[i] = "x"
+	}
+	valid, err := parser.ValidateDomainSpec(strings.Join(placeholders, "."))
+	if err != nil {
+		return "", err
+	}
+	out := strings.Split(valid, ".")
+	for _, i := range raw {
+		out[i] = labels[i]
+	}
+
+	return strings.Join(out, "."), nil
+}
+
+// rawLabel reports whether lbl is printable ASCII outside the letters,
+// digits, hyphens and underscores of a hostname label.
+func rawLabel(lbl string) bool {
+	raw := false
+	for i := 0; i < len(lbl); i++ {
+		switch c := lbl[i]; {
+		case c <= ' ' || c > '~':
+			return false
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			raw = true
+		}
+	}
+
+	return raw
+}
+
+// countLookup charges one DNS-querying term against the limit in RFC 7208
+// section 4.6.4.
+func (e *evaluation) countLookup() error {
+	e.lookups++
+	if e.unlimited {
+		if e.lookups > unlimitedLookups {
+			return fmt.Errorf("%w: safety limit is %d", ErrTooManyLookups, unlimitedLookups)
+		}
+		return nil
+	}
+	if e.lookups > e.checker.maxLookups {
+		return fmt.Errorf("%w: limit is %d", ErrTooManyLookups, e.checker.maxLookups)
+	}
+
+	return nil
+}
+
+// countVoid records a lookup that returned NXDOMAIN or, when nodata is set,
+// no answers.  No-data answers are ignored unless the Checker's VoidPolicy
+// counts them.
+func (e *evaluation) countVoid(nodata bool) error {
+	if nodata && !e.checker.voidPolicy.NoData {
+		return nil
+	}
+	e.voids++
+	if !e.unlimited && e.voids > e.checker.maxVoidLookups {
+		return fmt.Errorf("%w: limit is %d", ErrTooManyVoidLookups, e.checker.maxVoidLookups)
+	}
+
+	return nil
+}
+
+// lookupIP performs the address lookup for a mechanism, counting empty
+// answers as void lookups when void is set.
+func (e *evaluation) lookupIP(ctx context.Context, host, network string, void bool) ([]net.IP, error) {
+	addrs, err := e.resolveIP(ctx, host, network)
+	if errors.Is(err, ErrNoDNSrecord) || (err == nil && len(addrs) == 0) {
+		if !void {
+			return nil, nil
+		}
+		return nil, e.countVoid(err == nil)
+	}
+
+	return addrs, err
+}
+
+// resolveIP queries the A or AAAA records of host and classifies failures.
+func (e *evaluation) resolveIP(ctx context.Context, host, network string) ([]net.IP, error) {
+	resolver, ok := e.checker.resolver.(IPResolver)
+	if !ok {
+		return nil, fmt.Errorf("%w: %w", ErrPermfail, ErrUnsupported)
+	}
+	e.stats.Queries++
+	addrs, err := resolver.LookupIP(ctx, network, queryName(host))
+	if err != nil {
+		return nil, classifyDNSError(err)
+	}
+
+	return addrs, nil
+}
@@ -0,0 +1,468 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	assert.Len(t, normalizeIP(net.ParseIP("192.0.2.1")), net.IPv4len)
+	assert.Len(t, normalizeIP(net.ParseIP("::ffff:192.0.2.1")), net.IPv4len)
+	assert.Len(t, normalizeIP(net.IPv4(192, 0, 2, 1).To4()), net.IPv4len)
+	assert.Len(t, normalizeIP(net.ParseIP("2001:db8::1")), net.IPv6len)
+	assert.Nil(t, normalizeIP(nil))
+}
+
+func TestChecker_DualStack(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":    {"v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 -all"},
+			"a.example.com":  {"v=spf1 a/24//64 -all"},
+			"mx.example.com": {"v=spf1 mx -all"},
+			"ex.example.com": {"v=spf1 exists:%{ir}.%{v}.list.example.com -all"},
+		},
+		ip: map[string][]string{
+			"a.example.com":                      {"198.51.100.1", "2001:db8:1::1"},
+			"mail.example.com":                   {"203.0.113.25", "2001:db8:2::25"},
+			"1.2.0.192.in-addr.list.example.com": {"127.0.0.2"},
+			"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.list.example.com": {"127.0.0.2"},
+		},
+		mx: map[string][]string{
+			"mx.example.com": {"mail.example.com."},
+		},
+	}
+
+	cases := []struct {
+		name   string
+		domain string
+		ip     net.IP
+		want   Result
+	}{
+		{"ip4 16-byte", "example.com", net.ParseIP("192.0.2.1"), Pass},
+		{"ip4 4-byte", "example.com", net.ParseIP("192.0.2.1").To4(), Pass},
+		{"ip4 mapped", "example.com", net.ParseIP("::ffff:192.0.2.1"), Pass},
+		{"ip6", "example.com", net.ParseIP("2001:db8::1"), Pass},
+		{"mapped never matches ip6", "example.com", net.ParseIP("::ffff:198.51.100.1"), Fail},
+		{"a ip4 cidr", "a.example.com", net.ParseIP("198.51.100.77"), Pass},
+		{"a ip4 mapped cidr", "a.example.com", net.ParseIP("::ffff:198.51.100.77"), Pass},
+		{"a ip6 cidr", "a.example.com", net.ParseIP("2001:db8:1::ffff"), Pass},
+		{"a ip6 outside cidr", "a.example.com", net.ParseIP("2001:db8:9::1"), Fail},
+		{"mx ip4", "mx.example.com", net.ParseIP("203.0.113.25"), Pass},
+		{"mx ip4 mapped", "mx.example.com", net.ParseIP("::ffff:203.0.113.25"), Pass},
+		{"mx ip6", "mx.example.com", net.ParseIP("2001:db8:2::25"), Pass},
+		{"mx no match", "mx.example.com", net.ParseIP("203.0.113.26"), Fail},
+		{"exists v=in-addr", "ex.example.com", net.ParseIP("192.0.2.1"), Pass},
+		{"exists mapped v=in-addr", "ex.example.com", net.ParseIP("::ffff:192.0.2.1"), Pass},
+		{"exists v=ip6", "ex.example.com", net.ParseIP("2001:db8::1"), Pass},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := NewChecker(NewCustomDNSResolver(zone))
+			res, err := ch.CheckHost(context.Background(), tc.ip, tc.domain, "user@example.com")
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, res.Code)
+		})
+	}
+}
+
+func TestChecker_LookupLimits(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"many.example.com": {"v=spf1 a a a a a a a a a a a -all"},
+			"void.example.com": {"v=spf1 a:n1.example.com a:n2.example.com a:n3.example.com -all"},
+		},
+		ip: map[string][]string{"many.example.com": {"198.51.100.1"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ip := net.ParseIP("192.0.2.1")
+
+	res, err := ch.CheckHost(context.Background(), ip, "many.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	require.ErrorIs(t, res.Cause, ErrTooManyLookups)
+
+	res, err = ch.CheckHost(context.Background(), ip, "void.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	require.ErrorIs(t, res.Cause, ErrTooManyVoidLookups)
+}
+
+func TestChecker_VoidPolicy(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"nodata.example.com": {"v=spf1 mx:m1.example.com mx:m2.example.com mx:m3.example.com -all"},
+			"exists.example.com": {"v=spf1 exists:e1.example.com exists:e2.example.com exists:e3.example.com -all"},
+		},
+		mx: map[string][]string{"m1.example.com": {}, "m2.example.com": {}, "m3.example.com": {}},
+	}
+	ip := net.ParseIP("192.0.2.1")
+
+	tests := []struct {
+		domain string
+		policy VoidPolicy
+		want   Result
+	}{
+		{"nodata.example.com", DefaultVoidPolicy, PermError},
+		{"nodata.example.com", VoidPolicy{Exists: true}, Fail},
+		{"exists.example.com", DefaultVoidPolicy, PermError},
+		{"exists.example.com", VoidPolicy{NoData: true}, Fail},
+	}
+	for _, tt := range tests {
+		ch := NewChecker(NewCustomDNSResolver(zone), WithVoidPolicy(tt.policy))
+		res, err := ch.CheckHost(context.Background(), ip, tt.domain, "")
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, res.Code, "%s %+v", tt.domain, tt.policy)
+	}
+}
+
+func TestChecker_NullMX(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"nomail.example.com": {"v=spf1 mx mx:null.example.com mx:null.example.com -all"},
+			"mixed.example.com":  {"v=spf1 mx -all"},
+		},
+		ip: map[string][]string{"mail.example.com": {"192.0.2.1"}},
+		mx: map[string][]string{
+			"nomail.example.com": {"."},
+			"null.example.com":   {"."},
+			"mixed.example.com":  {".", "mail.example.com."},
+		},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+
+	// RFC 7505: the null MX matches nothing and its lookups are not void
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "nomail.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.Equal(t, 3, res.Stats.Lookups)
+	assert.Zero(t, res.Stats.VoidLookups)
+	assert.Equal(t, 4, res.Stats.Queries, "no address lookup for the root")
+
+	res, err = ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "mixed.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+}
+
+func TestChecker_UpperCaseMacros(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"escaped.example":  {"v=spf1 exists:%{L}.x.example -all"},
+			"plain.example":    {"v=spf1 exists:%{l}.x.example -all"},
+			"mixed.example":    {"V=SPF1 Exists:%{L}.x.example -ALL"},
+			"redirect.example": {"v=spf1 Redirect=%{L}.r.example"},
+			"a%2Bb.r.example":  {"v=spf1 +all"},
+		},
+		ip: map[string][]string{"a%2Bb.x.example": {"127.0.0.2"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ip := net.ParseIP("192.0.2.1")
+
+	// RFC 7208 section 7.3: upper-case macro letters are URL escaped
+	for domain, want := range map[string]Result{
+		"escaped.example":  Pass,
+		"plain.example":    Fail,
+		"mixed.example":    Pass,
+		"redirect.example": Pass,
+	} {
+		res, err := ch.CheckHost(context.Background(), ip, domain, "a+b@"+domain)
+		require.NoError(t, err, domain)
+		assert.Equal(t, want, res.Code, domain)
+	}
+}
+
+func TestValidateExpanded(t *testing.T) {
+	for in, want := range map[string]string{
+		"Mail.Example.COM.":      "mail.example.com",
+		"a%2Bb.X.example":        "a%2Bb.x.example",
+		"a+b._spf.example.com":   "a+b._spf.example.com",
+		"strong-bad@x.example":   "strong-bad@x.example",
+		"1.2.0.192.in-addr.arpa": "1.2.0.192.in-addr.arpa",
+	} {
+		got, err := validateExpanded(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"a b.example.com", "a..example.com", "x.a%2Bb", "a%2Bb", strings.Repeat("%", 64) + ".example.com"} {
+		_, err := validateExpanded(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestChecker_ZeroCIDR(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"ip4.example.com":  {"v=spf1 ip4:192.0.2.1/0 -all"},
+			"ip6.example.com":  {"v=spf1 ip6:2001:db8::/0 -all"},
+			"a.example.com":    {"v=spf1 a/0 -all"},
+			"a6.example.com":   {"v=spf1 a//0 -all"},
+			"none.example.com": {"v=spf1 a:nohost.example.com/0//0 -all"},
+		},
+		ip: map[string][]string{
+			"a.example.com":  {"192.0.2.1"},
+			"a6.example.com": {"2001:db8::1"},
+		},
+	}
+	tests := []struct {
+		domain string
+		ip     string
+		want   Result
+	}{
+		{"ip4.example.com", "203.0.113.9", Pass},
+		{"ip4.example.com", "::ffff:203.0.113.9", Pass},
+		{"ip4.example.com", "2001:db8::9", Fail},
+		{"ip6.example.com", "fe80::1", Pass},
+		{"ip6.example.com", "::ffff:203.0.113.9", Fail},
+		{"a.example.com", "203.0.113.9", Pass},
+		{"a.example.com", "2001:db8::9", Fail},
+		{"a6.example.com", "fe80::1", Pass},
+		{"a6.example.com", "203.0.113.9", Fail},
+		{"none.example.com", "203.0.113.9", Fail},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	for _, tt := range tests {
+		res, err := ch.CheckHost(context.Background(), net.ParseIP(tt.ip), tt.domain, "")
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, res.Code, "%s from %s", tt.domain, tt.ip)
+	}
+}
+
+func TestChecker_MatchInfo(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":       {"v=spf1 ip4:192.0.2.1 include:_spf.example.com ?all"},
+			"_spf.example.com":  {"v=spf1 a:mail.example.com/24 include:wide.example.com -all"},
+			"wide.example.com":  {"v=spf1 +ip4:0.0.0.0/1 -all"},
+			"empty.example.com": {"v=spf1 ip4:198.51.100.1"},
+		},
+		ip: map[string][]string{
+			"mail.example.com": {"203.0.113.7"},
+		},
+	}
+	tests := []struct {
+		domain string
+		ip     string
+		want   *MatchInfo
+	}{
+		{"example.com", "192.0.2.1", &MatchInfo{Domain: "example.com", Term: "ip4:192.0.2.1/32", Prefix: netip.MustParsePrefix("192.0.2.1/32")}},
+		{"example.com", "203.0.113.99", &MatchInfo{Domain: "_spf.example.com", Term: "a:mail.example.com/24", Depth: 1, Prefix: netip.MustParsePrefix("203.0.113.0/24")}},
+		{"example.com", "10.0.0.1", &MatchInfo{Domain: "wide.example.com", Term: "ip4:0.0.0.0/1", Depth: 2, Prefix: netip.MustParsePrefix("0.0.0.0/1")}},
+		{"example.com", "198.51.100.1", &MatchInfo{Domain: "example.com", Term: "?all", Prefix: netip.MustParsePrefix("0.0.0.0/0")}},
+		{"empty.example.com", "192.0.2.1", nil},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	for _, tt := range tests {
+		res, err := ch.CheckHost(context.Background(), net.ParseIP(tt.ip), tt.domain, "")
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, res.Match, "%s from %s", tt.domain, tt.ip)
+	}
+}
+
+func TestChecker_IPv6Only(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{"example.com": {"v=spf1 a mx -all"}},
+		ip: map[string][]string{
+			"example.com":      {"2001:db8::1"},
+			"mail.example.com": {"2001:db8::25"},
+		},
+		mx: map[string][]string{"example.com": {"mail.example.com"}},
+	}
+	counter := newCountingResolver(zone)
+	ch := NewChecker(NewCustomDNSResolver(counter))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("2001:db8::25"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Zero(t, res.Stats.VoidLookups)
+	assert.Equal(t, map[string]int{
+		"TXT example.com":      1,
+		"ip6 example.com":      1,
+		"ip6 mail.example.com": 1,
+	}, counter.queries, "no A queries for an IPv6 client")
+}
+
+func TestChecker_UnsupportedLookup(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(&fakeResolver{txts: []string{"v=spf1 a -all"}}))
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	require.ErrorIs(t, res.Cause, ErrUnsupported)
+}
+
+func TestChecker_IncludeRedirect(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":          {"v=spf1 include:_spf.example.net redirect=_spf.example.com"},
+			"_spf.example.net":     {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"_spf.example.com":     {"v=spf1 ip4:198.51.100.0/24 -all"},
+			"allredir.example.com": {"v=spf1 ip4:203.0.113.0/24 ?all redirect=_spf.example.com"},
+			"missing.example.com":  {"v=spf1 include:nothing.example.com -all"},
+			"noredir.example.com":  {"v=spf1 redirect=nothing.example.com"},
+			"loop.example.com":     {"v=spf1 include:loop.example.com -all"},
+			"temp.example.com":     {"v=spf1 include:temp.example.net -all"},
+		},
+	}
+	temp := &tempOverlay{zoneResolver: zone, name: "temp.example.net"}
+
+	cases := []struct {
+		name   string
+		domain string
+		ip     string
+		want   Result
+		cause  error
+	}{
+		{"include pass", "example.com", "192.0.2.1", Pass, nil},
+		{"include fail falls through to redirect", "example.com", "198.51.100.1", Pass, nil},
+		{"redirect result is final", "example.com", "203.0.113.1", Fail, nil},
+		{"redirect ignored with all", "allredir.example.com", "198.51.100.1", Neutral, nil},
+		{"include without record", "missing.example.com", "192.0.2.1", PermError, ErrMissingRecord},
+		{"redirect without record", "noredir.example.com", "192.0.2.1", PermError, ErrMissingRecord},
+		{"include loop", "loop.example.com", "192.0.2.1", PermError, ErrTooManyLookups},
+		{"include temperror", "temp.example.com", "192.0.2.1", TempError, ErrTempfail},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := NewChecker(NewCustomDNSResolver(temp))
+			res, err := ch.CheckHost(context.Background(), net.ParseIP(tc.ip), tc.domain, "user@example.com")
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, res.Code)
+			if tc.cause != nil {
+				require.ErrorIs(t, res.Cause, tc.cause)
+			}
+		})
+	}
+}
+
+// tempOverlay fails TXT lookups for name with a temporary error.
+type tempOverlay struct {
+	*zoneResolver
+	name string
+}
+
+func (o *tempOverlay) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	if domain == o.name {
+		return nil, &net.DNSError{Err: "timeout", Name: domain, IsTemporary: true}
+	}
+	return o.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func TestChecker_Explanation(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":          {"v=spf1 ip4:192.0.2.0/24 -all exp=explain.example.com"},
+			"explain.example.com":  {"%{i} is not one of %{d}'s designated mail servers, see %{r}"},
+			"redir.example.com":    {"v=spf1 redirect=example.com"},
+			"twotxt.example.com":   {"v=spf1 -all exp=two.example.com"},
+			"two.example.com":      {"one", "two"},
+			"badmacro.example.com": {"v=spf1 -all exp=bad.example.com"},
+			"bad.example.com":      {"100%{"},
+			"domspec.example.com":  {"v=spf1 exists:%{t}.example.com -all"},
+		},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithReceiver("mx.example.net"))
+	ctx := context.Background()
+	ip := net.ParseIP("198.51.100.7")
+
+	res, err := ch.CheckHost(ctx, ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.Equal(t, "198.51.100.7 is not one of example.com's designated mail servers, see mx.example.net", res.Explanation)
+
+	res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Empty(t, res.Explanation)
+
+	res, err = ch.CheckHost(ctx, ip, "redir.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.Contains(t, res.Explanation, "example.com's designated", "redirect uses the target's exp")
+
+	for _, domain := range []string{"twotxt.example.com", "badmacro.example.com"} {
+		res, err = ch.CheckHost(ctx, ip, domain, "")
+		require.NoError(t, err)
+		assert.Equal(t, Fail, res.Code, domain)
+		assert.Empty(t, res.Explanation, domain)
+	}
+
+	res, err = ch.CheckHost(ctx, ip, "domspec.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	require.ErrorIs(t, res.Cause, ErrMacroSyntax)
+}
+
+// forbiddenTXT fails the test when the TXT record of name is queried.
+type forbiddenTXT struct {
+	*zoneResolver
+	t    *testing.T
+	name string
+}
+
+func (f *forbiddenTXT) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	if domain == f.name {
+		f.t.Errorf("unexpected TXT query for %s", domain)
+	}
+	return f.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func TestChecker_ExplanationOfInclude(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":         {"v=spf1 include:vendor.example.net -all exp=explain.example.com"},
+		"explain.example.com": {"not authorized by %{d}"},
+		"vendor.example.net":  {"v=spf1 -all exp=%{i}.exp.vendor.example.net"},
+	}}
+	r := &forbiddenTXT{zoneResolver: zone, t: t, name: "198.51.100.7.exp.vendor.example.net"}
+	ch := NewChecker(NewCustomDNSResolver(r))
+
+	// section 6.2: only the explanation of the queried record applies
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("198.51.100.7"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.Equal(t, "not authorized by example.com", res.Explanation)
+	assert.Equal(t, 3, res.Stats.Queries)
+}
+
+func TestChecker_ExplanationLimits(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"ctrl.example.com":       {"v=spf1 -all exp=ctrl.exp.example.com"},
+			"ctrl.exp.example.com":   {"denied\x00by policy"},
+			"long.example.com":       {"v=spf1 -all exp=long.exp.example.com"},
+			"long.exp.example.com":   {strings.Repeat("x", 200)},
+			"macros.example.com":     {"v=spf1 -all exp=macros.exp.example.com"},
+			"macros.exp.example.com": {"%{d} %{d} %{d} %{d}"},
+			"grow.example.com":       {"v=spf1 -all exp=grow.exp.example.com"},
+			"grow.exp.example.com":   {"%{d} is %{d}, %{d}"},
+		},
+	}
+	ctx := context.Background()
+	ip := net.ParseIP("198.51.100.7")
+
+	tests := []struct {
+		domain string
+		limits ExplanationLimits
+		want   string
+	}{
+		{"ctrl.example.com", ExplanationLimits{}, ""},
+		{"long.example.com", ExplanationLimits{}, strings.Repeat("x", 200)},
+		{"long.example.com", ExplanationLimits{MaxRecordSize: 100}, ""},
+		{"macros.example.com", ExplanationLimits{MaxMacros: 4}, "macros.example.com macros.example.com macros.example.com macros.example.com"},
+		{"macros.example.com", ExplanationLimits{MaxMacros: 3}, ""},
+		{"grow.example.com", ExplanationLimits{MaxLength: 60}, "grow.example.com is grow.example.com, grow.example.com"},
+		{"grow.example.com", ExplanationLimits{MaxLength: 40}, ""},
+	}
+	for _, tt := range tests {
+		ch := NewChecker(NewCustomDNSResolver(zone), WithExplanationLimits(tt.limits))
+		res, err := ch.CheckHost(ctx, ip, tt.domain, "")
+		require.NoError(t, err, tt.domain)
+		assert.Equal(t, Fail, res.Code, tt.domain)
+		assert.Equal(t, tt.want, res.Explanation, "%s %+v", tt.domain, tt.limits)
+	}
+}
@@ -0,0 +1,316 @@
+package spf
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EvidenceVersion is the version of the Evidence format written by
+// WithEvidence.
+const EvidenceVersion = 1
+
+// Errors of Evidence.Verify and Evidence.Reproduce.
+var (
+	ErrEvidenceSignature  = errors.New("evidence signature does not verify")
+	ErrEvidenceIncomplete = errors.New("evidence lacks a DNS answer")
+	ErrEvidenceMismatch   = errors.New("evidence does not reproduce its result")
+	ErrInvalidEvidence    = errors.New("invalid evidence")
+)
+
+// Evidence holds every DNS answer an evaluation used, with its identities
+// and result, so that the verdict can be reproduced offline long after the
+// records changed, e.g. to settle a dispute over a rejected message.  It
+// is collected with WithEvidence, serialised as JSON and can be signed by
+// the receiver.
+type Evidence struct {
+	Version  int       `json:"version"`
+	Time     time.Time `json:"time"` // of the evaluation, for the "t" macro
+	IP       string    `json:"ip"`
+	Domain   string    `json:"domain"`
+	Sender   string    `json:"sender,omitempty"`
+	HELO     string    `json:"helo,omitempty"`
+	Receiver string    `json:"receiver,omitempty"`
+	// Result is the evaluated result, before any Policy mapped it.
+	Result  Result           `json:"result"`
+	Answers []EvidenceAnswer `json:"answers"`
+	// Signature is the Ed25519 signature of Sign over the bundle without
+	// it.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// EvidenceAnswer is one DNS answer of an Evidence, in the order the
+// evaluation first asked for it.
+type EvidenceAnswer struct {
+	Type string `json:"type"` // "TXT", "A", "AAAA", "A/AAAA", "MX" or "PTR"
+	Name string `json:"name"`
+	// Records are the TXT strings, addresses, "preference host" pairs or
+	// names answered.
+	Records   []string `json:"records,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	NotFound  bool     `json:"not_found,omitempty"` // NXDOMAIN or no data
+	Temporary bool     `json:"temporary,omitempty"` // e.g. SERVFAIL or a timeout
+}
+
+// WithEvidence calls fn with the Evidence of every evaluation, once its
+// result is known.  Answers are recorded as the evaluation saw them, cache
+// hits included.  Records injected with Simulate or WithRecordOverrides
+// are not DNS answers and are not part of the Evidence.  fn is called
+// synchronously and must be safe for concurrent use when the Checker is.
+func WithEvidence(fn func(Evidence)) Option {
+	return func(c *Checker) {
+		if c.evidence == nil {
+			// outermost, to see the answers of the cache
+			c.middleware = append([]queryMiddleware{evidenceMiddleware}, c.middleware...)
+		}
+		c.evidence = fn
+	}
+}
+
+// evidenceKey is the context key of the evidenceRecorder of an evaluation.
+type evidenceKey struct{}
+
+// evidenceRecorder collects the answers of one evaluation.
+type evidenceRecorder struct {
+	mu      sync.Mutex
+	seen    map[query]bool
+	answers []EvidenceAnswer
+}
+
+// evidenceMiddleware records the answers of the queries of evaluations
+// collecting Evidence.  Context errors are no answers and are left out.
+func evidenceMiddleware(ctx context.Context, q query, next queryFunc) (any, error) {
+	v, err := next(ctx)
+	rec, ok := ctx.Value(evidenceKey{}).(*evidenceRecorder)
+	if !ok || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return v, err
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if !rec.seen[q] {
+		if rec.seen == nil {
+			rec.seen = make(map[query]bool)
+		}
+		rec.seen[q] = true
+		rec.answers = append(rec.answers, evidenceAnswer(q, v, err))
+	}
+
+	return v, err
+}
+
+// evidenceAnswer encodes the answer v or err to q.
+func evidenceAnswer(q query, v any, err error) EvidenceAnswer {
+	a := EvidenceAnswer{Type: q.Type, Name: q.Name}
+	if err != nil {
+		a.Error = err.Error()
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			a.NotFound, a.Temporary = dnsErr.IsNotFound, dnsErr.Temporary()
+		}
+		return a
+	}
+	switch v := v.(type) {
+	case []string:
+		a.Records = v
+	case []net.IP:
+		for _, ip := range v {
+			a.Records = append(a.Records, ip.String())
+		}
+	case []*net.MX:
+		for _, mx := range v {
+			a.Records = append(a.Records, strconv.Itoa(int(mx.Pref))+" "+mx.Host)
+		}
+	}
+
+	return a
+}
+
+// bundle returns the Evidence of e, evaluated for domain with result r.
+func (rec *evidenceRecorder) bundle(e *evaluation, domain string, r Result) Evidence {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return Evidence{
+		Version:  EvidenceVersion,
+		Time:     e.macro.now.UTC(),
+		IP:       e.ip.String(),
+		Domain:   domain,
+		Sender:   e.sender,
+		HELO:     e.helo,
+		Receiver: e.checker.receiver,
+		Result:   r,
+		Answers:  append([]EvidenceAnswer(nil), rec.answers...),
+	}
+}
+
+// signed returns the bytes covered by the signature of ev.
+func (ev *Evidence) signed() ([]byte, error) {
+	unsigned := *ev
+	unsigned.Signature = nil
+
+	return json.Marshal(unsigned)
+}
+
+// Sign signs ev with key, replacing any previous signature.
+func (ev *Evidence) Sign(key ed25519.PrivateKey) error {
+	msg, err := ev.signed()
+	if err != nil {
+		return err
+	}
+	ev.Signature = ed25519.Sign(key, msg)
+
+	return nil
+}
+
+// Verify checks the signature of ev against the public key of the signer.
+// It fails with ErrEvidenceSignature for unsigned or altered evidence.
+func (ev *Evidence) Verify(pub ed25519.PublicKey) error {
+	msg, err := ev.signed()
+	if err != nil {
+		return err
+	}
+	if len(ev.Signature) == 0 || !ed25519.Verify(pub, msg, ev.Signature) {
+		return ErrEvidenceSignature
+	}
+
+	return nil
+}
+
+// Reproduce evaluates ev again without network, answering every query from
+// ev.Answers at the time of ev, and checks that the result is ev.Result.
+// opts should repeat the options of the evaluating Checker that change
+// verdicts, such as WithMaxLookups or WithRecordOverrides.  A query without
+// answer in ev fails with ErrEvidenceIncomplete, a different result with
+// ErrEvidenceMismatch; the result is returned in both cases.
+func (ev *Evidence) Reproduce(ctx context.Context, opts ...Option) (CheckHostResult, error) {
+	if ev.Version != EvidenceVersion {
+		return CheckHostResult{}, fmt.Errorf("%w: version %d", ErrInvalidEvidence, ev.Version)
+	}
+	ip := net.ParseIP(ev.IP)
+	if ip == nil {
+		return CheckHostResult{}, fmt.Errorf("%w: ip %q", ErrInvalidEvidence, ev.IP)
+	}
+	r := &evidenceResolver{answers: make(map[query]EvidenceAnswer, len(ev.Answers))}
+	for _, a := range ev.Answers {
+		r.answers[query{Type: a.Type, Name: a.Name}] = a
+	}
+	at := ev.Time
+	ch := NewChecker(r, append([]Option{WithClock(func() time.Time { return at }), WithReceiver(ev.Receiver)}, opts...)...)
+	e := ch.newEvaluation(ip, ev.Sender)
+	e.helo = ev.HELO
+
+	res, err := ch.run(ctx, e, ev.Domain)
+	if missing := r.firstMissing(); missing != nil {
+		return res, fmt.Errorf("%w: %s %s", ErrEvidenceIncomplete, missing.Type, missing.Name)
+	}
+	if got := evaluatedResult(res); got != ev.Result {
+		return res, fmt.Errorf("%w: got %q, recorded %q", ErrEvidenceMismatch, got, ev.Result)
+	}
+
+	return res, err
+}
+
+// evaluatedResult returns the result of res before any Policy.
+func evaluatedResult(res CheckHostResult) Result {
+	if res.Unmapped != "" {
+		return res.Unmapped
+	}
+
+	return res.Code
+}
+
+// evidenceResolver answers from the answers of an Evidence.  Queries
+// without answer are temporary failures, and remembered.
+type evidenceResolver struct {
+	answers map[query]EvidenceAnswer
+
+	mu      sync.Mutex
+	missing *query
+}
+
+func (r *evidenceResolver) firstMissing() *query {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.missing
+}
+
+// answer returns the records answered to q, or the recorded error.
+func (r *evidenceResolver) answer(q query) ([]string, error) {
+	a, ok := r.answers[q]
+	if !ok {
+		r.mu.Lock()
+		if r.missing == nil {
+			r.missing = &q
+		}
+		r.mu.Unlock()
+		return nil, &net.DNSError{Err: ErrEvidenceIncomplete.Error(), Name: q.Name, IsTemporary: true}
+	}
+	switch {
+	case a.NotFound:
+		return nil, &net.DNSError{Err: a.Error, Name: q.Name, IsNotFound: true}
+	case a.Temporary:
+		return nil, &net.DNSError{Err: a.Error, Name: q.Name, IsTemporary: true}
+	case a.Error != "":
+		return nil, &net.DNSError{Err: a.Error, Name: q.Name}
+	}
+
+	return a.Records, nil
+}
+
+func (r *evidenceResolver) LookupTXT(_ context.Context, domain string) ([]string, error) {
+	return r.answer(query{Type: "TXT", Name: domain})
+}
+
+func (r *evidenceResolver) LookupIP(_ context.Context, network, host string) ([]net.IP, error) {
+	qtype := "A/AAAA"
+	switch network {
+	case "ip4":
+		qtype = "A"
+	case "ip6":
+		qtype = "AAAA"
+	}
+	records, err := r.answer(query{Type: qtype, Name: host})
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(records))
+	for _, s := range records {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: address %q", ErrInvalidEvidence, s)
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+func (r *evidenceResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	records, err := r.answer(query{Type: "MX", Name: name})
+	if err != nil {
+		return nil, err
+	}
+	mxs := make([]*net.MX, 0, len(records))
+	for _, s := range records {
+		pref, host, _ := strings.Cut(s, " ")
+		n, err := strconv.ParseUint(pref, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("%w: mx %q", ErrInvalidEvidence, s)
+		}
+		mxs = append(mxs, &net.MX{Host: host, Pref: uint16(n)})
+	}
+
+	return mxs, nil
+}
+
+func (r *evidenceResolver) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	return r.answer(query{Type: "PTR", Name: addr})
+}
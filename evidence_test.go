@@ -0,0 +1,139 @@
+package spf
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func evidenceZone() *zoneResolver {
+	return &zoneResolver{
+		txt: map[string][]string{
+			"example.com":          {"v=spf1 mx include:_spf.example.com exists:%{i}.x.example.com a:gone.example.com -all exp=exp.example.com"},
+			"_spf.example.com":     {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"exp.example.com":      {"%{i} is not allowed"},
+			"redirect.example.com": {"v=spf1 redirect=example.com"},
+		},
+		ip: map[string][]string{
+			"mx1.example.com": {"198.51.100.1", "2001:db8::1"},
+		},
+		mx: map[string][]string{
+			"example.com": {"mx1.example.com"},
+		},
+	}
+}
+
+func TestWithEvidence(t *testing.T) {
+	var got []Evidence
+	clock := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	cache := NewMemoryCache(time.Hour)
+	ch := NewChecker(NewCustomDNSResolver(evidenceZone()),
+		WithCache(cache), WithEvidence(func(ev Evidence) { got = append(got, ev) }),
+		WithClock(func() time.Time { return clock }), WithReceiver("mx.example.net"),
+		WithPolicy(StrictPolicy))
+	ctx := context.Background()
+
+	res, err := ch.CheckHostWithHELO(ctx, net.ParseIP("203.0.113.9"), "redirect.example.com", "alice@redirect.example.com", "mail.example.org")
+	require.NoError(t, err)
+	require.Equal(t, Fail, res.Code)
+	require.Len(t, got, 1)
+	ev := got[0]
+	assert.Equal(t, EvidenceVersion, ev.Version)
+	assert.Equal(t, clock, ev.Time)
+	assert.Equal(t, "203.0.113.9", ev.IP)
+	assert.Equal(t, "redirect.example.com", ev.Domain)
+	assert.Equal(t, "alice@redirect.example.com", ev.Sender)
+	assert.Equal(t, "mail.example.org", ev.HELO)
+	assert.Equal(t, "mx.example.net", ev.Receiver)
+	assert.Equal(t, Fail, ev.Result)
+	assert.Equal(t, []EvidenceAnswer{
+		{Type: "TXT", Name: "redirect.example.com", Records: []string{"v=spf1 redirect=example.com"}},
+		{Type: "TXT", Name: "example.com", Records: evidenceZone().txt["example.com"]},
+		{Type: "MX", Name: "example.com", Records: []string{"0 mx1.example.com"}},
+		{Type: "A", Name: "mx1.example.com", Records: []string{"198.51.100.1"}},
+		{Type: "TXT", Name: "_spf.example.com", Records: []string{"v=spf1 ip4:192.0.2.0/24 -all"}},
+		{Type: "A", Name: "203.0.113.9.x.example.com", Error: "lookup 203.0.113.9.x.example.com: no such host", NotFound: true},
+		{Type: "A", Name: "gone.example.com", Error: "lookup gone.example.com: no such host", NotFound: true},
+		{Type: "TXT", Name: "exp.example.com", Records: []string{"%{i} is not allowed"}},
+	}, ev.Answers)
+
+	// answers served from the cache are recorded as well
+	_, err = ch.CheckHost(ctx, net.ParseIP("203.0.113.9"), "redirect.example.com", "")
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, ev.Answers, got[1].Answers)
+
+	res, err = ev.Reproduce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.Equal(t, "203.0.113.9 is not allowed", res.Explanation)
+}
+
+func TestEvidence_SignAndReproduce(t *testing.T) {
+	var ev Evidence
+	ch := NewChecker(NewCustomDNSResolver(evidenceZone()), WithEvidence(func(e Evidence) { ev = e }))
+	ctx := context.Background()
+	_, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.7"), "example.com", "bob@example.com")
+	require.NoError(t, err)
+	require.Equal(t, Pass, ev.Result)
+
+	pub, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, ev.Verify(pub), ErrEvidenceSignature, "unsigned")
+	require.NoError(t, ev.Sign(key))
+	require.NoError(t, ev.Verify(pub))
+
+	// the bundle survives serialisation
+	b, err := json.Marshal(ev)
+	require.NoError(t, err)
+	var loaded Evidence
+	require.NoError(t, json.Unmarshal(b, &loaded))
+	require.NoError(t, loaded.Verify(pub))
+	res, err := loaded.Reproduce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	tampered := loaded
+	tampered.Result = Fail
+	assert.ErrorIs(t, tampered.Verify(pub), ErrEvidenceSignature)
+	_, err = tampered.Reproduce(ctx)
+	assert.ErrorIs(t, err, ErrEvidenceMismatch)
+
+	incomplete := loaded
+	incomplete.Answers = incomplete.Answers[:1]
+	_, err = incomplete.Reproduce(ctx)
+	assert.ErrorIs(t, err, ErrEvidenceIncomplete)
+	assert.ErrorContains(t, err, "MX example.com")
+
+	bad := loaded
+	bad.IP = "not an address"
+	_, err = bad.Reproduce(ctx)
+	assert.ErrorIs(t, err, ErrInvalidEvidence)
+}
+
+func TestEvidence_TemporaryError(t *testing.T) {
+	var ev Evidence
+	zone := evidenceZone()
+	ch := NewChecker(NewCustomDNSResolver(tempfailResolver{zone}), WithEvidence(func(e Evidence) { ev = e }))
+	res, _ := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.7"), "example.com", "")
+	require.Equal(t, TempError, res.Code)
+	require.Len(t, ev.Answers, 1)
+	assert.True(t, ev.Answers[0].Temporary)
+
+	res, err := ev.Reproduce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, TempError, res.Code)
+}
+
+// tempfailResolver fails every TXT lookup temporarily.
+type tempfailResolver struct{ *zoneResolver }
+
+func (tempfailResolver) LookupTXT(_ context.Context, domain string) ([]string, error) {
+	return nil, &net.DNSError{Err: "server misbehaving", Name: domain, IsTemporary: true}
+}
@@ -0,0 +1,55 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// Explanation returns the explanation the record of domain gives a Fail
+// result for the client ip and sender, without evaluating the record: the
+// TXT record named by the exp modifier, its strings joined, macro-expanded
+// as in RFC 7208 section 6.2.  It lets an MTA build rejection text only when
+// it rejects, from CheckHostResult.Match.Domain for a Fail of an included or
+// redirected record.
+//
+// As during evaluation, a record without exp modifier, or whose explanation
+// cannot be fetched or expanded or exceeds the ExplanationLimits, yields an
+// empty explanation, as does a domain without SPF record.  Errors are
+// returned for the failure to fetch or parse the record of domain, for
+// context errors, and as by MatchMechanism for invalid arguments.
+func (c *Checker) Explanation(ctx context.Context, ip net.IP, domain, sender string) (string, error) {
+	ctx, cancel, valDomain, err := c.prepare(ctx, ip, domain)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	e := c.newEvaluation(ip, sender)
+	e.simulated, e.correlationID = simulatedFrom(ctx), CorrelationID(ctx)
+	ctx = withTTL(withStats(ctx, &e.stats), &e.ttl)
+	text, err := e.record(ctx, valDomain)
+	switch {
+	case errors.Is(err, ErrNoDNSrecord):
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	if text == "" {
+		return "", nil
+	}
+	rec, err := c.parse(text)
+	if err != nil {
+		return "", c.syntaxError(valDomain, text, err)
+	}
+	if rec.Exp == nil {
+		return "", nil
+	}
+
+	return e.explain(ctx, rec.Exp, valDomain)
+}
+
+// GetExplanation is Explanation with a Checker for r configured by opts.
+func GetExplanation(ctx context.Context, ip net.IP, domain, sender string, r TXTResolver, opts ...Option) (string, error) {
+	return NewChecker(r, opts...).Explanation(ctx, ip, domain, sender)
+}
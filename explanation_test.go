@@ -0,0 +1,56 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplanation(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":         {"v=spf1 ip4:192.0.2.0/24 -all exp=explain.example.com"},
+			"explain.example.com": {"%{i} may not send mail for %{o} as %{l}"},
+			"plain.example.com":   {"v=spf1 -all"},
+			"broken.example.com":  {"v=spf1 ip4:192.0.2.300 -all exp=explain.example.com"},
+			"twice.example.com":   {"v=spf1 -all exp=explain.example.com", "v=spf1 ~all"},
+		},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ctx := context.Background()
+	ip := net.ParseIP("198.51.100.7")
+
+	exp, err := ch.Explanation(ctx, ip, "example.com", "bob@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.7 may not send mail for example.com as bob", exp)
+
+	exp, err = GetExplanation(ctx, ip, "example.com", "", NewCustomDNSResolver(zone))
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.7 may not send mail for example.com as postmaster", exp)
+
+	for _, domain := range []string{"plain.example.com", "missing.example.com"} {
+		exp, err = ch.Explanation(ctx, ip, domain, "")
+		require.NoError(t, err, domain)
+		assert.Empty(t, exp, domain)
+	}
+
+	_, err = ch.Explanation(ctx, ip, "broken.example.com", "")
+	require.Error(t, err)
+	_, err = ch.Explanation(ctx, ip, "twice.example.com", "")
+	require.ErrorIs(t, err, ErrMultipleSPF)
+	_, err = ch.Explanation(ctx, nil, "example.com", "")
+	require.ErrorIs(t, err, ErrInvalidIP)
+
+	lenient := NewChecker(NewCustomDNSResolver(zone), WithMultipleRecords(MultipleRecordsFirst))
+	exp, err = lenient.Explanation(ctx, ip, "twice.example.com", "")
+	require.NoError(t, err)
+	assert.Contains(t, exp, "may not send mail")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ch.Explanation(cancelled, ip, "example.com", "")
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,169 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// ExtensionRequest is a site-specific term handed to an Extension, with the
+// identities of the evaluation.
+type ExtensionRequest struct {
+	Name     string // the mechanism or modifier name in lower case, e.g. "x-geo"
+	Value    string // the argument after ":" or "=", macros expanded
+	Modifier bool   // the term is a modifier rather than a mechanism
+	Domain   string // domain of the record holding the term
+	IP       net.IP
+	Sender   string
+	HELO     string
+}
+
+// ExtensionResponse is the answer of an Extension.
+type ExtensionResponse struct {
+	// Match reports whether a mechanism matches the client, which then gets
+	// the result of its qualifier.  It is ignored for modifiers.
+	Match bool
+	// Result, for a modifier, ends the evaluation of its record with that
+	// result.  For a mechanism only TempError and PermError are allowed,
+	// ending the evaluation as errors of the term would.
+	Result Result
+}
+
+// Extension handles a site-specific mechanism or modifier.  RFC 7208 makes
+// unknown mechanisms a PermError and has verifiers ignore unknown
+// modifiers; extensions give them a meaning local to the Checker, e.g. for
+// private policies between partners.  An error is a TempError of the term,
+// context errors aside.  An Extension must be safe for concurrent use when
+// the Checker is.
+type Extension func(ctx context.Context, req ExtensionRequest) (ExtensionResponse, error)
+
+// WithMechanism has records accept the mechanism name, e.g. "x-geo", and
+// evaluates it with fn.  Terms "[qualifier]name[:argument]" then parse
+// instead of being unknown mechanisms; their evaluation counts no DNS
+// lookup.  The names of RFC 7208 mechanisms cannot be overridden.
+func WithMechanism(name string, fn Extension) Option {
+	return func(c *Checker) {
+		if c.mechanisms == nil {
+			c.mechanisms = make(map[string]Extension)
+		}
+		c.mechanisms[strings.ToLower(name)] = fn
+	}
+}
+
+// WithModifier evaluates the modifier name, e.g. "x-rate", with fn instead
+// of ignoring it.  Modifiers are consulted in record order once no
+// mechanism matched, before any redirect, and a Result in a response ends
+// the evaluation of the record.
+func WithModifier(name string, fn Extension) Option {
+	return func(c *Checker) {
+		if c.modifiers == nil {
+			c.modifiers = make(map[string]Extension)
+		}
+		c.modifiers[strings.ToLower(name)] = fn
+	}
+}
+
+// parse parses a record, accepting the mechanisms of WithMechanism.
+func (c *Checker) parse(text string) (*parser.Record, error) {
+	if len(c.mechanisms) == 0 {
+		return parser.ParseWith(text, c.parseLimits)
+	}
+
+	return parser.ParseExtended(text, c.parseLimits, slices.Sorted(maps.Keys(c.mechanisms)))
+}
+
+// matchExtension evaluates a mechanism of WithMechanism.
+func (e *evaluation) matchExtension(ctx context.Context, fn Extension, mech *parser.Mechanism, domain string) (bool, error) {
+	resp, err := e.callExtension(ctx, fn, mech.Kind, mech.Domain, false, domain)
+	if err != nil {
+		return false, err
+	}
+	if resp.Result == "" {
+		return resp.Match, nil
+	}
+	if err := extensionError(mech.Kind, resp.Result); err != nil {
+		return false, err
+	}
+
+	return false, fmt.Errorf("%w: %s answered %q for a mechanism", ErrPermfail, mech.Kind, resp.Result)
+}
+
+// modifierExtensions consults the modifiers of rec handled by WithModifier
+// and reports whether one of them ended the evaluation with res.
+func (e *evaluation) modifierExtensions(ctx context.Context, rec *parser.Record, domain string) (CheckHostResult, bool, error) {
+	if len(e.checker.modifiers) == 0 {
+		return CheckHostResult{}, false, nil
+	}
+	for _, mod := range rec.Unknown {
+		name := strings.ToLower(mod.Name)
+		fn, ok := e.checker.modifiers[name]
+		if !ok {
+			continue
+		}
+		resp, err := e.callExtension(ctx, fn, name, mod.Value, true, domain)
+		if err == nil && resp.Result == "" {
+			continue
+		}
+		if err == nil {
+			err = extensionError(name, resp.Result)
+		}
+		if _, verr := resp.Result.Value(); err == nil && verr != nil {
+			err = fmt.Errorf("%w: %s: %w", ErrPermfail, name, verr)
+		}
+		if err != nil {
+			res, err := resultFromError(termError(domain, rec, modifierIndex(rec, mod.Name), err))
+			return res, true, err
+		}
+		e.chain, e.matched = nil, nil
+		return CheckHostResult{Code: resp.Result}, true, nil
+	}
+
+	return CheckHostResult{}, false, nil
+}
+
+// extensionError returns the error for a TempError or PermError answered by
+// the extension name, nil for other results.
+func extensionError(name string, r Result) error {
+	switch r {
+	case TempError:
+		return fmt.Errorf("%w: %s answered temperror", ErrTempfail, name)
+	case PermError:
+		return fmt.Errorf("%w: %s answered permerror", ErrPermfail, name)
+	}
+
+	return nil
+}
+
+// callExtension expands the macros of value and calls fn.
+func (e *evaluation) callExtension(ctx context.Context, fn Extension, name, value string, modifier bool, domain string) (ExtensionResponse, error) {
+	if strings.ContainsRune(value, '%') {
+		expanded, err := e.macro.Expand(ctx, value, domain)
+		if err != nil {
+			return ExtensionResponse{}, err
+		}
+		value = expanded
+	}
+	resp, err := fn(ctx, ExtensionRequest{
+		Name:     name,
+		Value:    value,
+		Modifier: modifier,
+		Domain:   domain,
+		IP:       e.ip,
+		Sender:   e.sender,
+		HELO:     e.helo,
+	})
+	switch {
+	case err == nil:
+		return resp, nil
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ExtensionResponse{}, err
+	}
+
+	return ExtensionResponse{}, fmt.Errorf("%w: %s: %w", ErrTempfail, name, err)
+}
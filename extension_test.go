@@ -0,0 +1,96 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMechanism(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":       {"v=spf1 -x-geo:%{d}.eu ip4:192.0.2.0/24 ~all"},
+		"plain.example.com": {"v=spf1 x-geo:eu -all"},
+		"temp.example.com":  {"v=spf1 x-geo:down -all"},
+		"bad.example.com":   {"v=spf1 x-geo:pass -all"},
+	}}
+	var got []ExtensionRequest
+	geo := func(_ context.Context, req ExtensionRequest) (ExtensionResponse, error) {
+		got = append(got, req)
+		switch req.Value {
+		case "down":
+			return ExtensionResponse{}, errors.New("geo database unavailable")
+		case "pass":
+			return ExtensionResponse{Result: Pass}, nil
+		}
+		return ExtensionResponse{Match: req.IP.Equal(net.ParseIP("198.51.100.1"))}, nil
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithMechanism("X-Geo", geo))
+	ctx := context.Background()
+
+	res, err := ch.CheckHostWithHELO(ctx, net.ParseIP("198.51.100.1"), "example.com", "alice@example.com", "mx.example.net")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	require.NotNil(t, res.Match)
+	assert.Equal(t, "-x-geo:%{d}.eu", res.Match.Term)
+	assert.Equal(t, []ExtensionRequest{{
+		Name: "x-geo", Value: "example.com.eu", Domain: "example.com",
+		IP: net.ParseIP("198.51.100.1").To4(), Sender: "alice@example.com", HELO: "mx.example.net",
+	}}, got)
+
+	res, _ = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	assert.Equal(t, Pass, res.Code, "no match falls through to the next mechanism")
+	assert.Equal(t, 0, res.Stats.Lookups, "extensions count no lookup")
+
+	res, _ = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "temp.example.com", "")
+	assert.Equal(t, TempError, res.Code)
+	assert.ErrorContains(t, res.Cause, "geo database unavailable")
+	var te *TermError
+	require.ErrorAs(t, res.Cause, &te)
+	assert.Equal(t, "x-geo:down", te.Term)
+
+	res, _ = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "bad.example.com", "")
+	assert.Equal(t, PermError, res.Code, "mechanisms may only answer errors as results")
+
+	res, _ = NewChecker(NewCustomDNSResolver(zone)).CheckHost(ctx, net.ParseIP("192.0.2.1"), "plain.example.com", "")
+	assert.Equal(t, PermError, res.Code, "unknown mechanism without the extension")
+}
+
+func TestWithModifier(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":       {"v=spf1 ip4:192.0.2.0/24 x-rate=%{i} redirect=_spf.example.com"},
+		"_spf.example.com":  {"v=spf1 ?all"},
+		"other.example.com": {"v=spf1 ip4:192.0.2.1 x-rate=bogus"},
+	}}
+	rate := func(_ context.Context, req ExtensionRequest) (ExtensionResponse, error) {
+		switch req.Value {
+		case "198.51.100.1":
+			return ExtensionResponse{Result: SoftFail}, nil
+		case "198.51.100.2":
+			return ExtensionResponse{Result: TempError}, nil
+		case "bogus":
+			return ExtensionResponse{Result: "bogus"}, nil
+		}
+		return ExtensionResponse{}, nil
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithModifier("x-rate", rate))
+	ctx := context.Background()
+
+	res, _ := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	assert.Equal(t, Pass, res.Code, "modifiers are consulted after the mechanisms")
+	res, _ = ch.CheckHost(ctx, net.ParseIP("198.51.100.1"), "example.com", "")
+	assert.Equal(t, SoftFail, res.Code)
+	assert.Nil(t, res.Match)
+	res, _ = ch.CheckHost(ctx, net.ParseIP("198.51.100.2"), "example.com", "")
+	assert.Equal(t, TempError, res.Code)
+	res, _ = ch.CheckHost(ctx, net.ParseIP("198.51.100.3"), "example.com", "")
+	assert.Equal(t, Neutral, res.Code, "no answer continues with the redirect")
+	res, _ = ch.CheckHost(ctx, net.ParseIP("198.51.100.3"), "other.example.com", "")
+	assert.Equal(t, PermError, res.Code)
+
+	res, _ = NewChecker(NewCustomDNSResolver(zone)).CheckHost(ctx, net.ParseIP("198.51.100.1"), "example.com", "")
+	assert.Equal(t, Neutral, res.Code, "unknown modifiers are ignored without the extension")
+}
@@ -0,0 +1,187 @@
+package spf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// LintSchemaVersion is the version of the JSON written by WriteLintJSON.  It
+// changes only when a field is removed or changes meaning; fields may be
+// added within a version.
+const LintSchemaVersion = 1
+
+// LintResult holds the warnings about one domain, tied to the zone file
+// publishing it when known, so that CI pipelines can annotate the file.
+type LintResult struct {
+	Domain   string    `json:"domain"`
+	File     string    `json:"file,omitempty"` // path of the zone file, relative to the repository root
+	Line     int       `json:"line,omitempty"` // 1-based line of the record in File
+	Warnings []Warning `json:"warnings"`
+}
+
+// lintDocument is the top-level object of WriteLintJSON.
+type lintDocument struct {
+	Version int          `json:"version"`
+	Results []LintResult `json:"results"`
+}
+
+// WriteLintJSON writes results as one JSON document:
+//
+//	{"version":1,"results":[{"domain":"example.com","warnings":[
+//	  {"rule":"zero-cidr","severity":"warning","term":"ip4:0.0.0.0/0","message":"..."}]}]}
+func WriteLintJSON(w io.Writer, results []LintResult) error {
+	results = append([]LintResult{}, results...)
+	for i := range results {
+		if results[i].Warnings == nil {
+			results[i].Warnings = []Warning{}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(lintDocument{Version: LintSchemaVersion, Results: results})
+}
+
+// ReadLintJSON reads a document written by WriteLintJSON, rejecting other
+// schema versions.
+func ReadLintJSON(r io.Reader) ([]LintResult, error) {
+	var doc lintDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != LintSchemaVersion {
+		return nil, fmt.Errorf("unsupported lint schema version %d", doc.Version)
+	}
+
+	return doc.Results, nil
+}
+
+// SARIF 2.1.0 objects, limited to the properties WriteSARIF sets.
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID                   string       `json:"id"`
+		ShortDescription     *sarifText   `json:"shortDescription,omitempty"`
+		DefaultConfiguration sarifDefault `json:"defaultConfiguration"`
+	}
+	sarifDefault struct {
+		Level string `json:"level"`
+	}
+	sarifText struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID     string            `json:"ruleId,omitempty"`
+		RuleIndex  *int              `json:"ruleIndex,omitempty"`
+		Level      string            `json:"level"`
+		Message    sarifText         `json:"message"`
+		Locations  []sarifLocation   `json:"locations"`
+		Properties map[string]string `json:"properties,omitempty"`
+	}
+	sarifLocation struct {
+		PhysicalLocation *sarifPhysical `json:"physicalLocation,omitempty"`
+		LogicalLocations []sarifLogical `json:"logicalLocations"`
+	}
+	sarifPhysical struct {
+		ArtifactLocation sarifArtifact `json:"artifactLocation"`
+		Region           *sarifRegion  `json:"region,omitempty"`
+	}
+	sarifArtifact struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine int `json:"startLine"`
+	}
+	sarifLogical struct {
+		Name string `json:"name"`
+		Kind string `json:"kind"`
+	}
+)
+
+// sarifLevel maps a severity to a SARIF result level.
+func sarifLevel(s Severity) string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+
+	return "note"
+}
+
+// WriteSARIF writes results as a SARIF 2.1.0 log, the format code scanning
+// tools use to annotate pull requests.  The rules of l and AuditRules are
+// listed with the severities configured in l.  Each result is located in the
+// zone file of its LintResult, when set, and logically at its domain.
+func (l *Linter) WriteSARIF(w io.Writer, results []LintResult) error {
+	driver := sarifDriver{Name: "mailspire-spf", InformationURI: "https://github.com/mailspire/spf", Rules: []sarifRule{}}
+	index := make(map[string]int)
+	for _, rule := range append(append([]LintRule{}, l.Rules...), AuditRules()...) {
+		if _, ok := index[rule.ID]; ok {
+			continue
+		}
+		severity := rule.Severity
+		if s, ok := l.Severity[rule.ID]; ok {
+			severity = s
+		}
+		r := sarifRule{ID: rule.ID, DefaultConfiguration: sarifDefault{Level: sarifLevel(severity)}}
+		if rule.Description != "" {
+			r.ShortDescription = &sarifText{Text: rule.Description}
+		}
+		index[rule.ID] = len(driver.Rules)
+		driver.Rules = append(driver.Rules, r)
+	}
+
+	run := sarifRun{Tool: sarifTool{Driver: driver}, Results: []sarifResult{}}
+	for _, res := range results {
+		loc := sarifLocation{LogicalLocations: []sarifLogical{{Name: res.Domain, Kind: "resource"}}}
+		if res.File != "" {
+			loc.PhysicalLocation = &sarifPhysical{ArtifactLocation: sarifArtifact{URI: res.File}}
+			if res.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: res.Line}
+			}
+		}
+		for _, warning := range res.Warnings {
+			r := sarifResult{
+				RuleID:    warning.Rule,
+				Level:     sarifLevel(warning.Severity),
+				Message:   sarifText{Text: warning.Message},
+				Locations: []sarifLocation{loc},
+			}
+			if i, ok := index[warning.Rule]; ok {
+				r.RuleIndex = &i
+			}
+			if warning.Term != "" {
+				r.Properties = map[string]string{"term": warning.Term}
+			}
+			run.Results = append(run.Results, r)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
@@ -0,0 +1,101 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lintResults(t *testing.T) []LintResult {
+	report, err := Audit(context.Background(), auditZone(), "example.com")
+	require.NoError(t, err)
+
+	return []LintResult{
+		{Domain: report.Domain, File: "zones/example.com.zone", Line: 12, Warnings: report.Warnings},
+		{Domain: "clean.example"},
+	}
+}
+
+func TestWriteLintJSON(t *testing.T) {
+	results := lintResults(t)
+	var buf bytes.Buffer
+	require.NoError(t, WriteLintJSON(&buf, results))
+	assert.Contains(t, buf.String(), `"severity": "error"`)
+	assert.Contains(t, buf.String(), `"warnings": []`)
+	assert.Nil(t, results[1].Warnings, "results are not modified")
+
+	got, err := ReadLintJSON(&buf)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, results[0], got[0])
+	assert.Empty(t, got[1].Warnings)
+
+	_, err = ReadLintJSON(strings.NewReader(`{"version":2,"results":[]}`))
+	require.Error(t, err)
+	_, err = ReadLintJSON(strings.NewReader(`{"version":1,"results":[{"warnings":[{"severity":"fatal"}]}]}`))
+	require.Error(t, err)
+}
+
+func TestLinter_WriteSARIF(t *testing.T) {
+	l := NewLinter()
+	l.Severity["sender-id"] = SeverityInfo
+	var buf bytes.Buffer
+	require.NoError(t, l.WriteSARIF(&buf, lintResults(t)))
+
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID                   string `json:"id"`
+						DefaultConfiguration struct {
+							Level string `json:"level"`
+						} `json:"defaultConfiguration"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				RuleIndex int    `json:"ruleIndex"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region struct {
+							StartLine int `json:"startLine"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+					LogicalLocations []struct {
+						Name string `json:"name"`
+					} `json:"logicalLocations"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+	assert.Equal(t, "2.1.0", log.Version)
+	require.Len(t, log.Runs, 1)
+	run := log.Runs[0]
+	require.Len(t, run.Tool.Driver.Rules, 7)
+	require.Len(t, run.Results, 2)
+
+	for _, res := range run.Results {
+		rule := run.Tool.Driver.Rules[res.RuleIndex]
+		assert.Equal(t, res.RuleID, rule.ID)
+		require.Len(t, res.Locations, 1)
+		assert.Equal(t, "zones/example.com.zone", res.Locations[0].PhysicalLocation.ArtifactLocation.URI)
+		assert.Equal(t, 12, res.Locations[0].PhysicalLocation.Region.StartLine)
+		assert.Equal(t, "example.com", res.Locations[0].LogicalLocations[0].Name)
+	}
+	assert.Equal(t, "error", run.Results[0].Level)
+	assert.Equal(t, "warning", run.Results[1].Level, "as labelled by the auditing Linter")
+	assert.Equal(t, "note", run.Tool.Driver.Rules[run.Results[1].RuleIndex].DefaultConfiguration.Level, "sender-id is configured as info")
+}
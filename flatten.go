@@ -0,0 +1,315 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// ErrShadowingTerm is returned by Flatten for policies with "-", "~" or "?"
+// terms that a flattened record cannot preserve.
+var ErrShadowingTerm = errors.New("term with another result shadows authorized networks")
+
+// Flatten resolves the policy of source into a record without include, a,
+// mx or redirect terms, which can be published at another domain to save
+// DNS lookups.  The record passes the networks listed by
+// ListAuthorizedNetworks and ends with the "all" that finally applies to
+// source.  Client-dependent terms such as exists are kept verbatim unless
+// they depend on the current domain through %{d}.
+//
+// Flatten fails when part of the policy cannot be resolved, since publishing
+// a partial record would reject legitimate senders.  It fails with
+// ErrShadowingTerm when a "-", "~" or "?" term other than "all" could match
+// an authorized network, or cannot be resolved to networks, since dropping
+// it would pass senders the source rejects.  The inventory the record was
+// built from is returned alongside it.
+func Flatten(ctx context.Context, r TXTResolver, source string) (string, *NetworkInventory, error) {
+	g, err := BuildGraph(ctx, r, source)
+	if err != nil {
+		return "", nil, err
+	}
+	inv, err := listNetworks(ctx, r, g)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkShadowing(g, inv); err != nil {
+		return "", nil, err
+	}
+
+	senders := SenderInventory{All: finalAll(g)}
+	for _, n := range inv.Networks {
+		senders.Prefixes = append(senders.Prefixes, n.Prefix)
+	}
+	for _, u := range inv.Unresolved {
+		term, err := flatTerm(u)
+		if err != nil {
+			return "", nil, err
+		}
+		senders.Terms = append(senders.Terms, term)
+	}
+	record, err := Generate(senders)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return record, inv, nil
+}
+
+// checkShadowing returns an error matching ErrShadowingTerm for the first
+// term of g, other than "all", with a qualifier other than "+" that could
+// match a network of inv.  Only ip4 and ip6 terms are known not to when their
+// range is disjoint from every network.
+func checkShadowing(g *Graph, inv *NetworkInventory) error {
+	for _, domain := range slices.Sorted(maps.Keys(g.Nodes)) {
+		n := g.Nodes[domain]
+		if n.Record == nil {
+			continue
+		}
+		for _, m := range n.Record.Mechs {
+			if m.Qual == parser.QPlus || m.Kind == "all" {
+				continue
+			}
+			if (m.Kind == "ip4" || m.Kind == "ip6") && !overlapsNetworks(m, inv) {
+				continue
+			}
+
+			return fmt.Errorf("%s: %s: %w", domain, m.String(), ErrShadowingTerm)
+		}
+	}
+
+	return nil
+}
+
+// overlapsNetworks reports whether the range of the ip4 or ip6 term m
+// overlaps a network of inv.
+func overlapsNetworks(m parser.Mechanism, inv *NetworkInventory) bool {
+	ones, _ := m.Net.Mask.Size()
+	addr, _ := netip.AddrFromSlice(normalizeIP(m.Net.IP))
+	prefix := netip.PrefixFrom(addr, ones)
+	for _, n := range inv.Networks {
+		if n.Prefix.Overlaps(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flatTerm returns the term to keep in a flattened record for a term that
+// ListAuthorizedNetworks could not resolve.
+func flatTerm(u UnresolvedTerm) (string, error) {
+	fail := func(err error) (string, error) {
+		return "", fmt.Errorf("%s: %s: %w", u.Domain, u.Term, err)
+	}
+	switch {
+	case !errors.Is(u.Err, ErrClientDependent):
+		return fail(u.Err)
+	case strings.Contains(u.Term, "="), strings.Contains(strings.ToLower(u.Term), "%{d"):
+		return fail(ErrClientDependent)
+	case u.Term == "ptr":
+		return "ptr:" + u.Domain, nil
+	}
+
+	return u.Term, nil
+}
+
+// finalAll returns the qualifier of the "all" mechanism that applies when no
+// other term of the policy at g.Root matches, following redirects.
+func finalAll(g *Graph) parser.Qualifier {
+	seen := make(map[string]bool)
+	for d := g.Root; !seen[d]; {
+		seen[d] = true
+		n := g.Nodes[d]
+		if n == nil || n.Record == nil {
+			break
+		}
+		for _, m := range n.Record.Mechs {
+			if m.Kind == "all" {
+				return m.Qual
+			}
+		}
+		if n.Record.Redirect == nil {
+			break
+		}
+		edge := newEdge("redirect", parser.QPlus, n.Record.Redirect.Value)
+		if edge.Macro {
+			break
+		}
+		d = edge.Target
+	}
+
+	// section 4.7: the default result is neutral
+	return parser.QMark
+}
+
+// FlattenUpdate reports that the flattened records of a Flattener changed or
+// could not be regenerated.
+type FlattenUpdate struct {
+	Time time.Time
+	// Records is the complete new set, top record first.
+	Records []PublishedRecord
+	// Upsert lists the records to create or replace and Delete the names of
+	// records that are no longer needed.
+	Upsert []PublishedRecord
+	Delete []string
+	// Added and Removed are the networks gained and lost by the source since
+	// the previous update.
+	Added, Removed []netip.Prefix
+	Err            error
+}
+
+// Flattener maintains a flattened copy of the policy of Source, published
+// at Domain and split into sub-records under Namespace as SplitRecord does.
+// Each refresh re-resolves Source and reports the DNS changes needed to
+// publish the new version, so that automation can push them.  A Flattener
+// is not safe for concurrent use.
+type Flattener struct {
+	Resolver  TXTResolver
+	Source    string
+	Domain    string
+	Namespace string // namespace of the sub-records, Domain when empty
+	MaxSize   int    // record size limit, DefaultMaxRecordSize when 0
+	Interval  time.Duration
+	// Clock stamps updates; nil means time.Now.
+	Clock func() time.Time
+
+	records []PublishedRecord
+	inv     *NetworkInventory
+}
+
+// NewFlattener returns a Flattener publishing the policy of source at domain,
+// refreshed through r every interval.
+func NewFlattener(r TXTResolver, source, domain string, interval time.Duration) *Flattener {
+	return &Flattener{Resolver: r, Source: source, Domain: domain, Interval: interval}
+}
+
+// Records returns the records of the last successful refresh.
+func (f *Flattener) Records() []PublishedRecord {
+	return f.records
+}
+
+// Refresh regenerates the flattened records and returns the update, or nil
+// when they are unchanged.  Before the first successful refresh, the
+// records currently published at Domain serve as the baseline, so that a
+// restarted Flattener does not rewrite an up-to-date zone.
+func (f *Flattener) Refresh(ctx context.Context) (*FlattenUpdate, error) {
+	record, inv, err := Flatten(ctx, f.Resolver, f.Source)
+	if err != nil {
+		return nil, err
+	}
+	namespace := f.Namespace
+	if namespace == "" {
+		namespace = f.Domain
+	}
+	records, err := SplitRecord(f.Domain, record, namespace, f.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	old := f.records
+	if old == nil {
+		if old, err = f.published(ctx, namespace); err != nil {
+			return nil, err
+		}
+	}
+	up := &FlattenUpdate{Time: f.now(), Records: records}
+	up.Upsert, up.Delete = diffPublished(old, records)
+	up.Added, up.Removed = DiffNetworks(f.inv, inv)
+	f.records, f.inv = records, inv
+	if len(up.Upsert) == 0 && len(up.Delete) == 0 {
+		return nil, nil
+	}
+
+	return up, nil
+}
+
+// published reads the flattened records currently published at Domain: the
+// top record and the sub-records under namespace it includes.
+func (f *Flattener) published(ctx context.Context, namespace string) ([]PublishedRecord, error) {
+	// edge targets are lower case
+	sub := regexp.MustCompile(`^_spf\d+\.` + regexp.QuoteMeta(strings.ToLower(strings.TrimSuffix(namespace, "."))) + `$`)
+	var out []PublishedRecord
+	w := &Walker{Resolver: f.Resolver, Follow: func(_ *Node, edge Edge) bool {
+		return edge.Kind == "include" && edge.Qual == parser.QPlus && sub.MatchString(edge.Target)
+	}}
+	err := w.Walk(ctx, f.Domain, func(node *Node, _ int) error {
+		switch {
+		case errors.Is(node.Err, ErrNoDNSrecord), errors.Is(node.Err, ErrMissingRecord):
+			return nil
+		case node.Raw == "":
+			return node.Err
+		}
+		out = append(out, PublishedRecord{Name: node.Domain, Text: node.Raw})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// diffPublished returns the records of after that are missing from or
+// differ in before, and the names only present in before.
+func diffPublished(before, after []PublishedRecord) (upsert []PublishedRecord, del []string) {
+	old := make(map[string]string, len(before))
+	for _, r := range before {
+		old[r.Name] = r.Text
+	}
+	for _, r := range after {
+		if text, ok := old[r.Name]; !ok || text != r.Text {
+			upsert = append(upsert, r)
+		}
+		delete(old, r.Name)
+	}
+	for _, r := range before {
+		if _, ok := old[r.Name]; ok {
+			del = append(del, r.Name)
+		}
+	}
+
+	return upsert, del
+}
+
+// Run refreshes immediately and then every Interval, passing each update to
+// fn, until ctx is done.  Failed refreshes are passed as updates with Err
+// set.  It returns the context error.
+func (f *Flattener) Run(ctx context.Context, fn func(FlattenUpdate)) error {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+
+	for {
+		up, err := f.Refresh(ctx)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		switch {
+		case err != nil:
+			fn(FlattenUpdate{Time: f.now(), Err: err})
+		case up != nil:
+			fn(*up)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *Flattener) now() time.Time {
+	if f.Clock != nil {
+		return f.Clock()
+	}
+
+	return time.Now()
+}
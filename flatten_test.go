@@ -0,0 +1,117 @@
+package spf
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func flattenZone() *zoneResolver {
+	return &zoneResolver{
+		txt: map[string][]string{
+			"source.example.com": {"v=spf1 ip4:192.0.2.0/25 include:esp.example.net a:mail.example.com -ip4:198.51.100.1 redirect=base.example.com"},
+			"esp.example.net":    {"v=spf1 ip4:192.0.2.128/25 ip6:2001:db8::/32 exists:%{i}._spf.esp.example.net ~all"},
+			"base.example.com":   {"v=spf1 mx ~all"},
+		},
+		ip: map[string][]string{
+			"mail.example.com": {"198.51.100.10", "2001:db8::10"},
+			"mx.example.com":   {"198.51.100.20"},
+		},
+		mx: map[string][]string{"base.example.com": {"mx.example.com"}},
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	record, inv, err := Flatten(context.Background(), NewCustomDNSResolver(flattenZone()), "source.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.10 ip4:198.51.100.20 ip6:2001:db8::/32 exists:%{i}._spf.esp.example.net ~all", record)
+	assert.Equal(t, "source.example.com", inv.Domain)
+
+	zone := flattenZone()
+	zone.txt["esp.example.net"] = []string{"v=spf1 exists:%{d}.esp.example.net -all"}
+	_, _, err = Flatten(context.Background(), NewCustomDNSResolver(zone), "source.example.com")
+	require.ErrorIs(t, err, ErrClientDependent)
+
+	delete(zone.txt, "esp.example.net")
+	_, _, err = Flatten(context.Background(), NewCustomDNSResolver(zone), "source.example.com")
+	require.ErrorIs(t, err, ErrNoDNSrecord)
+}
+
+func TestFlatten_Shadowing(t *testing.T) {
+	tests := []struct {
+		esp  string
+		want string // error, "" for none
+	}{
+		{"v=spf1 ip4:192.0.2.128/25 ~ip4:203.0.113.0/24 ~all", ""},
+		{"v=spf1 -ip4:192.0.2.200 ip4:192.0.2.128/25 ~all", "esp.example.net: -ip4:192.0.2.200/32: "},
+		{"v=spf1 ?ip6:2001:db8::/48 ip6:2001:db8::/32 ~all", "esp.example.net: ?ip6:2001:db8::/48: "},
+		{"v=spf1 -include:deny.example.org ip4:192.0.2.128/25 ~all", "esp.example.net: -include:deny.example.org: "},
+		{"v=spf1 ~a:mail.example.com ip4:192.0.2.128/25 ~all", "esp.example.net: ~a:mail.example.com: "},
+	}
+	for _, tt := range tests {
+		zone := flattenZone()
+		zone.txt["esp.example.net"] = []string{tt.esp}
+		zone.txt["deny.example.org"] = []string{"v=spf1 ip4:192.0.2.200 -all"}
+		_, _, err := Flatten(context.Background(), NewCustomDNSResolver(zone), "source.example.com")
+		if tt.want == "" {
+			assert.NoError(t, err, tt.esp)
+			continue
+		}
+		require.ErrorIs(t, err, ErrShadowingTerm, tt.esp)
+		assert.ErrorContains(t, err, tt.want, tt.esp)
+	}
+}
+
+func TestFlattener_Refresh(t *testing.T) {
+	ctx := context.Background()
+	zone := flattenZone()
+	zone.txt["esp.example.net"] = []string{"v=spf1 ip4:192.0.2.128/25 ip6:2001:db8::/32 ~all"}
+	f := NewFlattener(NewCustomDNSResolver(zone), "source.example.com", "example.com", 0)
+	f.MaxSize = 64
+
+	up, err := f.Refresh(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, up)
+	assert.Empty(t, up.Delete)
+	assert.Equal(t, up.Records, up.Upsert)
+	require.Len(t, up.Records, 3)
+	assert.Equal(t, "v=spf1 include:_spf1.example.com include:_spf2.example.com ~all", up.Records[0].Text)
+	assert.Len(t, up.Added, 6)
+
+	up, err = f.Refresh(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, up, "unchanged source")
+
+	// a restarted flattener takes what is published as baseline
+	for _, r := range f.Records() {
+		zone.txt[r.Name] = []string{r.Text}
+	}
+	f = NewFlattener(NewCustomDNSResolver(zone), "source.example.com", "example.com", 0)
+	f.MaxSize = 64
+	up, err = f.Refresh(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, up)
+
+	// the namespace matches the published names in any case
+	f = NewFlattener(NewCustomDNSResolver(zone), "source.example.com", "example.com", 0)
+	f.Namespace = "Example.COM."
+	f.MaxSize = 64
+	up, err = f.Refresh(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, up)
+
+	zone.ip["mx.example.com"] = []string{"203.0.113.5"}
+	zone.ip["mail.example.com"] = []string{"198.51.100.10"}
+	zone.txt["esp.example.net"] = []string{"v=spf1 ip4:192.0.2.128/25 ~all"}
+	up, err = f.Refresh(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, up)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("203.0.113.5/32")}, up.Added)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("198.51.100.20/32"), netip.MustParsePrefix("2001:db8::/32"), netip.MustParsePrefix("2001:db8::10/128")}, up.Removed)
+	assert.Equal(t, []string{"_spf1.example.com", "_spf2.example.com"}, up.Delete)
+	assert.Equal(t, []PublishedRecord{{Name: "example.com", Text: "v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.10 ip4:203.0.113.5 ~all"}}, up.Upsert)
+	assert.Equal(t, up.Records, up.Upsert)
+}
@@ -0,0 +1,159 @@
+package spf
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// SenderInventory lists the senders a generated record authorizes.
+type SenderInventory struct {
+	// Prefixes are the authorized addresses and networks; a single address
+	// is a /32 or /128 prefix.
+	Prefixes []netip.Prefix
+	Hosts    []string // hosts authorized through their A and AAAA records
+	Includes []string // domains whose policies are included
+	// Terms are further mechanisms, e.g. exists terms with macros, written
+	// verbatim before "all".  Each counts as one DNS lookup.
+	Terms []string
+	// All is the qualifier of the final "all" mechanism; zero means "~".
+	All parser.Qualifier
+}
+
+// Add classifies entry as an address, a CIDR network, an "include:" target
+// or a host name and adds it to the inventory.
+func (inv *SenderInventory) Add(entry string) error {
+	entry = strings.TrimSpace(entry)
+	if target, ok := strings.CutPrefix(strings.ToLower(entry), "include:"); ok {
+		inv.Includes = append(inv.Includes, target)
+		return nil
+	}
+	if p, err := netip.ParsePrefix(entry); err == nil {
+		inv.Prefixes = append(inv.Prefixes, p)
+		return nil
+	}
+	if a, err := netip.ParseAddr(entry); err == nil {
+		inv.Prefixes = append(inv.Prefixes, netip.PrefixFrom(a, a.BitLen()))
+		return nil
+	}
+	// an all-numeric entry is a malformed address rather than a host name
+	if strings.ContainsAny(entry, ":/") || strings.Trim(entry, "0123456789.") == "" {
+		return fmt.Errorf("invalid address or network %q", entry)
+	}
+	inv.Hosts = append(inv.Hosts, entry)
+
+	return nil
+}
+
+// Generate returns the shortest record authorizing exactly the senders of
+// inv: duplicate entries are dropped, nested networks are folded into their
+// parents and adjacent networks are merged.  Terms are written as ip4, ip6,
+// a, include, the verbatim Terms and all, in that order, with hosts and
+// includes kept in the order given.  Generate fails on invalid host names or
+// include targets and when the record would need more than MaxDNSLookups
+// lookups.
+func Generate(inv SenderInventory) (string, error) {
+	hosts, err := uniqueDomains(inv.Hosts)
+	if err != nil {
+		return "", err
+	}
+	includes, err := uniqueDomains(inv.Includes)
+	if err != nil {
+		return "", err
+	}
+	if n := len(hosts) + len(includes) + len(inv.Terms); n > MaxDNSLookups {
+		return "", fmt.Errorf("%w: generated record needs %d", ErrTooManyLookups, n)
+	}
+
+	terms := []string{"v=spf1"}
+	for _, p := range aggregatePrefixes(inv.Prefixes) {
+		terms = append(terms, prefixTerm(p))
+	}
+	for _, h := range hosts {
+		terms = append(terms, "a:"+h)
+	}
+	for _, d := range includes {
+		terms = append(terms, "include:"+d)
+	}
+	terms = append(terms, inv.Terms...)
+	all := inv.All
+	if all == 0 {
+		all = parser.QTilde
+	}
+	terms = append(terms, parser.Mechanism{Qual: all, Kind: "all"}.String())
+
+	return strings.Join(terms, " "), nil
+}
+
+// uniqueDomains validates domains and removes duplicates, keeping the first
+// occurrence of each.
+func uniqueDomains(domains []string) ([]string, error) {
+	seen := make(map[string]bool, len(domains))
+	var out []string
+	for _, raw := range domains {
+		d, err := parser.ValidateDomainSpec(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", raw, err)
+		}
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+
+	return out, nil
+}
+
+// prefixTerm writes p as an ip4 or ip6 mechanism, omitting host masks.
+func prefixTerm(p netip.Prefix) string {
+	kind := "ip6:"
+	if p.Addr().Is4() {
+		kind = "ip4:"
+	}
+	if p.IsSingleIP() {
+		return kind + p.Addr().String()
+	}
+
+	return kind + p.String()
+}
+
+// aggregatePrefixes returns the smallest sorted set of prefixes covering the
+// same addresses as ps.  IPv4-mapped IPv6 prefixes are converted to IPv4.
+func aggregatePrefixes(ps []netip.Prefix) []netip.Prefix {
+	norm := make([]netip.Prefix, 0, len(ps))
+	for _, p := range ps {
+		if !p.IsValid() {
+			continue
+		}
+		if a := p.Addr(); a.Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(a.Unmap(), p.Bits()-96)
+		}
+		norm = append(norm, p.Masked())
+	}
+	sortPrefixes(norm)
+
+	var out []netip.Prefix
+	for _, p := range norm {
+		if n := len(out); n > 0 && out[n-1].Bits() <= p.Bits() && out[n-1].Contains(p.Addr()) {
+			continue
+		}
+		out = append(out, p)
+		// merge sibling halves into their parent as long as possible
+		for len(out) >= 2 {
+			a, b := out[len(out)-2], out[len(out)-1]
+			if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() {
+				break
+			}
+			pa, _ := a.Addr().Prefix(a.Bits() - 1)
+			pb, _ := b.Addr().Prefix(b.Bits() - 1)
+			if pa != pb {
+				break
+			}
+			out = append(out[:len(out)-2], pa)
+		}
+	}
+
+	return out
+}
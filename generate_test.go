@@ -0,0 +1,57 @@
+package spf
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mailspire/spf/parser"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		all     parser.Qualifier
+		want    string
+	}{
+		{"empty", nil, 0, "v=spf1 ~all"},
+		{"hosts", []string{"192.0.2.1", "2001:db8::1", "2001:db8::/32"}, parser.QMinus, "v=spf1 ip4:192.0.2.1 ip6:2001:db8::/32 -all"},
+		{"merge siblings", []string{"192.0.2.0/25", "192.0.2.128/25", "198.51.100.2", "198.51.100.3"}, 0, "v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.2/31 ~all"},
+		{"nested and duplicates", []string{"192.0.2.7", "192.0.2.0/24", "192.0.2.9/24", "::ffff:192.0.2.1"}, 0, "v=spf1 ip4:192.0.2.0/24 ~all"},
+		{"not adjacent", []string{"192.0.2.1", "192.0.2.2"}, 0, "v=spf1 ip4:192.0.2.1 ip4:192.0.2.2 ~all"},
+		{"domains", []string{"include:_spf.esp.example", "mail.example.com", "Mail.Example.com.", "include:_SPF.esp.example", "192.0.2.1"}, parser.QPlus,
+			"v=spf1 ip4:192.0.2.1 a:mail.example.com include:_spf.esp.example all"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv := SenderInventory{All: tt.all}
+			for _, e := range tt.entries {
+				require.NoError(t, inv.Add(e))
+			}
+			got, err := Generate(inv)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			_, err = parser.Parse(got)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	var inv SenderInventory
+	require.Error(t, inv.Add("192.0.2.300"))
+	require.Error(t, inv.Add("192.0.2.0/33"))
+
+	_, err := Generate(SenderInventory{Hosts: []string{"bad..example.com"}})
+	require.ErrorIs(t, err, parser.ErrEmptyLabel)
+
+	inv = SenderInventory{Prefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}}
+	for _, d := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"} {
+		inv.Includes = append(inv.Includes, d+".example.com")
+	}
+	_, err = Generate(inv)
+	require.ErrorIs(t, err, ErrTooManyLookups)
+}
@@ -0,0 +1,359 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// Graph holds the SPF records reachable from Root through include mechanisms
+// and redirect modifiers.  It is built without evaluating any client address
+// and serves as the basis for audits and visualisations.
+type Graph struct {
+	Root  string
+	Nodes map[string]*Node // keyed by domain
+}
+
+// Node is one domain of a Graph.
+type Node struct {
+	Domain string
+	Raw    string         // selected v=spf1 TXT record, "" when none
+	Record *parser.Record // nil when the record is missing or invalid
+	Err    error          // lookup, selection or parse error
+	// Lookups is the number of terms in this record that count against the
+	// limit of RFC 7208 section 4.6.4 (include, a, mx, ptr, exists and an
+	// effective redirect).
+	Lookups int
+	Edges   []Edge
+}
+
+// Edge is an include or redirect reference from one record to another.
+type Edge struct {
+	Kind   string           // "include" or "redirect"
+	Qual   parser.Qualifier // qualifier of an include, QPlus for redirect
+	Target string           // target domain or, when Macro is set, the raw domain-spec
+	// Macro is set when the target depends on macros and therefore on the
+	// evaluated message; such targets are not part of the graph.
+	Macro bool
+}
+
+// BuildGraph resolves domain and every record it references through include
+// and redirect.  A redirect in a record that also contains "all" is never
+// evaluated (RFC 7208 section 6.1) and is not followed.  The walk stops at
+// the default limits of a Walker.  Per-record problems, including records
+// left unfetched at those limits, are reported in Node.Err; only an invalid
+// domain or a context error is returned as error.  domain is validated like an include target, with
+// ValidateDomainSpec, so that records published below underscore labels
+// such as "_spf.example.com" can be graphed, e.g. by a Scanner.
+func BuildGraph(ctx context.Context, r TXTResolver, domain string) (*Graph, error) {
+	root, err := parser.ValidateDomainSpec(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Graph{Root: root, Nodes: make(map[string]*Node)}
+	err = NewWalker(r).Walk(ctx, root, func(node *Node, _ int) error {
+		g.Nodes[node.Domain] = node
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// buildNode fetches and parses the record of one domain.
+func buildNode(ctx context.Context, r TXTResolver, domain string) (*Node, error) {
+	node := &Node{Domain: domain}
+	raw, err := getSPFRecord(ctx, domain, r)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return nil, err
+	case err != nil:
+		node.Err = err
+		return node, nil
+	case raw == "":
+		node.Err = ErrMissingRecord
+		return node, nil
+	}
+
+	return nodeFromRecord(domain, raw), nil
+}
+
+// nodeFromRecord parses the selected record raw of domain into a Node.
+func nodeFromRecord(domain, raw string) *Node {
+	node := &Node{Domain: domain, Raw: raw}
+	rec, err := parser.Parse(raw)
+	if err != nil {
+		node.Err = err
+		return node
+	}
+	node.Record = rec
+
+	for _, mech := range rec.Mechs {
+		switch mech.Kind {
+		case "a", "mx", "ptr", "exists":
+			node.Lookups++
+		case "include":
+			node.Lookups++
+			node.Edges = append(node.Edges, newEdge("include", mech.Qual, mech.Domain))
+		}
+	}
+	if rec.Redirect != nil && !hasAll(rec) {
+		node.Lookups++
+		node.Edges = append(node.Edges, newEdge("redirect", parser.QPlus, rec.Redirect.Value))
+	}
+
+	return node
+}
+
+// newEdge builds an Edge, normalising targets that contain no macros.
+func newEdge(kind string, q parser.Qualifier, spec string) Edge {
+	edge := Edge{Kind: kind, Qual: q, Target: spec, Macro: true}
+	if valid, err := parser.ValidateDomainSpec(spec); err == nil {
+		edge.Target, edge.Macro = valid, false
+	}
+
+	return edge
+}
+
+// TotalLookups returns the number of DNS-querying terms that evaluating the
+// whole tree below Root costs when no term matches early, which is what the
+// limit of RFC 7208 section 4.6.4 is checked against.  Records reached more
+// than once are counted every time, as they would be during evaluation;
+// a cycle is counted once around.
+func (g *Graph) TotalLookups() int {
+	return g.lookupsFrom(g.Root, map[string]bool{})
+}
+
+func (g *Graph) lookupsFrom(domain string, path map[string]bool) int {
+	node, ok := g.Nodes[domain]
+	if !ok || path[domain] {
+		return 0
+	}
+	path[domain] = true
+	defer delete(path, domain)
+
+	total := node.Lookups
+	for _, edge := range node.Edges {
+		if !edge.Macro {
+			total += g.lookupsFrom(edge.Target, path)
+		}
+	}
+
+	return total
+}
+
+// Domains returns the domains of the graph in lexical order.
+func (g *Graph) Domains() []string {
+	domains := make([]string, 0, len(g.Nodes))
+	for d := range g.Nodes {
+		domains = append(domains, d)
+	}
+	sort.Strings(domains)
+
+	return domains
+}
+
+// DOT renders the graph in the Graphviz DOT language.  Every node shows its
+// own lookup cost, the graph label shows the total against the RFC limit and
+// nodes with errors are drawn in red.  Macro targets are drawn dashed.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	total := g.TotalLookups()
+	fmt.Fprintf(&b, "digraph spf {\n")
+	fmt.Fprintf(&b, "\tlabel=%s;\n", dotQuote(fmt.Sprintf("%s: %d of %d DNS lookups", g.Root, total, MaxDNSLookups)))
+	fmt.Fprintf(&b, "\tnode [shape=box];\n")
+
+	for _, domain := range g.Domains() {
+		node := g.Nodes[domain]
+		label := fmt.Sprintf("%s\n%s", domain, pluralLookups(node.Lookups))
+		attrs := ""
+		if node.Err != nil {
+			label += "\n" + node.Err.Error()
+			attrs = ", color=red"
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s%s];\n", dotQuote(domain), dotQuote(label), attrs)
+	}
+
+	for _, domain := range g.Domains() {
+		for _, edge := range g.Nodes[domain].Edges {
+			if edge.Macro {
+				fmt.Fprintf(&b, "\t%s [style=dashed];\n", dotQuote(edge.Target))
+			}
+			fmt.Fprintf(&b, "\t%s -> %s [label=%s];\n", dotQuote(domain), dotQuote(edge.Target), dotQuote(edge.term()))
+		}
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// Tree renders the graph as an indented text tree rooted at Root.  Each line
+// shows the lookups of that record and, in brackets, of its whole subtree.
+// A record that includes one of its ancestors is marked as a cycle.
+func (g *Graph) Tree() string {
+	var b strings.Builder
+	g.writeTree(&b, g.Root, "", "", map[string]bool{})
+
+	return b.String()
+}
+
+func (g *Graph) writeTree(b *strings.Builder, domain, term, indent string, path map[string]bool) {
+	line := domain
+	if term != "" {
+		line = term
+	}
+	node, ok := g.Nodes[domain]
+	switch {
+	case !ok:
+		fmt.Fprintf(b, "%s%s\n", indent, line)
+		return
+	case path[domain]:
+		fmt.Fprintf(b, "%s%s (cycle)\n", indent, line)
+		return
+	}
+
+	fmt.Fprintf(b, "%s%s: %s [%d total]", indent, line, pluralLookups(node.Lookups), g.lookupsFrom(domain, path))
+	if node.Err != nil {
+		fmt.Fprintf(b, " error: %v", node.Err)
+	}
+	b.WriteByte('\n')
+
+	path[domain] = true
+	defer delete(path, domain)
+	for _, edge := range node.Edges {
+		g.writeTree(b, edge.Target, edge.term(), indent+"  ", path)
+	}
+}
+
+// term returns the SPF term an edge was created from.
+func (e Edge) term() string {
+	if e.Kind == "redirect" {
+		return "redirect=" + e.Target
+	}
+	if e.Qual != parser.QPlus {
+		return e.Qual.String() + "include:" + e.Target
+	}
+
+	return "include:" + e.Target
+}
+
+func pluralLookups(n int) string {
+	if n == 1 {
+		return "1 lookup"
+	}
+
+	return fmt.Sprintf("%d lookups", n)
+}
+
+// dotQuote quotes s as a DOT string.
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// FragileHeadroom is the number of spare DNS lookups below which an
+// IncludeReport with references to records of other domains is fragile: a
+// vendor adding a mechanism or two to its record pushes the whole policy over
+// the limit of RFC 7208 section 4.6.4 and turns every result into permerror.
+const FragileHeadroom = 2
+
+// IncludeReport describes the shape of the include and redirect tree of a
+// Graph.
+type IncludeReport struct {
+	Root string
+	// Depth is the deepest nesting of references below Root, 0 when Root
+	// references nothing.
+	Depth int
+	// FanOut[i] counts the references made by the records at depth i,
+	// Root being depth 0.  Records reached more than once are counted every
+	// time, as for TotalLookups; macro targets are counted but not followed.
+	FanOut   []int
+	Lookups  int // TotalLookups
+	Headroom int // MaxDNSLookups - Lookups, negative when over the limit
+	// Vendors are the references leaving the domain of Root, i.e. to a
+	// target that is neither Root nor one of its subdomains, whose records
+	// the publisher does not control.
+	Vendors []VendorInclude
+}
+
+// VendorInclude is a reference to a record of another domain.
+type VendorInclude struct {
+	Path    []string // domains from Root to the target
+	Term    string   // e.g. "include:_spf.vendor.example"
+	Lookups int      // lookups of the target's subtree
+}
+
+// Fragile reports whether the tree depends on records of other domains
+// while having fewer than FragileHeadroom lookups to spare.
+func (r IncludeReport) Fragile() bool {
+	return len(r.Vendors) > 0 && r.Headroom < FragileHeadroom
+}
+
+// String formats r for humans, one line per level and vendor reference.
+func (r IncludeReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: depth %d, %d of %d DNS lookups", r.Root, r.Depth, r.Lookups, MaxDNSLookups)
+	if r.Fragile() {
+		b.WriteString(", fragile")
+	}
+	b.WriteByte('\n')
+	for depth, n := range r.FanOut {
+		fmt.Fprintf(&b, "  level %d: %d references\n", depth, n)
+	}
+	for _, v := range r.Vendors {
+		fmt.Fprintf(&b, "  %s via %s: %s\n", v.Term, strings.Join(v.Path[:len(v.Path)-1], " > "), pluralLookups(v.Lookups))
+	}
+
+	return b.String()
+}
+
+// IncludeReport returns the depth, per-level fan-out and vendor references
+// of the tree below Root.  A cycle is followed once around.
+func (g *Graph) IncludeReport() IncludeReport {
+	r := IncludeReport{Root: g.Root, Lookups: g.TotalLookups()}
+	r.Headroom = MaxDNSLookups - r.Lookups
+	g.walkIncludes(&r, []string{g.Root}, map[string]bool{g.Root: true})
+
+	return r
+}
+
+// walkIncludes adds the references of the last domain of path, at depth
+// len(path)-1, and of its subtree to r.
+func (g *Graph) walkIncludes(r *IncludeReport, path []string, onPath map[string]bool) {
+	domain := path[len(path)-1]
+	node, ok := g.Nodes[domain]
+	if !ok || len(node.Edges) == 0 {
+		return
+	}
+	depth := len(path) - 1
+	if len(r.FanOut) <= depth {
+		r.FanOut = append(r.FanOut, make([]int, depth+1-len(r.FanOut))...)
+	}
+	r.FanOut[depth] += len(node.Edges)
+	r.Depth = max(r.Depth, depth+1)
+
+	own := isSubdomain(domain, g.Root)
+	for _, edge := range node.Edges {
+		if edge.Macro || onPath[edge.Target] {
+			continue
+		}
+		child := append(path[:len(path):len(path)], edge.Target)
+		if own && !isSubdomain(edge.Target, g.Root) {
+			r.Vendors = append(r.Vendors, VendorInclude{
+				Path:    child,
+				Term:    edge.term(),
+				Lookups: g.lookupsFrom(edge.Target, onPath),
+			})
+		}
+		onPath[edge.Target] = true
+		g.walkIncludes(r, child, onPath)
+		delete(onPath, edge.Target)
+	}
+}
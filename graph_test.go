@@ -0,0 +1,137 @@
+package spf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mailspire/spf/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func graphZone() *zoneResolver {
+	return &zoneResolver{txt: map[string][]string{
+		"example.com":         {"v=spf1 mx include:_spf.example.com include:vendor.example.net ~include:%{d}.macro.example -all"},
+		"_spf.example.com":    {"v=spf1 a ip4:192.0.2.0/24 redirect=_spf2.example.com"},
+		"_spf2.example.com":   {"v=spf1 include:vendor.example.net ?all"},
+		"vendor.example.net":  {"v=spf1 exists:%{i}.x.example.net include:example.com -all"},
+		"broken.example.com":  {"v=spf1 include:missing.example.com bogus -all"},
+		"ignored.example.com": {"v=spf1 -all redirect=_spf.example.com"},
+	}}
+}
+
+func TestBuildGraph(t *testing.T) {
+	g, err := BuildGraph(context.Background(), graphZone(), "Example.COM")
+	require.NoError(t, err)
+
+	assert.Equal(t, "example.com", g.Root)
+	assert.Equal(t, []string{"_spf.example.com", "_spf2.example.com", "example.com", "vendor.example.net"}, g.Domains())
+
+	root := g.Nodes["example.com"]
+	assert.Equal(t, 4, root.Lookups)
+	assert.Equal(t, []Edge{
+		{Kind: "include", Qual: parser.QPlus, Target: "_spf.example.com"},
+		{Kind: "include", Qual: parser.QPlus, Target: "vendor.example.net"},
+		{Kind: "include", Qual: parser.QTilde, Target: "%{d}.macro.example", Macro: true},
+	}, root.Edges)
+
+	spf := g.Nodes["_spf.example.com"]
+	assert.Equal(t, 2, spf.Lookups)
+	assert.Equal(t, []Edge{{Kind: "redirect", Qual: parser.QPlus, Target: "_spf2.example.com"}}, spf.Edges)
+	require.NotNil(t, spf.Record)
+
+	// root 4 + _spf 2 + _spf2 1 + vendor 2 (twice) — the cycle back to
+	// example.com is not followed again
+	assert.Equal(t, 4+2+1+2+2, g.TotalLookups())
+}
+
+func TestBuildGraph_Errors(t *testing.T) {
+	g, err := BuildGraph(context.Background(), graphZone(), "broken.example.com")
+	require.NoError(t, err)
+	require.Error(t, g.Nodes["broken.example.com"].Err, "parse error")
+	assert.Nil(t, g.Nodes["broken.example.com"].Record)
+
+	g, err = BuildGraph(context.Background(), graphZone(), "ignored.example.com")
+	require.NoError(t, err)
+	assert.Empty(t, g.Nodes["ignored.example.com"].Edges, "redirect next to all is ignored")
+	assert.Len(t, g.Nodes, 1)
+
+	g, err = BuildGraph(context.Background(), &zoneResolver{txt: map[string][]string{
+		"example.com": {"v=spf1 include:missing.example.com -all"},
+	}}, "example.com")
+	require.NoError(t, err)
+	require.ErrorIs(t, g.Nodes["missing.example.com"].Err, ErrNoDNSrecord)
+
+	g, err = BuildGraph(context.Background(), graphZone(), "_SPF.example.com")
+	require.NoError(t, err, "underscore labels are accepted")
+	assert.Equal(t, "_spf.example.com", g.Root)
+
+	_, err = BuildGraph(context.Background(), graphZone(), "localhost")
+	require.ErrorIs(t, err, parser.ErrSingleLabel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = BuildGraph(ctx, graphZone(), "example.com")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestGraph_Render(t *testing.T) {
+	g, err := BuildGraph(context.Background(), graphZone(), "example.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, `example.com: 4 lookups [11 total]
+  include:_spf.example.com: 2 lookups [5 total]
+    redirect=_spf2.example.com: 1 lookup [3 total]
+      include:vendor.example.net: 2 lookups [2 total]
+        include:example.com (cycle)
+  include:vendor.example.net: 2 lookups [2 total]
+    include:example.com (cycle)
+  ~include:%{d}.macro.example
+`, g.Tree())
+
+	dot := g.DOT()
+	assert.Contains(t, dot, `label="example.com: 11 of 10 DNS lookups";`)
+	assert.Contains(t, dot, `"_spf.example.com" [label="_spf.example.com\n2 lookups"];`)
+	assert.Contains(t, dot, `"_spf.example.com" -> "_spf2.example.com" [label="redirect=_spf2.example.com"];`)
+	assert.Contains(t, dot, `"%{d}.macro.example" [style=dashed];`)
+	assert.Contains(t, dot, `"example.com" -> "%{d}.macro.example" [label="~include:%{d}.macro.example"];`)
+
+	g, err = BuildGraph(context.Background(), graphZone(), "broken.example.com")
+	require.NoError(t, err)
+	assert.Contains(t, g.DOT(), "color=red")
+	assert.Contains(t, g.Tree(), "error:")
+}
+
+func TestGraph_IncludeReport(t *testing.T) {
+	g, err := BuildGraph(context.Background(), graphZone(), "example.com")
+	require.NoError(t, err)
+
+	r := g.IncludeReport()
+	assert.Equal(t, 4, r.Depth)
+	assert.Equal(t, []int{3, 2, 1, 1}, r.FanOut)
+	assert.Equal(t, 11, r.Lookups)
+	assert.Equal(t, -1, r.Headroom)
+	assert.Equal(t, []VendorInclude{
+		{Path: []string{"example.com", "_spf.example.com", "_spf2.example.com", "vendor.example.net"}, Term: "include:vendor.example.net", Lookups: 2},
+		{Path: []string{"example.com", "vendor.example.net"}, Term: "include:vendor.example.net", Lookups: 2},
+	}, r.Vendors)
+	assert.True(t, r.Fragile())
+	assert.Contains(t, r.String(), "include:vendor.example.net via example.com > _spf.example.com > _spf2.example.com: 2 lookups")
+
+	g, err = BuildGraph(context.Background(), &zoneResolver{txt: map[string][]string{
+		"example.org":         {"v=spf1 include:_spf.vendor.example -all"},
+		"_spf.vendor.example": {"v=spf1 ip4:192.0.2.0/24 -all"},
+	}}, "example.org")
+	require.NoError(t, err)
+	r = g.IncludeReport()
+	assert.Equal(t, 9, r.Headroom)
+	assert.Len(t, r.Vendors, 1)
+	assert.False(t, r.Fragile(), "enough headroom")
+
+	g, err = BuildGraph(context.Background(), graphZone(), "ignored.example.com")
+	require.NoError(t, err)
+	r = g.IncludeReport()
+	assert.Zero(t, r.Depth)
+	assert.Empty(t, r.FanOut)
+	assert.False(t, r.Fragile())
+}
@@ -0,0 +1,164 @@
+package spf
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultQuarantine is the time an upstream server is left out of rotation
+// once it is considered unhealthy, unless UpstreamResolver.QuarantineFor is
+// set.
+const DefaultQuarantine = 30 * time.Second
+
+// ServerHealth describes the health of one upstream server.
+type ServerHealth struct {
+	Server   string // "address:port"
+	Failures int    // consecutive failed queries and probes
+	LastErr  error  // error of the last failure
+	// QuarantinedUntil is the end of the quarantine of an unhealthy server
+	// and zero for a healthy one.
+	QuarantinedUntil time.Time
+}
+
+// Quarantined reports whether the server is out of rotation at now.
+func (h ServerHealth) Quarantined(now time.Time) bool {
+	return now.Before(h.QuarantinedUntil)
+}
+
+// healthTable tracks the ServerHealth of the servers of an UpstreamResolver.
+type healthTable struct {
+	mu      sync.Mutex
+	servers map[string]*ServerHealth
+}
+
+// record notes the outcome of a query to server.  After failures
+// consecutive failures the server is quarantined until now+period; a
+// success ends the quarantine.
+func (t *healthTable) record(server string, err error, failures int, now time.Time, period time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.servers == nil {
+		t.servers = make(map[string]*ServerHealth)
+	}
+	h, ok := t.servers[server]
+	if !ok {
+		h = &ServerHealth{Server: server}
+		t.servers[server] = h
+	}
+	if err == nil {
+		h.Failures, h.LastErr, h.QuarantinedUntil = 0, nil, time.Time{}
+		return
+	}
+	h.Failures++
+	h.LastErr = err
+	if failures > 0 && h.Failures >= failures {
+		h.QuarantinedUntil = now.Add(period)
+	}
+}
+
+// available returns the servers not in quarantine at now, keeping their
+// order.  When every server is quarantined all are returned, since trying
+// them beats failing every lookup.
+func (t *healthTable) available(servers []string, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]string, 0, len(servers))
+	for _, s := range servers {
+		if h, ok := t.servers[s]; !ok || !h.Quarantined(now) {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return servers
+	}
+
+	return out
+}
+
+// Health returns the health of the upstream servers queried so far, sorted
+// by server.
+func (u *UpstreamResolver) Health() []ServerHealth {
+	u.health.mu.Lock()
+	defer u.health.mu.Unlock()
+	out := make([]ServerHealth, 0, len(u.health.servers))
+	for _, h := range u.health.servers {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Server < out[j].Server })
+
+	return out
+}
+
+// Probe sends a health probe, a query for the NS records of ProbeName or
+// the root, to every upstream server, quarantined ones included, and
+// records the outcomes as for lookups.  Any answer other than SERVFAIL,
+// REFUSED and the like counts as healthy.
+func (u *UpstreamResolver) Probe(ctx context.Context) error {
+	name := u.ProbeName
+	if name == "" {
+		name = "."
+	}
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return err
+	}
+	servers, err := u.servers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, server := range servers {
+		msg, err := u.exchange(ctx, server, qname, dnsmessage.TypeNS)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			err = rcodeError(msg, name, server)
+		}
+		u.recordHealth(server, err)
+	}
+
+	return nil
+}
+
+// RunHealthChecks probes the upstream servers immediately and then every
+// interval until ctx is done, so that unhealthy servers are quarantined
+// before mail is checked against them and come back once they answer again.
+// Probes that cannot be sent, e.g. because a server name does not resolve,
+// are retried at the next interval.  It returns the context error.
+func (u *UpstreamResolver) RunHealthChecks(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := u.Probe(ctx); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordHealth notes the outcome of a query to server.
+func (u *UpstreamResolver) recordHealth(server string, err error) {
+	period := u.QuarantineFor
+	if period <= 0 {
+		period = DefaultQuarantine
+	}
+	u.health.record(server, err, u.QuarantineAfter, u.now(), period)
+}
+
+func (u *UpstreamResolver) now() time.Time {
+	if u.clock != nil {
+		return u.clock()
+	}
+
+	return time.Now()
+}
@@ -0,0 +1,96 @@
+package spf
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestUpstreamResolver_Quarantine(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	flaky := newDNSServer(t, func(q dnsmessage.Message, _ bool) dnsmessage.Message {
+		if failing.Load() {
+			return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}}
+		}
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 -all")}}
+	})
+	good := newDNSServer(t, func(q dnsmessage.Message, _ bool) dnsmessage.Message {
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 -all")}}
+	})
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	u := NewUpstreamResolver(flaky.addr, good.addr)
+	u.QuarantineAfter = 2
+	u.QuarantineFor = time.Minute
+	u.clock = func() time.Time { return now }
+	ctx := context.Background()
+
+	for range 3 {
+		_, err := u.LookupTXT(ctx, "example.com")
+		require.NoError(t, err)
+	}
+	assert.Len(t, flaky.received(), 2, "quarantined after two failures")
+	require.Len(t, u.Health(), 2)
+	health := serverHealth(u, flaky.addr)
+	assert.Equal(t, 2, health.Failures)
+	assert.True(t, health.Quarantined(now))
+	require.ErrorContains(t, health.LastErr, "server misbehaving")
+	assert.False(t, serverHealth(u, good.addr).Quarantined(now))
+
+	// back in rotation when the quarantine ends
+	now = now.Add(2 * time.Minute)
+	_, err := u.LookupTXT(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Len(t, flaky.received(), 3)
+	assert.True(t, serverHealth(u, flaky.addr).Quarantined(now), "failed again")
+
+	// a successful probe ends the quarantine
+	failing.Store(false)
+	require.NoError(t, u.Probe(ctx))
+	probe := flaky.received()[3]
+	assert.Equal(t, dnsmessage.TypeNS, probe.Questions[0].Type)
+	assert.Equal(t, ".", probe.Questions[0].Name.String())
+	assert.Equal(t, ServerHealth{Server: flaky.addr}, serverHealth(u, flaky.addr))
+}
+
+func serverHealth(u *UpstreamResolver, server string) ServerHealth {
+	for _, h := range u.Health() {
+		if h.Server == server {
+			return h
+		}
+	}
+
+	return ServerHealth{}
+}
+
+func TestUpstreamResolver_QuarantineAll(t *testing.T) {
+	broken := newDNSServer(t, func(dnsmessage.Message, bool) dnsmessage.Message {
+		return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeRefused}}
+	})
+	u := NewUpstreamResolver(broken.addr)
+	u.QuarantineAfter = 1
+
+	for range 2 {
+		_, err := u.LookupTXT(context.Background(), "example.com")
+		require.ErrorIs(t, classifyDNSError(err), ErrTempfail)
+	}
+	assert.Len(t, broken.received(), 2, "the only server is still tried")
+}
+
+func TestUpstreamResolver_RunHealthChecks(t *testing.T) {
+	srv := newDNSServer(t, func(dnsmessage.Message, bool) dnsmessage.Message { return dnsmessage.Message{} })
+	u := NewUpstreamResolver(srv.addr)
+	u.ProbeName = "example.com"
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, u.RunHealthChecks(ctx, 10*time.Millisecond), context.DeadlineExceeded)
+	queries := srv.received()
+	assert.GreaterOrEqual(t, len(queries), 2)
+	assert.Equal(t, "example.com.", queries[0].Questions[0].Name.String())
+}
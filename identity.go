@@ -0,0 +1,298 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mailspire/spf/parser"
+	"golang.org/x/net/idna"
+)
+
+// Errors returned while parsing identities.
+var (
+	// ErrAddressLiteral is the Cause reported by CheckHELO when the HELO
+	// identity is an address literal rather than a domain name.
+	ErrAddressLiteral = errors.New("HELO identity is an address literal")
+	// ErrBadReversePath is returned by ParseReversePath for malformed input.
+	ErrBadReversePath = errors.New("malformed reverse-path")
+)
+
+// ParseAddressLiteral parses an RFC 5321 section 4.1.3 address literal such as
+// "[192.0.2.1]" or "[IPv6:2001:db8::1]".  The boolean result reports whether s
+// uses the address-literal syntax at all; the returned IP is nil for general
+// address literals ("[tag:content]") and for literals whose address does not
+// parse, in which case the error describes the problem.
+func ParseAddressLiteral(s string) (net.IP, bool, error) {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") || len(s) < 2 {
+		return nil, false, nil
+	}
+	content := s[1 : len(s)-1]
+
+	tag, addr, tagged := strings.Cut(content, ":")
+	switch {
+	case !tagged:
+		// IPv4-address-literal
+		ip := net.ParseIP(content)
+		if ip == nil || ip.To4() == nil {
+			return nil, true, fmt.Errorf("invalid IPv4 address literal %q", s)
+		}
+		return ip.To4(), true, nil
+	case strings.EqualFold(tag, "IPv6"):
+		ip := net.ParseIP(addr)
+		if ip == nil || strings.Contains(addr, "%") {
+			return nil, true, fmt.Errorf("invalid IPv6 address literal %q", s)
+		}
+		return ip, true, nil
+	default:
+		// General-address-literal, the address is opaque to us
+		return nil, true, nil
+	}
+}
+
+// CheckHELO checks the HELO/EHLO identity as described in RFC 7208 section
+// 2.3.  check_host() is evaluated with <domain> set to helo and <sender> set
+// to "postmaster@" + helo.  When helo is an address literal there is no domain
+// to check and the result is None with ErrAddressLiteral as the cause.  The
+// result's Scope is ScopeHELO.
+func (c *Checker) CheckHELO(ctx context.Context, ip net.IP, helo string) (CheckHostResult, error) {
+	helo = strings.TrimSpace(helo)
+	if _, literal, err := ParseAddressLiteral(helo); literal {
+		if err != nil {
+			return CheckHostResult{Code: None, Cause: fmt.Errorf("%w: %w", ErrAddressLiteral, err), Scope: ScopeHELO}, nil
+		}
+		return CheckHostResult{Code: None, Cause: ErrAddressLiteral, Scope: ScopeHELO}, nil
+	}
+	return c.checkScope(ctx, ScopeHELO, ip, helo, "postmaster@"+helo, helo)
+}
+
+// Combiner merges the HELO and MAIL FROM results of CheckIdentities into the
+// verdict a receiver acts upon.
+type Combiner func(helo, mailFrom CheckHostResult) Result
+
+// Combiners for CheckIdentities.
+var (
+	// CombineMailFrom uses the MAIL FROM result, as RFC 7208 section 2.4
+	// requires; the HELO result is informational.
+	CombineMailFrom Combiner = func(_, mailFrom CheckHostResult) Result { return mailFrom.Code }
+	// CombineHELOFail lets a HELO Fail decide, the conclusive determination
+	// RFC 7208 section 2.3 allows before MAIL FROM, and otherwise uses the
+	// MAIL FROM result.
+	CombineHELOFail Combiner = func(helo, mailFrom CheckHostResult) Result {
+		if helo.Code == Fail {
+			return Fail
+		}
+		return mailFrom.Code
+	}
+	// CombinePassEither passes when either identity passes, e.g. for
+	// forwarders that rewrite MAIL FROM but keep a known HELO.
+	CombinePassEither Combiner = func(helo, mailFrom CheckHostResult) Result {
+		if helo.Code == Pass {
+			return Pass
+		}
+		return mailFrom.Code
+	}
+)
+
+// IdentitiesResult holds the results of CheckIdentities.
+type IdentitiesResult struct {
+	HELO     CheckHostResult
+	MailFrom CheckHostResult
+	Verdict  Result // the results merged by the Combiner
+	// Additional holds the results of the additional identities, in the
+	// order given; they do not enter the Verdict.
+	Additional []IdentityResult
+}
+
+// Identity is an identity checked by CheckIdentities besides HELO and MAIL
+// FROM, such as the address of the Sender or List-Post header of mailing
+// list traffic, whose MAIL FROM often fails after the list rewrote it.
+type Identity struct {
+	Name    string // label for reports, e.g. "sender" or "list-post"
+	Address string // a mailbox such as "list@lists.example", or a domain
+}
+
+// IdentityResult is the result of one additional identity.
+type IdentityResult struct {
+	Identity Identity
+	Result   CheckHostResult
+}
+
+// CheckIdentities checks the HELO identity and then the MAIL FROM identity
+// as RFC 7208 sections 2.3 and 2.4 recommend and merges their results with
+// combine, CombineMailFrom when nil.  For the null reverse-path the MAIL FROM
+// identity is "postmaster@" + helo, so the HELO result is used for both
+// without a second evaluation.  Errors that come with a result, such as
+// ErrNoDNSrecord for a domain that does not exist, are left in the Cause of
+// that result; context errors and ErrInvalidIP are returned.
+//
+// The additional identities are then checked like a MAIL FROM, a bare domain
+// with the local part "postmaster", and reported without Scope in
+// Additional.  RFC 7208 does not define these checks; their results are
+// only evidence for filters, e.g. a list whose domain authorizes the
+// client.  An address without domain yields None with ErrBadReversePath.
+func (c *Checker) CheckIdentities(ctx context.Context, ip net.IP, helo, mailFrom string, combine Combiner, additional ...Identity) (IdentitiesResult, error) {
+	if combine == nil {
+		combine = CombineMailFrom
+	}
+	var out IdentitiesResult
+	var err error
+	if out.HELO, err = resultOnly(c.CheckHELO(ctx, ip, helo)); err != nil {
+		return IdentitiesResult{}, err
+	}
+	if domain, ok := getSenderDomain(mailFrom); ok {
+		if out.MailFrom, err = resultOnly(c.check(ctx, ip, domain, mailFrom, strings.TrimSpace(helo))); err != nil {
+			return IdentitiesResult{}, err
+		}
+	} else {
+		out.MailFrom = out.HELO
+	}
+	out.Verdict = combine(out.HELO, out.MailFrom)
+
+	for _, id := range additional {
+		res, err := c.checkIdentity(ctx, ip, strings.TrimSpace(helo), id.Address)
+		if err != nil {
+			return IdentitiesResult{}, err
+		}
+		out.Additional = append(out.Additional, IdentityResult{Identity: id, Result: res})
+	}
+
+	return out, nil
+}
+
+// checkIdentity checks an additional identity of CheckIdentities.
+func (c *Checker) checkIdentity(ctx context.Context, ip net.IP, helo, address string) (CheckHostResult, error) {
+	sender := strings.TrimSpace(address)
+	if !strings.Contains(sender, "@") {
+		sender = "postmaster@" + sender
+	}
+	domain, ok := getSenderDomain(sender)
+	if !ok {
+		return CheckHostResult{Code: None, Cause: fmt.Errorf("%w: no domain in %q", ErrBadReversePath, address)}, nil
+	}
+	res, err := resultOnly(c.check(ctx, ip, domain, sender, helo))
+	res.Scope = ""
+
+	return res, err
+}
+
+// resultOnly drops the errors returned together with a result, which is then
+// the answer to the check; errors without a result and context errors are
+// kept.
+func resultOnly(res CheckHostResult, err error) (CheckHostResult, error) {
+	if err != nil && (res.Code == "" || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// ParseReversePath splits the argument of MAIL FROM into its local part and
+// domain following the Reverse-path grammar of RFC 5321 section 4.1.2.
+//
+//	<alice@example.com>              → "alice", "example.com"
+//	<"a@b"@example.com>              → `"a@b"`, "example.com"
+//	<@relay.example:bob@example.org> → "bob", "example.org" (source route dropped)
+//	<>                               → "", "" (null reverse-path)
+//
+// The local part is returned exactly as written, including any quotes, since
+// that is what the "l" macro expands to.  Surrounding angle brackets are
+// optional.  Input without an '@' is returned as a local part with an empty
+// domain.
+func ParseReversePath(path string) (local, domain string, err error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "<")
+	path = strings.TrimSuffix(path, ">")
+	if path == "" {
+		return "", "", nil
+	}
+
+	// obsolete A-d-l source route: "@one,@two:user@domain"
+	if path[0] == '@' && strings.ContainsRune(path, ':') {
+		_, path, _ = strings.Cut(path, ":")
+	}
+
+	at, quoted := -1, false
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && quoted:
+			i++ // quoted-pair
+		case c == '"':
+			quoted = !quoted
+		case c == '@' && !quoted:
+			at = i
+		}
+	}
+	if quoted {
+		return "", "", fmt.Errorf("%w: unterminated quoted local part in %q", ErrBadReversePath, path)
+	}
+	if at < 0 {
+		return path, "", nil
+	}
+
+	return path[:at], path[at+1:], nil
+}
+
+// LocalPartMode controls how a non-ASCII local part from an SMTPUTF8
+// (RFC 6531) MAIL FROM is substituted for the "l" and "s" macros.  RFC 8616
+// section 4 leaves the choice to the verifier; ASCII local parts are never
+// altered.
+type LocalPartMode int
+
+const (
+	// LocalPartUTF8 substitutes the local part as received.  When the
+	// expansion is used as a domain name it is converted to A-labels
+	// together with the rest of the name.
+	LocalPartUTF8 LocalPartMode = iota
+	// LocalPartPunycode converts each dot-separated piece of the local part
+	// to its Punycode A-label form ("xn--...") before substitution.
+	LocalPartPunycode
+	// LocalPartPostmaster substitutes "postmaster", as if the local part
+	// were missing (RFC 7208 section 4.3).
+	LocalPartPostmaster
+)
+
+// mapLocalPart applies mode to a non-ASCII local part.
+func mapLocalPart(local string, mode LocalPartMode) string {
+	if isASCII(local) {
+		return local
+	}
+	switch mode {
+	case LocalPartPunycode:
+		if ascii, err := idna.Punycode.ToASCII(local); err == nil {
+			return ascii
+		}
+		return "postmaster"
+	case LocalPartPostmaster:
+		return "postmaster"
+	default:
+		return local
+	}
+}
+
+// normalizeSenderDomain converts a U-label sender domain to the A-label form
+// used for lookups.  Domains that do not validate are returned unchanged.
+func normalizeSenderDomain(domain string) string {
+	if isASCII(domain) {
+		return strings.ToLower(domain)
+	}
+	if ascii, err := parser.ValidateDomain(domain); err == nil {
+		return ascii
+	}
+
+	return domain
+}
+
+// isASCII reports whether s contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
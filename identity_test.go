@@ -0,0 +1,273 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddressLiteral(t *testing.T) {
+	tc := []struct {
+		in      string
+		literal bool
+		ip      string
+		wantErr bool
+	}{
+		{"[192.0.2.1]", true, "192.0.2.1", false},
+		{"[IPv6:2001:db8::1]", true, "2001:db8::1", false},
+		{"[ipv6:::1]", true, "::1", false},
+		{"[IPv6:::ffff:192.0.2.1]", true, "192.0.2.1", false},
+		{"[x-tag:opaque]", true, "", false},
+		{"[192.0.2]", true, "", true},
+		{"[IPv6:2001:db8::zz]", true, "", true},
+		{"[2001:db8::1]", true, "", false},
+		{"mail.example.com", false, "", false},
+		{"[", false, "", false},
+	}
+
+	for _, c := range tc {
+		t.Run(c.in, func(t *testing.T) {
+			ip, literal, err := ParseAddressLiteral(c.in)
+			assert.Equal(t, c.literal, literal)
+			if c.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if c.ip == "" {
+				assert.Nil(t, ip)
+			} else {
+				assert.True(t, net.ParseIP(c.ip).Equal(ip))
+			}
+		})
+	}
+}
+
+func TestChecker_CheckHELO(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"mail.example.com": {"v=spf1 a exists:%{h}.%{l}.helo.example.com -all"},
+		},
+		ip: map[string][]string{
+			"mail.example.com": {"192.0.2.1"},
+			"mail.example.com.postmaster.helo.example.com": {"127.0.0.2"},
+		},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+
+	for _, helo := range []string{"[192.0.2.1]", "[IPv6:::1]", "[bogus]", "[x:y]"} {
+		res, err := ch.CheckHELO(context.Background(), net.ParseIP("192.0.2.1"), helo)
+		require.NoError(t, err, helo)
+		assert.Equal(t, None, res.Code, helo)
+		require.ErrorIs(t, res.Cause, ErrAddressLiteral, helo)
+	}
+
+	res, err := ch.CheckHELO(context.Background(), net.ParseIP("192.0.2.1"), "mail.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	res, err = ch.CheckHELO(context.Background(), net.ParseIP("198.51.100.1"), "mail.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code, "matched through %{h} and postmaster local part")
+
+	res, err = ch.CheckHELO(context.Background(), net.ParseIP("198.51.100.1"), "MAIL.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+}
+
+func TestChecker_CheckIdentities(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"mx.relay.example": {"v=spf1 a -all"},
+			"corp.example":     {"v=spf1 ip4:198.51.100.0/24 -all"},
+		},
+		ip: map[string][]string{"mx.relay.example": {"192.0.2.1"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ctx := context.Background()
+	relay, other := net.ParseIP("192.0.2.1"), net.ParseIP("203.0.113.9")
+
+	res, err := ch.CheckIdentities(ctx, relay, "mx.relay.example", "alice@corp.example", nil)
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.HELO.Code)
+	assert.Equal(t, Fail, res.MailFrom.Code)
+	assert.Equal(t, Fail, res.Verdict)
+
+	res, err = ch.CheckIdentities(ctx, relay, "mx.relay.example", "alice@corp.example", CombinePassEither)
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Verdict)
+
+	res, err = ch.CheckIdentities(ctx, other, "mx.relay.example", "<alice@missing.example>", CombineHELOFail)
+	require.NoError(t, err)
+	assert.Equal(t, None, res.MailFrom.Code)
+	require.ErrorIs(t, res.MailFrom.Cause, ErrNoDNSrecord)
+	assert.Equal(t, Fail, res.Verdict)
+
+	// the null reverse-path uses the HELO result
+	res, err = ch.CheckIdentities(ctx, relay, "mx.relay.example", "<>", nil)
+	require.NoError(t, err)
+	assert.Equal(t, res.HELO, res.MailFrom)
+	assert.Equal(t, Pass, res.Verdict)
+
+	_, err = ch.CheckIdentities(ctx, nil, "mx.relay.example", "alice@corp.example", nil)
+	require.ErrorIs(t, err, ErrInvalidIP)
+}
+
+func TestChecker_CheckIdentities_Additional(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"mx.lists.example": {"v=spf1 a -all"},
+			"lists.example":    {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"corp.example":     {"v=spf1 ip4:198.51.100.0/24 -all"},
+		},
+		ip: map[string][]string{"mx.lists.example": {"192.0.2.1"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ctx := context.Background()
+	list := net.ParseIP("192.0.2.1")
+
+	res, err := ch.CheckIdentities(ctx, list, "mx.lists.example", "alice@corp.example", nil,
+		Identity{Name: "sender", Address: "dev-owner@lists.example"},
+		Identity{Name: "list-post", Address: "lists.example"},
+		Identity{Name: "from", Address: "alice@corp.example"},
+		Identity{Name: "broken", Address: "nobody@"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Verdict, "additional identities are evidence only")
+	require.Len(t, res.Additional, 4)
+	assert.Equal(t, "sender", res.Additional[0].Identity.Name)
+	assert.Equal(t, Pass, res.Additional[0].Result.Code)
+	assert.Equal(t, "lists.example", res.Additional[0].Result.Domain)
+	assert.Empty(t, res.Additional[0].Result.Scope)
+	assert.Equal(t, Pass, res.Additional[1].Result.Code)
+	assert.Equal(t, Fail, res.Additional[2].Result.Code)
+	assert.Equal(t, None, res.Additional[3].Result.Code)
+	require.ErrorIs(t, res.Additional[3].Result.Cause, ErrBadReversePath)
+
+	res, err = ch.CheckIdentities(ctx, list, "mx.lists.example", "alice@corp.example", nil)
+	require.NoError(t, err)
+	assert.Nil(t, res.Additional)
+}
+
+func TestCheckHostResult_Identity(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"mx.relay.example": {"v=spf1 a -all"},
+			"corp.example":     {"v=spf1 ip4:198.51.100.0/24 -all"},
+		},
+		ip: map[string][]string{"mx.relay.example": {"192.0.2.1"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithPolicy(StrictPolicy))
+	ctx := context.Background()
+
+	res, err := ch.CheckIdentities(ctx, net.ParseIP("192.0.2.1"), "mx.relay.example", "alice@corp.example", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "mx.relay.example", res.HELO.Domain)
+	assert.Equal(t, ScopeHELO, res.HELO.Scope)
+	assert.Equal(t, "corp.example", res.MailFrom.Domain)
+	assert.Equal(t, ScopeMailFrom, res.MailFrom.Scope)
+
+	helo, err := ch.CheckHELO(ctx, net.ParseIP("192.0.2.1"), "[192.0.2.1]")
+	require.NoError(t, err)
+	assert.Equal(t, ScopeHELO, helo.Scope)
+	assert.Empty(t, helo.Domain)
+
+	// a domain unrelated to the sender has no scope
+	mfrom, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "corp.example", "bob@other.example")
+	require.NoError(t, err)
+	assert.Equal(t, "corp.example", mfrom.Domain)
+	assert.Empty(t, mfrom.Scope)
+
+	mfrom, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "bücher.example", "info@bücher.example")
+	require.ErrorIs(t, err, ErrNoDNSrecord)
+	assert.Equal(t, "xn--bcher-kva.example", mfrom.Domain)
+	assert.Equal(t, ScopeMailFrom, mfrom.Scope)
+}
+
+func TestParseReversePath(t *testing.T) {
+	tc := []struct {
+		in, local, domain string
+		wantErr           bool
+	}{
+		{"alice@example.com", "alice", "example.com", false},
+		{"<alice@example.com>", "alice", "example.com", false},
+		{`<"a@b"@example.com>`, `"a@b"`, "example.com", false},
+		{`"a\"@b"@example.com`, `"a\"@b"`, "example.com", false},
+		{"<@relay.example,@hop.example:bob@example.org>", "bob", "example.org", false},
+		{"<>", "", "", false},
+		{"", "", "", false},
+		{"@example.com", "", "example.com", false},
+		{"postmaster", "postmaster", "", false},
+		{`<"unterminated@example.com>`, "", "", true},
+	}
+
+	for _, c := range tc {
+		t.Run(c.in, func(t *testing.T) {
+			local, domain, err := ParseReversePath(c.in)
+			if c.wantErr {
+				require.ErrorIs(t, err, ErrBadReversePath)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.local, local)
+			assert.Equal(t, c.domain, domain)
+		})
+	}
+}
+
+func TestChecker_QuotedLocalPartMacros(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com": {"v=spf1 exists:%{l}.%{o}.users.example.net -all"},
+		},
+		ip: map[string][]string{
+			"bob.example.org.users.example.net": {"127.0.0.2"},
+		},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "<@relay.example:bob@example.org>")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+}
+
+func TestChecker_SMTPUTF8Sender(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"xn--bcher-kva.example": {"v=spf1 exists:%{l}.%{o}.users.example.net -all"},
+		},
+		ip: map[string][]string{
+			"xn--jrg-sna.xn--bcher-kva.example.users.example.net": {"127.0.0.2"},
+			"postmaster.xn--bcher-kva.example.users.example.net":  {"127.0.0.2"},
+		},
+	}
+
+	cases := []struct {
+		name string
+		mode LocalPartMode
+		want Result
+	}{
+		{"utf8 converted with the name", LocalPartUTF8, Pass},
+		{"punycode", LocalPartPunycode, Pass},
+		{"postmaster", LocalPartPostmaster, Pass},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := NewChecker(NewCustomDNSResolver(zone), WithLocalPartMode(tc.mode))
+			res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "bücher.example", "jörg@bücher.example")
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, res.Code)
+		})
+	}
+}
+
+func TestMapLocalPart(t *testing.T) {
+	assert.Equal(t, "alice", mapLocalPart("alice", LocalPartPostmaster))
+	assert.Equal(t, "jörg", mapLocalPart("jörg", LocalPartUTF8))
+	assert.Equal(t, "xn--jrg-sna.xn--mller-kva", mapLocalPart("jörg.müller", LocalPartPunycode))
+	assert.Equal(t, "postmaster", mapLocalPart("jörg", LocalPartPostmaster))
+}
@@ -0,0 +1,76 @@
+// Package parquet checks the Parquet files of ParquetScanWriter with
+// parquet-go, an independent implementation of the format.  It is a module
+// of its own so that the spf module does not depend on parquet-go.
+package parquet
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scanRow is a row of ParquetScanWriter.
+type scanRow struct {
+	Domain        string  `parquet:"domain"`
+	Record        *string `parquet:"record,optional"`
+	Lookups       *int32  `parquet:"lookups,optional"`
+	All           *string `parquet:"all,optional"`
+	ErrorCategory *string `parquet:"error_category,optional"`
+	Error         *string `parquet:"error,optional"`
+	Attempts      int32   `parquet:"attempts"`
+	DurationMS    int64   `parquet:"duration_ms"`
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// TestGolden reads the golden file of the spf module, written by
+// ParquetScanWriter with two rows per row group.
+func TestGolden(t *testing.T) {
+	const path = "../../testdata/scan.parquet"
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	file, err := parquet.OpenFile(f, info.Size())
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), file.NumRows())
+	assert.Len(t, file.RowGroups(), 3)
+
+	schema := file.Schema().String()
+	for _, column := range []string{
+		"required binary domain (STRING)",
+		"optional binary record (STRING)",
+		"optional int32 lookups",
+		"required int64 duration_ms",
+	} {
+		assert.True(t, strings.Contains(schema, column), "%s in %s", column, schema)
+	}
+
+	rows, err := parquet.ReadFile[scanRow](path)
+	require.NoError(t, err)
+	require.Len(t, rows, 5)
+	assert.Equal(t, scanRow{
+		Domain:     "example.com",
+		Record:     ptr("v=spf1 include:_spf.example.com ~all"),
+		Lookups:    ptr[int32](1),
+		All:        ptr("~all"),
+		Attempts:   1,
+		DurationMS: 1,
+	}, rows[0])
+	assert.Equal(t, "_spf.example.com", rows[1].Domain)
+	assert.Nil(t, rows[1].Lookups)
+	assert.Equal(t, ptr("syntax"), rows[1].ErrorCategory)
+	assert.Equal(t, ptr[int32](2), rows[2].Lookups)
+	assert.Equal(t, ptr("multiple records"), rows[3].ErrorCategory)
+	assert.Equal(t, scanRow{
+		Domain:        "bad..example",
+		ErrorCategory: ptr("invalid domain"),
+		Error:         ptr("domain has empty label"),
+		DurationMS:    1,
+	}, rows[4])
+}
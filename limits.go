@@ -0,0 +1,57 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// unlimitedLookups bounds the DNS lookups of an evaluation without limits,
+// which would otherwise never end for records including each other.
+const unlimitedLookups = 100
+
+// LimitReport holds the results of CheckLimits.
+type LimitReport struct {
+	// Enforced is the result of CheckHost, with the lookup limits of RFC
+	// 7208 section 4.6.4.
+	Enforced CheckHostResult
+	// Unlimited is the result the evaluation would have without the DNS
+	// and void lookup limits.  It equals Enforced unless a limit was hit.
+	Unlimited CheckHostResult
+}
+
+// LimitInduced reports whether the PermError of Enforced is caused only by
+// the lookup limits: without them the evaluation gives another result.
+func (r LimitReport) LimitInduced() bool {
+	return r.Enforced.Code != r.Unlimited.Code && limitError(r.Enforced.Cause)
+}
+
+// CheckLimits is a diagnostic variant of CheckHost evaluating the policy of
+// domain with and without the DNS and void lookup limits, so that operators
+// see at once when a PermError is purely limit-induced.  The second
+// evaluation is only performed when the first hits a limit; it still stops
+// after 100 lookups to end include loops.  As with CheckIdentities, errors
+// that come with a result are left in its Cause.
+func (c *Checker) CheckLimits(ctx context.Context, ip net.IP, domain, sender string) (LimitReport, error) {
+	res, err := resultOnly(c.CheckHost(ctx, ip, domain, sender))
+	if err != nil {
+		return LimitReport{}, err
+	}
+	report := LimitReport{Enforced: res, Unlimited: res}
+	if !limitError(res.Cause) {
+		return report, nil
+	}
+
+	e := c.newEvaluation(ip, sender)
+	e.unlimited = true
+	if report.Unlimited, err = resultOnly(c.run(ctx, e, domain)); err != nil {
+		return LimitReport{}, err
+	}
+	report.Unlimited.Scope = res.Scope
+
+	return report, nil
+}
+
+func limitError(err error) bool {
+	return errors.Is(err, ErrTooManyLookups) || errors.Is(err, ErrTooManyVoidLookups)
+}
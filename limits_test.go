@@ -0,0 +1,52 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker_CheckLimits(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"small.example.com": {"v=spf1 include:v0.example.com -all"},
+			"large.example.com": {"v=spf1 include:v0.example.com include:v1.example.com include:v2.example.com include:v3.example.com include:v4.example.com include:v5.example.com -all"},
+			"void.example.com":  {"v=spf1 a:n1.example.com a:n2.example.com a:n3.example.com ip4:192.0.2.0/24 -all"},
+			"loop.example.com":  {"v=spf1 include:loop.example.com -all"},
+		},
+	}
+	for i := range 6 {
+		zone.txt[fmt.Sprintf("v%d.example.com", i)] = []string{fmt.Sprintf("v=spf1 a:m%d.example.com mx:x%d.example.com ip4:198.51.100.%d", i, i, i)}
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ctx := context.Background()
+
+	tests := []struct {
+		domain    string
+		ip        string
+		enforced  Result
+		unlimited Result
+		induced   bool
+	}{
+		{"small.example.com", "198.51.100.0", Pass, Pass, false},
+		{"large.example.com", "198.51.100.5", PermError, Pass, true},
+		{"large.example.com", "203.0.113.1", PermError, Fail, true},
+		{"void.example.com", "192.0.2.1", PermError, Pass, true},
+		{"loop.example.com", "192.0.2.1", PermError, PermError, false},
+	}
+	for _, tt := range tests {
+		report, err := ch.CheckLimits(ctx, net.ParseIP(tt.ip), tt.domain, "")
+		require.NoError(t, err, tt.domain)
+		assert.Equal(t, tt.enforced, report.Enforced.Code, tt.domain)
+		assert.Equal(t, tt.unlimited, report.Unlimited.Code, tt.domain)
+		assert.Equal(t, tt.induced, report.LimitInduced(), tt.domain)
+	}
+
+	report, err := ch.CheckLimits(ctx, net.ParseIP("192.0.2.1"), "loop.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, unlimitedLookups+1, report.Unlimited.Stats.Lookups)
+}
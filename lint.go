@@ -0,0 +1,315 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// Severity ranks lint warnings.
+type Severity int
+
+// Severities, from least to most serious.
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError // every evaluation reaching the term fails
+)
+
+var severityNames = []string{"info", "warning", "error"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return "unknown"
+	}
+
+	return severityNames[s]
+}
+
+// MarshalText encodes s as its name, e.g. "warning".
+func (s Severity) MarshalText() ([]byte, error) {
+	if s < 0 || int(s) >= len(severityNames) {
+		return nil, fmt.Errorf("invalid severity %d", int(s))
+	}
+
+	return []byte(severityNames[s]), nil
+}
+
+// UnmarshalText decodes the name of a severity.
+func (s *Severity) UnmarshalText(text []byte) error {
+	i := slices.Index(severityNames, string(text))
+	if i < 0 {
+		return fmt.Errorf("unknown severity %q", text)
+	}
+	*s = Severity(i)
+
+	return nil
+}
+
+// Warning describes a construct in an SPF record that is valid according to
+// RFC 7208 but most likely not what the publisher intended.  Its JSON form is
+// part of the stable schema of WriteLintJSON.
+type Warning struct {
+	Rule     string   `json:"rule,omitempty"` // ID of the LintRule, "" for warnings raised outside a Linter
+	Severity Severity `json:"severity"`
+	Term     string   `json:"term,omitempty"` // offending term, "" when the warning is about the record
+	Message  string   `json:"message"`
+}
+
+// LintRule is one lint check.  Record checks a single parsed record without
+// DNS lookups and Graph checks a resolved tree; a rule sets either or both.
+// The Rule and Severity of the returned warnings are filled in by the Linter.
+type LintRule struct {
+	ID          string
+	Severity    Severity
+	Description string // one sentence, for reports such as SARIF
+	Record      func(rec *parser.Record) []Warning
+	Graph       func(g *Graph) []Warning
+}
+
+// Linter runs a configurable set of lint rules:
+//
+//	l := spf.NewLinter()
+//	l.Disabled["zero-cidr"] = true
+//	l.Severity["redirect-with-all"] = spf.SeverityError
+//	l.Rules = append(l.Rules, myRule)
+type Linter struct {
+	Rules    []LintRule
+	Disabled map[string]bool     // IDs of rules that are not run
+	Severity map[string]Severity // severities overriding those of the rules
+}
+
+// NewLinter returns a Linter running rules, or DefaultLintRules when none
+// are given.
+func NewLinter(rules ...LintRule) *Linter {
+	if len(rules) == 0 {
+		rules = DefaultLintRules()
+	}
+
+	return &Linter{Rules: rules, Disabled: make(map[string]bool), Severity: make(map[string]Severity)}
+}
+
+// DefaultLintRules returns the rules run by Lint and LintGraph.
+func DefaultLintRules() []LintRule {
+	return []LintRule{
+		RedirectWithAllRule(),
+		ZeroCIDRRule(),
+		TargetMissingRule(),
+		IncludeNeverMatchesRule(),
+	}
+}
+
+// Lint runs the enabled record rules of l against rec.
+func (l *Linter) Lint(rec *parser.Record) []Warning {
+	var warnings []Warning
+	for _, rule := range l.Rules {
+		if rule.Record != nil && !l.Disabled[rule.ID] {
+			warnings = append(warnings, l.label(rule, rule.Record(rec))...)
+		}
+	}
+
+	return warnings
+}
+
+// LintGraph runs the enabled graph rules of l against g.
+func (l *Linter) LintGraph(g *Graph) []Warning {
+	var warnings []Warning
+	for _, rule := range l.Rules {
+		if rule.Graph != nil && !l.Disabled[rule.ID] {
+			warnings = append(warnings, l.label(rule, rule.Graph(g))...)
+		}
+	}
+
+	return warnings
+}
+
+// label sets the rule ID and severity of the warnings of rule.
+func (l *Linter) label(rule LintRule, warnings []Warning) []Warning {
+	severity := rule.Severity
+	if s, ok := l.Severity[rule.ID]; ok {
+		severity = s
+	}
+	for i := range warnings {
+		warnings[i].Rule, warnings[i].Severity = rule.ID, severity
+	}
+
+	return warnings
+}
+
+// Lint inspects a parsed record and returns warnings about suspicious but
+// syntactically valid constructs, running the record rules of
+// DefaultLintRules.  It performs no DNS lookups.
+func Lint(rec *parser.Record) []Warning {
+	return NewLinter().Lint(rec)
+}
+
+// LintGraph returns warnings about the include and redirect targets of g,
+// running the graph rules of DefaultLintRules.
+func LintGraph(g *Graph) []Warning {
+	return NewLinter().LintGraph(g)
+}
+
+// RedirectWithAllRule reports a redirect next to an all mechanism, which is
+// ignored (RFC 7208 section 6.1).
+func RedirectWithAllRule() LintRule {
+	return LintRule{
+		ID:          "redirect-with-all",
+		Severity:    SeverityWarning,
+		Description: "A redirect modifier next to an all mechanism is ignored (RFC 7208 section 6.1).",
+		Record: func(rec *parser.Record) []Warning {
+			if rec.Redirect == nil {
+				return nil
+			}
+			for _, m := range rec.Mechs {
+				if m.Kind == "all" {
+					return []Warning{{
+						Term: "redirect=" + rec.Redirect.Value,
+						Message: "redirect is ignored because the record contains " + m.String() +
+							" (RFC 7208 section 6.1); remove " + m.String() + " to use the redirect target's policy",
+					}}
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// ZeroCIDRRule reports a zero prefix length, which matches every address of
+// the family (RFC 7208 section 5.6).
+func ZeroCIDRRule() LintRule {
+	return LintRule{
+		ID:          "zero-cidr",
+		Severity:    SeverityWarning,
+		Description: "A /0 prefix length matches every address of the family (RFC 7208 section 5.6).",
+		Record: func(rec *parser.Record) []Warning {
+			var warnings []Warning
+			for _, m := range rec.Mechs {
+				v4, v6 := m.ZeroCIDR()
+				family := "IPv4"
+				switch {
+				case v4 && v6:
+					family = "IPv4 and IPv6"
+				case v6:
+					family = "IPv6"
+				case !v4:
+					continue
+				}
+				warnings = append(warnings, Warning{
+					Term:    m.String(),
+					Message: "the /0 prefix length matches every " + family + " address",
+				})
+			}
+			return warnings
+		},
+	}
+}
+
+// TargetMissingRule reports include and redirect targets without an SPF
+// record, with several or with an invalid one, which make every evaluation
+// reaching them a permerror (RFC 7208 sections 5.2 and 6.1).
+func TargetMissingRule() LintRule {
+	rule := edgeRule("target-missing", SeverityError, func(g *Graph, edge Edge) string {
+		node := g.Nodes[edge.Target]
+		switch {
+		case node == nil:
+			return ""
+		case errors.Is(node.Err, ErrMissingRecord), errors.Is(node.Err, ErrNoDNSrecord):
+			return edge.Target + " has no SPF record, so evaluating the " + edge.Kind + " is a permerror"
+		case errors.Is(node.Err, ErrMultipleSPF):
+			msg := edge.Target + " publishes several SPF records, so evaluating the " + edge.Kind + " is a permerror"
+			var multi *MultipleSPFError
+			if errors.As(node.Err, &multi) {
+				msg = fmt.Sprintf("%s publishes %d SPF records, so evaluating the %s is a permerror; keep only one of %q",
+					edge.Target, len(multi.Records), edge.Kind, multi.Records)
+			}
+			return msg
+		case node.Err != nil && node.Record == nil && node.Raw != "":
+			return edge.Target + " has an invalid SPF record, so evaluating the " + edge.Kind + " is a permerror: " + node.Err.Error()
+		}
+		return "" // DNS failures are transient
+	})
+	rule.Description = "An include or redirect target without a single valid SPF record makes evaluation a permerror."
+
+	return rule
+}
+
+// IncludeNeverMatchesRule reports included records that authorize no host,
+// so that the include never matches.
+func IncludeNeverMatchesRule() LintRule {
+	rule := edgeRule("include-never-matches", SeverityWarning, func(g *Graph, edge Edge) string {
+		node := g.Nodes[edge.Target]
+		if edge.Kind != "include" || node == nil || node.Err != nil || g.authorizes(edge.Target, map[string]bool{}) {
+			return ""
+		}
+		return edge.Target + " authorizes no host, so the include never matches"
+	})
+	rule.Description = "An included record that authorizes no host never matches."
+
+	return rule
+}
+
+// edgeRule builds a LintRule checking the include and redirect references of
+// a graph.  Each warning names the branch from Root to the reference; a
+// target reached along several branches is checked for the first.
+func edgeRule(id string, severity Severity, check func(g *Graph, edge Edge) string) LintRule {
+	return LintRule{
+		ID:       id,
+		Severity: severity,
+		Graph: func(g *Graph) []Warning {
+			var warnings []Warning
+			seen := map[string]bool{g.Root: true}
+			var walk func(path []string)
+			walk = func(path []string) {
+				node, ok := g.Nodes[path[len(path)-1]]
+				if !ok {
+					return
+				}
+				for _, edge := range node.Edges {
+					if edge.Macro || seen[edge.Target] {
+						continue
+					}
+					seen[edge.Target] = true
+					if msg := check(g, edge); msg != "" {
+						warnings = append(warnings, Warning{
+							Term:    edge.term(),
+							Message: msg + " (via " + strings.Join(path, " > ") + ")",
+						})
+					}
+					walk(append(path[:len(path):len(path)], edge.Target))
+				}
+			}
+			walk([]string{g.Root})
+			return warnings
+		},
+	}
+}
+
+// authorizes reports whether the record of domain can evaluate to pass: it
+// has a "+" mechanism or redirects to a record that authorizes a host.
+// Records that are missing, invalid or unknown are given the benefit of the
+// doubt, as they are reported on their own.
+func (g *Graph) authorizes(domain string, seen map[string]bool) bool {
+	if seen[domain] {
+		return false // a redirect loop
+	}
+	seen[domain] = true
+	node, ok := g.Nodes[domain]
+	if !ok || node.Record == nil {
+		return true
+	}
+	for _, m := range node.Record.Mechs {
+		if m.Qual == parser.QPlus {
+			return true
+		}
+	}
+	for _, edge := range node.Edges {
+		if edge.Kind == "redirect" {
+			return edge.Macro || g.authorizes(edge.Target, seen)
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,134 @@
+package spf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mailspire/spf/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint_RedirectWithAll(t *testing.T) {
+	cases := []struct {
+		name   string
+		record string
+		want   int
+	}{
+		{"redirect and all", "v=spf1 ip4:192.0.2.0/24 ~all redirect=_spf.example.com", 1},
+		{"redirect only", "v=spf1 ip4:192.0.2.0/24 redirect=_spf.example.com", 0},
+		{"all only", "v=spf1 ip4:192.0.2.0/24 -all", 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, err := parser.Parse(tc.record)
+			require.NoError(t, err)
+			assert.Len(t, Lint(rec), tc.want)
+		})
+	}
+
+	rec, err := parser.Parse("v=spf1 redirect=_spf.example.com ~all")
+	require.NoError(t, err)
+	assert.Equal(t, []Warning{{
+		Rule:     "redirect-with-all",
+		Severity: SeverityWarning,
+		Term:     "redirect=_spf.example.com",
+		Message:  "redirect is ignored because the record contains ~all (RFC 7208 section 6.1); remove ~all to use the redirect target's policy",
+	}}, Lint(rec))
+}
+
+func TestLint_ZeroCIDR(t *testing.T) {
+	cases := []struct {
+		record string
+		want   []Warning
+	}{
+		{"v=spf1 ip4:192.0.2.0/24 -all", nil},
+		{"v=spf1 ip4:192.0.2.1/0 -all", []Warning{{Rule: "zero-cidr", Severity: SeverityWarning, Term: "ip4:0.0.0.0/0", Message: "the /0 prefix length matches every IPv4 address"}}},
+		{"v=spf1 -ip6:2001:db8::/0 mx/0//0", []Warning{
+			{Rule: "zero-cidr", Severity: SeverityWarning, Term: "-ip6:::/0", Message: "the /0 prefix length matches every IPv6 address"},
+			{Rule: "zero-cidr", Severity: SeverityWarning, Term: "mx/0//0", Message: "the /0 prefix length matches every IPv4 and IPv6 address"},
+		}},
+	}
+	for _, tc := range cases {
+		rec, err := parser.Parse(tc.record)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, Lint(rec), tc.record)
+	}
+}
+
+func TestLintGraph(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":          {"v=spf1 include:_spf.example.com include:gone.example.net include:%{d}.example.net -all"},
+		"_spf.example.com":     {"v=spf1 include:deny.example.net include:gone.example.net redirect=bad.example.net"},
+		"deny.example.net":     {"v=spf1 -all"},
+		"bad.example.net":      {"v=spf1 bogus"},
+		"empty.example.net":    {"google-site-verification=abc"},
+		"twice.example.net":    {"v=spf1 -all", "v=spf1 +all"},
+		"fine.example.org":     {"v=spf1 include:empty.example.net redirect=twice.example.net"},
+		"redirected.example":   {"v=spf1 redirect=deny.example.net"},
+		"passing.example.net":  {"v=spf1 redirect=deny.example.net"},
+		"indirect.example.org": {"v=spf1 include:passing.example.net -all"},
+	}}
+	lint := func(domain string) []Warning {
+		g, err := BuildGraph(context.Background(), zone, domain)
+		require.NoError(t, err)
+		return LintGraph(g)
+	}
+
+	warnings := lint("example.com")
+	require.Len(t, warnings, 3)
+	assert.Equal(t, Warning{
+		Rule:     "target-missing",
+		Severity: SeverityError,
+		Term:     "include:gone.example.net",
+		Message:  "gone.example.net has no SPF record, so evaluating the include is a permerror (via example.com > _spf.example.com)",
+	}, warnings[0])
+	assert.Equal(t, "redirect=bad.example.net", warnings[1].Term)
+	assert.Contains(t, warnings[1].Message, "has an invalid SPF record")
+	assert.Equal(t, Warning{
+		Rule:     "include-never-matches",
+		Severity: SeverityWarning,
+		Term:     "include:deny.example.net",
+		Message:  "deny.example.net authorizes no host, so the include never matches (via example.com > _spf.example.com)",
+	}, warnings[2])
+
+	warnings = lint("fine.example.org")
+	require.Len(t, warnings, 2)
+	assert.Contains(t, warnings[0].Message, "empty.example.net has no SPF record")
+	assert.Contains(t, warnings[1].Message, "publishes 2 SPF records")
+
+	assert.Empty(t, lint("redirected.example"), "a redirect may end in -all")
+	assert.Len(t, lint("indirect.example.org"), 1, "redirect=deny.example.net is the include's only policy")
+}
+
+func TestLinter(t *testing.T) {
+	rec, err := parser.Parse("v=spf1 ip4:192.0.2.0/0 ~all redirect=_spf.example.com")
+	require.NoError(t, err)
+
+	l := NewLinter()
+	l.Disabled["zero-cidr"] = true
+	l.Severity["redirect-with-all"] = SeverityError
+	l.Rules = append(l.Rules, LintRule{
+		ID:       "soft-fail",
+		Severity: SeverityInfo,
+		Record: func(rec *parser.Record) []Warning {
+			for _, m := range rec.Mechs {
+				if m.Kind == "all" && m.Qual == parser.QTilde {
+					return []Warning{{Term: m.String(), Message: "prefer -all once the policy is complete"}}
+				}
+			}
+			return nil
+		},
+	})
+
+	warnings := l.Lint(rec)
+	require.Len(t, warnings, 2)
+	assert.Equal(t, "redirect-with-all", warnings[0].Rule)
+	assert.Equal(t, SeverityError, warnings[0].Severity)
+	assert.Equal(t, Warning{Rule: "soft-fail", Severity: SeverityInfo, Term: "~all", Message: "prefer -all once the policy is complete"}, warnings[1])
+
+	assert.Len(t, Lint(rec), 2, "the default rules are unaffected")
+	assert.Empty(t, NewLinter(ZeroCIDRRule()).LintGraph(&Graph{Root: "example.com"}))
+	assert.Equal(t, "warning", SeverityWarning.String())
+}
@@ -0,0 +1,201 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Errors of the local policy.  ErrTrustedForwarder and ErrLocalPolicy are
+// the Cause of a Pass granted by the LocalPolicy rather than by the record
+// of the domain.
+var (
+	ErrInvalidLocalPolicy = errors.New("invalid local policy")
+	ErrTrustedForwarder   = errors.New("client is a trusted forwarder")
+	ErrLocalPolicy        = errors.New("passed by local policy")
+)
+
+// LocalPolicy holds the exceptions a site makes to the published records.
+// Unlike the other settings of a Checker it can be replaced at runtime with
+// SetLocalPolicy or WatchLocalPolicy, so that operators push exceptions
+// without restarting the MTA.  Every evaluation uses a single LocalPolicy
+// from start to end.
+type LocalPolicy struct {
+	// Overrides are the records of WithRecordOverrides by domain.
+	Overrides map[string]string `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+	// TrustedForwarders are the networks of hosts relaying mail on behalf
+	// of others, such as secondary MXs or mailing lists.  Their clients
+	// Pass without evaluation, with ErrTrustedForwarder as Cause and the
+	// network in Bypass.
+	TrustedForwarders []netip.Prefix `json:"trusted_forwarders,omitempty" yaml:"trusted_forwarders,omitempty"`
+	// Mechanisms, e.g. "ip4:10.0.0.0/8 include:_relays.example.net", are
+	// evaluated for the queried domain when its record gives Fail, SoftFail
+	// or Neutral, like the local policy of libspf2 inserted before the final
+	// "all".  A Pass replaces the result, with ErrLocalPolicy as Cause;
+	// anything else leaves it unchanged.  Their lookups count against the
+	// limits of the evaluation.
+	Mechanisms string `json:"mechanisms,omitempty" yaml:"mechanisms,omitempty"`
+}
+
+// localPolicy is a LocalPolicy prepared for evaluations.
+type localPolicy struct {
+	LocalPolicy
+	overrides map[string]string // by overrideKey
+	record    string            // Mechanisms as a record, "" without
+}
+
+// LoadLocalPolicy reads a LocalPolicy from YAML, or JSON:
+//
+//	overrides:
+//	  partner.example: "v=spf1 include:_spf.partner.example ~all"
+//	trusted_forwarders: [192.0.2.0/24, "2001:db8::/32"]
+//	mechanisms: "include:_relays.example.net"
+func LoadLocalPolicy(r io.Reader) (LocalPolicy, error) {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	var p LocalPolicy
+	if err := dec.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return LocalPolicy{}, fmt.Errorf("%w: %w", ErrInvalidLocalPolicy, err)
+	}
+
+	return p, nil
+}
+
+// LoadLocalPolicyFile is LoadLocalPolicy reading the file at path.
+func LoadLocalPolicyFile(path string) (LocalPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return LocalPolicy{}, err
+	}
+	p, err := LoadLocalPolicy(bytes.NewReader(data))
+	if err != nil {
+		return LocalPolicy{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return p, nil
+}
+
+// WithLocalPolicy makes p the initial LocalPolicy of the Checker, replacing
+// the overrides of an earlier WithRecordOverrides.  Unlike SetLocalPolicy
+// it cannot report Mechanisms that do not parse, which then never pass.
+func WithLocalPolicy(p LocalPolicy) Option {
+	return func(c *Checker) { c.local.Store(newLocalPolicy(p)) }
+}
+
+// SetLocalPolicy atomically replaces the LocalPolicy of c.  Evaluations in
+// progress finish with the previous one.  It returns an error matching
+// ErrInvalidLocalPolicy, and keeps the current policy, when the Mechanisms
+// of p do not parse or contain modifiers.
+func (c *Checker) SetLocalPolicy(p LocalPolicy) error {
+	lp := newLocalPolicy(p)
+	if lp.record != "" {
+		rec, err := c.parse(lp.record)
+		switch {
+		case err != nil:
+			return fmt.Errorf("%w: mechanisms: %w", ErrInvalidLocalPolicy, err)
+		case rec.Redirect != nil || rec.Exp != nil || len(rec.Unknown) > 0:
+			return fmt.Errorf("%w: mechanisms: modifiers are not allowed", ErrInvalidLocalPolicy)
+		}
+	}
+	c.local.Store(lp)
+
+	return nil
+}
+
+// LocalPolicy returns the current LocalPolicy of c.
+func (c *Checker) LocalPolicy() LocalPolicy {
+	if lp := c.local.Load(); lp != nil {
+		return lp.LocalPolicy
+	}
+
+	return LocalPolicy{}
+}
+
+// WatchLocalPolicy loads the LocalPolicy of the file at path into c, then
+// checks the file every interval and loads it again whenever its content
+// changed, until ctx is done.  A file that cannot be read or is invalid
+// leaves the current policy in place and is reported to onError, which may
+// be nil.  It returns the context error.
+func (c *Checker) WatchLocalPolicy(ctx context.Context, path string, interval time.Duration, onError func(error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil && (last == nil || !bytes.Equal(data, last)) {
+			err = c.loadLocalPolicy(data)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", path, err)
+			}
+			last = data
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// loadLocalPolicy decodes data and installs it with SetLocalPolicy.
+func (c *Checker) loadLocalPolicy(data []byte) error {
+	p, err := LoadLocalPolicy(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	return c.SetLocalPolicy(p)
+}
+
+// newLocalPolicy prepares p for evaluations.
+func newLocalPolicy(p LocalPolicy) *localPolicy {
+	lp := &localPolicy{LocalPolicy: p, overrides: make(map[string]string, len(p.Overrides))}
+	for domain, text := range p.Overrides {
+		lp.overrides[overrideKey(domain)] = text
+	}
+	if mechs := strings.TrimSpace(p.Mechanisms); mechs != "" {
+		lp.record = "v=spf1 " + mechs
+	}
+
+	return lp
+}
+
+// applyLocalMechanisms evaluates the Mechanisms of the local policy for
+// domain when res, the result of its record, is negative or neutral, and
+// returns the Pass they give instead.
+func (e *evaluation) applyLocalMechanisms(ctx context.Context, domain string, res CheckHostResult) (CheckHostResult, error) {
+	if e.local == nil || e.local.record == "" {
+		return res, nil
+	}
+	switch res.Code {
+	case Fail, SoftFail, Neutral:
+	default:
+		return res, nil
+	}
+
+	matched, chain := e.matched, e.chain
+	local, err := e.evaluate(ctx, domain, e.local.record)
+	if err != nil {
+		return CheckHostResult{}, err
+	}
+	if local.Code != Pass {
+		e.matched, e.chain = matched, chain
+		return res, nil
+	}
+	local.Cause = ErrLocalPolicy
+
+	return local, nil
+}
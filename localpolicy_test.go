@@ -0,0 +1,184 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalPolicy(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":         {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"_relays.example.net": {"v=spf1 ip4:198.51.100.0/24 -all"},
+		},
+	}
+	ctx := context.Background()
+	ch := NewChecker(NewCustomDNSResolver(zone), WithLocalPolicy(LocalPolicy{
+		TrustedForwarders: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")},
+		Mechanisms:        "include:_relays.example.net",
+	}))
+
+	res, err := ch.CheckHost(ctx, net.ParseIP("203.0.113.7"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.ErrorIs(t, res.Cause, ErrTrustedForwarder)
+	assert.Zero(t, res.Stats.Queries)
+
+	res, err = ch.CheckHost(ctx, net.ParseIP("198.51.100.7"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.ErrorIs(t, res.Cause, ErrLocalPolicy)
+	require.NotNil(t, res.Match)
+	assert.Equal(t, "_relays.example.net", res.Match.Domain)
+
+	res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.NoError(t, res.Cause)
+
+	// the local mechanisms do not change other results than a pass
+	res, err = ch.CheckHost(ctx, net.ParseIP("10.0.0.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	require.NotNil(t, res.Match)
+	assert.Equal(t, "-all", res.Match.Term)
+}
+
+func TestChecker_SetLocalPolicy(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 -all"}}}
+	ctx := context.Background()
+	ip := net.ParseIP("192.0.2.1")
+	ch := NewChecker(NewCustomDNSResolver(zone), WithRecordOverrides(map[string]string{
+		"Example.com.": "v=spf1 ip4:192.0.2.0/24 -all",
+	}))
+	assert.Equal(t, map[string]string{"Example.com.": "v=spf1 ip4:192.0.2.0/24 -all"}, ch.LocalPolicy().Overrides)
+
+	res, _ := ch.CheckHost(ctx, ip, "example.com", "")
+	assert.Equal(t, Pass, res.Code)
+
+	require.NoError(t, ch.SetLocalPolicy(LocalPolicy{}))
+	res, _ = ch.CheckHost(ctx, ip, "example.com", "")
+	assert.Equal(t, Fail, res.Code)
+
+	require.NoError(t, ch.SetLocalPolicy(LocalPolicy{Mechanisms: "IP4:192.0.2.1"}))
+	res, _ = ch.CheckHost(ctx, ip, "example.com", "")
+	assert.Equal(t, Pass, res.Code)
+
+	for _, mechs := range []string{"ip4:192.0.2", "ip4:192.0.2.1 redirect=example.net"} {
+		err := ch.SetLocalPolicy(LocalPolicy{Mechanisms: mechs})
+		assert.ErrorIs(t, err, ErrInvalidLocalPolicy, mechs)
+	}
+	assert.Equal(t, "IP4:192.0.2.1", ch.LocalPolicy().Mechanisms, "the invalid policies are not installed")
+}
+
+func TestLocalPolicy_MechanismMacroCase(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{"example.com": {"v=spf1 -all"}},
+		ip:  map[string][]string{"a%2Bb.x.example": {"127.0.0.2"}},
+	}
+	// upper-case macros keep their case and are URL escaped
+	ch := NewChecker(NewCustomDNSResolver(zone), WithLocalPolicy(LocalPolicy{Mechanisms: "Exists:%{L}.x.example"}))
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "a+b@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.ErrorIs(t, res.Cause, ErrLocalPolicy)
+}
+
+func TestChecker_SetLocalPolicy_Concurrent(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 -all"}}}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ip := net.ParseIP("192.0.2.1")
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				if i == 0 {
+					_ = ch.SetLocalPolicy(LocalPolicy{Overrides: map[string]string{"example.com": "v=spf1 +all"}})
+					continue
+				}
+				res, err := ch.CheckHost(context.Background(), ip, "example.com", "")
+				assert.NoError(t, err, j)
+				assert.Contains(t, []Result{Pass, Fail}, res.Code)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLoadLocalPolicy(t *testing.T) {
+	p, err := LoadLocalPolicy(strings.NewReader(`
+overrides:
+  partner.example: "v=spf1 ip4:192.0.2.0/24 ~all"
+trusted_forwarders: [192.0.2.0/24, "2001:db8::/32"]
+mechanisms: include:_relays.example.net
+`))
+	require.NoError(t, err)
+	assert.Equal(t, LocalPolicy{
+		Overrides:         map[string]string{"partner.example": "v=spf1 ip4:192.0.2.0/24 ~all"},
+		TrustedForwarders: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("2001:db8::/32")},
+		Mechanisms:        "include:_relays.example.net",
+	}, p)
+
+	for _, text := range []string{"forwarders: [192.0.2.0/24]", "trusted_forwarders: [192.0.2.1]"} {
+		_, err := LoadLocalPolicy(strings.NewReader(text))
+		assert.ErrorIs(t, err, ErrInvalidLocalPolicy, text)
+	}
+
+	cfg, err := LoadConfig(strings.NewReader("trusted_forwarders: [192.0.2.0/24]\nmechanisms: a:relay.example.net"))
+	require.NoError(t, err)
+	assert.Equal(t, "a:relay.example.net", cfg.LocalPolicy.Mechanisms)
+	assert.Equal(t, cfg.LocalPolicy, cfg.NewChecker().LocalPolicy())
+}
+
+func TestChecker_WatchLocalPolicy(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 -all"}}}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	path := filepath.Join(t.TempDir(), "local.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("trusted_forwarders: [192.0.2.0/24]\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 16)
+	done := make(chan error)
+	go func() {
+		done <- ch.WatchLocalPolicy(ctx, path, 10*time.Millisecond, func(err error) { errs <- err })
+	}()
+
+	trusted := func(ip string) func() bool {
+		return func() bool {
+			res, _ := ch.CheckHost(context.Background(), net.ParseIP(ip), "example.com", "")
+			return res.Code == Pass
+		}
+	}
+	assert.Eventually(t, trusted("192.0.2.1"), time.Second, 5*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(path, []byte("trusted_forwarders: [198.51.100.0/24]\n"), 0o600))
+	assert.Eventually(t, trusted("198.51.100.1"), time.Second, 5*time.Millisecond)
+	assert.False(t, trusted("192.0.2.1")())
+
+	// an invalid file keeps the current policy
+	require.NoError(t, os.WriteFile(path, []byte("mechanisms: \"ip4:\"\n"), 0o600))
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrInvalidLocalPolicy)
+		assert.ErrorContains(t, err, path)
+	case <-time.After(time.Second):
+		t.Fatal("invalid file not reported")
+	}
+	assert.True(t, trusted("198.51.100.1")())
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
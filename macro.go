@@ -0,0 +1,484 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrMacroSyntax is returned when a domain-spec contains a malformed macro.
+// RFC 7208 section 7.1 treats this as a permerror.
+var ErrMacroSyntax = errors.New("permerror: invalid macro syntax")
+
+// maxDomainLength is the limit applied to expanded domain-specs before they
+// are used in a DNS query (RFC 7208 section 7.3).
+const maxDomainLength = 253
+
+// macroEnv holds the values substituted for the macro letters described in
+// RFC 7208 section 7.2.
+type macroEnv struct {
+	ip     net.IP // normalised with normalizeIP
+	ipText string // macroIP(ip), when computed beforehand
+	sender string // <sender>
+	domain string // <domain> of the current check_host evaluation
+	helo   string // HELO/EHLO identity, may be empty
+	now    time.Time
+
+	// explanation enables the "c", "r" and "t" macros, which RFC 7208
+	// section 7.2 only allows in explanation strings.
+	explanation bool
+	receiver    string // "r" macro, "unknown" when empty
+
+	// validated computes the "p" macro on demand since it costs DNS
+	// lookups.  When nil, the expander's memoised lookup is used if set,
+	// and "p" expands to "unknown" otherwise.
+	validated func() (string, error)
+	expander  *MacroExpander
+	ctx       context.Context // of the expansion, for the expander
+}
+
+// MacroExpander expands the macro-strings of RFC 7208 section 7 for one
+// evaluation, i.e. a fixed client address, sender, HELO identity and time.
+// It memoises the expensive "p" macro per domain as well as every completed
+// expansion, so one expander can be reused for all the terms of a record and
+// of the records it includes.  A MacroExpander is not safe for concurrent use.
+type MacroExpander struct {
+	// Receiver is the value of the "r" macro; empty means "unknown".
+	Receiver string
+
+	eval      *evaluation
+	now       time.Time
+	validated map[string]string
+	expanded  map[expansionKey]string
+	ipText    string // the "i" macro
+	buf       []byte // reused for expansions
+
+	// scratch is the environment reused for expansions; sender is its
+	// <sender> for the domain senderFor.
+	scratch   macroEnv
+	sender    string
+	senderFor string
+}
+
+// expansionKey identifies a memoised expansion.
+type expansionKey struct {
+	spec        string
+	domain      string
+	explanation bool
+}
+
+// NewMacroExpander returns a MacroExpander for expanding macros outside of
+// CheckHost.  r is used for the lookups of the "p" macro, which are bounded
+// by MaxDNSLookups; with a nil resolver "p" expands to "unknown".
+func NewMacroExpander(r TXTResolver, ip net.IP, sender, helo string) *MacroExpander {
+	e := NewChecker(r).newEvaluation(ip, sender)
+	e.helo = helo
+
+	return e.macro
+}
+
+// newMacroExpander returns the expander used by evaluation e.  Lookups for
+// the "p" macro are charged to e.
+func newMacroExpander(e *evaluation) *MacroExpander {
+	return &MacroExpander{
+		Receiver:  e.checker.receiver,
+		eval:      e,
+		now:       e.checker.now(),
+		validated: make(map[string]string),
+		expanded:  make(map[expansionKey]string),
+		ipText:    macroIP(e.ip),
+	}
+}
+
+// Expand expands a domain-spec evaluated for domain.  The explanation-only
+// macros "c", "r" and "t" are rejected with ErrMacroSyntax.  The result is
+// neither truncated nor validated as a domain name.
+func (m *MacroExpander) Expand(ctx context.Context, spec, domain string) (string, error) {
+	return m.expand(ctx, spec, domain, false)
+}
+
+// ExpandExplanation expands explanation text (RFC 7208 section 6.2) for
+// domain, where every macro letter is allowed.
+func (m *MacroExpander) ExpandExplanation(ctx context.Context, text, domain string) (string, error) {
+	return m.expand(ctx, text, domain, true)
+}
+
+// AppendExpand is Expand appending the expansion to dst, for callers
+// expanding many domain-specs without allocating a string for each.  Unlike
+// Expand it does not memoise the expansion.
+func (m *MacroExpander) AppendExpand(ctx context.Context, dst []byte, spec, domain string) ([]byte, error) {
+	if val, ok := m.expanded[expansionKey{spec: spec, domain: domain}]; ok {
+		return append(dst, val...), nil
+	}
+	if !strings.ContainsRune(spec, '%') {
+		return append(dst, spec...), nil
+	}
+	if err := m.eval.countComplexity(LimitMacroExpansions); err != nil {
+		return dst, err
+	}
+
+	return appendMacros(dst, spec, m.env(ctx, domain))
+}
+
+func (m *MacroExpander) expand(ctx context.Context, spec, domain string, explanation bool) (string, error) {
+	key := expansionKey{spec: spec, domain: domain, explanation: explanation}
+	if val, ok := m.expanded[key]; ok {
+		return val, nil
+	}
+	if !strings.ContainsRune(spec, '%') {
+		return spec, nil
+	}
+	if err := m.eval.countComplexity(LimitMacroExpansions); err != nil {
+		return "", err
+	}
+
+	env := m.env(ctx, domain)
+	env.explanation = explanation
+	b, err := appendMacros(m.buf[:0], spec, env)
+	m.buf = b
+	if err != nil {
+		return "", err
+	}
+	val := string(b)
+	m.expanded[key] = val
+
+	return val, nil
+}
+
+// env returns the macro values for evaluating terms of domain.  The values
+// are valid until the next call.
+func (m *MacroExpander) env(ctx context.Context, domain string) *macroEnv {
+	e := m.eval
+	if m.sender == "" || m.senderFor != domain {
+		// RFC 7208 section 4.3: a missing local part is "postmaster" and a
+		// missing sender domain is the domain being evaluated
+		senderDomain, ok := getSenderDomain(e.sender)
+		if !ok {
+			senderDomain = domain
+		}
+		local := mapLocalPart(localPart(e.sender), e.checker.localPart)
+		m.sender, m.senderFor = local+"@"+normalizeSenderDomain(senderDomain), domain
+	}
+	m.scratch = macroEnv{
+		ip:       e.ip,
+		ipText:   m.ipText,
+		sender:   m.sender,
+		domain:   domain,
+		helo:     e.helo,
+		now:      m.now,
+		receiver: m.Receiver,
+		expander: m,
+		ctx:      ctx,
+	}
+
+	return &m.scratch
+}
+
+// validatedDomain memoises evaluation.validatedDomain so that the PTR work
+// for the "p" macro is done, and charged, at most once per domain.
+func (m *MacroExpander) validatedDomain(ctx context.Context, domain string) (string, error) {
+	if name, ok := m.validated[domain]; ok {
+		return name, nil
+	}
+	name, err := m.eval.validatedDomain(ctx, domain)
+	if err != nil {
+		return "", err
+	}
+	m.validated[domain] = name
+
+	return name, nil
+}
+
+// expandMacros expands a macro-string as defined in RFC 7208 section 7.1.
+//
+//	%%         → "%"
+//	%_         → " "
+//	%-         → "%20"
+//	%{l1r+-}   → letter, digit transformer, reverse flag, delimiters
+//
+// Any other use of '%' is a syntax error.
+func expandMacros(spec string, env *macroEnv) (string, error) {
+	if !strings.ContainsRune(spec, '%') {
+		return spec, nil
+	}
+	b, err := appendMacros(make([]byte, 0, 2*len(spec)), spec, env)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// appendMacros appends the expansion of spec to dst.  Macro values are
+// copied into dst directly, so no string is allocated per macro.
+func appendMacros(dst []byte, spec string, env *macroEnv) ([]byte, error) {
+	for i := 0; i < len(spec); i++ {
+		ch := spec[i]
+		if ch != '%' {
+			dst = append(dst, ch)
+			continue
+		}
+		if i+1 >= len(spec) {
+			return dst, fmt.Errorf("%w: trailing %% in %q", ErrMacroSyntax, spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			dst = append(dst, '%')
+		case '_':
+			dst = append(dst, ' ')
+		case '-':
+			dst = append(dst, "%20"...)
+		case '{':
+			end := strings.IndexByte(spec[i:], '}')
+			if end < 0 {
+				return dst, fmt.Errorf("%w: unterminated macro in %q", ErrMacroSyntax, spec)
+			}
+			var err error
+			if dst, err = env.appendTerm(dst, spec[i+1:i+end]); err != nil {
+				return dst, err
+			}
+			i += end
+		default:
+			return dst, fmt.Errorf("%w: bad escape %%%c in %q", ErrMacroSyntax, spec[i], spec)
+		}
+	}
+
+	return dst, nil
+}
+
+// appendTerm appends the expansion of the body of one "%{...}" macro-expand
+// term to dst.
+func (env *macroEnv) appendTerm(dst []byte, body string) ([]byte, error) {
+	if body == "" {
+		return dst, fmt.Errorf("%w: empty macro", ErrMacroSyntax)
+	}
+	letter := body[0]
+	escape := letter >= 'A' && letter <= 'Z'
+	if escape {
+		letter += 'a' - 'A'
+	}
+	val, err := env.letter(letter)
+	if err != nil {
+		return dst, err
+	}
+
+	rest := body[1:]
+	n := 0
+	for n < len(rest) && rest[n] >= '0' && rest[n] <= '9' {
+		n++
+	}
+	digits := rest[:n]
+	rest = rest[n:]
+
+	reverse := false
+	if rest != "" && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse = true
+		rest = rest[1:]
+	}
+
+	delims := rest
+	for i := 0; i < len(delims); i++ {
+		if !strings.ContainsRune(".-+,/_=", rune(delims[i])) {
+			return dst, fmt.Errorf("%w: bad delimiter %q", ErrMacroSyntax, delims[i])
+		}
+	}
+	if delims == "" {
+		delims = "."
+	}
+
+	fields := countFields(val, delims)
+	keep := fields
+	if digits != "" {
+		keep, err = strconv.Atoi(digits)
+		if err != nil || keep == 0 {
+			return dst, fmt.Errorf("%w: bad transformer %q", ErrMacroSyntax, digits)
+		}
+		keep = min(keep, fields)
+	}
+
+	// The kept fields are the last keep of the possibly reversed list, i.e.
+	// fields fields-keep to the end, or fields keep-1 down to 0 when
+	// reversed.
+	if !reverse {
+		first, idx, from := fields-keep, 0, 0
+		for i := 0; i <= len(val); i++ {
+			if i < len(val) && strings.IndexByte(delims, val[i]) < 0 {
+				continue
+			}
+			if idx > first {
+				dst = append(dst, '.')
+			}
+			if idx >= first {
+				dst = appendMacroValue(dst, val[from:i], escape)
+			}
+			idx, from = idx+1, i+1
+		}
+		return dst, nil
+	}
+	idx, end := fields-1, len(val)
+	for i := len(val) - 1; i >= -1; i-- {
+		if i >= 0 && strings.IndexByte(delims, val[i]) < 0 {
+			continue
+		}
+		if idx < keep-1 {
+			dst = append(dst, '.')
+		}
+		if idx < keep {
+			dst = appendMacroValue(dst, val[i+1:end], escape)
+		}
+		idx, end = idx-1, i
+	}
+
+	return dst, nil
+}
+
+// countFields returns the number of fields of s split on the bytes of
+// delims, counting empty ones.
+func countFields(s, delims string) int {
+	n := 1
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(delims, s[i]) >= 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// appendMacroValue appends s to dst, URL escaped for upper-case macro
+// letters (RFC 7208 section 7.3).
+func appendMacroValue(dst []byte, s string, escape bool) []byte {
+	if !escape {
+		return append(dst, s...)
+	}
+
+	return appendURLEscaped(dst, s)
+}
+
+// urlEscape percent-encodes every byte outside the RFC 3986 unreserved set.
+func urlEscape(s string) string {
+	return string(appendURLEscaped(nil, s))
+}
+
+// appendURLEscaped appends urlEscape(s) to dst.
+func appendURLEscaped(dst []byte, s string) []byte {
+	const hexDigits = "0123456789ABCDEF"
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			dst = append(dst, c)
+		default:
+			dst = append(dst, '%', hexDigits[c>>4], hexDigits[c&0x0f])
+		}
+	}
+
+	return dst
+}
+
+// letter returns the raw value for a macro letter (RFC 7208 section 7.2).
+func (env *macroEnv) letter(l byte) (string, error) {
+	switch l {
+	case 's':
+		return env.sender, nil
+	case 'l':
+		return localPart(env.sender), nil
+	case 'o':
+		if d, ok := getSenderDomain(env.sender); ok && d != "" {
+			return d, nil
+		}
+		return env.domain, nil
+	case 'd':
+		return env.domain, nil
+	case 'i':
+		if env.ipText != "" {
+			return env.ipText, nil
+		}
+		return macroIP(env.ip), nil
+	case 'p':
+		switch {
+		case env.validated != nil:
+			return env.validated()
+		case env.expander != nil:
+			return env.expander.validatedDomain(env.ctx, env.domain)
+		}
+		return unknownDomain, nil
+	case 'v':
+		if env.ip.To4() != nil {
+			return "in-addr", nil
+		}
+		return "ip6", nil
+	case 'h':
+		return env.helo, nil
+	case 'c', 'r', 't':
+		if !env.explanation {
+			return "", fmt.Errorf("%w: %%{%c} is only allowed in explanations", ErrMacroSyntax, l)
+		}
+		return env.explanationLetter(l), nil
+	default:
+		return "", fmt.Errorf("%w: unknown macro letter %q", ErrMacroSyntax, l)
+	}
+}
+
+// explanationLetter returns the value of an explanation-only macro letter.
+func (env *macroEnv) explanationLetter(l byte) string {
+	switch l {
+	case 'c':
+		// readable client address, IPv6 in its compressed form
+		if env.ip == nil {
+			return ""
+		}
+		return env.ip.String()
+	case 'r':
+		if env.receiver == "" {
+			return unknownDomain
+		}
+		return env.receiver
+	default:
+		return strconv.FormatInt(env.now.Unix(), 10)
+	}
+}
+
+// macroIP formats ip for the "i" macro: dotted quad for IPv4 (including
+// IPv4-mapped IPv6 addresses) and dot-separated nibbles for IPv6.
+func macroIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	v6 := ip.To16()
+	if v6 == nil {
+		return ""
+	}
+
+	const hexDigits = "0123456789abcdef"
+	b := make([]byte, 0, 2*len(v6)*2)
+	for i, octet := range v6 {
+		if i > 0 {
+			b = append(b, '.')
+		}
+		b = append(b, hexDigits[octet>>4], '.', hexDigits[octet&0x0f])
+	}
+
+	return string(b)
+}
+
+// truncateDomain drops labels from the left of an expanded domain-spec until
+// it fits in 253 octets (RFC 7208 section 7.3).
+func truncateDomain(domain string) string {
+	domain = strings.TrimSuffix(domain, ".")
+	for len(domain) > maxDomainLength {
+		_, rest, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+		domain = rest
+	}
+
+	return domain
+}
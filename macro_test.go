@@ -0,0 +1,217 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Examples from RFC 7208 section 7.4.
+func TestExpandMacros(t *testing.T) {
+	env4 := &macroEnv{ip: normalizeIP(net.ParseIP("192.0.2.3")), sender: "strong-bad@email.example.com", domain: "email.example.com"}
+	env6 := &macroEnv{ip: normalizeIP(net.ParseIP("2001:db8::cb01")), sender: "strong-bad@email.example.com", domain: "email.example.com"}
+	mapped := &macroEnv{ip: normalizeIP(net.ParseIP("::ffff:192.0.2.3")), sender: "strong-bad@email.example.com", domain: "email.example.com"}
+
+	tc := []struct {
+		spec string
+		env  *macroEnv
+		want string
+	}{
+		{"%{s}", env4, "strong-bad@email.example.com"},
+		{"%{o}", env4, "email.example.com"},
+		{"%{d}", env4, "email.example.com"},
+		{"%{d4}", env4, "email.example.com"},
+		{"%{d3}", env4, "email.example.com"},
+		{"%{d2}", env4, "example.com"},
+		{"%{d1}", env4, "com"},
+		{"%{dr}", env4, "com.example.email"},
+		{"%{d2r}", env4, "example.email"},
+		{"%{l}", env4, "strong-bad"},
+		{"%{l-}", env4, "strong.bad"},
+		{"%{lr}", env4, "strong-bad"},
+		{"%{lr-}", env4, "bad.strong"},
+		{"%{l1r-}", env4, "strong"},
+		{"%{ir}.%{v}._spf.%{d2}", env4, "3.2.0.192.in-addr._spf.example.com"},
+		{"%{lr-}.lp._spf.%{d2}", env4, "bad.strong.lp._spf.example.com"},
+		{"%{ir}.%{v}._spf.%{d2}", mapped, "3.2.0.192.in-addr._spf.example.com"},
+		{"%{ir}.%{v}._spf.%{d2}", env6, "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"},
+		{"%%%_%-", env4, "% %20"},
+		{"%{t}", &macroEnv{now: time.Unix(1700000000, 0), explanation: true}, "1700000000"},
+	}
+
+	for _, c := range tc {
+		t.Run(c.spec, func(t *testing.T) {
+			got, err := expandMacros(c.spec, c.env)
+			require.NoError(t, err)
+			assert.Equal(t, c.want, got)
+		})
+	}
+}
+
+func TestExpandMacros_Explanation(t *testing.T) {
+	env := &macroEnv{
+		ip:          normalizeIP(net.ParseIP("192.0.2.3")),
+		sender:      "strong-bad@email.example.com",
+		domain:      "email.example.com",
+		now:         time.Unix(1700000000, 0),
+		explanation: true,
+		receiver:    "mx.example.net",
+	}
+	env6 := *env
+	env6.ip = normalizeIP(net.ParseIP("2001:db8::cb01"))
+
+	tc := []struct {
+		spec string
+		env  *macroEnv
+		want string
+	}{
+		{"%{c}", env, "192.0.2.3"},
+		{"%{c}", &env6, "2001:db8::cb01"},
+		{"%{r}", env, "mx.example.net"},
+		{"%{r}", &macroEnv{explanation: true}, "unknown"},
+		{"%{t}", env, "1700000000"},
+		{"%{i} is not one of %{d}'s designated mail servers.", env,
+			"192.0.2.3 is not one of email.example.com's designated mail servers."},
+		{"%{S}", env, "strong-bad%40email.example.com"},
+		{"%{L}", &macroEnv{sender: "a+b@example.com"}, "a%2Bb"},
+		{"%{D2}", env, "example.com"},
+		{"%{Lr-}", env, "bad.strong"},
+	}
+	for _, c := range tc {
+		t.Run(c.spec, func(t *testing.T) {
+			got, err := expandMacros(c.spec, c.env)
+			require.NoError(t, err)
+			assert.Equal(t, c.want, got)
+		})
+	}
+
+	// the explanation-only letters are rejected in domain-specs
+	for _, spec := range []string{"%{c}", "%{r}.example.com", "%{t}.example.com", "%{C}"} {
+		_, err := expandMacros(spec, &macroEnv{})
+		require.ErrorIs(t, err, ErrMacroSyntax, spec)
+	}
+}
+
+func TestExpandMacros_Errors(t *testing.T) {
+	env := &macroEnv{domain: "example.com"}
+	for _, spec := range []string{"%", "%{d", "%{}", "%{x}", "%{d0}", "%{d!}", "%a"} {
+		_, err := expandMacros(spec, env)
+		require.ErrorIs(t, err, ErrMacroSyntax, spec)
+	}
+}
+
+func TestAppendMacros_Allocs(t *testing.T) {
+	env := &macroEnv{
+		ip:     normalizeIP(net.ParseIP("2001:db8::cb01")),
+		sender: "Strong-Bad@email.example.com",
+		domain: "email.example.com",
+		helo:   "mx.example.org",
+	}
+	env.ipText = macroIP(env.ip)
+	spec := "%{ir}.%{v}.%{l1r-}.%{L}._spf.%{d2}.%{h}"
+	want, err := expandMacros(spec, env)
+	require.NoError(t, err)
+
+	buf := make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, err = appendMacros(buf[:0], spec, env)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, want, string(buf))
+	assert.Zero(t, allocs)
+}
+
+func TestMacroExpander_AppendExpand(t *testing.T) {
+	m := NewMacroExpander(nil, net.ParseIP("192.0.2.3"), "strong-bad@email.example.com", "")
+	ctx := context.Background()
+	for _, spec := range []string{"%{ir}.%{v}._spf.%{d2}", "%{l2r-}.example.com", "_spf.example.com", "%{d0}"} {
+		want, wantErr := m.Expand(ctx, spec, "email.example.com")
+		got, err := m.AppendExpand(ctx, []byte("prefix "), spec, "email.example.com")
+		if wantErr != nil {
+			require.ErrorIs(t, err, ErrMacroSyntax, spec)
+			continue
+		}
+		require.NoError(t, err, spec)
+		assert.Equal(t, "prefix "+want, string(got), spec)
+	}
+}
+
+func BenchmarkMacroExpander_Expand(b *testing.B) {
+	specs := []string{"%{ir}.%{v}._spf.%{d2}", "%{l}.%{i}.rate.%{d}", "%{h}.%{ir}.helo.%{d}"}
+	m := NewMacroExpander(nil, net.ParseIP("2001:db8::cb01"), "user@example.com", "mx.example.org")
+	ctx := context.Background()
+	b.ReportAllocs()
+	var buf []byte
+	for i := range b.N {
+		for _, spec := range specs {
+			var err error
+			if buf, err = m.AppendExpand(ctx, buf[:0], spec, "example.com"); err != nil {
+				b.Fatal(i, err)
+			}
+		}
+	}
+}
+
+func TestTruncateDomain(t *testing.T) {
+	long := strings.Repeat("a", 60) + "." + strings.Repeat("b", 60) + "." +
+		strings.Repeat("c", 60) + "." + strings.Repeat("d", 60) + ".example.com"
+	got := truncateDomain(long)
+	assert.LessOrEqual(t, len(got), maxDomainLength)
+	assert.True(t, strings.HasSuffix(got, ".example.com"))
+	assert.Equal(t, "example.com", truncateDomain("example.com."))
+}
+
+// countingPTR counts reverse lookups made through a zoneResolver.
+type countingPTR struct {
+	*zoneResolver
+	ptrLookups int
+}
+
+func (c *countingPTR) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	c.ptrLookups++
+	return c.zoneResolver.LookupAddr(ctx, addr)
+}
+
+func TestMacroExpander(t *testing.T) {
+	r := &countingPTR{zoneResolver: ptrZone()}
+	m := NewMacroExpander(r, net.ParseIP("192.0.2.1"), "alice@example.org", "mail.example.org")
+	m.Receiver = "mx.example.net"
+	ctx := context.Background()
+
+	got, err := m.Expand(ctx, "%{p}.%{l}.%{h}", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "mail.example.com.alice.mail.example.org", got)
+
+	got, err = m.Expand(ctx, "%{p2}.allow.%{d}", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com.allow.example.com", got)
+	assert.Equal(t, 1, r.ptrLookups, "%{p} is resolved once per domain")
+
+	got, err = m.ExpandExplanation(ctx, "%{c} rejected by %{r}", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1 rejected by mx.example.net", got)
+
+	_, err = m.Expand(ctx, "%{r}", "example.com")
+	require.ErrorIs(t, err, ErrMacroSyntax)
+
+	got, err = NewMacroExpander(nil, net.ParseIP("192.0.2.1"), "", "").Expand(ctx, "%{p}", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "unknown", got)
+}
+
+func TestChecker_PMacroResolvedOnce(t *testing.T) {
+	zone := ptrZone()
+	zone.txt["twice.example.com"] = []string{"v=spf1 exists:%{p}.deny.example.net exists:%{p}.allow.example.net -all"}
+	r := &countingPTR{zoneResolver: zone}
+	ch := NewChecker(NewCustomDNSResolver(r))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.2"), "twice.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code, "second exists matches mx.sub.example.com.allow.example.net")
+	assert.Equal(t, 1, r.ptrLookups)
+}
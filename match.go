@@ -0,0 +1,61 @@
+package spf
+
+import (
+	"context"
+	"net"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// MatchMechanism reports whether mech, a mechanism of the record of domain,
+// matches the client ip for mail from sender, evaluating only that term of
+// check_host() (RFC 7208 section 5).  An include matches when its target
+// evaluates to pass, e.g. to test whether a vendor's include authorizes an
+// address.  The qualifier of mech is not applied.
+//
+// The conditions that end check_host() with temperror or permerror, such as a
+// DNS failure, an exceeded lookup limit or an include target without a
+// record, are returned as errors, as are ErrInvalidIP for a nil ip and the
+// validation error of an invalid domain.
+func (c *Checker) MatchMechanism(ctx context.Context, mech *parser.Mechanism, ip net.IP, domain, sender string) (bool, error) {
+	ctx, cancel, valDomain, err := c.prepare(ctx, ip, domain)
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+
+	e := c.newEvaluation(ip, sender)
+	e.simulated, e.correlationID = simulatedFrom(ctx), CorrelationID(ctx)
+
+	return e.match(withTTL(withStats(ctx, &e.stats), &e.ttl), mech, valDomain)
+}
+
+// Matches is MatchMechanism with a Checker for r configured by opts.
+func Matches(ctx context.Context, mech *parser.Mechanism, ip net.IP, domain string, r TXTResolver, opts ...Option) (bool, error) {
+	return NewChecker(r, opts...).MatchMechanism(ctx, mech, ip, domain, "")
+}
+
+// prepare checks the arguments of an evaluation outside CheckHost and
+// applies the Checker's timeout to ctx.  It returns domain validated; the
+// CancelFunc must be called when err is nil.
+func (c *Checker) prepare(ctx context.Context, ip net.IP, domain string) (context.Context, context.CancelFunc, string, error) {
+	if c.closed.Load() {
+		return nil, nil, "", ErrClosed
+	}
+	if ip == nil {
+		return nil, nil, "", ErrInvalidIP
+	}
+	valDomain, err := parser.ValidateDomainWith(domain, parser.ValidateOptions{
+		AllowSingleLabel: c.allowSingleLabel,
+		Profile:          c.idnaProfile,
+	})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	cancel := context.CancelFunc(func() {})
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+
+	return ctx, cancel, valDomain, nil
+}
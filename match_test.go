@@ -0,0 +1,62 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mailspire/spf/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchMechanism(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":         {"v=spf1 include:_spf.vendor.example -all"},
+			"_spf.vendor.example": {"v=spf1 ip4:198.51.100.0/24 a:relay.vendor.example ~all"},
+			"loop.example":        {"v=spf1 include:loop.example -all"},
+		},
+		ip: map[string][]string{"relay.vendor.example": {"203.0.113.5"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ctx := context.Background()
+	mech := func(term string) *parser.Mechanism {
+		m, err := parser.ParseMechanism(term)
+		require.NoError(t, err)
+		return m
+	}
+
+	cases := []struct {
+		term string
+		ip   string
+		want bool
+	}{
+		{"include:_spf.vendor.example", "198.51.100.7", true},
+		{"include:_spf.vendor.example", "203.0.113.5", true},
+		{"-include:_spf.vendor.example", "203.0.113.5", true}, // the qualifier is not applied
+		{"include:_spf.vendor.example", "192.0.2.1", false},   // ~all is not a pass
+		{"ip4:192.0.2.0/24", "192.0.2.1", true},
+		{"ip4:192.0.2.0/24", "2001:db8::1", false},
+		{"a:relay.vendor.example/24", "203.0.113.77", true},
+		{"all", "2001:db8::1", true},
+	}
+	for _, c := range cases {
+		got, err := ch.MatchMechanism(ctx, mech(c.term), net.ParseIP(c.ip), "example.com", "")
+		require.NoError(t, err, c.term)
+		assert.Equal(t, c.want, got, "%s %s", c.term, c.ip)
+	}
+
+	ok, err := Matches(ctx, mech("include:_spf.vendor.example"), net.ParseIP("198.51.100.7"), "example.com", NewCustomDNSResolver(zone))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = ch.MatchMechanism(ctx, mech("include:missing.example"), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.ErrorIs(t, err, ErrMissingRecord)
+	_, err = ch.MatchMechanism(ctx, mech("include:loop.example"), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.ErrorIs(t, err, ErrTooManyLookups)
+	_, err = ch.MatchMechanism(ctx, mech("all"), nil, "example.com", "")
+	require.ErrorIs(t, err, ErrInvalidIP)
+	_, err = ch.MatchMechanism(ctx, mech("all"), net.ParseIP("192.0.2.1"), "bad..example", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// ErrNoClientIP is returned by CheckMessage when neither the Connection nor
+// the Received header give the address of the client.
+var ErrNoClientIP = errors.New("client IP address unknown")
+
+// Connection is the SMTP session a message was received in, as far as the
+// caller knows it.  Empty fields are taken from the message headers.
+type Connection struct {
+	IP       net.IP
+	HELO     string
+	MailFrom string // the MAIL FROM reverse-path, e.g. "<alice@example.com>" or "<>"
+	// Hop selects the Received header describing the session, 0 for the
+	// topmost one added by the last receiving MTA.  Raise it when internal
+	// relays added headers after the border MTA.
+	Hop int
+}
+
+// MessageResult is the result of CheckMessage.
+type MessageResult struct {
+	Connection Connection // the session as checked, completed from the headers
+	Identities IdentitiesResult
+}
+
+// receivedFrom matches the "from" clause of a Received header (RFC 5321
+// section 4.4): the HELO name and the comment holding the client address,
+// e.g. "from mx.example.net (mail.example.net [192.0.2.1]) by ...".
+var receivedFrom = regexp.MustCompile(`(?i)^\s*from\s+(\S+)(?:\s+\(([^)]*)\))?`)
+
+// bracketedIP matches an address literal in the comment of a "from" clause.
+var bracketedIP = regexp.MustCompile(`(?i)\[(?:IPv6:)?([0-9a-f:.]+)\]`)
+
+// CheckMessage checks the HELO and MAIL FROM identities of a received
+// message with CheckIdentities, for analysing mail after delivery.  Fields
+// of conn that are empty are taken from msg: MAIL FROM from the Return-Path
+// header, HELO and the client address from the "from" clause of the
+// Received header selected by conn.Hop.  Header values are only hints: the
+// Received headers below the receiving MTA's own can be forged by the
+// sender.  ErrNoClientIP is returned when no address is found.
+func (c *Checker) CheckMessage(ctx context.Context, msg *mail.Message, conn Connection) (*MessageResult, error) {
+	if conn.MailFrom == "" {
+		conn.MailFrom = strings.TrimSpace(msg.Header.Get("Return-Path"))
+	}
+	if received := msg.Header["Received"]; conn.Hop >= 0 && conn.Hop < len(received) && (conn.IP == nil || conn.HELO == "") {
+		helo, ip := parseReceived(received[conn.Hop])
+		if conn.HELO == "" {
+			conn.HELO = helo
+		}
+		if conn.IP == nil {
+			conn.IP = ip
+		}
+	}
+	if conn.IP == nil {
+		return nil, ErrNoClientIP
+	}
+
+	res, err := c.CheckIdentities(ctx, conn.IP, conn.HELO, conn.MailFrom, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MessageResult{Connection: conn, Identities: res}, nil
+}
+
+// CheckMessageReader is CheckMessage for a message read from r.
+func (c *Checker) CheckMessageReader(ctx context.Context, r io.Reader, conn Connection) (*MessageResult, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.CheckMessage(ctx, msg, conn)
+}
+
+// parseReceived returns the HELO name and client address of the "from"
+// clause of a Received header value, empty when missing.
+func parseReceived(value string) (string, net.IP) {
+	m := receivedFrom.FindStringSubmatch(value)
+	if m == nil {
+		return "", nil
+	}
+	var ip net.IP
+	if lit := bracketedIP.FindStringSubmatch(m[2]); lit != nil {
+		ip = net.ParseIP(lit[1])
+	}
+	if ip == nil {
+		// a HELO address literal is the client address of last resort
+		ip, _, _ = ParseAddressLiteral(m[1])
+	}
+
+	return m[1], ip
+}
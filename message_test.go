@@ -0,0 +1,75 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecker_CheckMessage(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"mail.example.net": {"v=spf1 a -all"},
+			"example.net":      {"v=spf1 ip4:192.0.2.0/24 -all"},
+		},
+		ip: map[string][]string{"mail.example.net": {"192.0.2.1"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ctx := context.Background()
+	const message = "Return-Path: <alice@example.net>\r\n" +
+		"Received: from relay.internal (relay.internal [10.0.0.5])\r\n" +
+		"\tby mx.example.org (Postfix) with ESMTP id 1; Mon, 1 Jan 2024 00:00:00 +0000\r\n" +
+		"Received: from mail.example.net (mail.example.net [192.0.2.1])\r\n" +
+		"\tby border.example.org [10.0.0.1] with ESMTPS id 2; Mon, 1 Jan 2024 00:00:00 +0000\r\n" +
+		"Received: from [IPv6:2001:db8::1] (unknown)\r\n" +
+		"\tby mail.example.net with ESMTPSA id 3; Mon, 1 Jan 2024 00:00:00 +0000\r\n" +
+		"Subject: hi\r\n\r\nbody\r\n"
+
+	res, err := ch.CheckMessageReader(ctx, strings.NewReader(message), Connection{Hop: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "mail.example.net", res.Connection.HELO)
+	assert.Equal(t, "192.0.2.1", res.Connection.IP.String())
+	assert.Equal(t, "<alice@example.net>", res.Connection.MailFrom)
+	assert.Equal(t, Pass, res.Identities.HELO.Code)
+	assert.Equal(t, Pass, res.Identities.MailFrom.Code)
+
+	// the caller's metadata wins over the headers
+	res, err = ch.CheckMessageReader(ctx, strings.NewReader(message), Connection{
+		IP: net.ParseIP("203.0.113.9"), MailFrom: "<>", Hop: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "mail.example.net", res.Connection.HELO)
+	assert.Equal(t, Fail, res.Identities.Verdict)
+
+	res, err = ch.CheckMessageReader(ctx, strings.NewReader(message), Connection{Hop: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", res.Connection.IP.String())
+	assert.Equal(t, None, res.Identities.HELO.Code)
+	require.ErrorIs(t, res.Identities.HELO.Cause, ErrAddressLiteral)
+	assert.Equal(t, Fail, res.Identities.MailFrom.Code)
+
+	_, err = ch.CheckMessageReader(ctx, strings.NewReader(message), Connection{Hop: 3})
+	require.ErrorIs(t, err, ErrNoClientIP)
+	_, err = ch.CheckMessageReader(ctx, strings.NewReader("Subject: x\r\n\r\n"), Connection{})
+	require.ErrorIs(t, err, ErrNoClientIP)
+}
+
+func TestParseReceived(t *testing.T) {
+	cases := []struct {
+		value, helo, ip string
+	}{
+		{"from mx.example.net (mx.example.net [192.0.2.1]) by mx.example.org", "mx.example.net", "192.0.2.1"},
+		{"FROM host.example (HELO host.example) (198.51.100.2) by mx", "host.example", "<nil>"},
+		{"from [192.0.2.7] (port=25) by mx", "[192.0.2.7]", "192.0.2.7"},
+		{"by mx.example.org with LMTP", "", "<nil>"},
+	}
+	for _, c := range cases {
+		helo, ip := parseReceived(c.value)
+		assert.Equal(t, c.helo, helo, c.value)
+		assert.Equal(t, c.ip, ip.String(), c.value)
+	}
+}
@@ -0,0 +1,127 @@
+package spf
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// query identifies one DNS question sent through a Checker's resolver.
+type query struct {
+	Type string // "TXT", "A", "AAAA", "A/AAAA", "MX" or "PTR"
+	Name string
+}
+
+type queryFunc func(ctx context.Context) (any, error)
+
+// queryMiddleware wraps a DNS query, e.g. to cache or log it.  It must call
+// next to send the query on.
+type queryMiddleware func(ctx context.Context, q query, next queryFunc) (any, error)
+
+// middlewareResolver sends every query of r through mw, the first element
+// outermost.  It implements all resolver interfaces and returns
+// ErrUnsupported for lookups r cannot perform, like DNSResolver.
+type middlewareResolver struct {
+	r  TXTResolver
+	mw []queryMiddleware
+}
+
+func (m *middlewareResolver) do(ctx context.Context, q query, fn queryFunc) (any, error) {
+	next := fn
+	for i := len(m.mw) - 1; i >= 0; i-- {
+		mw, inner := m.mw[i], next
+		next = func(ctx context.Context) (any, error) { return mw(ctx, q, inner) }
+	}
+
+	return next(ctx)
+}
+
+func (m *middlewareResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	v, err := m.do(ctx, query{Type: "TXT", Name: domain}, func(ctx context.Context) (any, error) {
+		return m.r.LookupTXT(ctx, domain)
+	})
+	txts, _ := v.([]string)
+
+	return txts, err
+}
+
+func (m *middlewareResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	r, ok := m.r.(IPResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	qtype := "A/AAAA"
+	switch network {
+	case "ip4":
+		qtype = "A"
+	case "ip6":
+		qtype = "AAAA"
+	}
+	v, err := m.do(ctx, query{Type: qtype, Name: host}, func(ctx context.Context) (any, error) {
+		return r.LookupIP(ctx, network, host)
+	})
+	addrs, _ := v.([]net.IP)
+
+	return addrs, err
+}
+
+func (m *middlewareResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r, ok := m.r.(MXResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	v, err := m.do(ctx, query{Type: "MX", Name: name}, func(ctx context.Context) (any, error) {
+		return r.LookupMX(ctx, name)
+	})
+	mxs, _ := v.([]*net.MX)
+
+	return mxs, err
+}
+
+func (m *middlewareResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r, ok := m.r.(PTRResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	v, err := m.do(ctx, query{Type: "PTR", Name: addr}, func(ctx context.Context) (any, error) {
+		return r.LookupAddr(ctx, addr)
+	})
+	names, _ := v.([]string)
+
+	return names, err
+}
+
+// logMiddleware logs each query with its duration and outcome.
+func logMiddleware(l *slog.Logger) queryMiddleware {
+	return func(ctx context.Context, q query, next queryFunc) (any, error) {
+		start := time.Now()
+		v, err := next(ctx)
+		attrs := []any{"type", q.Type, "name", q.Name, "duration", time.Since(start)}
+		if id := CorrelationID(ctx); id != "" {
+			attrs = append(attrs, "correlation_id", id)
+		}
+		if err != nil {
+			attrs = append(attrs, "error", err)
+		}
+		l.DebugContext(ctx, "spf dns query", attrs...)
+
+		return v, err
+	}
+}
+
+// timeoutMiddleware gives every query d to complete.  A query that runs out
+// of time while ctx is still live is reported as a temporary DNS error rather
+// than as a context error.
+func timeoutMiddleware(d time.Duration) queryMiddleware {
+	return func(ctx context.Context, q query, next queryFunc) (any, error) {
+		qctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		v, err := next(qctx)
+		if err != nil && ctx.Err() == nil && qctx.Err() != nil {
+			return nil, &net.DNSError{Err: "query timed out", Name: q.Name, IsTimeout: true, IsTemporary: true}
+		}
+
+		return v, err
+	}
+}
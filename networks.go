@@ -0,0 +1,243 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// ErrClientDependent marks terms whose matching addresses depend on the
+// evaluated message rather than on DNS alone: ptr, exists and terms with
+// macros.  ListAuthorizedNetworks cannot enumerate them.
+var ErrClientDependent = errors.New("term depends on the evaluated client")
+
+// AuthorizedNetwork is an address range that a domain's policy passes,
+// together with the term it comes from.
+type AuthorizedNetwork struct {
+	Prefix netip.Prefix
+	Domain string // domain whose record contains the term
+	Term   string // the term, e.g. "ip4:192.0.2.0/24" or "mx/24"
+	Host   string // name resolved for "a" and "mx" terms, "" otherwise
+	// Path is the include/redirect chain from the queried domain to Domain,
+	// both inclusive.
+	Path []string
+}
+
+// UnresolvedTerm is a term whose addresses could not be listed.
+type UnresolvedTerm struct {
+	Domain string // domain whose record contains the term
+	Term   string
+	Err    error
+}
+
+// NetworkInventory is the result of ListAuthorizedNetworks.
+type NetworkInventory struct {
+	Domain     string
+	Networks   []AuthorizedNetwork
+	Unresolved []UnresolvedTerm
+}
+
+// ListAuthorizedNetworks returns the address ranges for which the policy of
+// domain can yield Pass, resolving include, redirect, a and mx.  Only terms
+// with the "+" qualifier, reached through "+include" or redirect, authorize
+// a range; "+all" is listed as 0.0.0.0/0 and ::/0.  Terms that match earlier
+// in a record are not taken into account, so a range listed here may still be
+// shadowed by e.g. a preceding "-ip4".
+//
+// r must implement IPResolver and MXResolver for a and mx terms to be
+// resolved.  Per-term problems are reported in NetworkInventory.Unresolved;
+// only an invalid domain or a context error is returned as error.
+func ListAuthorizedNetworks(ctx context.Context, r TXTResolver, domain string) (*NetworkInventory, error) {
+	g, err := BuildGraph(ctx, r, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return listNetworks(ctx, r, g)
+}
+
+// listNetworks is ListAuthorizedNetworks for an already built graph.
+func listNetworks(ctx context.Context, r TXTResolver, g *Graph) (*NetworkInventory, error) {
+	l := &networkLister{r: r, graph: g, seen: make(map[string]bool)}
+	l.inv.Domain = g.Root
+	if err := l.walk(ctx, g.Root, "", nil); err != nil {
+		return nil, err
+	}
+
+	return &l.inv, nil
+}
+
+// networkLister collects the networks of a Graph.
+type networkLister struct {
+	r     TXTResolver
+	graph *Graph
+	seen  map[string]bool
+	inv   NetworkInventory
+}
+
+// walk lists the networks of domain, reached from the queried domain through
+// path using the term via.  Every record is listed once.
+func (l *networkLister) walk(ctx context.Context, domain, via string, path []string) error {
+	if l.seen[domain] {
+		return nil
+	}
+	l.seen[domain] = true
+	path = append(path[:len(path):len(path)], domain)
+
+	node := l.graph.Nodes[domain]
+	if node.Err != nil {
+		l.unresolved(domain, via, node.Err)
+		return nil
+	}
+
+	for _, mech := range node.Record.Mechs {
+		if mech.Qual != parser.QPlus {
+			continue
+		}
+		if err := l.mechanism(ctx, node, mech, path); err != nil {
+			return err
+		}
+	}
+	if node.Record.Redirect != nil && !hasAll(node.Record) {
+		edge := newEdge("redirect", parser.QPlus, node.Record.Redirect.Value)
+		if edge.Macro {
+			l.unresolved(domain, edge.term(), ErrClientDependent)
+			return nil
+		}
+		return l.walk(ctx, edge.Target, edge.term(), path)
+	}
+
+	return nil
+}
+
+// mechanism lists the networks of one "+" mechanism of node.
+func (l *networkLister) mechanism(ctx context.Context, node *Node, mech parser.Mechanism, path []string) error {
+	term := mech.String()
+	switch mech.Kind {
+	case "all":
+		l.add(netip.MustParsePrefix("0.0.0.0/0"), node.Domain, term, "", path)
+		l.add(netip.MustParsePrefix("::/0"), node.Domain, term, "", path)
+	case "ip4", "ip6":
+		ones, _ := mech.Net.Mask.Size()
+		addr, _ := netip.AddrFromSlice(normalizeIP(mech.Net.IP))
+		l.add(netip.PrefixFrom(addr, ones), node.Domain, term, "", path)
+	case "a", "mx":
+		if mech.Macro {
+			l.unresolved(node.Domain, term, ErrClientDependent)
+			return nil
+		}
+		target := node.Domain
+		if mech.Domain != "" {
+			target = strings.ToLower(mech.Domain)
+		}
+		hosts := []string{target}
+		if mech.Kind == "mx" {
+			var err error
+			if hosts, err = l.mxHosts(ctx, target); err != nil {
+				return l.fail(node.Domain, term, err)
+			}
+		}
+		for _, host := range hosts {
+			if err := l.hostNetworks(ctx, node, mech, host, path); err != nil {
+				return l.fail(node.Domain, term, err)
+			}
+		}
+	case "include":
+		edge := newEdge("include", mech.Qual, mech.Domain)
+		if edge.Macro {
+			l.unresolved(node.Domain, term, ErrClientDependent)
+			return nil
+		}
+		return l.walk(ctx, edge.Target, term, path)
+	default:
+		l.unresolved(node.Domain, term, ErrClientDependent)
+	}
+
+	return nil
+}
+
+// mxHosts returns the exchanges of domain, none for a null MX.
+func (l *networkLister) mxHosts(ctx context.Context, domain string) ([]string, error) {
+	resolver, ok := l.r.(MXResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	mxs, err := resolver.LookupMX(ctx, queryName(domain))
+	if err != nil {
+		if err = classifyDNSError(err); errors.Is(err, ErrNoDNSrecord) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(mxs))
+	for _, mx := range mxs {
+		if !isNullMX(mx) {
+			hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+		}
+	}
+
+	return hosts, nil
+}
+
+// hostNetworks lists the A and AAAA records of host, widened by the dual
+// CIDR lengths of mech.
+func (l *networkLister) hostNetworks(ctx context.Context, node *Node, mech parser.Mechanism, host string, path []string) error {
+	resolver, ok := l.r.(IPResolver)
+	if !ok {
+		return ErrUnsupported
+	}
+	for _, network := range []string{"ip4", "ip6"} {
+		addrs, err := resolver.LookupIP(ctx, network, queryName(host))
+		if err != nil {
+			if err = classifyDNSError(err); errors.Is(err, ErrNoDNSrecord) {
+				continue
+			}
+			return err
+		}
+		for _, ip := range addrs {
+			addr, ok := netip.AddrFromSlice(normalizeIP(ip))
+			if !ok {
+				continue
+			}
+			ones := mech.Mask6
+			if addr.Is4() {
+				ones = mech.Mask4
+			}
+			if ones < 0 {
+				ones = addr.BitLen()
+			}
+			prefix, _ := addr.Prefix(ones)
+			l.add(prefix, node.Domain, mech.String(), host, path)
+		}
+	}
+
+	return nil
+}
+
+func (l *networkLister) add(prefix netip.Prefix, domain, term, host string, path []string) {
+	l.inv.Networks = append(l.inv.Networks, AuthorizedNetwork{
+		Prefix: prefix,
+		Domain: domain,
+		Term:   term,
+		Host:   host,
+		Path:   path,
+	})
+}
+
+func (l *networkLister) unresolved(domain, term string, err error) {
+	l.inv.Unresolved = append(l.inv.Unresolved, UnresolvedTerm{Domain: domain, Term: term, Err: err})
+}
+
+// fail records err for term unless it is a context error, which is returned.
+func (l *networkLister) fail(domain, term string, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	l.unresolved(domain, term, err)
+
+	return nil
+}
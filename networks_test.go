@@ -0,0 +1,86 @@
+package spf
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func networkZone() *zoneResolver {
+	return &zoneResolver{
+		txt: map[string][]string{
+			"example.com":        {"v=spf1 mx/24 a:web.example.com//64 include:_spf.example.com -include:deny.example.com ptr -all"},
+			"_spf.example.com":   {"v=spf1 ip4:192.0.2.0/24 ~ip4:198.51.100.0/24 exists:%{i}.bl.example include:missing.example.com redirect=_spf2.example.com"},
+			"_spf2.example.com":  {"v=spf1 ip6:2001:db8::/32 include:example.com -all"},
+			"deny.example.com":   {"v=spf1 ip4:203.0.113.0/24 -all"},
+			"open.example.com":   {"v=spf1 +all"},
+			"mxfail.example.com": {"v=spf1 mx -all"},
+			"nomail.example.com": {"v=spf1 mx ip4:192.0.2.0/24 -all"},
+		},
+		ip: map[string][]string{
+			"mail.example.com": {"192.0.2.25", "2001:db8::25"},
+			"web.example.com":  {"198.51.100.80", "2001:db8:1::80"},
+		},
+		mx: map[string][]string{
+			"example.com":        {"mail.example.com."},
+			"nomail.example.com": {"."},
+		},
+	}
+}
+
+func TestListAuthorizedNetworks(t *testing.T) {
+	inv, err := ListAuthorizedNetworks(context.Background(), networkZone(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", inv.Domain)
+
+	root := []string{"example.com"}
+	spf := []string{"example.com", "_spf.example.com"}
+	assert.Equal(t, []AuthorizedNetwork{
+		{Prefix: netip.MustParsePrefix("192.0.2.0/24"), Domain: "example.com", Term: "mx/24", Host: "mail.example.com", Path: root},
+		{Prefix: netip.MustParsePrefix("2001:db8::25/128"), Domain: "example.com", Term: "mx/24", Host: "mail.example.com", Path: root},
+		{Prefix: netip.MustParsePrefix("198.51.100.80/32"), Domain: "example.com", Term: "a:web.example.com//64", Host: "web.example.com", Path: root},
+		{Prefix: netip.MustParsePrefix("2001:db8:1::/64"), Domain: "example.com", Term: "a:web.example.com//64", Host: "web.example.com", Path: root},
+		{Prefix: netip.MustParsePrefix("192.0.2.0/24"), Domain: "_spf.example.com", Term: "ip4:192.0.2.0/24", Path: spf},
+		{Prefix: netip.MustParsePrefix("2001:db8::/32"), Domain: "_spf2.example.com", Term: "ip6:2001:db8::/32", Path: append(spf, "_spf2.example.com")},
+	}, inv.Networks)
+
+	require.Len(t, inv.Unresolved, 3)
+	assert.Equal(t, "exists:%{i}.bl.example", inv.Unresolved[0].Term)
+	require.ErrorIs(t, inv.Unresolved[0].Err, ErrClientDependent)
+	assert.Equal(t, "missing.example.com", inv.Unresolved[1].Domain)
+	require.ErrorIs(t, inv.Unresolved[1].Err, ErrNoDNSrecord)
+	assert.Equal(t, "ptr", inv.Unresolved[2].Term)
+}
+
+func TestListAuthorizedNetworks_Special(t *testing.T) {
+	ctx := context.Background()
+
+	inv, err := ListAuthorizedNetworks(ctx, networkZone(), "open.example.com")
+	require.NoError(t, err)
+	require.Len(t, inv.Networks, 2)
+	assert.Equal(t, "0.0.0.0/0", inv.Networks[0].Prefix.String())
+	assert.Equal(t, "::/0", inv.Networks[1].Prefix.String())
+
+	inv, err = ListAuthorizedNetworks(ctx, networkZone(), "nomail.example.com")
+	require.NoError(t, err)
+	require.Len(t, inv.Networks, 1, "a null MX authorizes nothing")
+	assert.Equal(t, "ip4:192.0.2.0/24", inv.Networks[0].Term)
+	assert.Empty(t, inv.Unresolved)
+
+	inv, err = ListAuthorizedNetworks(ctx, &fakeResolver{txts: []string{"v=spf1 mx -all"}}, "mxfail.example.com")
+	require.NoError(t, err)
+	assert.Empty(t, inv.Networks)
+	require.Len(t, inv.Unresolved, 1)
+	require.ErrorIs(t, inv.Unresolved[0].Err, ErrUnsupported)
+
+	_, err = ListAuthorizedNetworks(ctx, networkZone(), "bad..domain")
+	require.Error(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ListAuthorizedNetworks(cancelled, networkZone(), "example.com")
+	require.ErrorIs(t, err, context.Canceled)
+}
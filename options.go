@@ -0,0 +1,236 @@
+package spf
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// Option configures a Checker created by NewChecker.
+type Option func(*Checker)
+
+// WithMaxLookups sets the limit on DNS-querying terms per evaluation
+// (RFC 7208 section 4.6.4).  The default is MaxDNSLookups.
+func WithMaxLookups(n int) Option {
+	return func(c *Checker) { c.maxLookups = n }
+}
+
+// WithMaxVoidLookups sets the limit on lookups returning no answers.  The
+// default is MaxVoidLookups.
+func WithMaxVoidLookups(n int) Option {
+	return func(c *Checker) { c.maxVoidLookups = n }
+}
+
+// WithSingleLabel makes the Checker evaluate single-label domains such as
+// "localhost" instead of returning None for them as malformed (RFC 7208
+// section 4.3).  Such names are queried as rooted names ("localhost.") so
+// that the system resolver's search list never turns them into a different
+// domain.
+func WithSingleLabel() Option {
+	return func(c *Checker) { c.allowSingleLabel = true }
+}
+
+// WithLocalPartMode selects how a non-ASCII (SMTPUTF8) local part is
+// substituted for the "l" and "s" macros.  The default uses it unchanged.
+func WithLocalPartMode(m LocalPartMode) Option {
+	return func(c *Checker) { c.localPart = m }
+}
+
+// WithIDNAProfile sets the profile used to convert internationalised domains
+// passed to CheckHost and CheckHELO to A-labels.  The default is idna.Lookup.
+func WithIDNAProfile(p *idna.Profile) Option {
+	return func(c *Checker) { c.idnaProfile = p }
+}
+
+// WithClock sets the source of the current time for the "t" macro and any
+// other time-dependent behaviour.  Tests and replays can install a fixed
+// clock; the default is time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(c *Checker) { c.clock = now }
+}
+
+// WithReceiver sets the domain name of the host performing the check, used
+// for the "r" macro in explanations.  The default is "unknown".
+func WithReceiver(domain string) Option {
+	return func(c *Checker) { c.receiver = domain }
+}
+
+// WithTimeout bounds every evaluation, including all nested DNS queries, to
+// d.  It applies in addition to any deadline of the caller's context.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Checker) { c.timeout = d }
+}
+
+// WithTrace installs fn to be called for every evaluated mechanism.  fn is
+// called synchronously and must be safe for concurrent use when the Checker
+// is.
+func WithTrace(fn func(TraceEvent)) Option {
+	return func(c *Checker) { c.tracer = fn }
+}
+
+// WithCache answers repeated DNS queries from cache.  Successful answers and
+// NXDOMAIN are cached; temporary failures are not.  The Checker owns the
+// cache and closes it in Close if it implements io.Closer.
+func WithCache(cache Cache) Option {
+	return func(c *Checker) {
+		c.middleware = append(c.middleware, cacheMiddleware(cache))
+		if closer, ok := cache.(io.Closer); ok {
+			c.closers = append(c.closers, closer)
+		}
+	}
+}
+
+// WithLogger logs every DNS query sent to the resolver at debug level.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Checker) { c.middleware = append(c.middleware, logMiddleware(l)) }
+}
+
+// WithQueryTimeout bounds each individual DNS query to d, independently of
+// the evaluation deadline, so one unresponsive nameserver in an include chain
+// fails fast.  A query running out of time is a temporary DNS failure and
+// results in TempError.
+func WithQueryTimeout(d time.Duration) Option {
+	return func(c *Checker) { c.middleware = append(c.middleware, timeoutMiddleware(d)) }
+}
+
+// ExplanationLimits bounds the work spent on exp= explanations (RFC 7208
+// section 6.2).  An explanation exceeding a limit is ignored, as if the record
+// had no exp modifier.  Zero disables a limit.
+type ExplanationLimits struct {
+	MaxRecordSize int // bytes of the fetched TXT record
+	MaxMacros     int // macro-expand terms in the record
+	MaxLength     int // bytes of the expanded explanation
+}
+
+// DefaultExplanationLimits are the limits used unless WithExplanationLimits
+// is given.  They comfortably fit an SMTP reply line.
+var DefaultExplanationLimits = ExplanationLimits{
+	MaxRecordSize: 1024,
+	MaxMacros:     16,
+	MaxLength:     1024,
+}
+
+// WithExplanationLimits replaces DefaultExplanationLimits.
+func WithExplanationLimits(l ExplanationLimits) Option {
+	return func(c *Checker) { c.expLimits = l }
+}
+
+// WithParseLimits replaces parser.DefaultLimits for the records evaluated.
+// A record beyond the limits is a PermError whose Cause matches
+// parser.ErrLimitExceeded.
+func WithParseLimits(l parser.Limits) Option {
+	return func(c *Checker) { c.parseLimits = l }
+}
+
+// VoidPolicy selects the DNS answers counted against the void lookup limit
+// (RFC 7208 section 4.6.4).  NXDOMAIN answers of the a, mx and ptr
+// mechanisms always count.  Note that net.Resolver reports empty answers as
+// not found, so with DNSResolver they count as NXDOMAIN regardless of NoData.
+type VoidPolicy struct {
+	NoData bool // also count successful answers without records
+	Exists bool // count misses of the exists mechanism
+}
+
+// DefaultVoidPolicy counts every void lookup the RFC describes.
+var DefaultVoidPolicy = VoidPolicy{NoData: true, Exists: true}
+
+// WithVoidPolicy replaces DefaultVoidPolicy, e.g. to stop exists probes
+// from causing permerrors.
+func WithVoidPolicy(p VoidPolicy) Option {
+	return func(c *Checker) { c.voidPolicy = p }
+}
+
+// MultipleRecordPolicy selects what a Checker does with a domain publishing
+// several SPF records.
+type MultipleRecordPolicy int
+
+const (
+	// MultipleRecordsPermError makes the evaluation a PermError, as RFC 7208
+	// section 4.5 requires.
+	MultipleRecordsPermError MultipleRecordPolicy = iota
+	// MultipleRecordsFirst evaluates the first record of the DNS answer.
+	// Resolvers may rotate the records of an answer, so the choice is only
+	// stable for a given resolver.
+	MultipleRecordsFirst
+	// MultipleRecordsLongest evaluates the longest record, the smallest in
+	// byte order among records of equal length.
+	MultipleRecordsLongest
+)
+
+var multipleRecordNames = []string{"permerror", "first", "longest"}
+
+// UnmarshalText decodes the name of a policy: "permerror", "first" or
+// "longest".
+func (p *MultipleRecordPolicy) UnmarshalText(text []byte) error {
+	i := slices.Index(multipleRecordNames, strings.ToLower(string(text)))
+	if i < 0 {
+		return fmt.Errorf("unknown multiple record policy %q", text)
+	}
+	*p = MultipleRecordPolicy(i)
+
+	return nil
+}
+
+// WithMultipleRecords makes the Checker evaluate one of the records of a
+// domain publishing several SPF records instead of returning PermError, for
+// receivers preferring a best-effort verdict over rejecting on the
+// publisher's mistake.  The records set aside are listed in the Discarded
+// field of the FetchedRecord evaluated instead, and CheckHostResult.Lenient
+// reports the condition.
+func WithMultipleRecords(p MultipleRecordPolicy) Option {
+	return func(c *Checker) { c.multipleRecords = p }
+}
+
+// Lenient reports whether r was obtained by evaluating one of several SPF
+// records published by a domain, as configured with WithMultipleRecords.
+func (r CheckHostResult) Lenient() bool {
+	for _, rec := range r.Records {
+		if len(rec.Discarded) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selectRecord returns the record of records that p evaluates and the
+// others, all as published.
+func (p MultipleRecordPolicy) selectRecord(records []string) (string, []string) {
+	i := 0
+	if p == MultipleRecordsLongest {
+		for j, rec := range records {
+			if len(rec) > len(records[i]) || len(rec) == len(records[i]) && rec < records[i] {
+				i = j
+			}
+		}
+	}
+	discarded := slices.Delete(slices.Clone(records), i, i+1)
+
+	return records[i], discarded
+}
+
+// WithRecordOverrides makes the Checker use records[domain] as the SPF record
+// of domain instead of querying DNS, wherever domain appears in the tree:
+// as the queried domain or as an include or redirect target.  It lets
+// operators accept the mail of a partner whose published record is broken
+// without changing the result policy for everyone.  An empty text makes the
+// domain behave as publishing no record.  Domain names are matched
+// case-insensitively and without a trailing dot.  The overrides are part of
+// the LocalPolicy and can be replaced at runtime with SetLocalPolicy.
+func WithRecordOverrides(records map[string]string) Option {
+	return func(c *Checker) {
+		var p LocalPolicy
+		if lp := c.local.Load(); lp != nil {
+			p = lp.LocalPolicy
+		}
+		p.Overrides = records
+		c.local.Store(newLocalPolicy(p))
+	}
+}
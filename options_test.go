@@ -0,0 +1,398 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingResolver counts the TXT and A queries sent to a zoneResolver.
+type countingResolver struct {
+	*zoneResolver
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+func newCountingResolver(z *zoneResolver) *countingResolver {
+	return &countingResolver{zoneResolver: z, queries: make(map[string]int)}
+}
+
+func (c *countingResolver) count(key string) {
+	c.mu.Lock()
+	c.queries[key]++
+	c.mu.Unlock()
+}
+
+func (c *countingResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	c.count("TXT " + domain)
+	return c.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func (c *countingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	c.count(network + " " + host)
+	return c.zoneResolver.LookupIP(ctx, network, host)
+}
+
+func optionsZone() *zoneResolver {
+	return &zoneResolver{
+		txt: map[string][]string{
+			"example.com":      {"v=spf1 a:mail.example.com include:_spf.example.com -all"},
+			"_spf.example.com": {"v=spf1 ip4:192.0.2.0/24 -all"},
+		},
+		ip: map[string][]string{"mail.example.com": {"198.51.100.1"}},
+	}
+}
+
+func TestWithMaxLookups(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(optionsZone()), WithMaxLookups(1))
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	require.ErrorIs(t, res.Cause, ErrTooManyLookups)
+}
+
+func TestWithCache(t *testing.T) {
+	counter := newCountingResolver(optionsZone())
+	cache := NewMemoryCache(time.Minute)
+	ch := NewChecker(counter, WithCache(cache))
+	ctx := context.Background()
+
+	for range 3 {
+		res, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+		require.NoError(t, err)
+		assert.Equal(t, Pass, res.Code)
+		res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "missing.example.com", "")
+		require.ErrorIs(t, err, ErrNoDNSrecord)
+		assert.Equal(t, None, res.Code)
+	}
+	assert.Equal(t, map[string]int{
+		"TXT example.com":         1,
+		"ip4 mail.example.com":    1,
+		"TXT _spf.example.com":    1,
+		"TXT missing.example.com": 1,
+	}, counter.queries)
+	assert.Equal(t, 4, cache.Len())
+
+	// entries expire after the TTL
+	now := time.Now()
+	cache.clock = func() time.Time { return now.Add(2 * time.Minute) }
+	_, ok := cache.Get("TXT example.com")
+	assert.False(t, ok)
+}
+
+func TestMemoryCache_Stats(t *testing.T) {
+	cache := NewMemoryCache(time.Minute)
+	ch := NewChecker(NewCustomDNSResolver(optionsZone()), WithCache(cache))
+	ctx := context.Background()
+
+	for range 2 {
+		res, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+		require.NoError(t, err)
+		assert.Equal(t, Pass, res.Code)
+	}
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 3, Entries: 3}, cache.Stats())
+
+	now := time.Now()
+	cache.clock = func() time.Time { return now.Add(2 * time.Minute) }
+	_, ok := cache.Get("TXT example.com")
+	assert.False(t, ok)
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 4, Evictions: 1, Entries: 2}, cache.Stats())
+}
+
+func TestMemoryCache_Concurrent(t *testing.T) {
+	cache := NewMemoryCache(time.Minute)
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				key := "TXT " + strconv.Itoa(g) + "-" + strconv.Itoa(i) + ".example.com"
+				cache.Set(key, i)
+				v, ok := cache.Get(key)
+				assert.True(t, ok)
+				assert.Equal(t, i, v)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1600, cache.Len())
+	assert.Equal(t, CacheStats{Hits: 1600, Entries: 1600}, cache.Stats())
+	used := 0
+	for i := range cache.shards {
+		if len(cache.shards[i].entries) > 0 {
+			used++
+		}
+	}
+	assert.Equal(t, memoryCacheShards, used, "keys spread over every shard")
+}
+
+func TestWithCache_TempErrorNotCached(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24 -all"}}}
+	flaky := &flakyResolver{zoneResolver: zone, failures: 1, calls: make(map[string]int)}
+	ch := NewChecker(flaky, WithCache(NewMemoryCache(time.Minute)))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, TempError, res.Code)
+	res, err = ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+}
+
+func TestWithTrace(t *testing.T) {
+	var events []TraceEvent
+	ch := NewChecker(NewCustomDNSResolver(optionsZone()), WithTrace(func(ev TraceEvent) { events = append(events, ev) }))
+	_, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+
+	assert.Equal(t, []TraceEvent{
+		{Domain: "example.com", Term: "a:mail.example.com"},
+		{Domain: "_spf.example.com", Term: "ip4:192.0.2.0/24", Matched: true},
+		{Domain: "example.com", Term: "include:_spf.example.com", Matched: true},
+	}, events)
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ch := NewChecker(NewCustomDNSResolver(optionsZone()), WithLogger(logger))
+	_, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "msg=\"spf dns query\" type=TXT name=example.com")
+	assert.Contains(t, out, "type=A name=mail.example.com")
+}
+
+// slowResolver answers TXT queries after delay.
+type slowResolver struct {
+	delay time.Duration
+}
+
+func (s slowResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	select {
+	case <-time.After(s.delay):
+		return []string{"v=spf1 -all"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	ch := NewChecker(slowResolver{delay: time.Second}, WithTimeout(10*time.Millisecond))
+	_, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// stallingResolver answers from zone but never for the names in stall.
+type stallingResolver struct {
+	*zoneResolver
+	stall map[string]bool
+}
+
+func (s stallingResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	if s.stall[domain] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return s.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func TestWithTimeout_PartialResult(t *testing.T) {
+	zone := optionsZone()
+	zone.txt["example.com"] = []string{"v=spf1 a:mail.example.com include:slow.example.com -all"}
+	r := stallingResolver{zoneResolver: zone, stall: map[string]bool{"slow.example.com": true}}
+	ch := NewChecker(NewCustomDNSResolver(r), WithTimeout(10*time.Millisecond))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, TempError, res.Code)
+	require.ErrorIs(t, res.Cause, context.DeadlineExceeded)
+	assert.Equal(t, 2, res.Stats.Lookups)
+	assert.Equal(t, 3, res.Stats.Queries)
+	require.Len(t, res.Partial, 2)
+	assert.Equal(t, TraceEvent{Domain: "example.com", Term: "a:mail.example.com"}, res.Partial[0])
+	assert.Equal(t, "include:slow.example.com", res.Partial[1].Term)
+	require.ErrorIs(t, res.Partial[1].Err, context.DeadlineExceeded)
+}
+
+func TestPartialTerms(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(optionsZone()))
+
+	// a context that cannot be canceled never yields a partial result
+	e := ch.newEvaluation(net.ParseIP("192.0.2.1"), "")
+	_, err := ch.run(context.Background(), e, "example.com")
+	require.NoError(t, err)
+	assert.Nil(t, e.evaluated)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e = ch.newEvaluation(net.ParseIP("192.0.2.1"), "")
+	_, err = ch.run(ctx, e, "example.com")
+	require.NoError(t, err)
+	assert.Len(t, e.evaluated, 3)
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	ch := NewChecker(slowResolver{delay: time.Second}, WithQueryTimeout(10*time.Millisecond))
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, TempError, res.Code)
+	require.ErrorIs(t, res.Cause, ErrTempfail)
+
+	ch = NewChecker(slowResolver{delay: time.Millisecond}, WithQueryTimeout(time.Second))
+	res, err = ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+
+	// the evaluation deadline still surfaces as a context error
+	ch = NewChecker(slowResolver{delay: time.Second}, WithQueryTimeout(time.Second), WithTimeout(10*time.Millisecond))
+	_, err = ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// closingCache records whether it was closed.
+type closingCache struct {
+	*MemoryCache
+	closed int
+}
+
+func (c *closingCache) Close() error {
+	c.closed++
+	return nil
+}
+
+type closingResolver struct {
+	*zoneResolver
+	err error
+}
+
+func (c *closingResolver) Close() error { return c.err }
+
+func TestChecker_Close(t *testing.T) {
+	cache := &closingCache{MemoryCache: NewMemoryCache(time.Minute)}
+	resolver := &closingResolver{zoneResolver: optionsZone(), err: errors.New("socket busy")}
+	ch := NewChecker(resolver, WithCache(cache))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	require.EqualError(t, ch.Close(), "socket busy")
+	require.EqualError(t, ch.Close(), "socket busy")
+	assert.Equal(t, 1, cache.closed)
+
+	_, err = ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.ErrorIs(t, err, ErrClosed)
+
+	require.NoError(t, NewChecker(optionsZone()).Close())
+}
+
+func TestCheckHostResult_Stats(t *testing.T) {
+	zone := optionsZone()
+	zone.txt["void.example.com"] = []string{"v=spf1 a:nothing.example.com include:_spf.example.com -all"}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithCache(NewMemoryCache(time.Minute)))
+	ctx := context.Background()
+
+	res, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "void.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	stats := res.Stats
+	assert.Positive(t, stats.Duration)
+	stats.Duration = 0
+	assert.Equal(t, EvalStats{Queries: 3, Lookups: 2, VoidLookups: 1}, stats)
+
+	res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "void.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Stats.CacheHits)
+}
+
+func TestWithRecordOverrides(t *testing.T) {
+	zone := optionsZone()
+	zone.txt["partner.example"] = []string{"v=spf1 ip4:203.0.113.0/33 -all"}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithRecordOverrides(map[string]string{
+		"Partner.Example.":  "v=spf1 ip4:203.0.113.0/24 -all",
+		"_spf.example.com":  "v=spf1 ip4:198.51.100.0/24 -all",
+		"gone.example.com":  "",
+		"fresh.example.com": "v=spf1 +all",
+	}))
+	ctx := context.Background()
+
+	res, err := ch.CheckHost(ctx, net.ParseIP("203.0.113.5"), "partner.example", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Zero(t, res.Stats.Queries)
+
+	// include targets are overridden too
+	res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+
+	res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "fresh.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	res, _ = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "gone.example.com", "")
+	assert.Equal(t, None, res.Code)
+}
+
+func TestWithRecordOverrides_MixedCase(t *testing.T) {
+	record := "V=SPF1 IP4:192.0.2.0/24 Include:_spf.example.com -ALL"
+	zone := optionsZone()
+	zone.txt["dns.example"] = []string{record}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithRecordOverrides(map[string]string{"override.example": record}))
+
+	// an override evaluates like the same record served from DNS
+	for _, domain := range []string{"dns.example", "override.example"} {
+		res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), domain, "")
+		require.NoError(t, err, domain)
+		assert.Equal(t, Pass, res.Code, domain)
+		res, err = ch.CheckHost(context.Background(), net.ParseIP("203.0.113.1"), domain, "")
+		require.NoError(t, err, domain)
+		assert.Equal(t, Fail, res.Code, domain)
+	}
+}
+
+func TestWithMultipleRecords(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":   {"v=spf1 include:twice.example -all"},
+		"twice.example": {"v=spf1 ip4:192.0.2.0/24 -all", "v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.0/24 -all", "unrelated"},
+	}}
+	r := NewCustomDNSResolver(zone)
+	ctx := context.Background()
+	ip := net.ParseIP("198.51.100.1")
+
+	res, err := NewChecker(r).CheckHost(ctx, ip, "twice.example", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	assert.False(t, res.Lenient())
+
+	res, err = NewChecker(r, WithMultipleRecords(MultipleRecordsFirst)).CheckHost(ctx, ip, "twice.example", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.True(t, res.Lenient())
+	require.Len(t, res.Records, 1)
+	assert.Equal(t, []string{"v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.0/24 -all"}, res.Records[0].Discarded)
+
+	// include targets are selected among too
+	res, err = NewChecker(r, WithMultipleRecords(MultipleRecordsLongest)).CheckHost(ctx, ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.True(t, res.Lenient())
+	require.Len(t, res.Records, 2)
+	assert.Empty(t, res.Records[0].Discarded)
+	assert.Equal(t, "v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.0/24 -all", res.Records[1].Text)
+	assert.Equal(t, []string{"v=spf1 ip4:192.0.2.0/24 -all"}, res.Records[1].Discarded)
+}
@@ -0,0 +1,139 @@
+package spf
+
+import (
+	"context"
+	"slices"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// Overlap is an authorized network that another term of the policy already
+// authorizes, wholly.
+type Overlap struct {
+	Covered AuthorizedNetwork
+	By      AuthorizedNetwork // the widest network containing Covered
+}
+
+// RedundantInclude is an include of the queried domain's record whose
+// networks are all authorized by other terms, so that removing it keeps the
+// authorized ranges and saves Lookups DNS lookups.
+type RedundantInclude struct {
+	Term    string // e.g. "include:_spf.vendor.example"
+	Lookups int    // the include itself and the lookups below it
+}
+
+// OverlapReport is the result of AnalyzeOverlaps.
+type OverlapReport struct {
+	Inventory *NetworkInventory
+	Overlaps  []Overlap
+	Redundant []RedundantInclude
+}
+
+// AnalyzeOverlaps lists the networks authorized by the policy of domain, as
+// ListAuthorizedNetworks does, and reports the networks authorized along more
+// than one path, e.g. by an ip4 term and again by an include, and the
+// includes of domain's own record that contribute nothing else.  An include
+// with a term that cannot be enumerated, such as exists or a missing record,
+// is never redundant.  Like ListAuthorizedNetworks, the analysis ignores
+// term order: an include is only safe to remove when no "-" or "~" term
+// before the covering term shadows it.
+func AnalyzeOverlaps(ctx context.Context, r TXTResolver, domain string) (*OverlapReport, error) {
+	g, err := BuildGraph(ctx, r, domain)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := listNetworks(ctx, r, g)
+	if err != nil {
+		return nil, err
+	}
+	report := &OverlapReport{Inventory: inv}
+
+	nets := inv.Networks
+	for i, covered := range nets {
+		by := -1
+		for j, n := range nets {
+			switch {
+			case i == j, n.Domain == covered.Domain && n.Term == covered.Term:
+				continue
+			case n.Prefix == covered.Prefix && j > i:
+				continue // reported the other way round
+			case !covers(n, covered):
+				continue
+			}
+			if by < 0 || n.Prefix.Bits() < nets[by].Prefix.Bits() {
+				by = j
+			}
+		}
+		if by >= 0 {
+			report.Overlaps = append(report.Overlaps, Overlap{Covered: covered, By: nets[by]})
+		}
+	}
+
+	root := g.Nodes[g.Root]
+	for _, edge := range root.Edges {
+		if edge.Kind != "include" || edge.Qual != parser.QPlus || edge.Macro {
+			continue
+		}
+		if report.redundant(g, edge.Target) {
+			report.Redundant = append(report.Redundant, RedundantInclude{
+				Term:    edge.term(),
+				Lookups: 1 + g.lookupsFrom(edge.Target, map[string]bool{g.Root: true}),
+			})
+		}
+	}
+
+	return report, nil
+}
+
+// redundant reports whether every network listed through the include of
+// target is covered by a network listed through another path.
+func (r *OverlapReport) redundant(g *Graph, target string) bool {
+	below := reachable(g, target, g.Root)
+	for _, u := range r.Inventory.Unresolved {
+		if below[u.Domain] {
+			return false
+		}
+	}
+	var inside, outside []AuthorizedNetwork
+	for _, n := range r.Inventory.Networks {
+		if len(n.Path) > 1 && n.Path[1] == target {
+			inside = append(inside, n)
+		} else {
+			outside = append(outside, n)
+		}
+	}
+
+	return !slices.ContainsFunc(inside, func(n AuthorizedNetwork) bool {
+		return !slices.ContainsFunc(outside, func(o AuthorizedNetwork) bool { return covers(o, n) })
+	})
+}
+
+// reachable returns domain and the domains its record references, directly
+// or not, without passing through stop.
+func reachable(g *Graph, domain, stop string) map[string]bool {
+	seen := map[string]bool{stop: true}
+	queue := []string{domain}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		if node, ok := g.Nodes[d]; ok {
+			for _, edge := range node.Edges {
+				if !edge.Macro {
+					queue = append(queue, edge.Target)
+				}
+			}
+		}
+	}
+	delete(seen, stop)
+
+	return seen
+}
+
+// covers reports whether the network of a contains that of b.
+func covers(a, b AuthorizedNetwork) bool {
+	return a.Prefix.Bits() <= b.Prefix.Bits() && a.Prefix.Contains(b.Prefix.Addr())
+}
@@ -0,0 +1,59 @@
+package spf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func overlapZone() *zoneResolver {
+	return &zoneResolver{
+		txt: map[string][]string{
+			"example.com":         {"v=spf1 ip4:192.0.2.0/24 a:mail.example.com include:old.example.net include:cloud.example.net include:dyn.example.net -all"},
+			"old.example.net":     {"v=spf1 ip4:192.0.2.128/25 include:_old2.example.net -all"},
+			"_old2.example.net":   {"v=spf1 a:relay.example.net -all"},
+			"cloud.example.net":   {"v=spf1 ip4:198.51.100.0/24 ip4:192.0.2.7 -all"},
+			"dyn.example.net":     {"v=spf1 ip4:192.0.2.9 exists:%{i}.dyn.example.net -all"},
+			"mail.example.com":    {},
+			"relay.example.net":   {},
+			"nothing.example.org": {"v=spf1 ip4:203.0.113.0/24 -all"},
+		},
+		ip: map[string][]string{
+			"mail.example.com":  {"192.0.2.25"},
+			"relay.example.net": {"192.0.2.200"},
+		},
+	}
+}
+
+func TestAnalyzeOverlaps(t *testing.T) {
+	report, err := AnalyzeOverlaps(context.Background(), overlapZone(), "example.com")
+	require.NoError(t, err)
+	require.NotNil(t, report.Inventory)
+
+	type pair struct{ covered, by string }
+	var pairs []pair
+	for _, o := range report.Overlaps {
+		assert.Equal(t, "192.0.2.0/24", o.By.Prefix.String())
+		pairs = append(pairs, pair{o.Covered.Domain + " " + o.Covered.Term, o.By.Domain + " " + o.By.Term})
+	}
+	assert.Equal(t, []pair{
+		{"example.com a:mail.example.com", "example.com ip4:192.0.2.0/24"},
+		{"old.example.net ip4:192.0.2.128/25", "example.com ip4:192.0.2.0/24"},
+		{"_old2.example.net a:relay.example.net", "example.com ip4:192.0.2.0/24"},
+		{"cloud.example.net ip4:192.0.2.7/32", "example.com ip4:192.0.2.0/24"},
+		{"dyn.example.net ip4:192.0.2.9/32", "example.com ip4:192.0.2.0/24"},
+	}, pairs)
+
+	// cloud adds 198.51.100.0/24 and dyn an exists term
+	assert.Equal(t, []RedundantInclude{{Term: "include:old.example.net", Lookups: 3}}, report.Redundant)
+
+	report, err = AnalyzeOverlaps(context.Background(), overlapZone(), "nothing.example.org")
+	require.NoError(t, err)
+	assert.Empty(t, report.Overlaps)
+	assert.Empty(t, report.Redundant)
+
+	_, err = AnalyzeOverlaps(context.Background(), overlapZone(), "bad..example")
+	require.Error(t, err)
+}
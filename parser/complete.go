@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Completion is a candidate for the term being typed in a record editor.
+type Completion struct {
+	Label    string // text replacing the typed prefix, e.g. "-all" or "include:"
+	Template string // full syntax with placeholders, e.g. "include:<domain-spec>"
+	Detail   string // one-line description
+}
+
+// TermDoc documents one mechanism or modifier.
+type TermDoc struct {
+	Name     string
+	Modifier bool
+	Template string
+	Detail   string
+	Section  string // RFC 7208 section
+}
+
+// TermDocs describes the mechanisms and modifiers of RFC 7208, mechanisms
+// first.
+var TermDocs = []TermDoc{
+	{Name: "all", Template: "all", Detail: "matches every client; ends the record", Section: "5.1"},
+	{Name: "include", Template: "include:<domain-spec>", Detail: "matches when the policy of another domain passes", Section: "5.2"},
+	{Name: "a", Template: "a[:<domain-spec>][/<ip4-cidr>][//<ip6-cidr>]", Detail: "matches the A or AAAA records of a domain", Section: "5.3"},
+	{Name: "mx", Template: "mx[:<domain-spec>][/<ip4-cidr>][//<ip6-cidr>]", Detail: "matches the addresses of a domain's mail exchangers", Section: "5.4"},
+	{Name: "ptr", Template: "ptr[:<domain-spec>]", Detail: "matches validated reverse DNS names; discouraged", Section: "5.5"},
+	{Name: "ip4", Template: "ip4:<ip4-network>[/<prefix-length>]", Detail: "matches an IPv4 network", Section: "5.6"},
+	{Name: "ip6", Template: "ip6:<ip6-network>[/<prefix-length>]", Detail: "matches an IPv6 network", Section: "5.6"},
+	{Name: "exists", Template: "exists:<domain-spec>", Detail: "matches when the domain has an A record", Section: "5.7"},
+	{Name: "redirect", Modifier: true, Template: "redirect=<domain-spec>", Detail: "uses the policy of another domain when nothing matches", Section: "6.1"},
+	{Name: "exp", Modifier: true, Template: "exp=<domain-spec>", Detail: "names the TXT record explaining a fail result", Section: "6.2"},
+}
+
+// qualifierDetails describes the qualifiers of RFC 7208 section 4.6.2.
+var qualifierDetails = map[Qualifier]string{
+	QPlus:  "pass on match (default)",
+	QMinus: "fail on match",
+	QTilde: "softfail on match",
+	QMark:  "neutral on match",
+}
+
+// Complete returns the completions for the term under the cursor, a byte
+// offset into record, together with the offset where the typed prefix
+// starts; an editor replaces record[start:cursor] with the chosen Label.
+// It offers the version tag, qualifiers, mechanisms and modifiers not yet
+// present.  Arguments after ":" or "=" are not completed.
+func Complete(record string, cursor int) (start int, items []Completion) {
+	cursor = max(0, min(cursor, len(record)))
+	start = strings.LastIndexAny(record[:cursor], " \t") + 1
+	prefix := record[start:cursor]
+
+	if strings.TrimSpace(record[:start]) == "" {
+		if strings.HasPrefix("v=spf1", strings.ToLower(prefix)) {
+			items = append(items, Completion{Label: "v=spf1", Template: "v=spf1", Detail: "SPF version 1, must come first"})
+		}
+		return start, items
+	}
+	if strings.ContainsAny(prefix, ":=/") {
+		return start, nil
+	}
+
+	_, name := stripQualifier(prefix)
+	qual := prefix[:len(prefix)-len(name)]
+	if prefix == "" {
+		for _, q := range []Qualifier{QMinus, QTilde, QMark, QPlus} {
+			items = append(items, Completion{Label: string(rune(q)), Template: string(rune(q)) + "<mechanism>", Detail: qualifierDetails[q]})
+		}
+	}
+
+	present := presentTerms(record[:start] + " " + record[cursor:])
+	name = strings.ToLower(name)
+	for _, doc := range TermDocs {
+		if !strings.HasPrefix(doc.Name, name) || present[doc.Name] && (doc.Modifier || doc.Name == "all") {
+			continue
+		}
+		switch {
+		case doc.Modifier && qual != "":
+			continue
+		case doc.Modifier:
+			items = append(items, Completion{Label: doc.Name + "=", Template: doc.Template, Detail: doc.Detail})
+		case doc.Name == "all" || doc.Name == "a" || doc.Name == "mx" || doc.Name == "ptr":
+			items = append(items, Completion{Label: qual + doc.Name, Template: qual + doc.Template, Detail: doc.Detail})
+			if doc.Name != "all" {
+				items = append(items, Completion{Label: qual + doc.Name + ":", Template: qual + doc.Template, Detail: doc.Detail})
+			}
+		default:
+			items = append(items, Completion{Label: qual + doc.Name + ":", Template: qual + doc.Template, Detail: doc.Detail})
+		}
+	}
+
+	return start, items
+}
+
+// presentTerms returns the names of the mechanisms and modifiers used in
+// text.
+func presentTerms(text string) map[string]bool {
+	present := make(map[string]bool)
+	for tok := range Terms(text) {
+		_, rest := stripQualifier(tok)
+		if end := strings.IndexAny(rest, ":=/"); end >= 0 {
+			rest = rest[:end]
+		}
+		present[strings.ToLower(rest)] = true
+	}
+
+	return present
+}
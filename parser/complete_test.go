@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func labels(items []Completion) []string {
+	var out []string
+	for _, c := range items {
+		out = append(out, c.Label)
+	}
+	return out
+}
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		name      string
+		record    string
+		cursor    int
+		wantStart int
+		want      []string
+	}{
+		{"version", "v=s", 3, 0, []string{"v=spf1"}},
+		{"not a version", "x", 1, 0, nil},
+		{"mechanism prefix", "v=spf1 i", 8, 7, []string{"include:", "ip4:", "ip6:"}},
+		{"qualified", "v=spf1 -a", 9, 7, []string{"-all", "-a", "-a:"}},
+		{"modifiers not qualified", "v=spf1 ~r", 9, 7, nil},
+		{"modifier", "v=spf1 mx r", 11, 10, []string{"redirect="}},
+		{"present modifier", "v=spf1 e redirect=example.com", 8, 7, []string{"exists:", "exp="}},
+		{"present all", "v=spf1 al -all", 9, 7, nil},
+		{"argument", "v=spf1 include:_s", 17, 7, nil},
+		{"cursor clamped", "v=spf1 ex", 99, 7, []string{"exists:", "exp="}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, items := Complete(tt.record, tt.cursor)
+			assert.Equal(t, tt.wantStart, start)
+			assert.Equal(t, tt.want, labels(items))
+		})
+	}
+}
+
+func TestComplete_Empty(t *testing.T) {
+	start, items := Complete("v=spf1 ", 7)
+	assert.Equal(t, 7, start)
+	got := labels(items)
+	assert.Equal(t, []string{"-", "~", "?", "+"}, got[:4])
+	assert.Contains(t, got, "redirect=")
+	assert.Equal(t, "include:<domain-spec>", items[5].Template)
+}
@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrLabelHyphen is reported by CheckDomain for a label starting or ending
+// with a hyphen, or with hyphens in the third and fourth position without
+// being an A-label (RFC 5891 section 4.2.3.1).  ValidateDomain reports these
+// labels as ErrIDNAConversion.
+var ErrLabelHyphen = errors.New("domain label has a misplaced hyphen")
+
+// DomainViolation is one check of RFC 7208 section 4.3 failed by a domain
+// name.
+type DomainViolation struct {
+	Label int    // index of the offending label, -1 when about the whole name
+	Text  string // the offending label as given, "" when Label is -1
+	Err   error  // one of the errors of ValidateDomain, or ErrLabelHyphen
+}
+
+func (v DomainViolation) String() string {
+	if v.Label < 0 {
+		return v.Err.Error()
+	}
+
+	return fmt.Sprintf("label %d %q: %v", v.Label+1, v.Text, v.Err)
+}
+
+// DomainReport is the result of CheckDomain.
+type DomainReport struct {
+	Input string
+	// Normalized is the lower-case A-label form of Input as returned by
+	// ValidateDomain; labels failing the IDNA conversion are lower-cased
+	// only.
+	Normalized string
+	Violations []DomainViolation
+}
+
+// Valid reports whether the name passed every check.
+func (r DomainReport) Valid() bool {
+	return len(r.Violations) == 0
+}
+
+// CheckDomain runs the checks of ValidateDomainWith and reports every
+// violation instead of stopping at the first, for zone linting tools.  A
+// name is valid exactly when ValidateDomainWith accepts it.  When the IDNA
+// conversion fails, labels are checked one at a time so that the offending
+// ones are named, with letters, digits and hyphens checked directly in ASCII
+// labels.
+func CheckDomain(raw string, opts ValidateOptions) DomainReport {
+	r := DomainReport{Input: raw}
+	name := strings.TrimSuffix(strings.TrimSpace(raw), ".")
+	profile := opts.Profile
+	if profile == nil {
+		profile = idna.Lookup
+	}
+
+	var labels []string
+	ascii := name
+	if opts.Profile != nil || !isLowerLDH(name) {
+		var err error
+		ascii, err = profile.ToASCII(name)
+		ascii = strings.ToLower(ascii)
+		if err != nil {
+			labels = strings.Split(name, ".")
+			for i, lbl := range labels {
+				if labels[i], err = labelToASCII(lbl, profile); err != nil {
+					r.Violations = append(r.Violations, DomainViolation{Label: i, Text: lbl, Err: err})
+					labels[i] = strings.ToLower(lbl)
+				}
+			}
+			if len(r.Violations) == 0 {
+				r.Violations = append(r.Violations, DomainViolation{Label: -1, Err: ErrIDNAConversion})
+			}
+			ascii = strings.Join(labels, ".")
+		}
+	}
+	if labels == nil {
+		labels = strings.Split(ascii, ".")
+	}
+	r.Normalized = ascii
+
+	if len(ascii) > 255 {
+		r.Violations = append(r.Violations, DomainViolation{Label: -1, Err: ErrDomainTooLong})
+	}
+	if len(labels) < 2 && !opts.AllowSingleLabel {
+		r.Violations = append(r.Violations, DomainViolation{Label: -1, Err: ErrSingleLabel})
+	}
+	for i, lbl := range labels {
+		switch {
+		case len(lbl) == 0:
+			r.Violations = append(r.Violations, DomainViolation{Label: i, Err: ErrEmptyLabel})
+		case len(lbl) > 63:
+			r.Violations = append(r.Violations, DomainViolation{Label: i, Text: lbl, Err: ErrLabelTooLong})
+		}
+	}
+
+	return r
+}
+
+// labelToASCII converts one label of a name failing the IDNA conversion.
+// ASCII labels other than A-labels are checked for letters, digits and
+// hyphens, which names the problem more precisely than the conversion.
+func labelToASCII(lbl string, profile *idna.Profile) (string, error) {
+	if lbl == "" {
+		return "", nil // reported as an empty label
+	}
+	lower := strings.ToLower(lbl)
+	if strings.ContainsFunc(lbl, func(r rune) bool { return r >= 0x80 }) || strings.HasPrefix(lower, "xn--") {
+		a, err := profile.ToASCII(lbl)
+		if err != nil {
+			return "", ErrIDNAConversion
+		}
+		return strings.ToLower(a), nil
+	}
+	for i := 0; i < len(lower); i++ {
+		c := lower[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return "", ErrInvalidChar
+		}
+	}
+	if lower[0] == '-' || lower[len(lower)-1] == '-' || len(lower) >= 4 && lower[2:4] == "--" {
+		return "", ErrLabelHyphen
+	}
+
+	return lower, nil
+}
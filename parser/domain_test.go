@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/idna"
+)
+
+func TestCheckDomain(t *testing.T) {
+	long := strings.Repeat("a", 64)
+	cases := []struct {
+		in         string
+		normalized string
+		errs       []error
+	}{
+		{"Mail.Example.COM.", "mail.example.com", nil},
+		{"bücher.example", "xn--bcher-kva.example", nil},
+		{"localhost", "localhost", []error{ErrSingleLabel}},
+		{"a..example.com", "a..example.com", []error{ErrEmptyLabel}},
+		{"-bad.under_score.ab--c.example", "-bad.under_score.ab--c.example", []error{ErrLabelHyphen, ErrInvalidChar, ErrLabelHyphen}},
+		{"bad-.." + long, "bad-.." + long, []error{ErrLabelHyphen, ErrEmptyLabel, ErrLabelTooLong}},
+		{strings.Repeat("abcdefgh.", 30) + "com", strings.Repeat("abcdefgh.", 30) + "com", []error{ErrDomainTooLong}},
+	}
+	for _, c := range cases {
+		r := CheckDomain(c.in, ValidateOptions{})
+		assert.Equal(t, c.normalized, r.Normalized, c.in)
+		var errs []error
+		for _, v := range r.Violations {
+			errs = append(errs, v.Err)
+		}
+		assert.Equal(t, c.errs, errs, c.in)
+		assert.Equal(t, len(c.errs) == 0, r.Valid(), c.in)
+
+		// agrees with ValidateDomain on validity and the first-checked form
+		ascii, err := ValidateDomain(c.in)
+		assert.Equal(t, err == nil, r.Valid(), c.in)
+		if err == nil {
+			assert.Equal(t, ascii, r.Normalized)
+		}
+	}
+
+	r := CheckDomain("-bad.under_score.example", ValidateOptions{})
+	require.Len(t, r.Violations, 2)
+	assert.Equal(t, DomainViolation{Label: 0, Text: "-bad", Err: ErrLabelHyphen}, r.Violations[0])
+	assert.Equal(t, `label 2 "under_score": domain label contains invalid character`, r.Violations[1].String())
+
+	assert.True(t, CheckDomain("localhost", ValidateOptions{AllowSingleLabel: true}).Valid())
+	assert.True(t, CheckDomain("bücher.example", ValidateOptions{Profile: idna.Registration}).Valid())
+}
@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrTermNotFound is returned by the editing methods of Record when the
+// referenced term is not part of the record.
+var ErrTermNotFound = errors.New("term not found")
+
+// String returns the record text: the version followed by Terms.
+func (r *Record) String() string {
+	return strings.Join(append([]string{"v=spf1"}, r.Terms...), " ")
+}
+
+// Index returns the position in Terms of the first term equal to term, or
+// -1.  Terms are compared case-insensitively and a "+" qualifier is
+// insignificant, so "MX" finds "+mx".
+func (r *Record) Index(term string) int {
+	want := strings.TrimPrefix(term, "+")
+	for i, t := range r.Terms {
+		if strings.EqualFold(strings.TrimPrefix(t, "+"), want) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// InsertBefore inserts term in front of the term before, e.g. a new include
+// in front of "-all".  An empty before appends term.
+func (r *Record) InsertBefore(before, term string) error {
+	i := len(r.Terms)
+	if before != "" {
+		if i = r.Index(before); i < 0 {
+			return fmt.Errorf("%w: %s", ErrTermNotFound, before)
+		}
+	}
+
+	return r.edit(slices.Insert(slices.Clone(r.Terms), i, term))
+}
+
+// Remove removes the first term equal to term.
+func (r *Record) Remove(term string) error {
+	i := r.Index(term)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrTermNotFound, term)
+	}
+
+	return r.edit(slices.Delete(slices.Clone(r.Terms), i, i+1))
+}
+
+// ReplaceMechanism replaces the first term equal to old with term, keeping
+// its position.
+func (r *Record) ReplaceMechanism(old, term string) error {
+	i := r.Index(old)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrTermNotFound, old)
+	}
+	terms := slices.Clone(r.Terms)
+	terms[i] = term
+
+	return r.edit(terms)
+}
+
+// edit replaces the terms of r after checking that they form a valid
+// record.  On error r is left unchanged.  Untouched terms keep their raw
+// text.
+func (r *Record) edit(terms []string) error {
+	for _, t := range terms {
+		if t == "" || strings.ContainsAny(t, " \t") {
+			return fmt.Errorf("invalid term %q", t)
+		}
+	}
+	rec, err := Parse(strings.Join(append([]string{"v=spf1"}, terms...), " "))
+	if err != nil {
+		return err
+	}
+	*r = *rec
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord_Edit(t *testing.T) {
+	const raw = "v=spf1 ip4:192.0.2.0/24 +mx include:_spf.Example.com ~all exp=Exp.example.com"
+
+	tests := []struct {
+		name string
+		edit func(r *Record) error
+		want string
+	}{
+		{"insert before all", func(r *Record) error { return r.InsertBefore("~ALL", "include:esp.example.net") },
+			"v=spf1 ip4:192.0.2.0/24 +mx include:_spf.Example.com include:esp.example.net ~all exp=Exp.example.com"},
+		{"append", func(r *Record) error { return r.InsertBefore("", "foo=bar") },
+			"v=spf1 ip4:192.0.2.0/24 +mx include:_spf.Example.com ~all exp=Exp.example.com foo=bar"},
+		{"remove implicit plus", func(r *Record) error { return r.Remove("MX") },
+			"v=spf1 ip4:192.0.2.0/24 include:_spf.Example.com ~all exp=Exp.example.com"},
+		{"replace", func(r *Record) error { return r.ReplaceMechanism("~all", "-all") },
+			"v=spf1 ip4:192.0.2.0/24 +mx include:_spf.Example.com -all exp=Exp.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, err := Parse(raw)
+			require.NoError(t, err)
+			require.NoError(t, tt.edit(rec))
+			assert.Equal(t, tt.want, rec.String())
+
+			reparsed, err := Parse(tt.want)
+			require.NoError(t, err)
+			assert.Equal(t, reparsed, rec)
+		})
+	}
+}
+
+func TestRecord_EditErrors(t *testing.T) {
+	rec, err := Parse("v=spf1 mx -all")
+	require.NoError(t, err)
+
+	require.ErrorIs(t, rec.Remove("a"), ErrTermNotFound)
+	require.ErrorIs(t, rec.InsertBefore("?all", "a"), ErrTermNotFound)
+	require.ErrorIs(t, rec.ReplaceMechanism("ptr", "a"), ErrTermNotFound)
+	require.Error(t, rec.InsertBefore("-all", "ip4:192.0.2.300"))
+	require.Error(t, rec.InsertBefore("-all", "a mx"))
+	require.NoError(t, rec.InsertBefore("", "exp=exp.example.com"))
+	require.ErrorIs(t, rec.InsertBefore("", "exp=exp.example.net"), ErrDuplicateModifier)
+	require.NoError(t, rec.Remove("exp=exp.example.com"))
+	assert.Equal(t, "v=spf1 mx -all", rec.String(), "failed edits leave the record unchanged")
+}
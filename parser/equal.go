@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"slices"
+	"strings"
+)
+
+// Equal reports whether r and other state the same policy: the same
+// mechanisms in the same order and the same modifiers.  Differences that do
+// not change evaluation are ignored: a "+" qualifier, the case of domain
+// names outside macro expressions, a trailing dot, CIDR lengths equal to the
+// defaults of /32 and /128, the position of modifiers, which RFC 7208
+// section 6 leaves insignificant, and the spacing kept in Terms.  Two nil
+// records are equal.
+func (r *Record) Equal(other *Record) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	if len(r.Mechs) != len(other.Mechs) {
+		return false
+	}
+	for i := range r.Mechs {
+		if r.Mechs[i].canonical() != other.Mechs[i].canonical() {
+			return false
+		}
+	}
+
+	return modifierText(r.Redirect) == modifierText(other.Redirect) &&
+		modifierText(r.Exp) == modifierText(other.Exp) &&
+		slices.Equal(unknownTexts(r.Unknown), unknownTexts(other.Unknown))
+}
+
+// canonical returns m in record syntax with the differences ignored by
+// Record.Equal removed.
+func (m Mechanism) canonical() string {
+	q := m.Qual
+	if q == 0 {
+		q = QPlus
+	}
+	m.Qual = QPlus
+	if m.Mask4 == 32 {
+		m.Mask4 = -1
+	}
+	if m.Mask6 == 128 {
+		m.Mask6 = -1
+	}
+	m.Domain = foldDomainSpec(m.Domain)
+
+	return q.String() + m.String()
+}
+
+// modifierText returns mod as "name=value" with its value folded, or "".
+func modifierText(mod *Modifier) string {
+	if mod == nil {
+		return ""
+	}
+
+	return strings.ToLower(mod.Name) + "=" + foldDomainSpec(mod.Value)
+}
+
+// unknownTexts returns the texts of unknown modifiers, sorted.
+func unknownTexts(mods []Modifier) []string {
+	out := make([]string, len(mods))
+	for i := range mods {
+		out[i] = modifierText(&mods[i])
+	}
+	slices.Sort(out)
+
+	return out
+}
+
+// foldDomainSpec lower-cases the domain-spec spec, except for macro
+// expressions whose letter case selects URL escaping (RFC 7208 section
+// 7.3), and removes a trailing dot.
+func foldDomainSpec(spec string) string {
+	var b strings.Builder
+	for rest := spec; rest != ""; {
+		i := strings.Index(rest, "%{")
+		j := strings.IndexByte(rest[max(i, 0):], '}')
+		if i < 0 || j < 0 {
+			b.WriteString(strings.ToLower(rest))
+			break
+		}
+		b.WriteString(strings.ToLower(rest[:i]))
+		b.WriteString(rest[i : i+j+1])
+		rest = rest[i+j+1:]
+	}
+
+	return strings.TrimSuffix(b.String(), ".")
+}
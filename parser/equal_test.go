@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord_Equal(t *testing.T) {
+	parse := func(s string) *Record {
+		r, err := Parse(s)
+		require.NoError(t, err, s)
+		return r
+	}
+	cases := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"v=spf1 +mx include:_spf.Example.COM -all", "v=spf1  mx   include:_spf.example.com. -all", true},
+		{"v=spf1 a/32 mx:mail.example.com/32//128 ip4:192.0.2.1 -all", "v=spf1 a mx:mail.example.com ip4:192.0.2.1/32 -all", true},
+		{"v=spf1 redirect=_spf.example.com exp=exp.example.com", "v=spf1 exp=exp.example.com redirect=_spf.example.com", true},
+		{"v=spf1 -all foo=1 bar=2", "v=spf1 bar=2 -all foo=1", true},
+		{"v=spf1 exists:%{i}.Bl.example -all", "v=spf1 exists:%{i}.bl.example -all", true},
+		{"v=spf1 exists:%{i}.bl.example -all", "v=spf1 exists:%{I}.bl.example -all", false},
+		{"v=spf1 mx -all", "v=spf1 mx ~all", false},
+		{"v=spf1 mx a -all", "v=spf1 a mx -all", false},
+		{"v=spf1 a/24 -all", "v=spf1 a -all", false},
+		{"v=spf1 mx -all", "v=spf1 mx -all exp=exp.example.com", false},
+		{"v=spf1 -all foo=1", "v=spf1 -all", false},
+		{"v=spf1 ip4:192.0.2.0/24", "v=spf1 ip4:192.0.2.0/24 -all", false},
+	}
+	for _, c := range cases {
+		a, b := parse(c.a), parse(c.b)
+		assert.Equal(t, c.equal, a.Equal(b), "%s == %s", c.a, c.b)
+		assert.Equal(t, c.equal, b.Equal(a), "%s == %s", c.b, c.a)
+	}
+
+	var none *Record
+	assert.True(t, none.Equal(nil))
+	assert.False(t, none.Equal(parse("v=spf1 -all")))
+	assert.False(t, parse("v=spf1 -all").Equal(nil))
+}
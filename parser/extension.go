@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"slices"
+	"strings"
+)
+
+// ParseExtended is ParseWith also accepting the site-specific mechanisms
+// named in mechanisms, such as "x-geo", which are otherwise unknown
+// mechanisms and a PermError (RFC 7208 section 5).  A term
+// "[qualifier]name[:argument]" for one of them gives a Mechanism whose Kind
+// is the name in lower case and whose Domain is the argument, kept verbatim
+// and marked Macro when it contains macros.  Names are matched
+// case-insensitively; those of the RFC 7208 mechanisms keep their meaning.
+func ParseExtended(rawTXT string, l Limits, mechanisms []string) (*Record, error) {
+	return parseWith(rawTXT, l, mechanisms)
+}
+
+// parseExtension parses rest, a term without its qualifier, as one of the
+// site-specific mechanisms named in ext, returning nil for other terms.
+func parseExtension(q Qualifier, rest string, ext []string) *Mechanism {
+	name, arg, _ := strings.Cut(rest, ":")
+	i := slices.IndexFunc(ext, func(e string) bool { return strings.EqualFold(e, name) })
+	if i < 0 || !isName(name) {
+		return nil
+	}
+
+	return &Mechanism{
+		Qual:   q,
+		Kind:   strings.ToLower(ext[i]),
+		Domain: arg,
+		Mask4:  -1,
+		Mask6:  -1,
+		Macro:  strings.ContainsRune(arg, '%'),
+	}
+}
+
+// isName reports whether s is a name of RFC 7208 section 12:
+// ALPHA *( ALPHA / DIGIT / "-" / "_" / "." ).
+func isName(s string) bool {
+	if s == "" || !isAlpha(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if !isAlpha(c) && (c < '0' || c > '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
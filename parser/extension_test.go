@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtended(t *testing.T) {
+	ext := []string{"x-geo", "X-Rate"}
+	rec, err := ParseExtended("v=spf1 -x-geo:%{i}.eu x-rate ip4:192.0.2.0/24 ~all", DefaultLimits, ext)
+	require.NoError(t, err)
+	require.Len(t, rec.Mechs, 4)
+	assert.Equal(t, Mechanism{Qual: QMinus, Kind: "x-geo", Domain: "%{i}.eu", Mask4: -1, Mask6: -1, Macro: true}, rec.Mechs[0])
+	assert.Equal(t, "x-rate", rec.Mechs[1].Kind)
+	assert.Equal(t, "-x-geo:%{i}.eu", rec.Mechs[0].String())
+	assert.Equal(t, "ip4", rec.Mechs[2].Kind, "standard mechanisms are unaffected")
+
+	_, err = ParseExtended("v=spf1 x-geo:eu -all", DefaultLimits, nil)
+	require.ErrorIs(t, err, ErrUnknownMechanism, "unregistered names stay unknown")
+	_, err = ParseExtended("v=spf1 x-other:eu -all", DefaultLimits, ext)
+	require.ErrorIs(t, err, ErrUnknownMechanism)
+	_, err = ParseExtended("v=spf1 x-geo/24 -all", DefaultLimits, ext)
+	require.Error(t, err)
+
+	rec, err = ParseExtended("v=spf1 x-geo=eu -all", DefaultLimits, ext)
+	require.NoError(t, err)
+	assert.Equal(t, []Modifier{{Name: "x-geo", Value: "eu"}}, rec.Unknown, "modifiers of the same name are modifiers")
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrLimitExceeded is matched by the errors ParseWith returns for records
+// beyond its Limits.
+var ErrLimitExceeded = errors.New("permerror: record exceeds parser limits")
+
+// Limits bounds the records accepted by ParseWith.  SPF records are DNS data
+// under the control of whoever sends the mail, so the limits are checked
+// before any term is parsed.  Zero disables a limit.
+type Limits struct {
+	MaxLength int // bytes of the record
+	MaxTerms  int // terms after the version
+	// MaxMacroLength bounds the bytes of the macro-string of a term: the
+	// domain-spec of a mechanism or the value of a modifier.
+	MaxMacroLength int
+	// MaxLabels bounds the dot-separated labels of a term's domain-spec.
+	MaxLabels int
+}
+
+// DefaultLimits are the limits used by Parse.  They are far above anything
+// published in practice: a domain has at most 127 labels, and a record
+// fitting the 10 DNS lookups of RFC 7208 section 4.6.4 rarely needs more than
+// a few hundred bytes.
+var DefaultLimits = Limits{
+	MaxLength:      8192,
+	MaxTerms:       512,
+	MaxMacroLength: 1024,
+	MaxLabels:      128,
+}
+
+// ParseWith is Parse with the input bounded by l instead of DefaultLimits.
+func ParseWith(rawTXT string, l Limits) (*Record, error) {
+	return parseWith(rawTXT, l, nil)
+}
+
+// parseWith parses rawTXT within l, accepting the mechanisms named in ext.
+func parseWith(rawTXT string, l Limits, ext []string) (*Record, error) {
+	if l.MaxLength > 0 && len(rawTXT) > l.MaxLength {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrLimitExceeded, len(rawTXT), l.MaxLength)
+	}
+	tokens, err := tokenizer(rawTXT, l)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTokens(tokens, ext)
+}
+
+// checkTerm applies the per-term limits to tok, the n-th term of a record
+// counting from 1.
+func (l Limits) checkTerm(tok string, n int) error {
+	if l.MaxTerms > 0 && n > l.MaxTerms {
+		return fmt.Errorf("%w: more than %d terms", ErrLimitExceeded, l.MaxTerms)
+	}
+	spec := termArgument(tok)
+	if l.MaxMacroLength > 0 && len(spec) > l.MaxMacroLength {
+		return fmt.Errorf("%w: %q has a %d byte macro-string, limit %d", ErrLimitExceeded, truncate(tok), len(spec), l.MaxMacroLength)
+	}
+	if labels := strings.Count(spec, ".") + 1; l.MaxLabels > 0 && labels > l.MaxLabels {
+		return fmt.Errorf("%w: %q has %d labels, limit %d", ErrLimitExceeded, truncate(tok), labels, l.MaxLabels)
+	}
+
+	return nil
+}
+
+// termArgument returns the value of a modifier or the part of a mechanism
+// after its name, e.g. "_spf.example.com/24" of "a:_spf.example.com/24".  It
+// is empty for terms without one, such as "all" or "mx/24".
+func termArgument(tok string) string {
+	if _, value, ok := strings.Cut(tok, "="); ok {
+		return value
+	}
+	_, spec, _ := strings.Cut(tok, ":")
+
+	return spec
+}
+
+// truncate shortens tok for error messages about oversized terms.
+func truncate(tok string) string {
+	const max = 64
+	if len(tok) <= max {
+		return tok
+	}
+
+	return tok[:max] + "..."
+}
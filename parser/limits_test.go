@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWith(t *testing.T) {
+	l := Limits{MaxLength: 200, MaxTerms: 4, MaxMacroLength: 40, MaxLabels: 5}
+	for _, tc := range []struct {
+		record string
+		ok     bool
+	}{
+		{"v=spf1 ip4:192.0.2.0/24 include:_spf.example.com -all", true},
+		{"v=spf1 " + strings.Repeat(" ", 200) + "-all", false},
+		{"v=spf1 mx a ptr ip4:192.0.2.1 -all", false},
+		{"v=spf1 exists:%{i}.%{l1r+-}._spf.%{d2}.%{o}.%{h}.example.com -all", false},
+		{"v=spf1 include:a.b.c.d.e.example.com -all", false},
+		{"v=spf1 redirect=a.b.c.example.com", true},
+		{"v=spf1 exp=a.b.c.d.e.example.com -all", false},
+		{"v=spf1 ip6:2001:db8::/32 a:mail.example.com/24//64", true},
+	} {
+		_, err := ParseWith(tc.record, l)
+		if tc.ok {
+			require.NoError(t, err, tc.record)
+			continue
+		}
+		require.ErrorIs(t, err, ErrLimitExceeded, tc.record)
+	}
+
+	// zero limits are unlimited, and syntax errors still apply
+	_, err := ParseWith("v=spf1 "+strings.Repeat("a:mail.example.com ", 600)+"-all", Limits{})
+	require.NoError(t, err)
+	_, err = ParseWith("v=spf1 "+strings.Repeat("mx ", 3)+"bogus", l)
+	require.ErrorIs(t, err, ErrUnknownMechanism)
+}
+
+func TestParse_DefaultLimits(t *testing.T) {
+	_, err := Parse("v=spf1 " + strings.Repeat("ip4:192.0.2.1 ", 600) + "-all")
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	assert.Contains(t, err.Error(), "limit 8192")
+
+	_, err = Parse("v=spf1 include:" + strings.Repeat("a", 2000) + ".example.com -all")
+	require.ErrorIs(t, err, ErrLimitExceeded)
+	assert.Contains(t, err.Error(), `"include:aaaa`)
+	assert.Contains(t, err.Error(), `..."`)
+}
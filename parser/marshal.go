@@ -0,0 +1,162 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// recordDoc is the structured form of a Record in JSON and YAML: its terms
+// in record order.
+type recordDoc struct {
+	Terms []termDoc `json:"terms" yaml:"terms"`
+}
+
+// termDoc is one term: a mechanism with its arguments, or a modifier.
+type termDoc struct {
+	Qualifier string `json:"qualifier,omitempty" yaml:"qualifier,omitempty"` // omitted for "+"
+	Mechanism string `json:"mechanism,omitempty" yaml:"mechanism,omitempty"`
+	Domain    string `json:"domain,omitempty" yaml:"domain,omitempty"`
+	Network   string `json:"network,omitempty" yaml:"network,omitempty"` // ip4 and ip6
+	CIDR4     *int   `json:"cidr4,omitempty" yaml:"cidr4,omitempty"`     // a and mx
+	CIDR6     *int   `json:"cidr6,omitempty" yaml:"cidr6,omitempty"`
+	Modifier  string `json:"modifier,omitempty" yaml:"modifier,omitempty"`
+	Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// MarshalJSON encodes r as its terms in record order, e.g.
+// {"terms":[{"mechanism":"ip4","network":"192.0.2.0/24"},{"qualifier":"-","mechanism":"all"}]}.
+func (r *Record) MarshalJSON() ([]byte, error) {
+	doc, err := r.document()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON.  The terms are
+// checked like those of a record passed to Parse.
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var doc recordDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	return r.fromDocument(doc)
+}
+
+// MarshalYAML encodes r like MarshalJSON, for DNS-as-code repositories
+// keeping structured SPF definitions in YAML.
+func (r *Record) MarshalYAML() (any, error) {
+	return r.document()
+}
+
+// UnmarshalYAML decodes the form written by MarshalYAML.
+func (r *Record) UnmarshalYAML(node *yaml.Node) error {
+	var doc recordDoc
+	if err := node.Decode(&doc); err != nil {
+		return err
+	}
+
+	return r.fromDocument(doc)
+}
+
+// document returns the structured form of the terms of r.
+func (r *Record) document() (recordDoc, error) {
+	doc := recordDoc{Terms: make([]termDoc, 0, len(r.Terms))}
+	for _, term := range r.Terms {
+		rec, err := Parse("v=spf1 " + term)
+		if err != nil {
+			return recordDoc{}, err
+		}
+		switch {
+		case len(rec.Mechs) == 1:
+			doc.Terms = append(doc.Terms, mechanismDoc(rec.Mechs[0], term))
+		case rec.Redirect != nil:
+			doc.Terms = append(doc.Terms, termDoc{Modifier: rec.Redirect.Name, Value: rec.Redirect.Value})
+		case rec.Exp != nil:
+			doc.Terms = append(doc.Terms, termDoc{Modifier: rec.Exp.Name, Value: rec.Exp.Value})
+		default:
+			doc.Terms = append(doc.Terms, termDoc{Modifier: rec.Unknown[0].Name, Value: rec.Unknown[0].Value})
+		}
+	}
+
+	return doc, nil
+}
+
+// mechanismDoc returns the structured form of m, parsed from term.  The
+// network of ip4 and ip6 is kept as written.
+func mechanismDoc(m Mechanism, term string) termDoc {
+	t := termDoc{Mechanism: m.Kind, Domain: m.Domain}
+	if m.Qual != QPlus {
+		t.Qualifier = string(m.Qual)
+	}
+	switch m.Kind {
+	case "ip4", "ip6":
+		_, t.Network, _ = strings.Cut(term, ":")
+	case "a", "mx":
+		if m.Mask4 >= 0 {
+			t.CIDR4 = &m.Mask4
+		}
+		if m.Mask6 >= 0 {
+			t.CIDR6 = &m.Mask6
+		}
+	}
+
+	return t
+}
+
+// fromDocument replaces r with the record of doc.
+func (r *Record) fromDocument(doc recordDoc) error {
+	terms := make([]string, 0, len(doc.Terms))
+	for i, t := range doc.Terms {
+		term, err := t.text()
+		if err != nil {
+			return fmt.Errorf("term %d: %w", i+1, err)
+		}
+		terms = append(terms, term)
+	}
+	rec, err := Parse(strings.Join(append([]string{"v=spf1"}, terms...), " "))
+	if err != nil {
+		return err
+	}
+	*r = *rec
+
+	return nil
+}
+
+// text returns the term in record syntax.
+func (t termDoc) text() (string, error) {
+	switch {
+	case t.Mechanism != "" && t.Modifier != "":
+		return "", fmt.Errorf("both mechanism %q and modifier %q", t.Mechanism, t.Modifier)
+	case t.Modifier != "":
+		return t.Modifier + "=" + t.Value, nil
+	case t.Mechanism == "":
+		return "", fmt.Errorf("neither mechanism nor modifier")
+	}
+
+	var b strings.Builder
+	if t.Qualifier != "+" {
+		b.WriteString(t.Qualifier)
+	}
+	b.WriteString(t.Mechanism)
+	switch {
+	case t.Network != "":
+		b.WriteString(":" + t.Network)
+	case t.Domain != "":
+		b.WriteString(":" + t.Domain)
+	}
+	if t.CIDR4 != nil {
+		b.WriteString("/" + strconv.Itoa(*t.CIDR4))
+	}
+	if t.CIDR6 != nil {
+		b.WriteString("//" + strconv.Itoa(*t.CIDR6))
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,66 @@
+package parser
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const marshalRecord = "v=spf1 ip4:192.0.2.0/24 a:mail.example.com/24//64 ~mx include:_spf.example.net -all redirect=_spf.example.com"
+
+func TestRecord_JSON(t *testing.T) {
+	rec, err := Parse(marshalRecord)
+	require.NoError(t, err)
+	data, err := json.Marshal(rec)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"terms":[
+		{"mechanism":"ip4","network":"192.0.2.0/24"},
+		{"mechanism":"a","domain":"mail.example.com","cidr4":24,"cidr6":64},
+		{"qualifier":"~","mechanism":"mx"},
+		{"mechanism":"include","domain":"_spf.example.net"},
+		{"qualifier":"-","mechanism":"all"},
+		{"modifier":"redirect","value":"_spf.example.com"}
+	]}`, string(data))
+
+	var back Record
+	require.NoError(t, json.Unmarshal(data, &back))
+	assert.Equal(t, marshalRecord, back.String())
+	assert.Equal(t, rec.Mechs, back.Mechs)
+}
+
+func TestRecord_YAML(t *testing.T) {
+	rec, err := Parse(marshalRecord)
+	require.NoError(t, err)
+	data, err := yaml.Marshal(rec)
+	require.NoError(t, err)
+	assert.Equal(t, `terms:
+    - mechanism: ip4
+      network: 192.0.2.0/24
+    - mechanism: a
+      domain: mail.example.com
+      cidr4: 24
+      cidr6: 64
+    - qualifier: "~"
+      mechanism: mx
+    - mechanism: include
+      domain: _spf.example.net
+    - qualifier: '-'
+      mechanism: all
+    - modifier: redirect
+      value: _spf.example.com
+`, string(data))
+
+	var back Record
+	require.NoError(t, yaml.Unmarshal(data, &back))
+	assert.Equal(t, marshalRecord, back.String())
+
+	// hand-written definitions are validated like parsed records
+	require.NoError(t, yaml.Unmarshal([]byte("terms:\n  - {qualifier: '+', mechanism: ip6, network: '2001:db8::/32'}\n  - {modifier: exp, value: explain.example.com}\n"), &back))
+	assert.Equal(t, "v=spf1 ip6:2001:db8::/32 exp=explain.example.com", back.String())
+	require.Error(t, yaml.Unmarshal([]byte("terms:\n  - {mechanism: ip4, network: 192.0.2.0/33}\n"), &back))
+	require.ErrorContains(t, yaml.Unmarshal([]byte("terms:\n  - {mechanism: all, modifier: exp}\n"), &back), "term 1")
+	require.Error(t, yaml.Unmarshal([]byte("terms: []\n"), &back))
+}
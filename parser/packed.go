@@ -0,0 +1,283 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+)
+
+// ErrInvalidPacked is returned by PackedRecord.Unpack for data not written by
+// Pack with the same Interner.
+var ErrInvalidPacked = errors.New("invalid packed record")
+
+// Interner assigns 32-bit IDs to the domains and modifier values of packed
+// records, so that a name shared by many records, such as a popular include
+// target, is stored once.  It is not safe for concurrent use.
+type Interner struct {
+	ids   map[string]uint32
+	names []string
+}
+
+// NewInterner returns an empty Interner.  ID 0 is the empty name.
+func NewInterner() *Interner {
+	return &Interner{ids: map[string]uint32{"": 0}, names: []string{""}}
+}
+
+// Intern returns the ID of name, assigning the next one if name is new.
+func (in *Interner) Intern(name string) uint32 {
+	if id, ok := in.ids[name]; ok {
+		return id
+	}
+	id := uint32(len(in.names))
+	in.ids[name] = id
+	in.names = append(in.names, name)
+
+	return id
+}
+
+// Name returns the name with the given ID.
+func (in *Interner) Name(id uint32) (string, bool) {
+	if int(id) >= len(in.names) {
+		return "", false
+	}
+
+	return in.names[id], true
+}
+
+// Len returns the number of names interned, including the empty name.
+func (in *Interner) Len() int {
+	return len(in.names)
+}
+
+// Term kinds of a PackedRecord, in bits 2-5 of a term's op byte.
+const (
+	packedAll = iota
+	packedIP4
+	packedIP6
+	packedA
+	packedMX
+	packedPTR
+	packedExists
+	packedInclude
+	packedRedirect
+	packedExp
+	packedModifier // any other modifier
+)
+
+var packedKinds = []string{"all", "ip4", "ip6", "a", "mx", "ptr", "exists", "include"}
+
+// Flags of a term's op byte: the CIDR lengths present on a or mx.
+const (
+	packedCIDR4 = 1 << 6
+	packedCIDR6 = 1 << 7
+)
+
+// packedQualifiers are the qualifiers in bits 0-1 of a term's op byte.
+var packedQualifiers = []string{"", "-", "~", "?"}
+
+// PackedRecord is a compact encoding of a Record for holding large corpora,
+// such as tens of millions of records collected for research, in memory.
+// Each term is one op byte, holding its qualifier and kind, and a few 32-bit
+// words: an ip4 network is its address and prefix length, an ip6 network its
+// 128-bit address as four words and prefix length, and domains and modifier
+// values are IDs of an Interner shared by the records.  The zero value is a
+// record without terms.
+type PackedRecord struct {
+	ops   []byte
+	words []uint32
+}
+
+// Pack encodes r, interning its names in in.  The networks of ip4 and ip6 are
+// kept as written; other terms are normalised as by Mechanism.String, e.g.
+// "+mx" becomes "mx".
+func Pack(r *Record, in *Interner) (PackedRecord, error) {
+	doc, err := r.document()
+	if err != nil {
+		return PackedRecord{}, err
+	}
+	p := PackedRecord{ops: make([]byte, 0, len(doc.Terms))}
+	for _, t := range doc.Terms {
+		if t.Modifier != "" {
+			switch t.Modifier {
+			case "redirect":
+				p.ops = append(p.ops, packedRedirect<<2)
+			case "exp":
+				p.ops = append(p.ops, packedExp<<2)
+			default:
+				p.ops = append(p.ops, packedModifier<<2)
+				p.words = append(p.words, in.Intern(t.Modifier))
+			}
+			p.words = append(p.words, in.Intern(t.Value))
+			continue
+		}
+
+		op := byte(0)
+		for i, q := range packedQualifiers {
+			if q == t.Qualifier {
+				op = byte(i)
+			}
+		}
+		for i, kind := range packedKinds {
+			if kind == t.Mechanism {
+				op |= byte(i) << 2
+			}
+		}
+		switch t.Mechanism {
+		case "ip4", "ip6":
+			if err := p.packNetwork(t.Network); err != nil {
+				return PackedRecord{}, err
+			}
+		case "a", "mx":
+			p.words = append(p.words, in.Intern(t.Domain))
+			if t.CIDR4 != nil {
+				op |= packedCIDR4
+				p.words = append(p.words, uint32(*t.CIDR4))
+			}
+			if t.CIDR6 != nil {
+				op |= packedCIDR6
+				p.words = append(p.words, uint32(*t.CIDR6))
+			}
+		case "ptr", "exists", "include":
+			p.words = append(p.words, in.Intern(t.Domain))
+		}
+		p.ops = append(p.ops, op)
+	}
+
+	return p, nil
+}
+
+// packNetwork appends the words of the network of an ip4 or ip6 term.
+func (p *PackedRecord) packNetwork(network string) error {
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		addr, aerr := netip.ParseAddr(network)
+		if aerr != nil {
+			return err
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if addr := prefix.Addr(); addr.Is4() {
+		b := addr.As4()
+		p.words = append(p.words, uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3]))
+	} else {
+		b := addr.As16()
+		for i := 0; i < 16; i += 4 {
+			p.words = append(p.words, uint32(b[i])<<24|uint32(b[i+1])<<16|uint32(b[i+2])<<8|uint32(b[i+3]))
+		}
+	}
+	p.words = append(p.words, uint32(prefix.Bits()))
+
+	return nil
+}
+
+// Size returns the bytes held by the terms of p, excluding the Interner.
+func (p PackedRecord) Size() int {
+	return len(p.ops) + 4*len(p.words)
+}
+
+// Unpack decodes p with the Interner it was packed with.  The record is
+// checked like one passed to Parse.
+func (p PackedRecord) Unpack(in *Interner) (*Record, error) {
+	doc := recordDoc{Terms: make([]termDoc, 0, len(p.ops))}
+	words := p.words
+	next := func(n int) ([]uint32, error) {
+		if len(words) < n {
+			return nil, ErrInvalidPacked
+		}
+		w := words[:n]
+		words = words[n:]
+		return w, nil
+	}
+	name := func() (string, error) {
+		w, err := next(1)
+		if err != nil {
+			return "", err
+		}
+		s, ok := in.Name(w[0])
+		if !ok {
+			return "", fmt.Errorf("%w: unknown name ID %d", ErrInvalidPacked, w[0])
+		}
+		return s, nil
+	}
+
+	for _, op := range p.ops {
+		var t termDoc
+		var err error
+		switch kind := int(op>>2) & 0xf; kind {
+		case packedRedirect, packedExp:
+			t.Modifier = "redirect"
+			if kind == packedExp {
+				t.Modifier = "exp"
+			}
+			t.Value, err = name()
+		case packedModifier:
+			if t.Modifier, err = name(); err == nil {
+				t.Value, err = name()
+			}
+		case packedIP4, packedIP6:
+			t.Mechanism = packedKinds[kind]
+			t.Network, err = unpackNetwork(next, kind == packedIP6)
+		case packedAll, packedA, packedMX, packedPTR, packedExists, packedInclude:
+			t.Mechanism = packedKinds[kind]
+			if kind != packedAll {
+				t.Domain, err = name()
+			}
+			if op&packedCIDR4 != 0 && err == nil {
+				t.CIDR4, err = unpackCIDR(next)
+			}
+			if op&packedCIDR6 != 0 && err == nil {
+				t.CIDR6, err = unpackCIDR(next)
+			}
+		default:
+			err = fmt.Errorf("%w: term kind %d", ErrInvalidPacked, kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t.Mechanism != "" {
+			t.Qualifier = packedQualifiers[op&3]
+		}
+		doc.Terms = append(doc.Terms, t)
+	}
+	if len(words) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing words", ErrInvalidPacked, len(words))
+	}
+
+	r := &Record{}
+	if err := r.fromDocument(doc); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// unpackNetwork returns the network of an ip4 or ip6 term in CIDR notation.
+func unpackNetwork(next func(int) ([]uint32, error), v6 bool) (string, error) {
+	n := 2
+	if v6 {
+		n = 5
+	}
+	w, err := next(n)
+	if err != nil {
+		return "", err
+	}
+	var b []byte
+	for _, word := range w[:n-1] {
+		b = append(b, byte(word>>24), byte(word>>16), byte(word>>8), byte(word))
+	}
+	addr, _ := netip.AddrFromSlice(b)
+
+	return addr.String() + "/" + strconv.Itoa(int(w[n-1])), nil
+}
+
+// unpackCIDR returns the CIDR length of an a or mx term.
+func unpackCIDR(next func(int) ([]uint32, error)) (*int, error) {
+	w, err := next(1)
+	if err != nil {
+		return nil, err
+	}
+	n := int(w[0])
+
+	return &n, nil
+}
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPack(t *testing.T) {
+	in := NewInterner()
+	for _, tc := range []struct{ record, want string }{
+		{
+			"v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.7 -ip6:2001:db8::/32 include:_spf.example.com ~all",
+			"v=spf1 ip4:192.0.2.0/24 ip4:198.51.100.7/32 -ip6:2001:db8::/32 include:_spf.example.com ~all",
+		},
+		{
+			"v=spf1 +a -mx:mail.example.com/24//64 ?ptr exists:%{i}._spf.%{d} a//48 redirect=_spf.example.com",
+			"v=spf1 a -mx:mail.example.com/24//64 ?ptr exists:%{i}._spf.%{d} a//48 redirect=_spf.example.com",
+		},
+		{
+			"v=spf1 include:_spf.example.com exp=explain.example.com custom=%{d} -all",
+			"v=spf1 include:_spf.example.com exp=explain.example.com custom=%{d} -all",
+		},
+	} {
+		rec, err := Parse(tc.record)
+		require.NoError(t, err)
+		p, err := Pack(rec, in)
+		require.NoError(t, err, tc.record)
+		back, err := p.Unpack(in)
+		require.NoError(t, err, tc.record)
+		assert.Equal(t, tc.want, back.String())
+		assert.Equal(t, rec.Mechs, back.Mechs)
+	}
+	// "_spf.example.com" is stored once
+	assert.Equal(t, 7, in.Len())
+
+	rec, err := Parse("v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 include:_spf.example.com -all")
+	require.NoError(t, err)
+	p, err := Pack(rec, in)
+	require.NoError(t, err)
+	assert.Equal(t, 4+4*(2+5+1), p.Size())
+
+	empty, err := PackedRecord{}.Unpack(in)
+	require.Error(t, err, "a record needs terms")
+	assert.Nil(t, empty)
+}
+
+func TestPackedRecord_Invalid(t *testing.T) {
+	in := NewInterner()
+	rec, err := Parse("v=spf1 include:_spf.example.com -all")
+	require.NoError(t, err)
+	p, err := Pack(rec, in)
+	require.NoError(t, err)
+
+	_, err = p.Unpack(NewInterner())
+	require.ErrorIs(t, err, ErrInvalidPacked)
+	_, err = PackedRecord{ops: p.ops}.Unpack(in)
+	require.ErrorIs(t, err, ErrInvalidPacked)
+	_, err = PackedRecord{ops: p.ops, words: append(p.words, 0)}.Unpack(in)
+	require.ErrorIs(t, err, ErrInvalidPacked)
+	_, err = PackedRecord{ops: []byte{15 << 2}}.Unpack(in)
+	require.ErrorIs(t, err, ErrInvalidPacked)
+}
@@ -22,6 +22,50 @@ const (
 	QMark  Qualifier = '?' // neutral
 )
 
+// ErrInvalidQualifier is returned by ParseQualifier for anything but "+",
+// "-", "~" or "?".
+var ErrInvalidQualifier = errors.New("invalid qualifier")
+
+// ParseQualifier parses a qualifier; the empty string is QPlus, which is
+// implied when a term has no qualifier (RFC 7208 section 4.6.2).
+func ParseQualifier(s string) (Qualifier, error) {
+	switch s {
+	case "":
+		return QPlus, nil
+	case "+", "-", "~", "?":
+		return Qualifier(s[0]), nil
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrInvalidQualifier, s)
+}
+
+// String returns the qualifier as written in a record, e.g. "-".  The zero
+// Qualifier is "+".
+func (q Qualifier) String() string {
+	if q == 0 {
+		return "+"
+	}
+
+	return string(rune(q))
+}
+
+// Result returns the name of the result a matching mechanism with qualifier
+// q yields (RFC 7208 section 4.6.2): "pass", "fail", "softfail" or
+// "neutral".  The names are those of spf.Result; the zero Qualifier is
+// "pass" and an invalid one is "neutral".
+func (q Qualifier) Result() string {
+	switch q {
+	case QPlus, 0:
+		return "pass"
+	case QMinus:
+		return "fail"
+	case QTilde:
+		return "softfail"
+	}
+
+	return "neutral"
+}
+
 // Modifier represents a key=value term such as "redirect" or "exp" from
 // RFC 7208 section 6.  The value may contain macros which are expanded during
 // evaluation.
@@ -39,9 +83,57 @@ type Mechanism struct {
 	Kind   string     // "all", "ipv4"
 	Net    *net.IPNet // only ipv4/ipv6 set this
 	Domain string     // only a, mx, include, exists use this
-	Mask4  int        // only a/mx when dual CIDR present
+	Mask4  int        // only a/mx when dual CIDR present, -1 = not given
 	Mask6  int
-	Macro  bool // only exists and later exp uses this
+	Macro  bool // Domain contains macros to expand during evaluation
+}
+
+// String returns the mechanism in record syntax, e.g. "-ip4:192.0.2.0/24" or
+// "a:mail.example.com/24//64".  The "+" qualifier is omitted.
+func (m Mechanism) String() string {
+	var b strings.Builder
+	if m.Qual != QPlus && m.Qual != 0 {
+		b.WriteRune(rune(m.Qual))
+	}
+	b.WriteString(m.Kind)
+	switch m.Kind {
+	case "ip4", "ip6":
+		if m.Net != nil {
+			b.WriteString(":" + m.Net.String())
+		}
+		return b.String()
+	}
+	if m.Domain != "" {
+		b.WriteString(":" + m.Domain)
+	}
+	if m.Kind == "a" || m.Kind == "mx" {
+		if m.Mask4 >= 0 {
+			b.WriteString("/" + strconv.Itoa(m.Mask4))
+		}
+		if m.Mask6 >= 0 {
+			b.WriteString("//" + strconv.Itoa(m.Mask6))
+		}
+	}
+
+	return b.String()
+}
+
+// ZeroCIDR reports the address families for which the mechanism uses a zero
+// prefix length: "ip4:192.0.2.0/0" matches every IPv4 client and "a/0" every
+// IPv4 client as soon as the host has any A record (RFC 7208 section 5.6).
+func (m Mechanism) ZeroCIDR() (v4, v6 bool) {
+	switch m.Kind {
+	case "ip4", "ip6":
+		if m.Net == nil {
+			return false, false
+		}
+		ones, _ := m.Net.Mask.Size()
+		return ones == 0 && m.Kind == "ip4", ones == 0 && m.Kind == "ip6"
+	case "a", "mx":
+		return m.Mask4 == 0, m.Mask6 == 0
+	}
+
+	return false, false
 }
 
 // Record holds a parsed SPF record.
@@ -50,6 +142,9 @@ type Record struct {
 	Redirect *Modifier // nil or the modifier
 	Exp      *Modifier
 	Unknown  []Modifier
+	// Terms holds the raw text of every term after the version, in record
+	// order.  It is maintained by the editing methods.
+	Terms []string
 }
 
 // Errors returned by ValidateDomain.  Each corresponds to one of the
@@ -60,27 +155,54 @@ var (
 	ErrLabelTooLong   = errors.New("domain label exceeds 63 octets")
 	ErrDomainTooLong  = errors.New("domain exceeds 255 octets")
 	ErrIDNAConversion = errors.New("IDNA ToASCII failed")
+	ErrInvalidChar    = errors.New("domain label contains invalid character")
 )
 
 var ErrNotModifier = errors.New("-not-modifier")
 
+// ErrDuplicateModifier is returned by Parse when "redirect" or "exp" appears
+// more than once.  RFC 7208 section 6 makes such a record a permerror.
+var ErrDuplicateModifier = errors.New("duplicate modifier")
+
 /* ========= public parser entry-point ========= */
 // Parse checks the record syntax defined in RFC 7208 section 4.6 and returns a structured representation.
 // The function performs no DNS lookups or macro expansion; evaluation according to section 5 is handled elsewhere.
+// Records beyond DefaultLimits are rejected with ErrLimitExceeded.
 
 func Parse(rawTXT string) (*Record, error) {
-	tokens, tokErr := tokenizer(rawTXT)
-	if tokErr != nil {
-		return nil, tokErr
+	return ParseWith(rawTXT, DefaultLimits)
+}
+
+// ErrNotMechanism is returned by ParseMechanism for a modifier.
+var ErrNotMechanism = errors.New("term is not a mechanism")
+
+// ParseMechanism parses a single mechanism such as "-ip4:192.0.2.0/24" or
+// "include:_spf.example.com", checked as in a record.
+func ParseMechanism(term string) (*Mechanism, error) {
+	if err := DefaultLimits.checkTerm(term, 1); err != nil {
+		return nil, err
+	}
+	rec, err := parseTokens([]string{term}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rec.Mechs) != 1 {
+		return nil, fmt.Errorf("%w: %q", ErrNotMechanism, term)
 	}
 
+	return &rec.Mechs[0], nil
+}
+
+// parseTokens parses the terms of a record, without its version, accepting
+// the site-specific mechanisms named in ext.
+func parseTokens(tokens []string, ext []string) (*Record, error) {
 	// ordered list of mechanism parsers
 	mechParsers := []func(Qualifier, string) (*Mechanism, error){
 		parseAll, parseIP4, parseIP6,
 		parseA, parseMX, parsePTR,
 		parseExists, parseInclude,
 	}
-	record := &Record{}
+	record := &Record{Terms: tokens}
 	for _, tok := range tokens {
 		// parse mod first if not  mod, then it's a mechanism
 		// rfc  7208 section 6.1 says the two mods... redirect and exp must not appear in a record more than once
@@ -91,24 +213,20 @@ func Parse(rawTXT string) (*Record, error) {
 			switch mod.Name {
 			case "redirect":
 				if record.Redirect != nil {
-					return nil, fmt.Errorf("duplicate redirect")
+					return nil, fmt.Errorf("%w: redirect", ErrDuplicateModifier)
 				}
-				if !strings.ContainsRune(mod.Value, '%') {
-					if _, e := ValidateDomain(mod.Value); e != nil {
-						return nil, e
-					}
+				if e := validateDomainSpec(mod.Value); e != nil {
+					return nil, e
 				}
 				record.Redirect = mod
 				mod.Macro = strings.ContainsRune(mod.Value, '%')
 
 			case "exp":
 				if record.Exp != nil {
-					return nil, fmt.Errorf("duplicate exp")
+					return nil, fmt.Errorf("%w: exp", ErrDuplicateModifier)
 				}
-				if !strings.ContainsRune(mod.Value, '%') {
-					if _, e := ValidateDomain(mod.Value); e != nil {
-						return nil, e
-					}
+				if e := validateDomainSpec(mod.Value); e != nil {
+					return nil, e
 				}
 				record.Exp = mod
 				mod.Macro = strings.ContainsRune(mod.Value, '%')
@@ -128,6 +246,7 @@ func Parse(rawTXT string) (*Record, error) {
 
 		// mechanisms are discovered from this point
 		q, rest := stripQualifier(tok)
+		rest = lowerName(rest)
 		var mech *Mechanism
 		var perr error
 		for _, pf := range mechParsers {
@@ -136,6 +255,12 @@ func Parse(rawTXT string) (*Record, error) {
 			}
 		}
 		if perr != nil || mech == nil {
+			mech = parseExtension(q, rest, ext)
+		}
+		if mech == nil {
+			if err := unknownTerm(tok); err != nil {
+				return nil, err
+			}
 			return nil, fmt.Errorf("permerror: %v", perr)
 		}
 		record.Mechs = append(record.Mechs, *mech)
@@ -145,14 +270,25 @@ func Parse(rawTXT string) (*Record, error) {
 
 // tokenizer splits a raw SPF record into whitespace-separated terms and drops
 // the leading "v=spf1" version tag.  It implements the tokenisation described
-// in RFC 7208 section 4.6.
-func tokenizer(raw string) ([]string, error) {
+// in RFC 7208 section 4.6, checking each term against l as it goes.
+func tokenizer(raw string, l Limits) ([]string, error) {
 	raw = strings.TrimSpace(raw)
 	if !strings.HasPrefix(strings.ToLower(raw), "v=spf1") {
 		return nil, fmt.Errorf("missing v=spf1")
 	}
-	// throw away version tag
-	fields := strings.Fields(raw)[1:]
+	var fields []string
+	first := true
+	for term := range Terms(raw) {
+		// throw away version tag
+		if first {
+			first = false
+			continue
+		}
+		if err := l.checkTerm(term, len(fields)+1); err != nil {
+			return nil, err
+		}
+		fields = append(fields, term)
+	}
 	// sanity check
 	if len(fields) == 0 {
 		return nil, fmt.Errorf("no terms")
@@ -160,6 +296,18 @@ func tokenizer(raw string) ([]string, error) {
 	return fields, nil
 }
 
+// lowerName lower-cases the name of a mechanism, which is case-insensitive
+// (RFC 7208 section 4.6.1), and leaves its argument as written: the letters
+// of macros in a domain-spec are case-sensitive (section 7.3).
+func lowerName(rest string) string {
+	i := strings.IndexAny(rest, ":/")
+	if i < 0 {
+		i = len(rest)
+	}
+
+	return strings.ToLower(rest[:i]) + rest[i:]
+}
+
 // stripQualifier returns the qualifier (+, -, ~, ?) and the remainder of the token.
 // if no qualifier is present, QPlus is implied.
 func stripQualifier(tok string) (Qualifier, string) {
@@ -196,6 +344,9 @@ func parseIP4(q Qualifier, rest string) (*Mechanism, error) {
 	if !strings.ContainsRune(cidr, '/') {
 		cidr += "/32"
 	}
+	if _, length, _ := strings.Cut(cidr, "/"); !validCIDRLength(length) {
+		return nil, fmt.Errorf("bad ipcidr %q", cidr)
+	}
 
 	ip, netw, err := net.ParseCIDR(cidr)
 	if err != nil || ip.To4() == nil {
@@ -226,6 +377,9 @@ func parseIP6(q Qualifier, rest string) (*Mechanism, error) {
 	if !strings.ContainsRune(cidr, '/') {
 		cidr += "/128"
 	}
+	if _, length, _ := strings.Cut(cidr, "/"); !validCIDRLength(length) {
+		return nil, fmt.Errorf("bad ipcidr %q", cidr)
+	}
 	ip, netw, err := net.ParseCIDR(cidr)
 	if err != nil || ip.To4() != nil {
 		return nil, fmt.Errorf("bad ipcidr %q", cidr) // permanent error
@@ -249,9 +403,11 @@ func parseIP6(q Qualifier, rest string) (*Mechanism, error) {
 //
 //	a                ; current domain, default masks
 //	a/24             ; v4 mask = 24, v6 = unlimited
-//	a/24/64          ; v4 = 24, v6 = 64
+//	a//64            ; v4 = unlimited, v6 = 64
+//	a/24//64         ; v4 = 24, v6 = 64
 //	a:mail.example   ; explicit domain, default masks
-//	a:mail.example/24/64
+//	a:mail.example/24//64
+//	a:%{d}.example   ; domain-spec with macros, expanded during evaluation
 //
 // If a slash segment is missing, defaults are /32 for IPv4 and /128 for IPv6.
 // Any syntax violation is a permerror (we return a regular error and let the
@@ -270,20 +426,20 @@ func parseA(q Qualifier, rest string) (*Mechanism, error) {
 	// bare "a" nothing more to parse
 
 	case strings.HasPrefix(spec, "/"):
-		// "/mask" or "/mask4/mask6" with no explicit domain
+		// "/mask4", "//mask6" or "/mask4//mask6" with no explicit domain
 		var err error
-		mask4, mask6, err = parseMasks(strings.TrimPrefix(spec, "/"))
+		mask4, mask6, err = parseMasks(spec)
 		if err != nil {
 			return nil, err
 		}
 	case strings.HasPrefix(spec, ":"):
 		// ":domain" [ "/" ... ]
 		afterColon := strings.TrimPrefix(spec, ":")
-		// split once: left = domain, right (optional) = "mask" or "mask4/mask6"
-		domainPart, maskPart, _ := strings.Cut(afterColon, "/")
+		// split once: left = domain, right (optional) = dual-cidr-length
+		domainPart, maskPart := splitDualCIDR(afterColon)
 		// check domain part
 		if domainPart != "" {
-			if _, err := ValidateDomain(domainPart); err != nil {
+			if err := validateDomainSpec(domainPart); err != nil {
 				return nil, fmt.Errorf("bad a record domain %q", domainPart)
 			}
 			domain = domainPart
@@ -308,57 +464,75 @@ func parseA(q Qualifier, rest string) (*Mechanism, error) {
 		Domain: domain, // "" = current domain
 		Mask4:  mask4,
 		Mask6:  mask6,
+		Macro:  strings.ContainsRune(domain, '%'),
 	}, nil
 }
 
-// parseMasks converts "24" or "24/64" into two integers.  It is used by the
-// A and MX mechanism parsers to interpret CIDR length suffixes.
+// parseMasks converts a dual-cidr-length suffix (RFC 7208 section 5.6) into
+// two integers.  It is used by the A and MX mechanism parsers to interpret
+// CIDR length suffixes.
 // input string examples :
 //
-//	"24"       -> mask4=24 mask6=-1
-//	"24/64"    -> mask4=24 mask6=64
+//	"/24"      -> mask4=24 mask6=-1
+//	"//64"     -> mask4=-1 mask6=64
+//	"/24//64"  -> mask4=24 mask6=64
 //
 // Returns error if:
 //   - non-decimal
-//   - /0 CIDR that exceeds bounds (0–32, 0–128)
-//   - more than two slash-separated parts
+//   - CIDR that exceeds bounds (0–32, 0–128)
+//   - anything other than the two forms above, e.g. "/24/64"
 func parseMasks(maskstr string) (mask4, mask6 int, err error) {
 	toInt := func(s string, max int) (int, error) {
 		n, e := strconv.Atoi(s)
-		if e != nil || n < 0 || n > max {
+		if e != nil || !validCIDRLength(s) || n > max {
 			return 0, fmt.Errorf("cidr out of range")
 		}
 		return n, nil
 	}
 
-	parts := strings.Split(maskstr, "/")
-	switch len(parts) {
-	case 1:
-		mask4, err = toInt(parts[0], 32)
-		mask6 = -1
-	case 2:
-		mask4, err = toInt(parts[0], 32)
-		if err != nil {
+	mask4, mask6 = -1, -1
+	v4, v6, dual := strings.Cut(maskstr, "//")
+	if v4 != "" {
+		if !strings.HasPrefix(v4, "/") {
+			return -1, -1, fmt.Errorf("invalid cidr length %q", maskstr)
+		}
+		if mask4, err = toInt(v4[1:], 32); err != nil {
 			return
 		}
-		mask6, err = toInt(parts[1], 128)
-
-	default:
-		err = fmt.Errorf("too many / segments in mask")
+	}
+	if dual {
+		mask6, err = toInt(v6, 128)
 	}
 	return
 }
 
+// validCIDRLength reports whether s is a decimal prefix length without sign
+// or leading zeros, as the ip4-cidr-length and ip6-cidr-length rules of
+// RFC 7208 section 5.6 require.  "0" is valid: a zero length matches every
+// address of the family.
+func validCIDRLength(s string) bool {
+	if s == "" || len(s) > 1 && s[0] == '0' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
 // parseMX - RFC 7208 section 5.4  —  “mx” mechanism
 //
 // ABNF recap (very similar to “a”):
 //
 //	mx                ; current domain’s MX hosts, default masks
 //	mx/24             ; v4 mask 24, v6 = unlimited
-//	mx/24/64          ; v4 mask 24, v6 mask 64
+//	mx/24//64         ; v4 mask 24, v6 mask 64
 //	mx:example.org    ; explicit domain, default masks
 //	mx:example.org/24 ; explicit domain, v4 mask 24
-//	mx:example.org/24/64
+//	mx:example.org/24//64
 //
 // If ip4-cidr-length is missing  → assume /32     ( section 5.6)
 // If ip6-cidr-length is missing  → assume /128    (section 5.6)
@@ -377,18 +551,18 @@ func parseMX(q Qualifier, rest string) (*Mechanism, error) {
 	case spec == "":
 		// bare mx, nothing to parse
 	case strings.HasPrefix(spec, "/"):
-		// "/mask" OR "/mask4/mask6"
+		// "/mask4", "//mask6" OR "/mask4//mask6"
 		var err error
-		mask4, mask6, err = parseMasks(strings.TrimPrefix(spec, "/"))
+		mask4, mask6, err = parseMasks(spec)
 		if err != nil {
 			return nil, err
 		}
 	case strings.HasPrefix(spec, ":"):
 		// ":domain"["/"...]
 		afterColon := strings.TrimPrefix(spec, ":")
-		domainPart, maskPart, _ := strings.Cut(afterColon, "/")
+		domainPart, maskPart := splitDualCIDR(afterColon)
 		if domainPart != "" {
-			if _, err := ValidateDomain(domainPart); err != nil {
+			if err := validateDomainSpec(domainPart); err != nil {
 				return nil, fmt.Errorf("bad domain %q", domainPart)
 			}
 			domain = domainPart
@@ -410,9 +584,30 @@ func parseMX(q Qualifier, rest string) (*Mechanism, error) {
 		Domain: domain,
 		Mask4:  mask4,
 		Mask6:  mask6,
+		Macro:  strings.ContainsRune(domain, '%'),
 	}, nil
 }
 
+// splitDualCIDR splits "domain/24//64" into the domain-spec and the
+// dual-cidr-length suffix (including its leading slash).
+func splitDualCIDR(s string) (domain, masks string) {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		return s[:i], s[i:]
+	}
+	return s, ""
+}
+
+// validateDomainSpec checks a domain-spec without macros with
+// ValidateDomainSpec.  Specs containing macros can only be checked after
+// expansion.
+func validateDomainSpec(spec string) error {
+	if strings.ContainsRune(spec, '%') {
+		return nil
+	}
+	_, err := ValidateDomainSpec(spec)
+	return err
+}
+
 // parsePTR parses the “ptr” mechanism – RFC 7208  section 5.5.
 //
 //	ptr              ; current domain
@@ -511,16 +706,43 @@ func parseInclude(q Qualifier, rest string) (*Mechanism, error) {
 // On success the function returns the ASCII (lower-case) domain and nil.
 // On failure, it returns an empty string along with a sentinel error.
 func ValidateDomain(raw string) (string, error) {
+	return ValidateDomainWith(raw, ValidateOptions{})
+}
+
+// ValidateOptions relaxes the checks performed by ValidateDomainWith.  The
+// zero value applies the RFC 7208 section 4.3 rules used by ValidateDomain.
+type ValidateOptions struct {
+	// AllowSingleLabel accepts names without a dot, such as "localhost" or
+	// internal host names used as HELO identities.  Every other check,
+	// including the empty label check, still applies.
+	AllowSingleLabel bool
+
+	// Profile is the IDNA profile used to convert the name to A-labels,
+	// e.g. idna.Registration for the stricter RFC 5891 section 4 rules or a
+	// profile built with idna.Transitional.  nil means idna.Lookup.
+	Profile *idna.Profile
+}
+
+// ValidateDomainWith is ValidateDomain with the checks adjusted by opts.
+func ValidateDomainWith(raw string, opts ValidateOptions) (string, error) {
 	raw = strings.TrimSpace(raw)
 	// Trim the single trailing dot if any
 	raw = strings.TrimSuffix(raw, ".")
 
-	// convert to A-label RFC 5890 section 2.3
-	ascii, err := idna.Lookup.ToASCII(raw)
-	if err != nil {
-		return "", ErrIDNAConversion
+	// convert to A-label RFC 5890 section 2.3; names that are already
+	// lower-case LDH are their own A-label form under idna.Lookup
+	ascii := raw
+	if opts.Profile != nil || !isLowerLDH(raw) {
+		profile := opts.Profile
+		if profile == nil {
+			profile = idna.Lookup
+		}
+		var err error
+		if ascii, err = profile.ToASCII(raw); err != nil {
+			return "", ErrIDNAConversion
+		}
+		ascii = strings.ToLower(ascii)
 	}
-	ascii = strings.ToLower(ascii)
 
 	// check overall length limit
 	if len(ascii) > 255 {
@@ -528,7 +750,7 @@ func ValidateDomain(raw string) (string, error) {
 	}
 
 	labels := strings.Split(ascii, ".")
-	if len(labels) < 2 {
+	if len(labels) < 2 && !opts.AllowSingleLabel {
 		return "", ErrSingleLabel
 	}
 
@@ -547,15 +769,101 @@ func ValidateDomain(raw string) (string, error) {
 	return ascii, nil
 }
 
+// isLowerLDH reports whether every label of name consists of lower-case
+// letters, digits and hyphens, without hyphens at either end or in the third
+// and fourth position (RFC 5891 section 4.2.3.1), which also excludes
+// A-labels.  idna.Lookup returns such names unchanged.
+func isLowerLDH(name string) bool {
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && name[i] != '.' {
+			c := name[i]
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+			continue
+		}
+		label := name[start:i]
+		start = i + 1
+		if label == "" {
+			continue
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' || len(label) >= 4 && label[2:4] == "--" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ToUnicode returns the U-label form of a domain validated by ValidateDomain,
+// suitable for displaying internationalised names to users.  Labels that are
+// not valid A-labels cause an ErrIDNAConversion error.
+func ToUnicode(domain string) (string, error) {
+	unicode, err := idna.Display.ToUnicode(domain)
+	if err != nil {
+		return "", ErrIDNAConversion
+	}
+	return unicode, nil
+}
+
+// ValidateDomainSpec normalises and validates a domain name taken from a
+// domain-spec (RFC 7208 section 7.1), such as the target of include or
+// redirect.  Unlike ValidateDomain it accepts underscore labels like
+// "_spf.example.com", which are common in published records; only the
+// top-level label must still be a hostname label.  Names without underscores
+// are handed to ValidateDomain unchanged.
+func ValidateDomainSpec(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	raw = strings.TrimSuffix(raw, ".")
+	if !strings.ContainsRune(raw, '_') {
+		return ValidateDomain(raw)
+	}
+
+	ascii := strings.ToLower(raw)
+	if len(ascii) > 255 {
+		return "", ErrDomainTooLong
+	}
+
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", ErrSingleLabel
+	}
+
+	for i, lbl := range labels {
+		switch {
+		case len(lbl) == 0:
+			return "", ErrEmptyLabel
+
+		case len(lbl) > 63:
+			return "", ErrLabelTooLong
+
+		case lbl[0] == '-' || lbl[len(lbl)-1] == '-':
+			return "", ErrInvalidChar
+		}
+		for j := 0; j < len(lbl); j++ {
+			c := lbl[j]
+			ok := c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' ||
+				c == '_' && i < len(labels)-1
+			if !ok {
+				return "", ErrInvalidChar
+			}
+		}
+	}
+
+	return ascii, nil
+}
+
 // parserModifier splits one SPF term of the form “name=value” into a *Modifier.
 // It performs *only* the neutral syntax work mandated by RFC 7208 section 6:
 //
 //   - returns (nil, ErrNotModifier) when the token contains no ‘=’ – letting the
 //     caller fall through to mechanism parsing.
 //
-//   - trims leading/trailing whitespace, lower-cases both name and value,
-//     and rejects an empty RHS (“modifier missing value”) with a regular error
-//     that callers SHOULD treat as a permerror.
+//   - trims leading/trailing whitespace, lower-cases the name, keeps the
+//     case of the value, whose macro letters are significant, and rejects
+//     an empty RHS (“modifier missing value”) with a regular error that
+//     callers SHOULD treat as a permerror.
 //
 //   - does **not** validate the value beyond being non-empty – redirect/exp
 //
@@ -568,8 +876,7 @@ func parserModifier(tok string) (*Modifier, error) {
 	var name, value string
 	var ok bool
 	if name, value, ok = strings.Cut(tok, "="); ok {
-		name, value = strings.ToLower(name), strings.ToLower(value)
-		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
 	}
 	if !ok {
 		return nil, ErrNotModifier
@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/idna"
 )
 
 // ---------- quick helpers ---------- //
@@ -113,9 +114,24 @@ func TestParse(t *testing.T) {
 		},
 		{
 			name:     "a explicit domain dual masks",
-			spf:      "v=spf1 a:mail.example.com/24/64 -all",
+			spf:      "v=spf1 a:mail.example.com/24//64 -all",
 			wantMech: []Mechanism{aMech(QPlus, "mail.example.com", 24, 64), allMech(QMinus, "all")},
 		},
+		{
+			name:     "a ip6 mask only",
+			spf:      "v=spf1 a//64 -all",
+			wantMech: []Mechanism{aMech(QPlus, "", -1, 64), allMech(QMinus, "all")},
+		},
+		{
+			name:    "a single slash between masks",
+			spf:     "v=spf1 a/24/64 -all",
+			wantErr: true,
+		},
+		{
+			name:     "a with macro domain",
+			spf:      "v=spf1 a:%{d}.example.com/24 -all",
+			wantMech: []Mechanism{{Qual: QPlus, Kind: "a", Domain: "%{d}.example.com", Mask4: 24, Mask6: -1, Macro: true}, allMech(QMinus, "all")},
+		},
 		{
 			name:    "a bad v4 mask",
 			spf:     "v=spf1 a/33 -all",
@@ -134,7 +150,7 @@ func TestParse(t *testing.T) {
 
 		{
 			name:     "mx explicit domain, dual masks",
-			spf:      "v=spf1 mx:mail.example.org/24/64 -all",
+			spf:      "v=spf1 mx:mail.example.org/24//64 -all",
 			wantMech: []Mechanism{mxMech(QPlus, "mail.example.org", 24, 64), allMech(QMinus, "all")},
 		},
 		{
@@ -142,6 +158,16 @@ func TestParse(t *testing.T) {
 			spf:     "v=spf1 mx/124/129 ~all",
 			wantErr: true,
 		},
+		{
+			name:    "mx single slash between masks",
+			spf:     "v=spf1 mx/24/64 ~all",
+			wantErr: true,
+		},
+		{
+			name:    "mx bad v6 mask after double slash",
+			spf:     "v=spf1 mx/24//129 ~all",
+			wantErr: true,
+		},
 		{
 			name:     "bare ptr then -all",
 			spf:      "v=spf1 ptr -all",
@@ -277,3 +303,248 @@ func TestValidateDomain(t *testing.T) {
 		})
 	}
 }
+
+func TestIsLowerLDH(t *testing.T) {
+	for _, name := range []string{
+		"example.com", "mail-1.example.com", "123.com", "a..com", ".com",
+		"-a.com", "a-.com", "ab--cd.com", "xn--bcher-kva.de", "xn--a.com",
+		"Example.com", "a_b.com", "bücher.de", "",
+	} {
+		fast := isLowerLDH(name)
+		if !fast {
+			continue
+		}
+		// the fast path must agree with idna.Lookup
+		ascii, err := idna.Lookup.ToASCII(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, ascii)
+	}
+	assert.True(t, isLowerLDH("mail-1.example.com"))
+	for _, name := range []string{"-a.com", "a-.com", "ab--cd.com", "xn--bcher-kva.de", "Example.com", "a_b.com", "bücher.de"} {
+		assert.False(t, isLowerLDH(name), name)
+	}
+}
+
+func BenchmarkValidateDomain(b *testing.B) {
+	for _, name := range []string{"mail.example.com", "Mail.Example.COM", "bücher.example"} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				if _, err := ValidateDomain(name); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestParse_DuplicateModifiers(t *testing.T) {
+	cases := []struct {
+		name string
+		spf  string
+	}{
+		{"two redirects", "v=spf1 redirect=a.example.com redirect=b.example.com"},
+		{"two exps", "v=spf1 -all exp=a.example.com exp=b.example.com"},
+		{"mixed case redirect", "v=spf1 redirect=a.example.com REDIRECT=b.example.com"},
+		{"mixed case exp", "v=spf1 -all Exp=a.example.com eXP=a.example.com"},
+		{"mixed case macro redirect", "v=spf1 Redirect=%{d}.example.com redirect=%{d}.example.net"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(tc.spf)
+			require.ErrorIs(t, err, ErrDuplicateModifier)
+		})
+	}
+
+	rec, err := Parse("v=spf1 -all redirect=a.example.com exp=b.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "a.example.com", rec.Redirect.Value)
+	assert.Equal(t, "b.example.com", rec.Exp.Value)
+}
+
+func TestParse_MacroCase(t *testing.T) {
+	rec, err := Parse("v=spf1 Exists:%{L}.x.example a:%{D}.example.com REDIRECT=%{S}.r.example exp=%{L}.exp.example")
+	require.NoError(t, err)
+	require.Len(t, rec.Mechs, 2)
+	assert.Equal(t, "exists", rec.Mechs[0].Kind)
+	assert.Equal(t, "%{L}.x.example", rec.Mechs[0].Domain)
+	assert.Equal(t, "%{D}.example.com", rec.Mechs[1].Domain)
+	assert.Equal(t, "redirect", rec.Redirect.Name)
+	assert.Equal(t, "%{S}.r.example", rec.Redirect.Value)
+	assert.Equal(t, "%{L}.exp.example", rec.Exp.Value)
+}
+
+func TestValidateDomainSpec(t *testing.T) {
+	tc := []struct {
+		raw    string
+		Err    error
+		output string
+	}{
+		{"_spf.example.com", nil, "_spf.example.com"},
+		{"_SPF.Example.COM.", nil, "_spf.example.com"},
+		{"s1._domainkey.example.org", nil, "s1._domainkey.example.org"},
+		{"example.com", nil, "example.com"},
+		{"bücher.example", nil, "xn--bcher-kva.example"},
+		{"_spf", ErrSingleLabel, ""},
+		{"_spf..example.com", ErrEmptyLabel, ""},
+		{"_spf.example._com", ErrInvalidChar, ""},
+		{"_spf.-bad.com", ErrInvalidChar, ""},
+		{"_spf.b!d.com", ErrInvalidChar, ""},
+		{"_" + strings.Repeat("a", 63) + ".com", ErrLabelTooLong, ""},
+	}
+
+	for _, c := range tc {
+		t.Run(c.raw, func(t *testing.T) {
+			domain, err := ValidateDomainSpec(c.raw)
+			if c.Err != nil {
+				require.ErrorIs(t, err, c.Err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.output, domain)
+		})
+	}
+}
+
+func TestValidateDomainWith_SingleLabel(t *testing.T) {
+	opts := ValidateOptions{AllowSingleLabel: true}
+
+	domain, err := ValidateDomainWith("LocalHost.", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", domain)
+
+	domain, err = ValidateDomainWith("example.com", opts)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", domain)
+
+	_, err = ValidateDomainWith("", opts)
+	require.ErrorIs(t, err, ErrEmptyLabel)
+
+	_, err = ValidateDomainWith("localhost", ValidateOptions{})
+	require.ErrorIs(t, err, ErrSingleLabel)
+}
+
+func TestValidateDomainWith_Profile(t *testing.T) {
+	// "ß" is mapped to "ss" by transitional processing only.
+	domain, err := ValidateDomainWith("faß.example", ValidateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "xn--fa-hia.example", domain)
+
+	transitional := idna.New(idna.MapForLookup(), idna.Transitional(true))
+	domain, err = ValidateDomainWith("faß.example", ValidateOptions{Profile: transitional})
+	require.NoError(t, err)
+	assert.Equal(t, "fass.example", domain)
+
+	// Registration rejects input that needs mapping, Lookup lower-cases it.
+	_, err = ValidateDomainWith("Bücher.example", ValidateOptions{Profile: idna.Registration})
+	require.ErrorIs(t, err, ErrIDNAConversion)
+	domain, err = ValidateDomainWith("Bücher.example", ValidateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "xn--bcher-kva.example", domain)
+}
+
+func TestToUnicode(t *testing.T) {
+	got, err := ToUnicode("xn--bcher-kva.example")
+	require.NoError(t, err)
+	assert.Equal(t, "bücher.example", got)
+
+	got, err = ToUnicode("example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", got)
+
+	_, err = ToUnicode("xn--a.example")
+	require.ErrorIs(t, err, ErrIDNAConversion)
+}
+
+func TestMechanism_String(t *testing.T) {
+	terms := []string{
+		"all", "-all", "ip4:192.0.2.0/24", "~ip6:2001:db8::/32", "a", "a/24",
+		"a//64", "a:mail.example.com/24//64", "?mx:example.org", "ptr",
+		"ptr:example.com", "exists:%{i}.bl.example", "-include:_spf.example.com",
+	}
+	for _, term := range terms {
+		rec, err := Parse("v=spf1 " + term)
+		require.NoError(t, err, term)
+		require.Len(t, rec.Mechs, 1)
+		assert.Equal(t, term, rec.Mechs[0].String())
+	}
+
+	rec, err := Parse("v=spf1 +ip4:192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, "ip4:192.0.2.1/32", rec.Mechs[0].String())
+}
+
+func TestParse_ZeroCIDR(t *testing.T) {
+	tests := []struct {
+		term   string
+		v4, v6 bool
+	}{
+		{"ip4:0.0.0.0/0", true, false},
+		{"ip4:192.0.2.1/0", true, false},
+		{"ip6:::/0", false, true},
+		{"a/0", true, false},
+		{"mx:example.com//0", false, true},
+		{"a/0//0", true, true},
+		{"ip4:192.0.2.0/24", false, false},
+		{"mx", false, false},
+		{"all", false, false},
+	}
+	for _, tt := range tests {
+		rec, err := Parse("v=spf1 " + tt.term)
+		require.NoError(t, err, tt.term)
+		v4, v6 := rec.Mechs[0].ZeroCIDR()
+		assert.Equal(t, []bool{tt.v4, tt.v6}, []bool{v4, v6}, tt.term)
+	}
+
+	for _, term := range []string{"ip4:192.0.2.0/00", "ip4:192.0.2.0/024", "ip6:::/+0", "a/00", "mx//064", "ip4:192.0.2.0/"} {
+		_, err := Parse("v=spf1 " + term)
+		require.Error(t, err, term)
+	}
+}
+
+func TestParseMechanism(t *testing.T) {
+	m, err := ParseMechanism("-ip4:192.0.2.0/24")
+	require.NoError(t, err)
+	assert.Equal(t, QMinus, m.Qual)
+	assert.Equal(t, "ip4", m.Kind)
+	assert.Equal(t, "-ip4:192.0.2.0/24", m.String())
+
+	m, err = ParseMechanism("include:_spf.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "_spf.example.com", m.Domain)
+
+	_, err = ParseMechanism("redirect=_spf.example.com")
+	require.ErrorIs(t, err, ErrNotMechanism)
+	for _, term := range []string{"", "bogus", "ip4:192.0.2.1 -all", "a:" + strings.Repeat("x", 2000)} {
+		_, err = ParseMechanism(term)
+		require.Error(t, err, term)
+	}
+}
+
+func TestQualifier(t *testing.T) {
+	cases := []struct {
+		in     string
+		q      Qualifier
+		result string
+	}{
+		{"", QPlus, "pass"},
+		{"+", QPlus, "pass"},
+		{"-", QMinus, "fail"},
+		{"~", QTilde, "softfail"},
+		{"?", QMark, "neutral"},
+	}
+	for _, c := range cases {
+		q, err := ParseQualifier(c.in)
+		require.NoError(t, err, c.in)
+		assert.Equal(t, c.q, q)
+		assert.Equal(t, c.result, q.Result())
+	}
+	assert.Equal(t, "-", QMinus.String())
+	assert.Equal(t, "+", Qualifier(0).String())
+	assert.Equal(t, "pass", Qualifier(0).Result())
+
+	for _, in := range []string{"!", "--", "all"} {
+		_, err := ParseQualifier(in)
+		require.ErrorIs(t, err, ErrInvalidQualifier, in)
+	}
+}
@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Fix is one change proposed by Repair.
+type Fix struct {
+	Term        string // offending text as written, "" for an insertion
+	Replacement string // new text, "" when the term is removed
+	Reason      string
+}
+
+func (f Fix) String() string {
+	switch {
+	case f.Term == "":
+		return fmt.Sprintf("add %q: %s", f.Replacement, f.Reason)
+	case f.Replacement == "":
+		return fmt.Sprintf("remove %q: %s", f.Term, f.Reason)
+	}
+
+	return fmt.Sprintf("replace %q with %q: %s", f.Term, f.Replacement, f.Reason)
+}
+
+// repairKinds are the mechanisms whose name Repair separates from a target
+// written without colon, longest first.
+var repairKinds = []string{"include", "exists", "ip4", "ip6", "ptr"}
+
+// Repair proposes fixes for the problems that make Parse reject raw and
+// returns the record with the fixes applied.  It restores a missing version,
+// rejoins "include: domain" split by a space, lower-cases mechanism names,
+// inserts missing colons, corrects misspelt mechanism names and malformed
+// ip4 and ip6 networks, drops duplicate modifiers and removes terms it
+// cannot make sense of.  Valid terms are kept as written.  Repair fails when
+// even the repaired record does not parse, e.g. because no term is left.
+func Repair(raw string) (string, []Fix, error) {
+	var fixes []Fix
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(raw), `"`))
+	switch {
+	case len(fields) > 0 && strings.EqualFold(fields[0], "v=spf1"):
+		fields = fields[1:]
+	case len(fields) > 0 && len(fields[0]) > 6 && strings.EqualFold(fields[0][:6], "v=spf1"):
+		fixes = append(fixes, Fix{Term: fields[0], Replacement: "v=spf1 " + fields[0][6:], Reason: "separate the version from the first term"})
+		fields[0] = fields[0][6:]
+	case len(fields) > 0 && strings.HasPrefix(strings.ToLower(fields[0]), "v=spf"), len(fields) > 0 && strings.EqualFold(fields[0], "spf1"):
+		fixes = append(fixes, Fix{Term: fields[0], Replacement: "v=spf1", Reason: "the version must be v=spf1"})
+		fields = fields[1:]
+	default:
+		fixes = append(fixes, Fix{Replacement: "v=spf1", Reason: "records start with the version v=spf1"})
+	}
+
+	var terms []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(fields); i++ {
+		tok := fields[i]
+		if strings.HasSuffix(tok, ":") && i+1 < len(fields) && validTerm(tok+fields[i+1]) {
+			fixes = append(fixes, Fix{Term: tok + " " + fields[i+1], Replacement: tok + fields[i+1], Reason: "no space is allowed after the colon"})
+			tok += fields[i+1]
+			i++
+		}
+		if mod, err := parserModifier(tok); err == nil && (mod.Name == "redirect" || mod.Name == "exp") {
+			if seen[mod.Name] {
+				fixes = append(fixes, Fix{Term: tok, Reason: mod.Name + " may appear only once"})
+				continue
+			}
+			seen[mod.Name] = true
+		}
+		if validTerm(tok) {
+			terms = append(terms, tok)
+			continue
+		}
+		fix := repairTerm(tok)
+		fixes = append(fixes, fix)
+		if fix.Replacement != "" {
+			terms = append(terms, fix.Replacement)
+		}
+	}
+
+	record := strings.Join(append([]string{"v=spf1"}, terms...), " ")
+	if _, err := Parse(record); err != nil {
+		return "", fixes, err
+	}
+
+	return record, fixes, nil
+}
+
+// validTerm reports whether Parse accepts tok as the only term of a record.
+func validTerm(tok string) bool {
+	_, err := Parse("v=spf1 " + tok)
+	return err == nil
+}
+
+// repairTerm proposes a fix for a term that does not parse.
+func repairTerm(tok string) Fix {
+	try := func(candidate, reason string) (Fix, bool) {
+		return Fix{Term: tok, Replacement: candidate, Reason: reason}, candidate != tok && validTerm(candidate)
+	}
+
+	if fix, ok := try(strings.TrimRight(strings.Trim(tok, `"'`), ".,;"), "stray punctuation"); ok {
+		return fix
+	}
+	_, rest := stripQualifier(tok)
+	prefix := tok[:len(tok)-len(rest)]
+	name, arg, hasArg := strings.Cut(rest, ":")
+	for _, kind := range repairKinds {
+		if lower := strings.ToLower(rest); len(lower) > len(kind) && strings.HasPrefix(lower, kind) && lower[len(kind)] != ':' {
+			if fix, ok := try(prefix+kind+":"+rest[len(kind):], "a colon separates the mechanism from its argument"); ok {
+				return fix
+			}
+		}
+	}
+	var unknown *UnknownTermError
+	if _, err := Parse("v=spf1 " + tok); errors.As(err, &unknown) && len(unknown.Suggestions) > 0 {
+		return Fix{Term: tok, Replacement: unknown.Suggestions[0], Reason: "unknown mechanism"}
+	}
+	if kind := strings.ToLower(name); hasArg && (kind == "ip4" || kind == "ip6") {
+		if network, ok := repairNetwork(arg); ok {
+			if fix, ok := try(prefix+network, fmt.Sprintf("%s is not a valid %s network", arg, kind)); ok {
+				return fix
+			}
+		}
+	}
+
+	return Fix{Term: tok, Reason: "not a valid mechanism or modifier"}
+}
+
+// repairNetwork returns the ip4 or ip6 term for a malformed network: an
+// address of the other family, a prefix length out of range, leading zeros
+// or a truncated IPv4 address such as "192.0.2" for 192.0.2.0/24.
+func repairNetwork(arg string) (string, bool) {
+	addr, bits, hasBits := strings.Cut(arg, "/")
+	addr = strings.TrimSuffix(addr, ".")
+
+	ip := net.ParseIP(addr)
+	if ip == nil && !strings.Contains(addr, ":") {
+		octets := strings.Split(addr, ".")
+		if len(octets) > 4 {
+			return "", false
+		}
+		for i, o := range octets {
+			n, err := strconv.Atoi(o)
+			if err != nil || n < 0 || n > 255 {
+				return "", false
+			}
+			octets[i] = strconv.Itoa(n)
+		}
+		if len(octets) < 4 && !hasBits {
+			bits, hasBits = strconv.Itoa(8*len(octets)), true
+		}
+		for len(octets) < 4 {
+			octets = append(octets, "0")
+		}
+		ip = net.ParseIP(strings.Join(octets, "."))
+	}
+	if ip == nil {
+		return "", false
+	}
+
+	kind, max := "ip6:", 128
+	if ip.To4() != nil {
+		kind, max = "ip4:", 32
+	}
+	if !hasBits {
+		return kind + ip.String(), true
+	}
+	n, err := strconv.Atoi(bits)
+	if err != nil {
+		return "", false
+	}
+	if n < 0 || n > max {
+		n = max
+	}
+
+	return kind + ip.String() + "/" + strconv.Itoa(n), true
+}
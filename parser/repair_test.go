@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		want  string
+		fixes []Fix
+	}{
+		{"valid", "v=spf1 mx -all", "v=spf1 mx -all", nil},
+		{"missing version", "mx -all", "v=spf1 mx -all", []Fix{{Replacement: "v=spf1", Reason: "records start with the version v=spf1"}}},
+		{"quoted, glued version", `"v=spf1mx -all"`, "v=spf1 mx -all", []Fix{{Term: "v=spf1mx", Replacement: "v=spf1 mx", Reason: "separate the version from the first term"}}},
+		{"space after colon", "v=spf1 include: _spf.example.com -all", "v=spf1 include:_spf.example.com -all",
+			[]Fix{{Term: "include: _spf.example.com", Replacement: "include:_spf.example.com", Reason: "no space is allowed after the colon"}}},
+		{"missing colon", "v=spf1 include_spf.example.com ~all", "v=spf1 include:_spf.example.com ~all",
+			[]Fix{{Term: "include_spf.example.com", Replacement: "include:_spf.example.com", Reason: "a colon separates the mechanism from its argument"}}},
+		{"upper case", "v=spf1 -IP4:192.0.2.1 -all", "v=spf1 -IP4:192.0.2.1 -all", nil},
+		{"punctuation", "v=spf1 mx, -all.", "v=spf1 mx -all", []Fix{
+			{Term: "mx,", Replacement: "mx", Reason: "stray punctuation"},
+			{Term: "-all.", Replacement: "-all", Reason: "stray punctuation"},
+		}},
+		{"networks", "v=spf1 ip4:2001:db8::/32 ip4:192.0.2 ip6:192.0.2.1/40 ip4:010.1.2.3 -all", "v=spf1 ip6:2001:db8::/32 ip4:192.0.2.0/24 ip4:192.0.2.1/32 ip4:10.1.2.3 -all", []Fix{
+			{Term: "ip4:2001:db8::/32", Replacement: "ip6:2001:db8::/32", Reason: "2001:db8::/32 is not a valid ip4 network"},
+			{Term: "ip4:192.0.2", Replacement: "ip4:192.0.2.0/24", Reason: "192.0.2 is not a valid ip4 network"},
+			{Term: "ip6:192.0.2.1/40", Replacement: "ip4:192.0.2.1/32", Reason: "192.0.2.1/40 is not a valid ip6 network"},
+			{Term: "ip4:010.1.2.3", Replacement: "ip4:10.1.2.3", Reason: "010.1.2.3 is not a valid ip4 network"},
+		}},
+		{"misspelt", "v=spf1 ipv4:192.0.2.1 -alle", "v=spf1 ip4:192.0.2.1 -all", []Fix{
+			{Term: "ipv4:192.0.2.1", Replacement: "ip4:192.0.2.1", Reason: "unknown mechanism"},
+			{Term: "-alle", Replacement: "-all", Reason: "unknown mechanism"},
+		}},
+		{"stray text and duplicates", "v=spf1 a please mx redirect=a.example.com redirect=b.example.com", "v=spf1 a mx redirect=a.example.com", []Fix{
+			{Term: "please", Reason: "not a valid mechanism or modifier"},
+			{Term: "redirect=b.example.com", Reason: "redirect may appear only once"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fixes, err := Repair(tt.raw)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.fixes, fixes)
+		})
+	}
+}
+
+func TestRepair_Unrepairable(t *testing.T) {
+	_, fixes, err := Repair("v=spf1 hello")
+	require.Error(t, err)
+	assert.Equal(t, []Fix{{Term: "hello", Reason: "not a valid mechanism or modifier"}}, fixes)
+}
+
+func TestFix_String(t *testing.T) {
+	assert.Equal(t, `add "v=spf1": why`, Fix{Replacement: "v=spf1", Reason: "why"}.String())
+	assert.Equal(t, `remove "x": why`, Fix{Term: "x", Reason: "why"}.String())
+	assert.Equal(t, `replace "x" with "y": why`, Fix{Term: "x", Replacement: "y", Reason: "why"}.String())
+}
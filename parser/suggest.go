@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownMechanism is matched by the errors Parse returns for terms that
+// name no mechanism of RFC 7208 section 5.
+var ErrUnknownMechanism = errors.New("unknown mechanism")
+
+// mechanismNames are the mechanisms of RFC 7208 section 5.
+var mechanismNames = []string{"all", "include", "a", "mx", "ptr", "ip4", "ip6", "exists"}
+
+// UnknownTermError is returned by Parse for a term naming no mechanism, such
+// as "ipv4:192.0.2.1" or "-alle".  Suggestions lists similar valid terms,
+// closest first.
+type UnknownTermError struct {
+	Term        string
+	Suggestions []string
+}
+
+func (e *UnknownTermError) Error() string {
+	msg := fmt.Sprintf("permerror: %v %q", ErrUnknownMechanism, e.Term)
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %q?", e.Suggestions[0])
+	}
+
+	return msg
+}
+
+func (e *UnknownTermError) Unwrap() error {
+	return ErrUnknownMechanism
+}
+
+// unknownTerm returns an *UnknownTermError when tok, which failed to parse,
+// does not name a mechanism in lower case, and nil otherwise.
+func unknownTerm(tok string) error {
+	_, rest := stripQualifier(tok)
+	prefix := tok[:len(tok)-len(rest)]
+	end := strings.IndexAny(rest, ":/")
+	if end < 0 {
+		end = len(rest)
+	}
+	name := rest[:end]
+	// section 4.6.1: mechanism names are case-insensitive
+	for _, known := range mechanismNames {
+		if strings.EqualFold(name, known) {
+			return nil
+		}
+	}
+
+	type candidate struct {
+		term string
+		dist int
+	}
+	var candidates []candidate
+	lower := strings.ToLower(name)
+	for _, known := range mechanismNames {
+		d := editDistance(lower, known)
+		if d > 2 || d > 1 && len(known) < 5 {
+			continue
+		}
+		if term := prefix + known + rest[end:]; validTerm(term) {
+			candidates = append(candidates, candidate{term, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+
+	err := &UnknownTermError{Term: tok}
+	for _, c := range candidates {
+		err.Suggestions = append(err.Suggestions, c.term)
+	}
+
+	return err
+}
+
+// editDistance returns the Damerau-Levenshtein distance between a and b,
+// counting insertions, deletions, substitutions and transpositions of
+// adjacent bytes.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(a)][len(b)]
+}
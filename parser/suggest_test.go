@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_Suggestions(t *testing.T) {
+	tests := []struct {
+		term string
+		want []string
+	}{
+		{"ipv4:192.0.2.1", []string{"ip4:192.0.2.1"}},
+		{"includ:_spf.example.com", []string{"include:_spf.example.com"}},
+		{"-alle", []string{"-all"}},
+		{"exsits:%{i}.example.com", []string{"exists:%{i}.example.com"}},
+		{"ipv6:2001:db8::1", []string{"ip6:2001:db8::1"}},
+		{"hello", nil},
+	}
+	for _, tt := range tests {
+		_, err := Parse("v=spf1 " + tt.term + " -all")
+		require.ErrorIs(t, err, ErrUnknownMechanism, tt.term)
+		var unknown *UnknownTermError
+		require.ErrorAs(t, err, &unknown)
+		assert.Equal(t, tt.term, unknown.Term)
+		assert.Equal(t, tt.want, unknown.Suggestions, tt.term)
+	}
+
+	_, err := Parse("v=spf1 ipv4:192.0.2.1")
+	assert.EqualError(t, err, `permerror: unknown mechanism "ipv4:192.0.2.1", did you mean "ip4:192.0.2.1"?`)
+
+	_, err = Parse("v=spf1 ip4:192.0.2.300")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnknownMechanism, "known mechanism with a bad argument")
+}
+
+func TestParse_MechanismCase(t *testing.T) {
+	rec, err := Parse("v=spf1 Include:example.com IP4:192.0.2.0/24 mX/24 -ALL")
+	require.NoError(t, err)
+	require.Len(t, rec.Mechs, 4)
+	for i, want := range []string{"include", "ip4", "mx", "all"} {
+		assert.Equal(t, want, rec.Mechs[i].Kind)
+	}
+	assert.Equal(t, QMinus, rec.Mechs[3].Qual)
+
+	for _, term := range []string{"-ALL", "Include:example.com", "EXISTS:%{i}.example.com"} {
+		assert.NoError(t, unknownTerm(term), "known mechanism %s", term)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("mx", "mx"))
+	assert.Equal(t, 1, editDistance("ipv4", "ip4"))
+	assert.Equal(t, 1, editDistance("exsits", "exists"))
+	assert.Equal(t, 3, editDistance("", "all"))
+}
@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"iter"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenKind classifies a byte range of a record for syntax highlighting.
+type TokenKind int
+
+const (
+	TokenVersion       TokenKind = iota // "v=spf1"
+	TokenQualifier                      // "+", "-", "~" or "?"
+	TokenMechanism                      // mechanism name, e.g. "include"
+	TokenSeparator                      // ":" or "=" after a term name
+	TokenDomainSpec                     // literal part of a domain-spec
+	TokenMacro                          // macro or escape, e.g. "%{d}" or "%%"
+	TokenCIDR                           // network or prefix length, e.g. "192.0.2.0/24" or "/24"
+	TokenModifierName                   // modifier name, e.g. "redirect"
+	TokenModifierValue                  // literal part of an unknown modifier's value
+	TokenInvalid                        // a term that names no mechanism or modifier
+)
+
+var tokenKindNames = [...]string{
+	"version", "qualifier", "mechanism", "separator", "domain-spec",
+	"macro", "cidr", "modifier-name", "modifier-value", "invalid",
+}
+
+func (k TokenKind) String() string {
+	if k < 0 || int(k) >= len(tokenKindNames) {
+		return "unknown"
+	}
+	return tokenKindNames[k]
+}
+
+// Token is a classified byte range [Start, End) of a record.
+type Token struct {
+	Kind       TokenKind
+	Start, End int
+}
+
+// Tokens classifies the bytes of record, skipping whitespace, so that
+// editors can highlight it without implementing the grammar of RFC 7208
+// section 4.6.  Tokens never fail: terms that are not well formed are
+// classified as far as possible and unknown terms are TokenInvalid.
+func Tokens(record string) []Token {
+	var toks []Token
+	add := func(kind TokenKind, start, end int) {
+		if end > start {
+			toks = append(toks, Token{Kind: kind, Start: start, End: end})
+		}
+	}
+
+	first := true
+	for i := 0; i < len(record); {
+		if record[i] == ' ' || record[i] == '\t' {
+			i++
+			continue
+		}
+		end := i + strings.IndexAny(record[i:]+" ", " \t")
+		term := record[i:end]
+		if first && strings.EqualFold(term, "v=spf1") {
+			add(TokenVersion, i, end)
+		} else {
+			tokenizeTerm(add, term, i)
+		}
+		first = false
+		i = end
+	}
+
+	return toks
+}
+
+// Terms returns an iterator over the whitespace-separated terms of record,
+// starting with its version, as substrings of record.  Unlike strings.Fields
+// it builds no slice, so callers processing large corpora can stream the
+// terms of each record, stopping at the first term they are not interested
+// in.
+func Terms(record string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		start := -1
+		for i := 0; i < len(record); {
+			r, size := rune(record[i]), 1
+			if r >= utf8.RuneSelf {
+				r, size = utf8.DecodeRuneInString(record[i:])
+			}
+			switch {
+			case !unicode.IsSpace(r):
+				if start < 0 {
+					start = i
+				}
+			case start >= 0:
+				if !yield(record[start:i]) {
+					return
+				}
+				start = -1
+			}
+			i += size
+		}
+		if start >= 0 {
+			yield(record[start:])
+		}
+	}
+}
+
+// tokenizeTerm classifies one term starting at offset off.
+func tokenizeTerm(add func(TokenKind, int, int), term string, off int) {
+	nameEnd := strings.IndexAny(term, ":/=")
+	if nameEnd < 0 {
+		nameEnd = len(term)
+	}
+
+	if nameEnd < len(term) && term[nameEnd] == '=' {
+		add(TokenModifierName, off, off+nameEnd)
+		add(TokenSeparator, off+nameEnd, off+nameEnd+1)
+		kind := TokenModifierValue
+		if name := strings.ToLower(term[:nameEnd]); name == "redirect" || name == "exp" {
+			kind = TokenDomainSpec
+		}
+		macroString(add, term[nameEnd+1:], off+nameEnd+1, kind)
+		return
+	}
+
+	_, rest := stripQualifier(term)
+	qlen := len(term) - len(rest)
+	name := strings.ToLower(term[qlen:nameEnd])
+	known := false
+	for _, m := range mechanismNames {
+		known = known || m == name
+	}
+	if !known {
+		add(TokenInvalid, off, off+len(term))
+		return
+	}
+	if qlen > 0 {
+		add(TokenQualifier, off, off+qlen)
+	}
+	add(TokenMechanism, off+qlen, off+nameEnd)
+
+	arg, argOff := term[nameEnd:], off+nameEnd
+	if strings.HasPrefix(arg, ":") {
+		add(TokenSeparator, argOff, argOff+1)
+		arg, argOff = arg[1:], argOff+1
+		if name == "ip4" || name == "ip6" {
+			add(TokenCIDR, argOff, argOff+len(arg))
+			return
+		}
+		specEnd := len(arg)
+		if name == "a" || name == "mx" {
+			if j := strings.IndexByte(arg, '/'); j >= 0 {
+				specEnd = j
+			}
+		}
+		macroString(add, arg[:specEnd], argOff, TokenDomainSpec)
+		arg, argOff = arg[specEnd:], argOff+specEnd
+	}
+	add(TokenCIDR, argOff, argOff+len(arg))
+}
+
+// macroString classifies a macro-string (RFC 7208 section 7.1) starting at
+// off, marking macros and escapes as TokenMacro and the rest as kind.
+func macroString(add func(TokenKind, int, int), s string, off int, kind TokenKind) {
+	lit := 0
+	for i := 0; i < len(s); {
+		if s[i] != '%' || i+1 >= len(s) {
+			i++
+			continue
+		}
+		end := i + 2
+		if s[i+1] == '{' {
+			j := strings.IndexByte(s[i:], '}')
+			if j < 0 {
+				i++
+				continue
+			}
+			end = i + j + 1
+		}
+		add(kind, off+lit, off+i)
+		add(TokenMacro, off+i, off+end)
+		lit, i = end, end
+	}
+	add(kind, off+lit, off+len(s))
+}
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// render shows each token as kind(text).
+func render(record string) []string {
+	var out []string
+	for _, t := range Tokens(record) {
+		out = append(out, fmt.Sprintf("%s(%s)", t.Kind, record[t.Start:t.End]))
+	}
+	return out
+}
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		record string
+		want   []string
+	}{
+		{"v=spf1  -all", []string{"version(v=spf1)", "qualifier(-)", "mechanism(all)"}},
+		{"v=spf1 ip4:192.0.2.0/24 ~ip6:2001:db8::/32", []string{
+			"version(v=spf1)", "mechanism(ip4)", "separator(:)", "cidr(192.0.2.0/24)",
+			"qualifier(~)", "mechanism(ip6)", "separator(:)", "cidr(2001:db8::/32)",
+		}},
+		{"v=spf1 a/24//64 mx:mail.example.com/28", []string{
+			"version(v=spf1)", "mechanism(a)", "cidr(/24//64)",
+			"mechanism(mx)", "separator(:)", "domain-spec(mail.example.com)", "cidr(/28)",
+		}},
+		{"v=spf1 exists:%{ir}.%{l1r+-}._spf.%{d} include:%%x", []string{
+			"version(v=spf1)", "mechanism(exists)", "separator(:)",
+			"macro(%{ir})", "domain-spec(.)", "macro(%{l1r+-})", "domain-spec(._spf.)", "macro(%{d})",
+			"mechanism(include)", "separator(:)", "macro(%%)", "domain-spec(x)",
+		}},
+		{"v=spf1 redirect=_spf.example.com foo=%{i}bar", []string{
+			"version(v=spf1)", "modifier-name(redirect)", "separator(=)", "domain-spec(_spf.example.com)",
+			"modifier-name(foo)", "separator(=)", "macro(%{i})", "modifier-value(bar)",
+		}},
+		{"v=spf1 ipv4:192.0.2.1 include:%{d", []string{
+			"version(v=spf1)", "invalid(ipv4:192.0.2.1)", "mechanism(include)", "separator(:)", "domain-spec(%{d)",
+		}},
+		{"spf1 mx", []string{"invalid(spf1)", "mechanism(mx)"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, render(tt.record), tt.record)
+	}
+}
+
+func TestTokenKind_String(t *testing.T) {
+	assert.Equal(t, "cidr", TokenCIDR.String())
+	assert.Equal(t, "unknown", TokenKind(42).String())
+}
+
+func TestTerms(t *testing.T) {
+	for _, record := range []string{
+		"v=spf1 ip4:192.0.2.0/24 -all",
+		"  v=spf1\tmx   a:mail.example.com ~all  ",
+		"",
+		"   ",
+		"v=spf1",
+	} {
+		assert.Equal(t, strings.Fields(record), append([]string{}, slices.Collect(Terms(record))...), record)
+	}
+
+	var seen []string
+	for term := range Terms("v=spf1 include:_spf.example.com -all exp=explain.example.com") {
+		if strings.HasSuffix(term, "all") {
+			break
+		}
+		seen = append(seen, term)
+	}
+	assert.Equal(t, []string{"v=spf1", "include:_spf.example.com"}, seen)
+
+	n := 0
+	allocs := testing.AllocsPerRun(100, func() {
+		n = 0
+		for range Terms("v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 include:_spf.example.com -all") {
+			n++
+		}
+	})
+	assert.Equal(t, 5, n)
+	assert.Zero(t, allocs)
+}
@@ -0,0 +1,230 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// Workload is a canned set of checks together with the DNS data answering
+// them, for comparing the performance of Checker configurations:
+//
+//	w := spf.Workload{TXT: records, Samples: samples, Latency: time.Millisecond}
+//	base, _ := w.Run(ctx, "default")
+//	cached, _ := w.Run(ctx, "cached", spf.WithCache(spf.NewMemoryCache(time.Minute)))
+//
+// Names missing from the maps do not exist.
+type Workload struct {
+	TXT map[string][]string // TXT records by name
+	IP  map[string][]string // A and AAAA addresses by name, e.g. "192.0.2.1"
+	MX  map[string][]string // exchanges by name, in preference order
+	PTR map[string][]string // names by address, e.g. "192.0.2.1"
+	// Latency delays every scripted answer, standing in for the network.
+	Latency time.Duration
+	Samples []Sample
+	Rounds  int // passes over Samples, at least 1
+}
+
+// StageStats measures one stage of a PerfReport.
+type StageStats struct {
+	Name   string
+	Calls  int
+	Time   time.Duration // total
+	Allocs uint64        // heap allocations, total
+	Bytes  uint64        // heap bytes allocated, total
+}
+
+// PerTime returns the average time of a call.
+func (s StageStats) PerTime() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+
+	return s.Time / time.Duration(s.Calls)
+}
+
+// PerAllocs returns the average allocations of a call.
+func (s StageStats) PerAllocs() uint64 {
+	if s.Calls == 0 {
+		return 0
+	}
+
+	return s.Allocs / uint64(s.Calls)
+}
+
+// PerfReport is the outcome of running a Workload with one Checker
+// configuration.
+type PerfReport struct {
+	Name string
+	// Stages are "parse", parsing the SPF record of every name once,
+	// "resolve", the time spent in the scripted resolver including
+	// Latency, and "check", the checks of the samples end to end.
+	// Allocations of the resolve stage are included in those of check.
+	Stages []StageStats
+	// P50 and P99 are percentiles of the latency of a check.
+	P50, P99 time.Duration
+	Results  map[Result]int
+}
+
+// Stage returns the stage named name.
+func (r PerfReport) Stage(name string) StageStats {
+	for _, s := range r.Stages {
+		if s.Name == name {
+			return s
+		}
+	}
+
+	return StageStats{Name: name}
+}
+
+// String formats r as one line per stage.
+func (r PerfReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: p50 %v, p99 %v\n", r.Name, r.P50, r.P99)
+	for _, s := range r.Stages {
+		fmt.Fprintf(&b, "  %-8s %6d calls %12v/call %6d allocs/call\n", s.Name, s.Calls, s.PerTime(), s.PerAllocs())
+	}
+
+	return b.String()
+}
+
+// Run checks the samples of w, one after another, with a Checker created
+// with opts on the scripted DNS data, and reports the time and allocations of
+// each stage.  Checks run sequentially so that allocations are attributed
+// to them; run one configuration at a time for the same reason.  Run stops
+// at the first context error or invalid sample.
+func (w Workload) Run(ctx context.Context, name string, opts ...Option) (PerfReport, error) {
+	report := PerfReport{Name: name, Results: make(map[Result]int)}
+
+	parse := StageStats{Name: "parse"}
+	measure(&parse, func() {
+		for _, txts := range w.TXT {
+			if rec, err := filterSPF(txts); err == nil && rec != "" {
+				parser.Parse(rec)
+				parse.Calls++
+			}
+		}
+	})
+
+	r := &workloadResolver{w: &w}
+	ch := NewChecker(NewCustomDNSResolver(r), opts...)
+	check := StageStats{Name: "check"}
+	var latencies []time.Duration
+	var err error
+	measure(&check, func() {
+		for range max(w.Rounds, 1) {
+			for _, s := range w.Samples {
+				start := time.Now()
+				var res CheckHostResult
+				if res, err = replaySample(ctx, ch, s); err != nil {
+					return
+				}
+				latencies = append(latencies, time.Since(start))
+				report.Results[res.Code]++
+			}
+		}
+	})
+	if err != nil {
+		return PerfReport{}, err
+	}
+	check.Calls = len(latencies)
+
+	report.Stages = []StageStats{parse, {Name: "resolve", Calls: r.calls, Time: r.time}, check}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		report.P50 = latencies[len(latencies)/2]
+		report.P99 = latencies[len(latencies)*99/100]
+	}
+
+	return report, nil
+}
+
+// measure runs fn and adds its time and heap allocations to s.
+func measure(s *StageStats, fn func()) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	fn()
+	s.Time += time.Since(start)
+	runtime.ReadMemStats(&after)
+	s.Allocs += after.Mallocs - before.Mallocs
+	s.Bytes += after.TotalAlloc - before.TotalAlloc
+}
+
+// workloadResolver answers from the maps of a Workload and times the
+// answers.  Checks run sequentially, so it needs no locking.
+type workloadResolver struct {
+	w     *Workload
+	calls int
+	time  time.Duration
+}
+
+// answer returns the entries of m for key after the workload's latency.
+func (r *workloadResolver) answer(ctx context.Context, m map[string][]string, key string) ([]string, error) {
+	start := time.Now()
+	defer func() {
+		r.calls++
+		r.time += time.Since(start)
+	}()
+	if r.w.Latency > 0 {
+		t := time.NewTimer(r.w.Latency)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+	values, ok := m[key]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: key, IsNotFound: true}
+	}
+
+	return values, nil
+}
+
+func (r *workloadResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	return r.answer(ctx, r.w.TXT, strings.TrimSuffix(domain, "."))
+}
+
+func (r *workloadResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	host = strings.TrimSuffix(host, ".")
+	addrs, err := r.answer(ctx, r.w.IP, host)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if ip := net.ParseIP(a); ip != nil && (ip.To4() != nil) == (network == "ip4") {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return ips, nil
+}
+
+func (r *workloadResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	hosts, err := r.answer(ctx, r.w.MX, strings.TrimSuffix(name, "."))
+	if err != nil {
+		return nil, err
+	}
+	mxs := make([]*net.MX, 0, len(hosts))
+	for i, h := range hosts {
+		mxs = append(mxs, &net.MX{Host: h, Pref: uint16(10 * i)})
+	}
+
+	return mxs, nil
+}
+
+func (r *workloadResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.answer(ctx, r.w.PTR, addr)
+}
@@ -0,0 +1,78 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func perfWorkload() Workload {
+	return Workload{
+		TXT: map[string][]string{
+			"example.com":       {"v=spf1 mx include:_spf.example.net -all"},
+			"_spf.example.net":  {"v=spf1 ip4:198.51.100.0/24 a:relay.example.net ~all"},
+			"other.example.org": {"not spf", "v=spf1 ip6:2001:db8::/32 -all"},
+		},
+		IP: map[string][]string{
+			"mx.example.com":    {"192.0.2.10"},
+			"relay.example.net": {"203.0.113.5", "2001:db8::5"},
+		},
+		MX: map[string][]string{"example.com": {"mx.example.com"}},
+		Samples: []Sample{
+			{IP: net.ParseIP("192.0.2.10"), MailFrom: "a@example.com"},
+			{IP: net.ParseIP("198.51.100.7"), MailFrom: "b@example.com"},
+			{IP: net.ParseIP("203.0.113.9"), MailFrom: "c@example.com"},
+			{IP: net.ParseIP("2001:db8::1"), MailFrom: "d@other.example.org"},
+		},
+		Rounds: 3,
+	}
+}
+
+func TestWorkload_Run(t *testing.T) {
+	w := perfWorkload()
+	report, err := w.Run(context.Background(), "default")
+	require.NoError(t, err)
+
+	assert.Equal(t, "default", report.Name)
+	assert.Equal(t, map[Result]int{Pass: 9, Fail: 3}, report.Results)
+	assert.Equal(t, 3, report.Stage("parse").Calls)
+	assert.Equal(t, 12, report.Stage("check").Calls)
+	assert.NotZero(t, report.Stage("check").Allocs)
+	assert.NotZero(t, report.Stage("resolve").Calls)
+	assert.LessOrEqual(t, report.P50, report.P99)
+	assert.Contains(t, report.String(), "check")
+	assert.Zero(t, report.Stage("missing").Calls)
+}
+
+func TestWorkload_RunCached(t *testing.T) {
+	w := perfWorkload()
+	w.Latency = time.Millisecond
+	ctx := context.Background()
+
+	base, err := w.Run(ctx, "default")
+	require.NoError(t, err)
+	cached, err := w.Run(ctx, "cached", WithCache(NewMemoryCache(time.Minute)))
+	require.NoError(t, err)
+
+	assert.Equal(t, base.Results, cached.Results)
+	assert.Less(t, cached.Stage("resolve").Calls, base.Stage("resolve").Calls)
+	assert.GreaterOrEqual(t, base.Stage("resolve").Time, time.Duration(base.Stage("resolve").Calls)*time.Millisecond)
+}
+
+func TestWorkload_RunErrors(t *testing.T) {
+	w := perfWorkload()
+	w.Samples = append(w.Samples, Sample{MailFrom: "x@example.com"})
+	_, err := w.Run(context.Background(), "invalid")
+	require.ErrorIs(t, err, ErrInvalidIP)
+
+	w = perfWorkload()
+	w.Latency = time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = w.Run(ctx, "canceled")
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,339 @@
+package spf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// PluginProtocol is the version of the plugin protocol spoken by Plugin.
+const PluginProtocol = 1
+
+// pluginGrace is the time a plugin process has to exit once its input is
+// closed, before it is killed.
+const pluginGrace = 5 * time.Second
+
+// ErrPluginClosed is returned for requests to a plugin that exited or was
+// closed.
+var ErrPluginClosed = errors.New("plugin closed")
+
+// PluginHello is the first line a plugin writes: the protocol version it
+// speaks and the terms it handles.
+//
+//	{"protocol":1,"mechanisms":["x-geo"],"modifiers":["x-rate"]}
+type PluginHello struct {
+	Protocol   int      `json:"protocol"`
+	Mechanisms []string `json:"mechanisms,omitempty"`
+	Modifiers  []string `json:"modifiers,omitempty"`
+}
+
+// PluginRequest is a line sent to a plugin for each term to evaluate, the
+// ExtensionRequest with an ID echoed in the response.
+//
+//	{"id":7,"name":"x-geo","value":"eu","domain":"example.com","ip":"192.0.2.1","sender":"alice@example.com","helo":"mx.example.com"}
+type PluginRequest struct {
+	ID       uint64 `json:"id"`
+	Name     string `json:"name"`
+	Value    string `json:"value,omitempty"`
+	Modifier bool   `json:"modifier,omitempty"`
+	Domain   string `json:"domain"`
+	IP       string `json:"ip"`
+	Sender   string `json:"sender,omitempty"`
+	HELO     string `json:"helo,omitempty"`
+}
+
+// PluginResponse is the line a plugin answers a request with, in any order:
+// the ExtensionResponse, or an error standing for a TempError.
+//
+//	{"id":7,"match":true}
+//	{"id":8,"result":"permerror"}
+//	{"id":9,"error":"geo database unavailable"}
+type PluginResponse struct {
+	ID     uint64 `json:"id"`
+	Match  bool   `json:"match,omitempty"`
+	Result Result `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Plugin evaluates site-specific mechanisms and modifiers in another
+// process, so that policies can be extended without recompiling the program
+// embedding the Checker.  The plugin speaks JSON lines over its standard
+// input and output: it first writes a PluginHello, then answers each
+// PluginRequest with a PluginResponse.  Requests are pipelined, so a plugin
+// may answer them concurrently.  A Plugin is safe for concurrent use.
+type Plugin struct {
+	// Mechanisms and Modifiers are the terms declared by the plugin.
+	Mechanisms []string
+	Modifiers  []string
+
+	cmd  *exec.Cmd
+	w    io.WriteCloser
+	wmu  sync.Mutex // serialises requests
+	done chan struct{}
+
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan PluginResponse
+	err     error // why the plugin stopped answering, once done
+}
+
+// StartPlugin starts the plugin program name with args and waits for its
+// PluginHello until ctx is done.  The plugin's standard error is the
+// caller's.
+func StartPlugin(ctx context.Context, name string, args ...string) (*Plugin, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p, err := newPlugin(ctx, r, w, cmd)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+
+	return p, nil
+}
+
+// NewPlugin speaks the plugin protocol over r and w, e.g. to a plugin
+// reached over a socket, and waits for its PluginHello until ctx is done.
+// Close closes w.
+func NewPlugin(ctx context.Context, r io.Reader, w io.WriteCloser) (*Plugin, error) {
+	return newPlugin(ctx, r, w, nil)
+}
+
+func newPlugin(ctx context.Context, r io.Reader, w io.WriteCloser, cmd *exec.Cmd) (*Plugin, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	hello := make(chan error, 1)
+	var h PluginHello
+	go func() {
+		if !sc.Scan() {
+			hello <- errors.Join(io.ErrUnexpectedEOF, sc.Err())
+			return
+		}
+		hello <- json.Unmarshal(sc.Bytes(), &h)
+	}()
+	select {
+	case <-ctx.Done():
+		w.Close()
+		return nil, ctx.Err()
+	case err := <-hello:
+		if err == nil && h.Protocol != PluginProtocol {
+			err = fmt.Errorf("unsupported protocol %d", h.Protocol)
+		}
+		if err != nil {
+			w.Close()
+			return nil, fmt.Errorf("bad hello: %w", err)
+		}
+	}
+
+	p := &Plugin{
+		Mechanisms: h.Mechanisms,
+		Modifiers:  h.Modifiers,
+		cmd:        cmd,
+		w:          w,
+		done:       make(chan struct{}),
+		pending:    make(map[uint64]chan PluginResponse),
+	}
+	go p.read(sc)
+
+	return p, nil
+}
+
+// read dispatches the responses of the plugin until it stops answering.
+func (p *Plugin) read(sc *bufio.Scanner) {
+	err := ErrPluginClosed
+	for sc.Scan() {
+		var resp PluginResponse
+		if jerr := json.Unmarshal(sc.Bytes(), &resp); jerr != nil {
+			err = fmt.Errorf("%w: bad response: %w", ErrPluginClosed, jerr)
+			break
+		}
+		p.mu.Lock()
+		ch, ok := p.pending[resp.ID]
+		delete(p.pending, resp.ID)
+		p.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+	if sc.Err() != nil {
+		err = fmt.Errorf("%w: %w", ErrPluginClosed, sc.Err())
+	}
+
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+	close(p.done)
+}
+
+// Handle is the Extension evaluating req in the plugin.
+func (p *Plugin) Handle(ctx context.Context, req ExtensionRequest) (ExtensionResponse, error) {
+	ch := make(chan PluginResponse, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		return ExtensionResponse{}, err
+	}
+	p.next++
+	id := p.next
+	p.pending[id] = ch
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	line, err := json.Marshal(PluginRequest{
+		ID:       id,
+		Name:     req.Name,
+		Value:    req.Value,
+		Modifier: req.Modifier,
+		Domain:   req.Domain,
+		IP:       req.IP.String(),
+		Sender:   req.Sender,
+		HELO:     req.HELO,
+	})
+	if err != nil {
+		return ExtensionResponse{}, err
+	}
+	p.wmu.Lock()
+	_, err = p.w.Write(append(line, '\n'))
+	p.wmu.Unlock()
+	if err != nil {
+		return ExtensionResponse{}, fmt.Errorf("%w: %w", ErrPluginClosed, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ExtensionResponse{}, ctx.Err()
+	case <-p.done:
+		select {
+		case resp := <-ch: // answered just before the plugin stopped
+			return resp.extension()
+		default:
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return ExtensionResponse{}, p.err
+	case resp := <-ch:
+		return resp.extension()
+	}
+}
+
+// extension returns the ExtensionResponse, or the error, of resp.
+func (resp PluginResponse) extension() (ExtensionResponse, error) {
+	if resp.Error != "" {
+		return ExtensionResponse{}, errors.New(resp.Error)
+	}
+
+	return ExtensionResponse{Match: resp.Match, Result: resp.Result}, nil
+}
+
+// Close closes the input of the plugin and, for a plugin started with
+// StartPlugin, waits for it to exit, killing it after a grace period.
+func (p *Plugin) Close() error {
+	err := p.w.Close()
+	if p.cmd == nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- p.cmd.Wait() }()
+	select {
+	case werr := <-exited:
+		return errors.Join(err, werr)
+	case <-time.After(pluginGrace):
+		_ = p.cmd.Process.Kill()
+		<-exited
+		return errors.Join(err, fmt.Errorf("plugin killed after %v", pluginGrace))
+	}
+}
+
+// WithPlugin evaluates the mechanisms and modifiers declared by p with it,
+// as WithMechanism and WithModifier do.  The Checker owns p: Close closes
+// it.
+func WithPlugin(p *Plugin) Option {
+	return func(c *Checker) {
+		for _, name := range p.Mechanisms {
+			WithMechanism(name, p.Handle)(c)
+		}
+		for _, name := range p.Modifiers {
+			WithModifier(name, p.Handle)(c)
+		}
+		c.closers = append(c.closers, p)
+	}
+}
+
+// ServePlugin implements the plugin side of the protocol for plugins
+// written in Go: it writes hello to w, then reads requests from r and
+// answers each with fn, concurrently, until r ends.
+//
+//	func main() {
+//		hello := spf.PluginHello{Protocol: spf.PluginProtocol, Mechanisms: []string{"x-geo"}}
+//		spf.ServePlugin(context.Background(), os.Stdin, os.Stdout, hello, geo)
+//	}
+func ServePlugin(ctx context.Context, r io.Reader, w io.Writer, hello PluginHello, fn Extension) error {
+	var wmu sync.Mutex
+	enc := json.NewEncoder(w)
+	write := func(v any) error {
+		wmu.Lock()
+		defer wmu.Unlock()
+		return enc.Encode(v)
+	}
+	if err := write(hello); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var req PluginRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			return fmt.Errorf("bad request: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := PluginResponse{ID: req.ID}
+			out, err := fn(ctx, ExtensionRequest{
+				Name:     req.Name,
+				Value:    req.Value,
+				Modifier: req.Modifier,
+				Domain:   req.Domain,
+				IP:       net.ParseIP(req.IP),
+				Sender:   req.Sender,
+				HELO:     req.HELO,
+			})
+			if err != nil {
+				resp.Error = err.Error()
+			} else {
+				resp.Match, resp.Result = out.Match, out.Result
+			}
+			_ = write(resp)
+		}()
+	}
+
+	return sc.Err()
+}
@@ -0,0 +1,138 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// geoPlugin matches clients in 198.51.100.0/24 for "x-geo:eu" and fails
+// the "x-rate" modifier for 198.51.100.66.
+func geoPlugin(_ context.Context, req ExtensionRequest) (ExtensionResponse, error) {
+	switch {
+	case req.Name == "x-geo" && req.Value == "down":
+		return ExtensionResponse{}, errors.New("geo database unavailable")
+	case req.Name == "x-geo":
+		_, eu, _ := net.ParseCIDR("198.51.100.0/24")
+		return ExtensionResponse{Match: req.Value == "eu" && eu.Contains(req.IP)}, nil
+	case req.Name == "x-rate" && req.IP.Equal(net.ParseIP("198.51.100.66")):
+		return ExtensionResponse{Result: Fail}, nil
+	}
+
+	return ExtensionResponse{}, nil
+}
+
+var geoHello = PluginHello{Protocol: PluginProtocol, Mechanisms: []string{"x-geo"}, Modifiers: []string{"x-rate"}}
+
+// pipePlugin serves fn over pipes and returns the Plugin talking to it.
+func pipePlugin(t *testing.T, hello PluginHello, fn Extension) *Plugin {
+	t.Helper()
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = ServePlugin(context.Background(), reqR, respW, hello, fn)
+		respW.Close()
+	}()
+	p, err := NewPlugin(context.Background(), respR, reqW)
+	require.NoError(t, err)
+	t.Cleanup(wg.Wait)
+
+	return p
+}
+
+func TestPlugin(t *testing.T) {
+	p := pipePlugin(t, geoHello, geoPlugin)
+	assert.Equal(t, []string{"x-geo"}, p.Mechanisms)
+	assert.Equal(t, []string{"x-rate"}, p.Modifiers)
+
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":      {"v=spf1 x-geo:eu x-rate=1 ~all"},
+		"down.example.com": {"v=spf1 x-geo:down -all"},
+	}}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithPlugin(p))
+	ctx := context.Background()
+
+	// concurrent evaluations share the plugin
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := ch.CheckHost(ctx, net.ParseIP("198.51.100.1"), "example.com", "")
+			assert.NoError(t, err)
+			assert.Equal(t, Pass, res.Code)
+		}()
+	}
+	wg.Wait()
+
+	res, _ := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	assert.Equal(t, SoftFail, res.Code)
+	res, _ = ch.CheckHost(ctx, net.ParseIP("198.51.100.66"), "example.com", "")
+	assert.Equal(t, Pass, res.Code, "the mechanism matches before the modifier")
+	res, _ = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "down.example.com", "")
+	assert.Equal(t, TempError, res.Code)
+	assert.ErrorContains(t, res.Cause, "geo database unavailable")
+
+	require.NoError(t, ch.Close())
+	_, err := p.Handle(ctx, ExtensionRequest{Name: "x-geo", IP: net.ParseIP("192.0.2.1")})
+	assert.ErrorIs(t, err, ErrPluginClosed)
+}
+
+func TestPlugin_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := NewPlugin(ctx, strings.NewReader(`{"protocol":2}`+"\n"), nopWriteCloser{io.Discard})
+	assert.ErrorContains(t, err, "unsupported protocol 2")
+	_, err = NewPlugin(ctx, strings.NewReader(""), nopWriteCloser{io.Discard})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	r, _ := io.Pipe()
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = NewPlugin(timeout, r, nopWriteCloser{io.Discard})
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "silent plugin")
+
+	// a plugin that stops answering fails the pending requests
+	respR, respW := io.Pipe()
+	go func() { _, _ = io.WriteString(respW, `{"protocol":1}`+"\n") }()
+	p, err := NewPlugin(ctx, respR, nopWriteCloser{io.Discard})
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		respW.Close()
+	}()
+	_, err = p.Handle(ctx, ExtensionRequest{Name: "x-geo", IP: net.ParseIP("192.0.2.1")})
+	assert.ErrorIs(t, err, ErrPluginClosed)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// TestPluginProcess runs the test binary as a plugin process.
+func TestPluginProcess(t *testing.T) {
+	if os.Getenv("SPF_TEST_PLUGIN") == "1" {
+		_ = ServePlugin(context.Background(), os.Stdin, os.Stdout, geoHello, geoPlugin)
+		os.Exit(0)
+	}
+	t.Setenv("SPF_TEST_PLUGIN", "1")
+
+	p, err := StartPlugin(context.Background(), os.Args[0], "-test.run=^TestPluginProcess$")
+	require.NoError(t, err)
+	resp, err := p.Handle(context.Background(), ExtensionRequest{Name: "x-geo", Value: "eu", IP: net.ParseIP("198.51.100.7")})
+	require.NoError(t, err)
+	assert.True(t, resp.Match)
+	require.NoError(t, p.Close())
+}
@@ -0,0 +1,73 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Policy maps evaluated results to the results a site acts upon, e.g.
+// treating SoftFail as Fail.  Results without an entry are kept.
+type Policy map[Result]Result
+
+// Common local policies.
+var (
+	// StrictPolicy rejects on every negative or broken result.
+	StrictPolicy = Policy{SoftFail: Fail, PermError: Fail}
+	// LenientPolicy never fails on weak assertions or broken records.
+	LenientPolicy = Policy{SoftFail: Neutral, Neutral: None, PermError: None}
+)
+
+// Map returns the result r is mapped to.
+func (p Policy) Map(r Result) Result {
+	if mapped, ok := p[r]; ok {
+		return mapped
+	}
+
+	return r
+}
+
+// apply rewrites res.Code, keeping the evaluated result in res.Unmapped.
+func (p Policy) apply(res CheckHostResult) CheckHostResult {
+	if mapped := p.Map(res.Code); mapped != res.Code {
+		res.Unmapped, res.Code = res.Code, mapped
+	}
+
+	return res
+}
+
+// WithPolicy applies p to every result returned by the Checker, so a local
+// policy is encoded once instead of in every caller.
+func WithPolicy(p Policy) Option {
+	return func(c *Checker) { c.policy = p }
+}
+
+// WithNoRecordResult sets the result for a domain that publishes no SPF
+// record or does not exist (RFC 7208 section 4.5).  The result carries
+// ErrNoPolicy as Cause and is returned without error, so callers can tell
+// policy absence apart from other None results with CheckHostResult.NoPolicy.
+// Use None to keep the RFC result, or e.g. Fail for internal domains that
+// must always publish a policy.
+//
+// Without this option a missing record yields an empty CheckHostResult and a
+// nonexistent domain returns ErrNoDNSrecord as error.
+func WithNoRecordResult(r Result) Option {
+	return func(c *Checker) { c.noRecord = r }
+}
+
+// NoPolicy reports whether r was produced for a domain without SPF policy.
+func (r CheckHostResult) NoPolicy() bool {
+	return errors.Is(r.Cause, ErrNoPolicy)
+}
+
+// noPolicy replaces the legacy results for a missing record or domain with
+// the configured result.
+func (c *Checker) noPolicy(res CheckHostResult, err error) (CheckHostResult, error) {
+	switch {
+	case errors.Is(err, ErrNoDNSrecord):
+		return CheckHostResult{Code: c.noRecord, Cause: fmt.Errorf("%w: %w", ErrNoPolicy, err), Stats: res.Stats}, nil
+	case err == nil && res.Code == "":
+		return CheckHostResult{Code: c.noRecord, Cause: ErrNoPolicy, Stats: res.Stats}, nil
+	}
+
+	return res, err
+}
@@ -0,0 +1,84 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_Map(t *testing.T) {
+	assert.Equal(t, Fail, StrictPolicy.Map(SoftFail))
+	assert.Equal(t, Pass, StrictPolicy.Map(Pass))
+	assert.Equal(t, None, LenientPolicy.Map(PermError))
+	assert.Equal(t, TempError, Policy(nil).Map(TempError))
+}
+
+func TestWithPolicy(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"soft.example.com":   {"v=spf1 ~all"},
+		"broken.example.com": {"v=spf1 bogus"},
+		"pass.example.com":   {"v=spf1 +all"},
+	}}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithPolicy(Policy{SoftFail: Fail, PermError: Fail, None: Neutral}))
+	ctx := context.Background()
+	ip := net.ParseIP("192.0.2.1")
+
+	cases := []struct {
+		domain   string
+		want     Result
+		unmapped Result
+	}{
+		{"soft.example.com", Fail, SoftFail},
+		{"broken.example.com", Fail, PermError},
+		{"pass.example.com", Pass, ""},
+		{"bad..example", Neutral, None},
+	}
+	for _, tc := range cases {
+		res, err := ch.CheckHost(ctx, ip, tc.domain, "")
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, res.Code, tc.domain)
+		assert.Equal(t, tc.unmapped, res.Unmapped, tc.domain)
+	}
+}
+
+func TestWithNoRecordResult(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"norecord.example.com": {"google-site-verification=abc"},
+		"pass.example.com":     {"v=spf1 +all"},
+	}}
+	ctx := context.Background()
+	ip := net.ParseIP("192.0.2.1")
+
+	// legacy behaviour
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	res, err := ch.CheckHost(ctx, ip, "norecord.example.com", "")
+	require.NoError(t, err)
+	assert.Empty(t, res.Code)
+	assert.NoError(t, res.Cause)
+	_, err = ch.CheckHost(ctx, ip, "nxdomain.example.com", "")
+	require.ErrorIs(t, err, ErrNoDNSrecord)
+
+	ch = NewChecker(NewCustomDNSResolver(zone), WithNoRecordResult(None))
+	for _, domain := range []string{"norecord.example.com", "nxdomain.example.com"} {
+		res, err = ch.CheckHost(ctx, ip, domain, "")
+		require.NoError(t, err)
+		assert.Equal(t, None, res.Code)
+		assert.True(t, res.NoPolicy(), domain)
+	}
+	res, err = ch.CheckHost(ctx, ip, "bad..example", "")
+	require.NoError(t, err)
+	assert.Equal(t, None, res.Code)
+	assert.False(t, res.NoPolicy(), "malformed domains are not policy absence")
+
+	ch = NewChecker(NewCustomDNSResolver(zone), WithNoRecordResult(Fail))
+	res, err = ch.CheckHost(ctx, ip, "nxdomain.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	require.ErrorIs(t, res.Cause, ErrNoDNSrecord)
+	res, err = ch.CheckHost(ctx, ip, "pass.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+}
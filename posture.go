@@ -0,0 +1,184 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// PostureCheck is one graded aspect of a PostureReport.
+type PostureCheck struct {
+	Name   string // "all", "lookups", "authorized-space" or "deprecated"
+	Score  int    // 0 to Weight
+	Weight int
+	Notes  []string // why points were taken off
+}
+
+// PostureReport grades the SPF policy of a domain from 0 to 100.
+type PostureReport struct {
+	Domain string
+	Score  int
+	Grade  string // "A" (90 and more) to "F" (below 60)
+	Checks []PostureCheck
+}
+
+// String formats r as a report card, one line per check.
+func (r PostureReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s (%d/100)\n", r.Domain, r.Grade, r.Score)
+	for _, c := range r.Checks {
+		fmt.Fprintf(&b, "  %-16s %3d/%d", c.Name, c.Score, c.Weight)
+		if len(c.Notes) > 0 {
+			fmt.Fprintf(&b, "  %s", strings.Join(c.Notes, "; "))
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// AssessPosture grades the SPF policy of domain on
+//
+//   - the "all" that applies when nothing else matches, -all scoring best,
+//   - the DNS lookups left before the limit of RFC 7208 section 4.6.4,
+//   - the IPv4 space the policy authorizes, as listed by
+//     ListAuthorizedNetworks, and
+//   - the use of the ptr mechanism and the "p" macro, which RFC 7208
+//     sections 5.5 and 7.3 discourage.
+//
+// A domain without a valid SPF record scores 0.  Only an invalid domain or a
+// context error is returned as error.
+func AssessPosture(ctx context.Context, r TXTResolver, domain string) (*PostureReport, error) {
+	g, err := BuildGraph(ctx, r, domain)
+	if err != nil {
+		return nil, err
+	}
+	report := &PostureReport{Domain: g.Root}
+	if root := g.Nodes[g.Root]; root.Err != nil {
+		report.Grade = "F"
+		report.Checks = []PostureCheck{{Name: "record", Weight: 100, Notes: []string{root.Err.Error()}}}
+		return report, nil
+	}
+	inv, err := listNetworks(ctx, r, g)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Checks = []PostureCheck{
+		gradeAll(g),
+		gradeLookups(g),
+		gradeSpace(inv),
+		gradeDeprecated(g),
+	}
+	for _, c := range report.Checks {
+		report.Score += c.Score
+	}
+	report.Grade = postureGrade(report.Score)
+
+	return report, nil
+}
+
+// gradeAll scores the final "all" of the policy.
+func gradeAll(g *Graph) PostureCheck {
+	c := PostureCheck{Name: "all", Weight: 35}
+	switch finalAll(g) {
+	case parser.QMinus:
+		c.Score = 35
+	case parser.QTilde:
+		c.Score = 28
+		c.Notes = append(c.Notes, "~all only marks unauthorized mail as softfail")
+	case parser.QMark:
+		c.Score = 8
+		c.Notes = append(c.Notes, "unauthorized mail is neutral; end the record with -all or ~all")
+	default:
+		c.Notes = append(c.Notes, "+all authorizes every host")
+	}
+
+	return c
+}
+
+// gradeLookups scores the lookup headroom of the tree.
+func gradeLookups(g *Graph) PostureCheck {
+	c := PostureCheck{Name: "lookups", Weight: 25}
+	r := g.IncludeReport()
+	switch {
+	case r.Headroom < 0:
+		c.Notes = append(c.Notes, fmt.Sprintf("%d DNS lookups exceed the limit of %d, evaluation is a permerror", r.Lookups, MaxDNSLookups))
+	case r.Headroom == 0:
+		c.Score = 8
+		c.Notes = append(c.Notes, "no DNS lookups to spare")
+	case r.Headroom < 3:
+		c.Score = 15
+		c.Notes = append(c.Notes, "only "+pluralLookups(r.Headroom)+" to spare")
+	default:
+		c.Score = 25
+	}
+	if r.Fragile() && r.Headroom >= 0 {
+		c.Notes = append(c.Notes, "a change to an included vendor record can exceed the limit")
+	}
+
+	return c
+}
+
+// gradeSpace scores the IPv4 space authorized by inv.
+func gradeSpace(inv *NetworkInventory) PostureCheck {
+	c := PostureCheck{Name: "authorized-space", Weight: 25}
+	n := ipv4Space(inv)
+	switch {
+	case n.Cmp(big.NewInt(1<<16)) <= 0:
+		c.Score = 25
+	case n.Cmp(big.NewInt(1<<20)) <= 0:
+		c.Score = 18
+	case n.Cmp(big.NewInt(1<<24)) <= 0:
+		c.Score = 8
+	}
+	if c.Score < 25 {
+		c.Notes = append(c.Notes, fmt.Sprintf("%s IPv4 addresses authorized", n))
+	}
+
+	return c
+}
+
+// gradeDeprecated scores the use of ptr and of the "p" macro in the tree.
+func gradeDeprecated(g *Graph) PostureCheck {
+	c := PostureCheck{Name: "deprecated", Score: 15, Weight: 15}
+	for _, d := range g.Domains() {
+		node := g.Nodes[d]
+		if node.Record == nil {
+			continue
+		}
+		for _, m := range node.Record.Mechs {
+			if m.Kind == "ptr" {
+				c.Score -= 10
+				c.Notes = append(c.Notes, d+" uses the ptr mechanism")
+				break
+			}
+		}
+		if strings.Contains(strings.ToLower(node.Raw), "%{p") {
+			c.Score -= 5
+			c.Notes = append(c.Notes, d+" uses the p macro")
+		}
+	}
+	c.Score = max(c.Score, 0)
+
+	return c
+}
+
+// postureGrade maps a score to a letter grade.
+func postureGrade(score int) string {
+	switch {
+	case score >= 90:
+		return "A"
+	case score >= 80:
+		return "B"
+	case score >= 70:
+		return "C"
+	case score >= 60:
+		return "D"
+	}
+
+	return "F"
+}
@@ -0,0 +1,55 @@
+package spf
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postureZone() *zoneResolver {
+	return &zoneResolver{txt: map[string][]string{
+		"strict.example":      {"v=spf1 ip4:192.0.2.0/24 include:_spf.vendor.example -all"},
+		"_spf.vendor.example": {"v=spf1 ip4:198.51.100.0/24 -all"},
+		"weak.example":        {"v=spf1 ip4:10.0.0.0/8 ptr exists:%{p}.allow.weak.example ?all"},
+		"busy.example":        {"v=spf1" + strings.Repeat(" a:host.busy.example", 9) + " ~all"},
+		"broken.example":      {"v=spf1 bogus -all"},
+	}}
+}
+
+func TestAssessPosture(t *testing.T) {
+	ctx := context.Background()
+
+	r, err := AssessPosture(ctx, postureZone(), "strict.example")
+	require.NoError(t, err)
+	assert.Equal(t, 100, r.Score)
+	assert.Equal(t, "A", r.Grade)
+	for _, c := range r.Checks {
+		assert.Equal(t, c.Weight, c.Score, c.Name)
+		assert.Empty(t, c.Notes, c.Name)
+	}
+
+	r, err = AssessPosture(ctx, postureZone(), "weak.example")
+	require.NoError(t, err)
+	assert.Equal(t, 8+25+8+0, r.Score)
+	assert.Equal(t, "F", r.Grade)
+	assert.Equal(t, []string{"16777216 IPv4 addresses authorized"}, r.Checks[2].Notes)
+	assert.Equal(t, []string{"weak.example uses the ptr mechanism", "weak.example uses the p macro"}, r.Checks[3].Notes)
+
+	r, err = AssessPosture(ctx, postureZone(), "busy.example")
+	require.NoError(t, err)
+	assert.Equal(t, 28+15+25+15, r.Score)
+	assert.Equal(t, "B", r.Grade)
+	assert.Contains(t, r.String(), "lookups           15/25  only 1 lookup to spare")
+
+	r, err = AssessPosture(ctx, postureZone(), "broken.example")
+	require.NoError(t, err)
+	assert.Zero(t, r.Score)
+	assert.Equal(t, "F", r.Grade)
+	require.Len(t, r.Checks, 1)
+
+	_, err = AssessPosture(ctx, postureZone(), "bad..example")
+	require.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// maxPTRNames bounds the PTR names that are forward-confirmed for the "ptr"
+// mechanism and the "p" macro; the rest are ignored (RFC 7208 section 4.6.4).
+const maxPTRNames = 10
+
+// unknownDomain is the value of the "p" macro when no name validates.
+const unknownDomain = "unknown"
+
+// validatedNames returns the client's PTR names whose A or AAAA records lead
+// back to the client address, as described in RFC 7208 section 5.5.  DNS
+// errors only leave names unvalidated; context errors and an exceeded void
+// lookup limit are returned.  The names do not depend on the domain being
+// evaluated, so they are resolved once, when first needed, and reused by
+// every later ptr mechanism and "p" macro of the evaluation; each use is
+// still charged against the DNS lookup limit by the caller.
+func (e *evaluation) validatedNames(ctx context.Context) ([]string, error) {
+	if e.ptrResolved {
+		return e.ptrNames, nil
+	}
+	names, err := e.resolveValidatedNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.ptrNames, e.ptrResolved = names, true
+
+	return names, nil
+}
+
+// resolveValidatedNames performs the lookups of validatedNames.
+func (e *evaluation) resolveValidatedNames(ctx context.Context) ([]string, error) {
+	resolver, ok := e.checker.resolver.(PTRResolver)
+	if !ok || e.ip == nil {
+		return nil, nil
+	}
+	e.stats.Queries++
+	names, err := resolver.LookupAddr(ctx, e.ip.String())
+	if err != nil {
+		err = classifyDNSError(err)
+		switch {
+		case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+			return nil, err
+		case errors.Is(err, ErrNoDNSrecord):
+			return nil, e.countVoid(false)
+		}
+		return nil, nil
+	}
+	if len(names) > maxPTRNames {
+		names = names[:maxPTRNames]
+	}
+
+	var validated []string
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		addrs, err := e.resolveIP(ctx, name, e.network())
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return nil, err
+			}
+			continue
+		}
+		for _, addr := range addrs {
+			if normalizeIP(addr).Equal(e.ip) {
+				validated = append(validated, name)
+				break
+			}
+		}
+	}
+
+	return validated, nil
+}
+
+// matchPTR implements the "ptr" mechanism: it matches when a validated name
+// is target or a subdomain of it.
+func (e *evaluation) matchPTR(ctx context.Context, target string) (bool, error) {
+	names, err := e.validatedNames(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range names {
+		if isSubdomain(name, target) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// validatedDomain computes the "p" macro for domain (RFC 7208 section 7.3).
+// The PTR query is charged against the DNS lookup limit.  Validated names are
+// preferred in this order: domain itself, a subdomain of domain, any other
+// name.  Without a validated name the macro expands to "unknown".
+func (e *evaluation) validatedDomain(ctx context.Context, domain string) (string, error) {
+	if err := e.countLookup(); err != nil {
+		return "", err
+	}
+	names, err := e.validatedNames(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		if name == domain {
+			return name, nil
+		}
+	}
+	for _, name := range names {
+		if isSubdomain(name, domain) {
+			return name, nil
+		}
+	}
+	if len(names) > 0 {
+		return names[0], nil
+	}
+
+	return unknownDomain, nil
+}
+
+// isSubdomain reports whether name equals domain or ends in "."+domain.
+func isSubdomain(name, domain string) bool {
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
@@ -0,0 +1,127 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptrZone() *zoneResolver {
+	return &zoneResolver{
+		txt: map[string][]string{
+			"example.com":        {"v=spf1 ptr -all"},
+			"other.example.com":  {"v=spf1 ptr:example.org -all"},
+			"p.example.com":      {"v=spf1 exists:%{p}.allow.example.net -all"},
+			"sub.example.com":    {"v=spf1 exists:%{p}.allow.example.net -all"},
+			"many.example.com":   {"v=spf1 a a a a a a a a a exists:%{p}.allow.example.net -all"},
+			"nonptr.example.com": {"v=spf1 ptr -all"},
+		},
+		ip: map[string][]string{
+			"mail.example.com":                     {"192.0.2.1"},
+			"mail.example.org":                     {"192.0.2.1"},
+			"p.example.com":                        {"192.0.2.2"},
+			"many.example.com":                     {"203.0.113.50"},
+			"mx.sub.example.com":                   {"192.0.2.2"},
+			"forged.example.com":                   {"198.51.100.1"},
+			"mail.example.com.allow.example.net":   {"127.0.0.2"},
+			"p.example.com.allow.example.net":      {"127.0.0.2"},
+			"mx.sub.example.com.allow.example.net": {"127.0.0.2"},
+			"unknown.allow.example.net":            {"127.0.0.2"},
+		},
+		ptr: map[string][]string{
+			"192.0.2.1":    {"mail.example.org.", "mail.example.com."},
+			"192.0.2.2":    {"mx.sub.example.com.", "p.example.com."},
+			"198.51.100.2": {"forged.example.com."},
+		},
+	}
+}
+
+func TestChecker_PTR(t *testing.T) {
+	cases := []struct {
+		name   string
+		domain string
+		ip     string
+		want   Result
+	}{
+		{"validated subdomain", "example.com", "192.0.2.1", Pass},
+		{"explicit domain", "other.example.com", "192.0.2.1", Pass},
+		{"forward confirmation fails", "example.com", "198.51.100.2", Fail},
+		{"no PTR", "nonptr.example.com", "203.0.113.1", Fail},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := NewChecker(NewCustomDNSResolver(ptrZone()))
+			res, err := ch.CheckHost(context.Background(), net.ParseIP(tc.ip), tc.domain, "")
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, res.Code)
+		})
+	}
+}
+
+func TestEvaluation_ValidatedDomain(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(ptrZone()))
+	ctx := context.Background()
+
+	cases := []struct {
+		ip, domain, want string
+	}{
+		{"192.0.2.1", "example.com", "mail.example.com"}, // subdomain preferred over other names
+		{"192.0.2.2", "p.example.com", "p.example.com"},  // exact domain preferred
+		{"192.0.2.2", "sub.example.com", "mx.sub.example.com"},
+		{"192.0.2.2", "example.net", "mx.sub.example.com"}, // any validated name
+		{"198.51.100.2", "example.com", "unknown"},         // forward confirmation fails
+		{"203.0.113.9", "example.com", "unknown"},          // no PTR
+	}
+	for _, tc := range cases {
+		e := ch.newEvaluation(net.ParseIP(tc.ip), "")
+		got, err := e.validatedDomain(ctx, tc.domain)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got, tc.ip+" "+tc.domain)
+		assert.Equal(t, 1, e.lookups, "the PTR query counts as one lookup")
+	}
+}
+
+func TestChecker_PMacro(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(ptrZone()))
+	ctx := context.Background()
+
+	res, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.2"), "p.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	res, err = ch.CheckHost(ctx, net.ParseIP("203.0.113.9"), "p.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code, "unknown.allow.example.net exists")
+
+	// nine "a" terms, "exists" and the PTR query of %{p} exceed the limit
+	res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "many.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, PermError, res.Code)
+	require.ErrorIs(t, res.Cause, ErrTooManyLookups)
+}
+
+func TestChecker_PTRResolvedOnce(t *testing.T) {
+	zone := ptrZone()
+	zone.txt["shared.example.com"] = []string{"v=spf1 ptr:example.net include:p.example.com -all"}
+	zone.txt["lazy.example.com"] = []string{"v=spf1 ip4:192.0.2.0/24 exists:%{p}.allow.example.net -all"}
+	r := &countingPTR{zoneResolver: zone}
+	ch := NewChecker(NewCustomDNSResolver(r))
+	ctx := context.Background()
+
+	// the ptr mechanism and the %{p} of the include share one reverse lookup
+	res, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.2"), "shared.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Equal(t, 1, r.ptrLookups)
+	assert.Equal(t, 4, res.Stats.Lookups, "each use still counts")
+
+	// %{p} is not resolved when its term is never reached
+	res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.2"), "lazy.example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Equal(t, 1, r.ptrLookups)
+}
@@ -0,0 +1,177 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	"golang.org/x/text/language"
+)
+
+// ErrNotFail is returned by RejectionFormatter.Format for results other
+// than Fail.
+var ErrNotFail = errors.New("result is not fail")
+
+// DefaultRejectionTemplate is the text of rejections unless configured
+// otherwise.  The explanation published by the domain is marked as coming
+// from it, as RFC 7208 section 6.2 asks.
+const DefaultRejectionTemplate = `{{if .Explanation}}{{.Domain}} explains: {{.Explanation}}{{else}}` +
+	`{{.IP}} is not allowed to send mail from {{.Domain}}{{end}}` +
+	`{{if .Mechanism}} (SPF fail by {{.Mechanism}}{{if ne .MechanismDomain .Domain}} in {{.MechanismDomain}}{{end}}){{end}}`
+
+// RejectionData is the data available to rejection templates.
+type RejectionData struct {
+	IP     string
+	Domain string // the checked domain
+	// Explanation is the expanded exp= text, empty when the domain has none.
+	Explanation string
+	// Mechanism is the failing mechanism, e.g. "-all", and MechanismDomain
+	// the domain whose record contains it.
+	Mechanism       string
+	MechanismDomain string
+	// URL is the RejectionFormatter's HelpURL.  Templates add the other
+	// values with the urlquery function, e.g. "{{.URL}}?d={{urlquery .Domain}}".
+	URL string
+}
+
+// RejectionFormatter composes the SMTP reply rejecting a message whose SPF
+// result is Fail (RFC 7208 section 8.4), optionally in the language of the
+// recipient.
+type RejectionFormatter struct {
+	// templates are the default template followed by those added with
+	// AddLocale, for the tags of matcher.
+	templates []*template.Template
+	tags      []language.Tag
+	matcher   language.Matcher
+	// Code and Status are the reply code and enhanced status code, 550 and
+	// "5.7.23" (RFC 7372) by default.
+	Code   int
+	Status string
+	// HelpURL is the page explaining rejections, available to templates as
+	// URL.
+	HelpURL string
+}
+
+// NewRejectionFormatter returns a RejectionFormatter rendering text, a
+// text/template executed with RejectionData; an empty text selects
+// DefaultRejectionTemplate.
+func NewRejectionFormatter(text string) (*RejectionFormatter, error) {
+	if text == "" {
+		text = DefaultRejectionTemplate
+	}
+	tmpl, err := template.New("rejection").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	f := &RejectionFormatter{Code: 550, Status: "5.7.23"}
+	f.add(language.Und, tmpl)
+
+	return f, nil
+}
+
+// AddLocale adds the template text for the language tag locale, e.g. "de"
+// or "pt-BR", used by FormatLocale.  Replies should stay in ASCII: other
+// characters are replaced, since SMTP reply text is ASCII.
+func (f *RejectionFormatter) AddLocale(locale, text string) error {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New("rejection-" + tag.String()).Parse(text)
+	if err != nil {
+		return err
+	}
+	f.add(tag, tmpl)
+
+	return nil
+}
+
+func (f *RejectionFormatter) add(tag language.Tag, tmpl *template.Template) {
+	f.templates = append(f.templates, tmpl)
+	f.tags = append(f.tags, tag)
+	f.matcher = language.NewMatcher(f.tags)
+}
+
+// maxReplyLine is the length of an SMTP reply line without its CRLF (RFC
+// 5321 section 4.5.3.1.5).
+const maxReplyLine = 510
+
+// Format returns the SMTP reply for res, the result of checking ip: one or
+// more lines such as "550 5.7.23 ..." joined by CRLF, without a final CRLF.
+// Text the domain published is reduced to printable ASCII, so that it
+// cannot inject reply lines of its own, and long text is wrapped into a
+// multiline reply.
+func (f *RejectionFormatter) Format(ip net.IP, res CheckHostResult) (string, error) {
+	return f.format(f.templates[0], ip, res)
+}
+
+// FormatLocale is Format with the template added for the locale closest to
+// locale, a language tag or an Accept-Language list such as "de-CH, fr;q=0.8".
+// The default template is used when no added locale matches.
+func (f *RejectionFormatter) FormatLocale(locale string, ip net.IP, res CheckHostResult) (string, error) {
+	_, i := language.MatchStrings(f.matcher, locale)
+
+	return f.format(f.templates[i], ip, res)
+}
+
+func (f *RejectionFormatter) format(tmpl *template.Template, ip net.IP, res CheckHostResult) (string, error) {
+	if res.Code != Fail {
+		return "", fmt.Errorf("%w: %s", ErrNotFail, res.Code)
+	}
+	data := RejectionData{IP: ip.String(), Domain: res.Domain, Explanation: printableASCII(res.Explanation), URL: f.HelpURL}
+	if m := res.Match; m != nil {
+		data.Mechanism, data.MechanismDomain = m.Term, m.Domain
+	}
+	var text strings.Builder
+	if err := tmpl.Execute(&text, data); err != nil {
+		return "", err
+	}
+
+	prefix := fmt.Sprintf("%03d %s ", f.Code, f.Status)
+	lines := wrapReply(printableASCII(text.String()), maxReplyLine-len(prefix))
+	for i := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		lines[i] = fmt.Sprintf("%03d%s%s %s", f.Code, sep, f.Status, lines[i])
+	}
+
+	return strings.Join(lines, "\r\n"), nil
+}
+
+// printableASCII replaces control characters and non-ASCII bytes of s by
+// spaces and collapses runs of spaces.
+func printableASCII(s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool { return r <= ' ' || r > '~' }), " ")
+}
+
+// wrapReply splits text into lines of at most width bytes at spaces, and
+// within words longer than width.
+func wrapReply(text string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		for len(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+
+	return append(lines, line)
+}
@@ -0,0 +1,98 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectionFormatter(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":         {"v=spf1 include:_spf.example.com -all exp=explain.example.com"},
+		"_spf.example.com":    {"v=spf1 ip4:192.0.2.0/24 -ip4:198.51.100.0/24"},
+		"explain.example.com": {"%{i} is not one of %{d}'s mail servers."},
+		"plain.example.com":   {"v=spf1 -all"},
+		"moved.example.com":   {"v=spf1 redirect=_spf.example.com"},
+	}}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	f, err := NewRejectionFormatter("")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	ip := net.ParseIP("203.0.113.5")
+	res, err := ch.CheckHost(ctx, ip, "example.com", "alice@example.com")
+	require.NoError(t, err)
+	reply, err := f.Format(ip, res)
+	require.NoError(t, err)
+	assert.Equal(t, "550 5.7.23 example.com explains: 203.0.113.5 is not one of example.com's mail servers. (SPF fail by -all)", reply)
+
+	ip = net.ParseIP("198.51.100.1")
+	res, err = ch.CheckHost(ctx, ip, "plain.example.com", "")
+	require.NoError(t, err)
+	reply, err = f.Format(ip, res)
+	require.NoError(t, err)
+	assert.Equal(t, "550 5.7.23 198.51.100.1 is not allowed to send mail from plain.example.com (SPF fail by -all)", reply)
+
+	// mechanisms of redirect targets name their domain
+	res, err = ch.CheckHost(ctx, ip, "moved.example.com", "")
+	require.NoError(t, err)
+	f, err = NewRejectionFormatter("Rejected{{with .Explanation}}: {{.}}{{end}} (SPF fail by {{.Mechanism}} in {{.MechanismDomain}})")
+	require.NoError(t, err)
+	f.Code, f.Status = 451, "4.7.23"
+	reply, err = f.Format(ip, res)
+	require.NoError(t, err)
+	assert.Equal(t, "451 4.7.23 Rejected (SPF fail by -ip4:198.51.100.0/24 in _spf.example.com)", reply)
+
+	// published text cannot add reply lines
+	reply, err = f.Format(ip, CheckHostResult{Code: Fail, Explanation: "bad\r\n250 2.0.0 ok\x00"})
+	require.NoError(t, err)
+	assert.Equal(t, "451 4.7.23 Rejected: bad 250 2.0.0 ok (SPF fail by in )", reply)
+
+	res, err = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	_, err = f.Format(net.ParseIP("192.0.2.1"), res)
+	require.ErrorIs(t, err, ErrNotFail)
+
+	_, err = NewRejectionFormatter("{{.Explanation")
+	require.Error(t, err)
+}
+
+func TestWrapReply(t *testing.T) {
+	f, err := NewRejectionFormatter(strings.Repeat("word ", 150) + strings.Repeat("x", 600))
+	require.NoError(t, err)
+	reply, err := f.Format(net.ParseIP("192.0.2.1"), CheckHostResult{Code: Fail, Domain: "example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"550-5.7.23 " + strings.TrimSpace(strings.Repeat("word ", 100)),
+		"550-5.7.23 " + strings.TrimSpace(strings.Repeat("word ", 50)),
+		"550-5.7.23 " + strings.Repeat("x", 499),
+		"550 5.7.23 " + strings.Repeat("x", 101),
+	}, strings.Split(reply, "\r\n"))
+}
+
+func TestRejectionFormatter_Locales(t *testing.T) {
+	f, err := NewRejectionFormatter("")
+	require.NoError(t, err)
+	f.HelpURL = "https://postmaster.example.net/spf"
+	require.NoError(t, f.AddLocale("de", "{{.IP}} darf keine Mail fuer {{.Domain}} senden, siehe {{.URL}}?d={{urlquery .Domain}}"))
+	require.NoError(t, f.AddLocale("fr", "{{.IP}} n'est pas autorise pour {{.Domain}} ({{.Mechanism}})"))
+	require.Error(t, f.AddLocale("not a tag", ""))
+	require.Error(t, f.AddLocale("es", "{{.IP"))
+
+	ip := net.ParseIP("192.0.2.1")
+	res := CheckHostResult{Code: Fail, Domain: "example.com", Match: &MatchInfo{Domain: "example.com", Term: "-all"}}
+	for locale, want := range map[string]string{
+		"de-CH":           "550 5.7.23 192.0.2.1 darf keine Mail fuer example.com senden, siehe https://postmaster.example.net/spf?d=example.com",
+		"ja, fr-CA;q=0.8": "550 5.7.23 192.0.2.1 n'est pas autorise pour example.com (-all)",
+		"ja":              "550 5.7.23 192.0.2.1 is not allowed to send mail from example.com (SPF fail by -all)",
+		"":                "550 5.7.23 192.0.2.1 is not allowed to send mail from example.com (SPF fail by -all)",
+	} {
+		reply, err := f.FormatLocale(locale, ip, res)
+		require.NoError(t, err, locale)
+		assert.Equal(t, want, reply, locale)
+	}
+}
@@ -0,0 +1,91 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Sample is one historical SMTP transaction replayed by Replay.
+type Sample struct {
+	IP       net.IP
+	MailFrom string // MAIL FROM argument, "<>" or empty for bounces
+	HELO     string
+}
+
+// Transition is the change of the result of a sample between the published
+// and the candidate records.
+type Transition struct {
+	Before, After Result
+}
+
+// ReplayOutcome is the result of one sample with the published records
+// (Before) and with the candidate records (After).
+type ReplayOutcome struct {
+	Sample        Sample
+	Before, After CheckHostResult
+}
+
+// ReplayReport summarizes a replay.
+type ReplayReport struct {
+	Samples int
+	Passing int // samples passing with the published records
+	// Transitions counts the samples per pair of results, unchanged ones
+	// included.
+	Transitions map[Transition]int
+	// Regressions lists the samples passing with the published records that
+	// no longer pass with the candidate ones, in the order given.
+	Regressions []ReplayOutcome
+}
+
+func (r ReplayReport) String() string {
+	return fmt.Sprintf("%d of %d passing samples would no longer pass (%d samples)", len(r.Regressions), r.Passing, r.Samples)
+}
+
+// Replay evaluates historical traffic with the published records and again
+// with the candidate records, injected with Simulate, and reports the
+// sources that would stop passing.  It is the safety check to run before
+// tightening a policy to "-all":
+//
+//	report, err := spf.Replay(ctx, ch, map[string]string{
+//		"corp.example": "v=spf1 include:_spf.corp.example -all",
+//	}, samples)
+//
+// The MAIL FROM identity is checked, or the HELO identity for bounces (RFC
+// 7208 section 2.4).  Samples are evaluated one after another; when they
+// share include targets a Checker with WithCache saves most of the queries.
+// Replay stops at the first context error or invalid sample.
+func Replay(ctx context.Context, ch *Checker, candidate map[string]string, samples []Sample) (ReplayReport, error) {
+	report := ReplayReport{Transitions: make(map[Transition]int)}
+	simulated := Simulate(ctx, candidate)
+	for i, s := range samples {
+		before, err := replaySample(ctx, ch, s)
+		if err != nil {
+			return report, fmt.Errorf("sample %d: %w", i, err)
+		}
+		after, err := replaySample(simulated, ch, s)
+		if err != nil {
+			return report, fmt.Errorf("sample %d: %w", i, err)
+		}
+
+		report.Samples++
+		report.Transitions[Transition{Before: before.Code, After: after.Code}]++
+		if before.Code == Pass {
+			report.Passing++
+			if after.Code != Pass {
+				report.Regressions = append(report.Regressions, ReplayOutcome{Sample: s, Before: before, After: after})
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// replaySample checks the identity of s that SPF applies to.
+func replaySample(ctx context.Context, ch *Checker, s Sample) (CheckHostResult, error) {
+	if domain, ok := getSenderDomain(s.MailFrom); ok {
+		return resultOnly(ch.check(ctx, s.IP, domain, s.MailFrom, s.HELO))
+	}
+
+	return resultOnly(ch.CheckHELO(ctx, s.IP, s.HELO))
+}
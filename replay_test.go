@@ -0,0 +1,48 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"corp.example":      {"v=spf1 include:_spf.corp.example ~all"},
+			"_spf.corp.example": {"v=spf1 ip4:192.0.2.0/24 +ip4:198.51.100.7"},
+			"mx1.corp.example":  {"v=spf1 a -all"},
+		},
+		ip: map[string][]string{"mx1.corp.example": {"203.0.113.1"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	samples := []Sample{
+		{IP: net.ParseIP("192.0.2.10"), MailFrom: "<alice@corp.example>", HELO: "mx1.corp.example"},
+		{IP: net.ParseIP("198.51.100.7"), MailFrom: "bob@corp.example", HELO: "crm.vendor.example"},
+		{IP: net.ParseIP("203.0.113.50"), MailFrom: "carol@corp.example", HELO: "unknown.example"},
+		{IP: net.ParseIP("203.0.113.1"), MailFrom: "<>", HELO: "mx1.corp.example"},
+	}
+
+	// drop the vendor address and tighten to -all
+	report, err := Replay(context.Background(), ch, map[string]string{
+		"corp.example":      "v=spf1 include:_spf.corp.example -all",
+		"_spf.corp.example": "v=spf1 ip4:192.0.2.0/24",
+	}, samples)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Samples)
+	assert.Equal(t, 3, report.Passing)
+	assert.Equal(t, map[Transition]int{
+		{Before: Pass, After: Pass}:     2,
+		{Before: Pass, After: Fail}:     1,
+		{Before: SoftFail, After: Fail}: 1,
+	}, report.Transitions)
+	require.Len(t, report.Regressions, 1)
+	assert.Equal(t, samples[1], report.Regressions[0].Sample)
+	assert.Equal(t, "1 of 3 passing samples would no longer pass (4 samples)", report.String())
+
+	_, err = Replay(context.Background(), ch, nil, []Sample{{MailFrom: "alice@corp.example"}})
+	require.ErrorIs(t, err, ErrInvalidIP)
+}
@@ -0,0 +1,88 @@
+package spf
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownResult is returned when decoding a text that is not one of the
+// results of RFC 7208 section 2.6.
+var ErrUnknownResult = errors.New("unknown SPF result")
+
+// results lists the valid results by their code.
+var results = map[string]Result{
+	"none":      None,
+	"neutral":   Neutral,
+	"pass":      Pass,
+	"fail":      Fail,
+	"softfail":  SoftFail,
+	"temperror": TempError,
+	"permerror": PermError,
+}
+
+// ParseResult returns the result named s, matched case-insensitively as in
+// the Received-SPF header (RFC 7208 section 9.1).
+func ParseResult(s string) (Result, error) {
+	r, ok := results[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownResult, s)
+	}
+
+	return r, nil
+}
+
+// MarshalText implements encoding.TextMarshaler with the lower-case result
+// names, which are stable codes.  The empty Result, returned for domains
+// without a record, is the empty text.
+func (r Result) MarshalText() ([]byte, error) {
+	if _, err := r.Value(); err != nil {
+		return nil, err
+	}
+
+	return []byte(r), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting any case.
+func (r *Result) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*r = ""
+		return nil
+	}
+	parsed, err := ParseResult(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+
+	return nil
+}
+
+// Value implements driver.Valuer.  The empty Result is stored as NULL.
+func (r Result) Value() (driver.Value, error) {
+	if r == "" {
+		return nil, nil
+	}
+	if _, ok := results[string(r)]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownResult, string(r))
+	}
+
+	return string(r), nil
+}
+
+// Scan implements sql.Scanner for text columns, with NULL as the empty
+// Result.
+func (r *Result) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*r = ""
+		return nil
+	case string:
+		return r.UnmarshalText([]byte(v))
+	case []byte:
+		return r.UnmarshalText(v)
+	}
+
+	return fmt.Errorf("cannot scan %T into Result", src)
+}
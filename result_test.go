@@ -0,0 +1,64 @@
+package spf
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ driver.Valuer = Result("")
+	_ sql.Scanner   = (*Result)(nil)
+)
+
+func TestParseResult(t *testing.T) {
+	for _, r := range []Result{None, Neutral, Pass, Fail, SoftFail, TempError, PermError} {
+		parsed, err := ParseResult(string(r))
+		require.NoError(t, err)
+		assert.Equal(t, r, parsed)
+	}
+	r, err := ParseResult(" SoftFail ")
+	require.NoError(t, err)
+	assert.Equal(t, SoftFail, r)
+	_, err = ParseResult("reject")
+	require.ErrorIs(t, err, ErrUnknownResult)
+	_, err = ParseResult("")
+	require.ErrorIs(t, err, ErrUnknownResult)
+}
+
+func TestResult_Text(t *testing.T) {
+	data, err := json.Marshal(map[string]Result{"spf": SoftFail, "none": ""})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"spf":"softfail","none":""}`, string(data))
+
+	var back map[string]Result
+	require.NoError(t, json.Unmarshal([]byte(`{"spf":"PASS","none":""}`), &back))
+	assert.Equal(t, map[string]Result{"spf": Pass, "none": ""}, back)
+	require.ErrorIs(t, json.Unmarshal([]byte(`{"spf":"maybe"}`), &back), ErrUnknownResult)
+
+	_, err = json.Marshal(Result("reject"))
+	require.ErrorIs(t, err, ErrUnknownResult)
+}
+
+func TestResult_SQL(t *testing.T) {
+	v, err := Fail.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "fail", v)
+	v, err = Result("").Value()
+	require.NoError(t, err)
+	assert.Nil(t, v)
+
+	var r Result
+	require.NoError(t, r.Scan([]byte("TempError")))
+	assert.Equal(t, TempError, r)
+	require.NoError(t, r.Scan(nil))
+	assert.Equal(t, Result(""), r)
+	require.NoError(t, r.Scan("pass"))
+	assert.Equal(t, Pass, r)
+	require.ErrorIs(t, r.Scan("reject"), ErrUnknownResult)
+	require.Error(t, r.Scan(42))
+}
@@ -0,0 +1,126 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// RoutingResolver sends the queries for names under configured suffixes to
+// their own resolvers and all others to a default one.  It serves
+// split-horizon DNS, where internal sender domains such as those under
+// corp.internal publish SPF records on internal servers only.  It implements
+// TXTResolver, IPResolver, MXResolver and PTRResolver; lookups routed to a
+// resolver without support for them return ErrUnsupported.
+type RoutingResolver struct {
+	fallback TXTResolver
+	routes   map[string]TXTResolver // by suffix, lower case without dots around
+}
+
+// NewRoutingResolver returns a RoutingResolver sending the queries for names
+// at or below each suffix of routes to its resolver, and the others to
+// fallback.  Suffixes may be written "corp.internal", ".corp.internal" or
+// "*.corp.internal" and are matched case-insensitively; the longest matching
+// suffix wins.  PTR lookups are routed by reverse zone, e.g. "10.in-addr.arpa".
+func NewRoutingResolver(fallback TXTResolver, routes map[string]TXTResolver) *RoutingResolver {
+	r := &RoutingResolver{fallback: fallback, routes: make(map[string]TXTResolver, len(routes))}
+	for suffix, resolver := range routes {
+		r.routes[routeKey(suffix)] = resolver
+	}
+
+	return r
+}
+
+// routeKey normalises a suffix or a queried name.
+func routeKey(name string) string {
+	name = strings.TrimPrefix(name, "*")
+
+	return strings.ToLower(strings.Trim(name, "."))
+}
+
+// resolver returns the resolver for name: that of its longest configured
+// suffix, or the fallback.
+func (r *RoutingResolver) resolver(name string) TXTResolver {
+	name = routeKey(name)
+	for {
+		if resolver, ok := r.routes[name]; ok {
+			return resolver
+		}
+		_, parent, ok := strings.Cut(name, ".")
+		if !ok {
+			return r.fallback
+		}
+		name = parent
+	}
+}
+
+// LookupTXT forwards the TXT lookup of domain to its resolver.
+func (r *RoutingResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	return r.resolver(domain).LookupTXT(ctx, domain)
+}
+
+// LookupIP forwards the A/AAAA lookup of host to its resolver.
+func (r *RoutingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	resolver, ok := r.resolver(host).(IPResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return resolver.LookupIP(ctx, network, host)
+}
+
+// LookupMX forwards the MX lookup of name to its resolver.
+func (r *RoutingResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	resolver, ok := r.resolver(name).(MXResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return resolver.LookupMX(ctx, name)
+}
+
+// LookupAddr forwards the PTR lookup of addr to the resolver of its reverse
+// name.
+func (r *RoutingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	route := r.fallback
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		route = r.resolver(reverseName(ip))
+	}
+	resolver, ok := route.(PTRResolver)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return resolver.LookupAddr(ctx, addr)
+}
+
+// Close closes the resolvers routed to that implement io.Closer, each once.
+// A Checker closes its resolver in Checker.Close.
+func (r *RoutingResolver) Close() error {
+	var closed []io.Closer
+	var errs []error
+	for _, resolver := range append([]TXTResolver{r.fallback}, slices.Collect(maps.Values(r.routes))...) {
+		closer, ok := resolver.(io.Closer)
+		if !ok || slices.ContainsFunc(closed, func(c io.Closer) bool { return sameResolver(c, closer) }) {
+			continue
+		}
+		closed = append(closed, closer)
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// sameResolver reports whether a and b are the same resolver, without
+// panicking on resolvers of types that are not comparable.
+func sameResolver(a, b io.Closer) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() && a == b
+}
@@ -0,0 +1,60 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingResolver(t *testing.T) {
+	public := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":         {"v=spf1 include:mail.corp.internal -all"},
+			"mail.corp.internal":  {"v=spf1 -all"},
+			"leaked.example.test": {"v=spf1 -all"},
+		},
+	}
+	internal := &zoneResolver{
+		txt: map[string][]string{"mail.corp.internal": {"v=spf1 a:relay.corp.internal -all"}},
+		ip:  map[string][]string{"relay.corp.internal": {"10.0.0.25"}},
+		ptr: map[string][]string{"10.0.0.25": {"relay.corp.internal."}},
+	}
+	lab := &zoneResolver{txt: map[string][]string{"lab.corp.internal": {"v=spf1 +all"}}}
+	r := NewRoutingResolver(public, map[string]TXTResolver{
+		"*.Corp.Internal":    internal,
+		".lab.corp.internal": lab,
+		"10.in-addr.arpa":    internal,
+		"legacy.internal":    slowResolver{},
+	})
+	ctx := context.Background()
+
+	res, err := NewChecker(r).CheckHost(ctx, net.ParseIP("10.0.0.25"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code, "include resolved internally")
+
+	txts, err := r.LookupTXT(ctx, "lab.corp.internal")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=spf1 +all"}, txts, "longest suffix wins")
+
+	names, err := r.LookupAddr(ctx, "10.0.0.25")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"relay.corp.internal."}, names)
+
+	// lookups the routed resolver cannot do are unsupported
+	_, err = r.LookupIP(ctx, "ip4", "host.legacy.internal")
+	require.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestRoutingResolver_Close(t *testing.T) {
+	shared := &closingResolver{zoneResolver: &zoneResolver{}, err: errors.New("socket busy")}
+	r := NewRoutingResolver(shared, map[string]TXTResolver{
+		"corp.internal":   shared,
+		"10.in-addr.arpa": &closingResolver{zoneResolver: &zoneResolver{}},
+		"lab.internal":    slowResolver{},
+	})
+	require.EqualError(t, r.Close(), "socket busy", "closed once")
+}
@@ -0,0 +1,204 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// ScanResult is the outcome of scanning one domain.
+type ScanResult struct {
+	Domain string
+	// Node holds the selected record, its parse result and its direct
+	// lookups and include/redirect edges.  Node.Err reports lookup, selection
+	// and parse problems.
+	Node *Node
+	// Graph is the full include/redirect tree, only built when
+	// Scanner.FollowIncludes is set and the record was found.
+	Graph    *Graph
+	Attempts int
+	Duration time.Duration
+	Err      error // invalid domain or context error; the record was not fetched
+}
+
+// Scanner fetches and parses the SPF records of many domains concurrently.
+// Each entry of Resolvers stands for one nameserver: queries are spread over
+// them round-robin and limited to RatePerResolver queries per second each.
+// Without Resolvers the Scanner uses NewDNSResolver.
+type Scanner struct {
+	Resolvers       []TXTResolver
+	Concurrency     int     // parallel domains, at least 1
+	RatePerResolver float64 // queries per second per resolver, 0 = unlimited
+	// Retries is the number of additional attempts for a domain whose
+	// lookup failed temporarily, waiting RetryBackoff before the first retry
+	// and twice as long before each further one.
+	Retries        int
+	RetryBackoff   time.Duration
+	FollowIncludes bool
+	// Timeout bounds the work on one domain, retries and the include graph
+	// included, so that a slow or hostile zone cannot hold a worker;
+	// DefaultScanTimeout when 0.  A domain timing out gets
+	// context.DeadlineExceeded in Err.
+	Timeout time.Duration
+}
+
+// DefaultScanTimeout is the Timeout of a Scanner that sets none.
+const DefaultScanTimeout = 30 * time.Second
+
+// NewScanner returns a Scanner using the given resolvers with 16 workers
+// and 2 retries.
+func NewScanner(resolvers ...TXTResolver) *Scanner {
+	return &Scanner{
+		Resolvers:    resolvers,
+		Concurrency:  16,
+		Retries:      2,
+		RetryBackoff: 500 * time.Millisecond,
+	}
+}
+
+// Scan reads domains until the channel is closed and emits one result per
+// domain, in completion order.  The returned channel is closed after the last
+// result or once ctx is done.
+func (s *Scanner) Scan(ctx context.Context, domains <-chan string) <-chan ScanResult {
+	configured := s.resolvers()
+	resolvers := make([]TXTResolver, len(configured))
+	for i, r := range configured {
+		resolvers[i] = newRateLimitedResolver(r, s.RatePerResolver)
+	}
+	var next atomic.Uint64
+	pick := func() TXTResolver {
+		return resolvers[(next.Add(1)-1)%uint64(len(resolvers))]
+	}
+
+	workers := max(s.Concurrency, 1)
+	out := make(chan ScanResult)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for {
+				var domain string
+				var ok bool
+				select {
+				case <-ctx.Done():
+					return
+				case domain, ok = <-domains:
+					if !ok {
+						return
+					}
+				}
+				res := s.scanOne(ctx, pick, domain)
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// resolvers returns the Resolvers of s, or the system resolver without any.
+func (s *Scanner) resolvers() []TXTResolver {
+	if len(s.Resolvers) == 0 {
+		return []TXTResolver{NewDNSResolver()}
+	}
+
+	return s.Resolvers
+}
+
+// scanOne fetches one domain within the Timeout of s, retrying temporary
+// failures.
+func (s *Scanner) scanOne(ctx context.Context, pick func() TXTResolver, domain string) ScanResult {
+	start := time.Now()
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultScanTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	res := ScanResult{Domain: domain}
+	valid, err := parser.ValidateDomainSpec(domain)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Domain = valid
+
+	backoff := s.RetryBackoff
+	for {
+		res.Attempts++
+		r := pick()
+		res.Node, res.Err = buildNode(ctx, r, valid)
+		if res.Err != nil || !errors.Is(res.Node.Err, ErrTempfail) || res.Attempts > s.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			res.Node, res.Err = nil, ctx.Err()
+			res.Duration = time.Since(start)
+			return res
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if res.Err == nil && s.FollowIncludes && res.Node.Record != nil {
+		res.Graph, res.Err = BuildGraph(ctx, pick(), valid)
+	}
+	res.Duration = time.Since(start)
+
+	return res
+}
+
+// rateLimitedResolver spaces the queries sent to a TXTResolver.
+type rateLimitedResolver struct {
+	TXTResolver
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newRateLimitedResolver limits r to perSecond queries; 0 returns r as is.
+func newRateLimitedResolver(r TXTResolver, perSecond float64) TXTResolver {
+	if perSecond <= 0 {
+		return r
+	}
+
+	return &rateLimitedResolver{TXTResolver: r, interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+func (l *rateLimitedResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait := time.Until(at); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return l.TXTResolver.LookupTXT(ctx, domain)
+}
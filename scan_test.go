@@ -0,0 +1,152 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyResolver fails the first failures TXT lookups of every name with a
+// temporary error.
+type flakyResolver struct {
+	*zoneResolver
+	failures int
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (f *flakyResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	f.mu.Lock()
+	f.calls[domain]++
+	n := f.calls[domain]
+	f.mu.Unlock()
+	if n <= f.failures {
+		return nil, &net.DNSError{Err: "timeout", Name: domain, IsTemporary: true}
+	}
+	return f.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func scanAll(t *testing.T, s *Scanner, domains ...string) map[string]ScanResult {
+	t.Helper()
+	in := make(chan string)
+	go func() {
+		defer close(in)
+		for _, d := range domains {
+			in <- d
+		}
+	}()
+
+	results := make(map[string]ScanResult)
+	for res := range s.Scan(context.Background(), in) {
+		results[res.Domain] = res
+	}
+	return results
+}
+
+func TestScanner(t *testing.T) {
+	s := NewScanner(graphZone(), graphZone())
+	s.Concurrency = 3
+	s.FollowIncludes = true
+
+	results := scanAll(t, s, "example.com", "_spf.example.com", "broken.example.com", "none.example.com", "bad..example")
+	require.Len(t, results, 5)
+
+	res := results["example.com"]
+	require.NoError(t, res.Err)
+	require.NoError(t, res.Node.Err)
+	assert.Equal(t, 4, res.Node.Lookups)
+	require.NotNil(t, res.Graph)
+	assert.Equal(t, 11, res.Graph.TotalLookups())
+	assert.Equal(t, 1, res.Attempts)
+	require.NoError(t, results["_spf.example.com"].Err)
+
+	require.Error(t, results["broken.example.com"].Node.Err)
+	assert.Nil(t, results["broken.example.com"].Graph)
+	require.ErrorIs(t, results["none.example.com"].Node.Err, ErrNoDNSrecord)
+	require.Error(t, results["bad..example"].Err)
+	assert.Nil(t, results["bad..example"].Node)
+}
+
+func TestScanner_Retry(t *testing.T) {
+	flaky := &flakyResolver{zoneResolver: graphZone(), failures: 2, calls: make(map[string]int)}
+	s := NewScanner(flaky)
+	s.RetryBackoff = time.Millisecond
+
+	res := scanAll(t, s, "example.com")["example.com"]
+	require.NoError(t, res.Node.Err)
+	assert.Equal(t, 3, res.Attempts)
+
+	s.Retries = 0
+	res = scanAll(t, s, "_spf.example.com")["_spf.example.com"]
+	require.ErrorIs(t, res.Node.Err, ErrTempfail)
+	assert.Equal(t, 1, res.Attempts)
+}
+
+func TestScanner_RateLimit(t *testing.T) {
+	s := NewScanner(graphZone())
+	s.RatePerResolver = 100
+	domains := []string{"example.com", "_spf.example.com", "_spf2.example.com", "vendor.example.net", "broken.example.com"}
+
+	start := time.Now()
+	results := scanAll(t, s, domains...)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond, "5 queries at 100/s")
+
+	got := make([]string, 0, len(results))
+	for d := range results {
+		got = append(got, d)
+	}
+	sort.Strings(got)
+	sort.Strings(domains)
+	assert.Equal(t, domains, got)
+}
+
+func TestScanner_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan string)
+	out := NewScanner(graphZone()).Scan(ctx, in)
+	cancel()
+	for range out {
+	}
+}
+
+func TestScanner_NoResolvers(t *testing.T) {
+	s := NewScanner()
+	require.Len(t, s.resolvers(), 1)
+	assert.IsType(t, &DNSResolver{}, s.resolvers()[0])
+
+	// the workers, which would panic dividing by zero resolvers, pick the
+	// system resolver; the lookup itself may fail without network
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	in := make(chan string, 1)
+	in <- "example.com"
+	close(in)
+	for range s.Scan(ctx, in) {
+	}
+}
+
+func TestScanner_Timeout(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":  {"v=spf1 include:slow.example -all"},
+		"fast.example": {"v=spf1 -all"},
+	}}
+	r := stallingResolver{zoneResolver: zone, stall: map[string]bool{"slow.example": true, "stalled.example": true}}
+	s := NewScanner(r)
+	s.FollowIncludes = true
+	s.Timeout = 20 * time.Millisecond
+
+	results := scanAll(t, s, "example.com", "stalled.example", "fast.example")
+	require.Len(t, results, 3)
+	require.ErrorIs(t, results["example.com"].Err, context.DeadlineExceeded, "the include graph is bounded")
+	assert.NotNil(t, results["example.com"].Node)
+	require.ErrorIs(t, results["stalled.example"].Err, context.DeadlineExceeded)
+	require.NoError(t, results["fast.example"].Err)
+	assert.NotNil(t, results["fast.example"].Graph)
+}
@@ -0,0 +1,397 @@
+package spf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// DefaultParquetRowGroupSize is the number of rows a ParquetScanWriter
+// buffers per row group when RowGroupSize is 0.
+const DefaultParquetRowGroupSize = 65536
+
+var errParquetComplete = errors.New("parquet file already complete")
+
+// Parquet physical types, repetition types and encodings of the format
+// specification (parquet.thrift).
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// parquetColumn describes a column of scanColumns in Parquet.
+type parquetColumn struct {
+	name     string
+	typ      int32 // physical type
+	optional bool  // empty values are null
+}
+
+// parquetColumns are scanColumns with their Parquet types, in the same order.
+var parquetColumns = []parquetColumn{
+	{"domain", parquetByteArray, false},
+	{"record", parquetByteArray, true},
+	{"lookups", parquetInt32, true},
+	{"all", parquetByteArray, true},
+	{"error_category", parquetByteArray, true},
+	{"error", parquetByteArray, true},
+	{"attempts", parquetInt32, false},
+	{"duration_ms", parquetInt64, false},
+}
+
+// parquetChunk is a column chunk written to the file.
+type parquetChunk struct {
+	offset int64 // of its page header
+	size   int64 // page header and page
+}
+
+// parquetRowGroup is a row group written to the file.
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// ParquetScanWriter writes scan results as a Parquet file with the columns
+// of CSVScanWriter, strings annotated as UTF-8.  Values CSVScanWriter leaves
+// empty, such as the lookups of a domain without record, are null.  Pages
+// are PLAIN encoded and uncompressed, one per column chunk.
+//
+// Rows are buffered and written a row group at a time, so that memory stays
+// bounded on large scans.  Flush writes the last row group and the footer,
+// completing the file; later writes fail.
+type ParquetScanWriter struct {
+	RowGroupSize int // rows per row group, DefaultParquetRowGroupSize when 0
+
+	w      io.Writer
+	offset int64 // bytes written so far
+	rows   [][]string
+	groups []parquetRowGroup
+	err    error // first write error
+	done   bool
+}
+
+// NewParquetScanWriter returns a ParquetScanWriter writing to w.
+func NewParquetScanWriter(w io.Writer) *ParquetScanWriter {
+	return &ParquetScanWriter{w: w}
+}
+
+// Write buffers the row of res and writes the row group once it is full.
+func (p *ParquetScanWriter) Write(res ScanResult) error {
+	switch {
+	case p.err != nil:
+		return p.err
+	case p.done:
+		return errParquetComplete
+	}
+	p.rows = append(p.rows, scanRow(res))
+	size := p.RowGroupSize
+	if size <= 0 {
+		size = DefaultParquetRowGroupSize
+	}
+	if len(p.rows) < size {
+		return nil
+	}
+
+	return p.writeRowGroup()
+}
+
+// Flush writes the buffered rows and the footer.  The file is complete
+// afterwards and further calls do nothing.
+func (p *ParquetScanWriter) Flush() error {
+	if p.err != nil || p.done {
+		return p.err
+	}
+	if err := p.writeRowGroup(); err != nil {
+		return err
+	}
+	if p.offset == 0 {
+		if err := p.write([]byte("PAR1")); err != nil {
+			return err
+		}
+	}
+	footer := p.footer()
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, "PAR1"...)
+	if err := p.write(footer); err != nil {
+		return err
+	}
+	p.done = true
+
+	return nil
+}
+
+// writeRowGroup writes the buffered rows, preceded by the magic number of
+// the file at its start.
+func (p *ParquetScanWriter) writeRowGroup() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	pages := make([][]byte, len(parquetColumns))
+	for i, col := range parquetColumns {
+		var err error
+		if pages[i], err = parquetPage(col, p.rows, i); err != nil {
+			p.err = err
+			return err
+		}
+	}
+	if p.offset == 0 {
+		if err := p.write([]byte("PAR1")); err != nil {
+			return err
+		}
+	}
+	group := parquetRowGroup{rows: int64(len(p.rows))}
+	for _, page := range pages {
+		chunk := parquetChunk{offset: p.offset, size: int64(len(page))}
+		if err := p.write(page); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+	}
+	p.groups = append(p.groups, group)
+	p.rows = p.rows[:0]
+
+	return nil
+}
+
+func (p *ParquetScanWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	if err != nil {
+		p.err = err
+	}
+
+	return err
+}
+
+// parquetPage returns the data page, with its header, of column i of rows.
+// Optional columns start with their definition levels: 1 for a value and 0
+// for null, RLE encoded with a bit width of 1.  It fails on integer columns
+// holding something else than a decimal of their size.
+func parquetPage(col parquetColumn, rows [][]string, i int) ([]byte, error) {
+	var levels, values []byte
+	run, last := 0, byte(0)
+	flush := func() {
+		if run > 0 {
+			levels = binary.AppendUvarint(levels, uint64(run)<<1)
+			levels = append(levels, last)
+		}
+	}
+	for _, row := range rows {
+		v := row[i]
+		if col.optional {
+			level := byte(0)
+			if v != "" {
+				level = 1
+			}
+			if level != last {
+				flush()
+				run, last = 0, level
+			}
+			run++
+			if v == "" {
+				continue
+			}
+		}
+		switch col.typ {
+		case parquetByteArray:
+			values = binary.LittleEndian.AppendUint32(values, uint32(len(v)))
+			values = append(values, v...)
+		case parquetInt32:
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parquet column %s: %w", col.name, err)
+			}
+			values = binary.LittleEndian.AppendUint32(values, uint32(n))
+		case parquetInt64:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parquet column %s: %w", col.name, err)
+			}
+			values = binary.LittleEndian.AppendUint64(values, uint64(n))
+		}
+	}
+
+	var body []byte
+	if col.optional {
+		flush()
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(levels)))
+		body = append(body, levels...)
+	}
+	body = append(body, values...)
+
+	// PageHeader of a DATA_PAGE
+	var h thriftWriter
+	h.i32(1, 0)
+	h.i32(2, int32(len(body)))
+	h.i32(3, int32(len(body)))
+	h.structField(5) // DataPageHeader
+	h.i32(1, int32(len(rows)))
+	h.i32(2, parquetPlain)
+	h.i32(3, parquetRLE)
+	h.i32(4, parquetRLE)
+	h.end()
+	h.end()
+
+	return append(h.b, body...), nil
+}
+
+// footer returns the FileMetaData of the file.
+func (p *ParquetScanWriter) footer() []byte {
+	var rows int64
+	for _, g := range p.groups {
+		rows += g.rows
+	}
+
+	var w thriftWriter
+	w.i32(1, 1) // version
+	w.list(2, thriftStruct, len(parquetColumns)+1)
+	w.begin()
+	w.str(4, "schema")
+	w.i32(5, int32(len(parquetColumns)))
+	w.end()
+	for _, col := range parquetColumns {
+		w.begin()
+		w.i32(1, col.typ)
+		repetition := int32(parquetRequired)
+		if col.optional {
+			repetition = parquetOptional
+		}
+		w.i32(3, repetition)
+		w.str(4, col.name)
+		if col.typ == parquetByteArray {
+			w.i32(6, 0) // UTF8
+		}
+		w.end()
+	}
+	w.i64(3, rows)
+	w.list(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		w.begin()
+		var total int64
+		w.list(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			col := parquetColumns[i]
+			w.begin()
+			w.i64(2, chunk.offset)
+			w.structField(3) // ColumnMetaData
+			w.i32(1, col.typ)
+			if col.optional {
+				w.list(2, thriftI32, 2)
+				w.varint(parquetPlain)
+				w.varint(parquetRLE)
+			} else {
+				w.list(2, thriftI32, 1)
+				w.varint(parquetPlain)
+			}
+			w.list(3, thriftBinary, 1)
+			w.bytes(col.name)
+			w.i32(4, 0) // UNCOMPRESSED
+			w.i64(5, g.rows)
+			w.i64(6, chunk.size)
+			w.i64(7, chunk.size)
+			w.i64(9, chunk.offset)
+			w.end()
+			w.end()
+			total += chunk.size
+		}
+		w.i64(2, total)
+		w.i64(3, g.rows)
+		w.end()
+	}
+	w.str(6, "github.com/mailspire/spf")
+	w.end()
+
+	return w.b
+}
+
+// Types of the Thrift compact protocol used by the Parquet metadata.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes Thrift structs with the compact protocol.  Fields
+// must be written in increasing id order; begin and end delimit structs
+// nested in lists or fields.
+type thriftWriter struct {
+	b     []byte
+	last  int16   // id of the previous field of the current struct
+	outer []int16 // last of the enclosing structs
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	if d := id - w.last; d > 0 && d <= 15 {
+		w.b = append(w.b, byte(d)<<4|typ)
+	} else {
+		w.b = append(w.b, typ)
+		w.b = binary.AppendVarint(w.b, int64(id))
+	}
+	w.last = id
+}
+
+// varint writes a zigzag varint, as i32 and i64 values are encoded.
+func (w *thriftWriter) varint(v int64) {
+	w.b = binary.AppendVarint(w.b, v)
+}
+
+func (w *thriftWriter) bytes(s string) {
+	w.b = binary.AppendUvarint(w.b, uint64(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) str(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.bytes(s)
+}
+
+// list writes the header of a list field of n elements of type elem.
+func (w *thriftWriter) list(id int16, elem byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.b = append(w.b, byte(n)<<4|elem)
+		return
+	}
+	w.b = append(w.b, 0xf0|elem)
+	w.b = binary.AppendUvarint(w.b, uint64(n))
+}
+
+// structField starts a struct field; end closes it.
+func (w *thriftWriter) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.begin()
+}
+
+// begin starts a nested struct.
+func (w *thriftWriter) begin() {
+	w.outer = append(w.outer, w.last)
+	w.last = 0
+}
+
+// end writes the stop field of the current struct.
+func (w *thriftWriter) end() {
+	w.b = append(w.b, 0)
+	if n := len(w.outer); n > 0 {
+		w.last, w.outer = w.outer[n-1], w.outer[:n-1]
+	}
+}
@@ -0,0 +1,208 @@
+package spf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// readThrift decodes a Thrift compact struct at the start of b into its
+// fields by id: int64 for integers, string for binaries, []any for lists
+// and map[int16]any for structs.  It returns the rest of b.
+func readThrift(t *testing.T, b []byte) (map[int16]any, []byte) {
+	t.Helper()
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		h := b[0]
+		b = b[1:]
+		if h == 0 {
+			return fields, b
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			v, n := binary.Varint(b)
+			id, b = int16(v), b[n:]
+		}
+		last = id
+		fields[id], b = readThriftValue(t, h&0x0f, b)
+	}
+}
+
+func readThriftValue(t *testing.T, typ byte, b []byte) (any, []byte) {
+	t.Helper()
+	switch typ {
+	case thriftI32, thriftI64:
+		v, n := binary.Varint(b)
+		return v, b[n:]
+	case thriftBinary:
+		l, n := binary.Uvarint(b)
+		b = b[n:]
+		return string(b[:l]), b[l:]
+	case thriftList:
+		h := b[0]
+		b = b[1:]
+		size := int(h >> 4)
+		if size == 15 {
+			s, n := binary.Uvarint(b)
+			size, b = int(s), b[n:]
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i], b = readThriftValue(t, h&0x0f, b)
+		}
+		return list, b
+	case thriftStruct:
+		return readThrift(t, b)
+	}
+	t.Fatalf("unexpected thrift type %d", typ)
+
+	return nil, nil
+}
+
+// readParquetColumn returns the values of column i of every row group of the
+// Parquet file data, with nil for nulls.
+func readParquetColumn(t *testing.T, data []byte, meta map[int16]any, i int) []any {
+	t.Helper()
+	col := parquetColumns[i]
+	var out []any
+	for _, g := range meta[4].([]any) {
+		chunk := g.(map[int16]any)[1].([]any)[i].(map[int16]any)[3].(map[int16]any)
+		header, page := readThrift(t, data[chunk[9].(int64):])
+		page = page[:header[2].(int64)]
+		n := int(header[5].(map[int16]any)[1].(int64))
+
+		defined := make([]bool, 0, n)
+		if col.optional {
+			levels := page[4 : 4+binary.LittleEndian.Uint32(page)]
+			page = page[4+len(levels):]
+			for len(levels) > 0 {
+				run, k := binary.Uvarint(levels)
+				for range run >> 1 {
+					defined = append(defined, levels[k] == 1)
+				}
+				levels = levels[k+1:]
+			}
+		} else {
+			for range n {
+				defined = append(defined, true)
+			}
+		}
+		require.Len(t, defined, n)
+
+		for _, ok := range defined {
+			if !ok {
+				out = append(out, nil)
+				continue
+			}
+			switch col.typ {
+			case parquetByteArray:
+				l := binary.LittleEndian.Uint32(page)
+				out = append(out, string(page[4:4+l]))
+				page = page[4+l:]
+			case parquetInt32:
+				out = append(out, int32(binary.LittleEndian.Uint32(page)))
+				page = page[4:]
+			case parquetInt64:
+				out = append(out, int64(binary.LittleEndian.Uint64(page)))
+				page = page[8:]
+			}
+		}
+		assert.Empty(t, page, col.name)
+	}
+
+	return out
+}
+
+func TestParquetScanWriter(t *testing.T) {
+	names := make([]string, len(parquetColumns))
+	for i, col := range parquetColumns {
+		names[i] = col.name
+	}
+	require.Equal(t, scanColumns, names)
+
+	results := scanWriterResults(t)
+	var buf bytes.Buffer
+	w := NewParquetScanWriter(&buf)
+	w.RowGroupSize = 2
+	n, err := WriteScanResults(w, scanWriterInput(results))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.ErrorIs(t, w.Write(results["example.com"]), errParquetComplete)
+
+	data := buf.Bytes()
+	require.Equal(t, "PAR1", string(data[:4]))
+	require.Equal(t, "PAR1", string(data[len(data)-4:]))
+	size := binary.LittleEndian.Uint32(data[len(data)-8:])
+	meta, rest := readThrift(t, data[len(data)-8-int(size):])
+	assert.Len(t, rest, 8)
+
+	assert.Equal(t, int64(5), meta[3])
+	assert.Len(t, meta[4], 3, "row groups")
+	schema := meta[2].([]any)
+	require.Len(t, schema, len(parquetColumns)+1)
+	assert.Equal(t, map[int16]any{4: "schema", 5: int64(len(parquetColumns))}, schema[0])
+	assert.Equal(t, map[int16]any{1: int64(parquetByteArray), 3: int64(parquetOptional), 4: "record", 6: int64(0)}, schema[2])
+	assert.Equal(t, map[int16]any{1: int64(parquetInt32), 3: int64(parquetOptional), 4: "lookups"}, schema[3])
+
+	var domains []any
+	for _, d := range scanWriterDomains {
+		domains = append(domains, d)
+	}
+	assert.Equal(t, domains, readParquetColumn(t, data, meta, 0))
+	assert.Equal(t, []any{int32(1), nil, int32(2), nil, nil}, readParquetColumn(t, data, meta, 2))
+	assert.Equal(t, []any{nil, "syntax", nil, "multiple records", "invalid domain"}, readParquetColumn(t, data, meta, 4))
+	assert.Equal(t, []any{int32(1), int32(1), int32(1), int32(1), int32(0)}, readParquetColumn(t, data, meta, 6))
+	assert.Equal(t, []any{int64(1), int64(1), int64(1), int64(1), int64(1)}, readParquetColumn(t, data, meta, 7))
+
+	// an empty scan is a valid file without row groups
+	buf.Reset()
+	empty := make(chan ScanResult)
+	close(empty)
+	_, err = WriteScanResults(NewParquetScanWriter(&buf), empty)
+	require.NoError(t, err)
+	assert.Equal(t, "PAR1", string(buf.Bytes()[:4]))
+	meta, _ = readThrift(t, buf.Bytes()[4:])
+	assert.Equal(t, int64(0), meta[3])
+
+	_, err = WriteScanResults(NewParquetScanWriter(failingWriter{}), scanWriterInput(results))
+	require.EqualError(t, err, "disk full")
+}
+
+// TestParquetScanWriter_Golden compares the output with testdata/scan.parquet,
+// which the interop/parquet module reads back with parquet-go, an independent
+// implementation of the format.  Run with -update after intended changes.
+func TestParquetScanWriter_Golden(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewParquetScanWriter(&buf)
+	w.RowGroupSize = 2
+	_, err := WriteScanResults(w, scanWriterInput(scanWriterResults(t)))
+	require.NoError(t, err)
+
+	golden := filepath.Join("testdata", "scan.parquet")
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(golden, buf.Bytes(), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, want, buf.Bytes())
+}
+
+func TestParquetPage_InvalidInteger(t *testing.T) {
+	_, err := parquetPage(parquetColumns[2], [][]string{{"example.com", "", "many"}}, 2)
+	require.EqualError(t, err, `parquet column lookups: strconv.ParseInt: parsing "many": invalid syntax`)
+
+	w := NewParquetScanWriter(&bytes.Buffer{})
+	w.rows = [][]string{{"example.com", "", "", "", "", "", "1", "x"}}
+	require.ErrorContains(t, w.Flush(), "parquet column duration_ms")
+	require.Error(t, w.Write(ScanResult{Domain: "example.com"}), "the error sticks")
+}
@@ -0,0 +1,93 @@
+package spf
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// ScanWriter writes scan results as rows for analytics tooling.  Write may
+// buffer; Flush writes out what was buffered and reports any earlier write
+// error.
+type ScanWriter interface {
+	Write(res ScanResult) error
+	Flush() error
+}
+
+// WriteScanResults writes every result received from results, as returned
+// by Scanner.Scan, to w and flushes it.  It returns the number of results
+// written.
+func WriteScanResults(w ScanWriter, results <-chan ScanResult) (int, error) {
+	n := 0
+	for res := range results {
+		if err := w.Write(res); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, w.Flush()
+}
+
+// scanColumns are the columns written for each scan result.
+var scanColumns = []string{"domain", "record", "lookups", "all", "error_category", "error", "attempts", "duration_ms"}
+
+// scanRow returns the values of scanColumns for res.  The error category is
+// the one ScanStats counts the result under; lookups and all are empty
+// without a parsed record.
+func scanRow(res ScanResult) []string {
+	row := make([]string, len(scanColumns))
+	row[0] = res.Domain
+	row[6] = strconv.Itoa(res.Attempts)
+	row[7] = strconv.FormatInt(res.Duration.Milliseconds(), 10)
+	switch {
+	case res.Err != nil:
+		row[4], row[5] = errorCategory(res.Err, true), res.Err.Error()
+		return row
+	case res.Node.Err != nil:
+		row[1] = res.Node.Raw
+		row[4], row[5] = errorCategory(res.Node.Err, false), res.Node.Err.Error()
+		return row
+	}
+
+	row[1] = res.Node.Raw
+	lookups := res.Node.Lookups
+	if res.Graph != nil {
+		lookups = res.Graph.TotalLookups()
+	}
+	row[2] = strconv.Itoa(lookups)
+	row[3] = allQualifier(res.Node.Record)
+
+	return row
+}
+
+// CSVScanWriter writes scan results as CSV (RFC 4180) with a header row,
+// one row per result as it arrives.
+type CSVScanWriter struct {
+	w      *csv.Writer
+	header bool // written
+}
+
+// NewCSVScanWriter returns a CSVScanWriter writing to w.
+func NewCSVScanWriter(w io.Writer) *CSVScanWriter {
+	return &CSVScanWriter{w: csv.NewWriter(w)}
+}
+
+// Write writes the row of res, preceded by the header on the first call.
+func (c *CSVScanWriter) Write(res ScanResult) error {
+	if !c.header {
+		if err := c.w.Write(scanColumns); err != nil {
+			return err
+		}
+		c.header = true
+	}
+
+	return c.w.Write(scanRow(res))
+}
+
+// Flush writes buffered rows to the underlying writer.
+func (c *CSVScanWriter) Flush() error {
+	c.w.Flush()
+
+	return c.w.Error()
+}
@@ -0,0 +1,80 @@
+package spf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+// scanWriterResults scans a few domains covering every column and returns
+// the results by domain, with a fixed duration.
+func scanWriterResults(t *testing.T) map[string]ScanResult {
+	t.Helper()
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":      {"v=spf1 include:_spf.example.com ~all"},
+		"_spf.example.com": {"v=spf1 ip4:192.0.2.0/24, -all"},
+		"redirect.example": {"v=spf1 redirect=example.com"},
+		"multiple.example": {"v=spf1 -all", "v=spf1 +all"},
+	}}
+	s := NewScanner(zone)
+	s.Concurrency = 1
+	s.Retries = 0
+	s.FollowIncludes = true
+	results := scanAll(t, s, scanWriterDomains...)
+	for domain, res := range results {
+		res.Duration = 1500 * time.Microsecond
+		results[domain] = res
+	}
+
+	return results
+}
+
+var scanWriterDomains = []string{"example.com", "_spf.example.com", "redirect.example", "multiple.example", "bad..example"}
+
+// scanWriterInput returns the results in the order of scanWriterDomains.
+func scanWriterInput(results map[string]ScanResult) <-chan ScanResult {
+	in := make(chan ScanResult, len(scanWriterDomains))
+	for _, domain := range scanWriterDomains {
+		in <- results[domain]
+	}
+	close(in)
+
+	return in
+}
+
+func TestCSVScanWriter(t *testing.T) {
+	results := scanWriterResults(t)
+	in := scanWriterInput(results)
+
+	var buf bytes.Buffer
+	n, err := WriteScanResults(NewCSVScanWriter(&buf), in)
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, `domain,record,lookups,all,error_category,error,attempts,duration_ms
+example.com,v=spf1 include:_spf.example.com ~all,1,~all,,,1,1
+_spf.example.com,"v=spf1 ip4:192.0.2.0/24, -all",,,syntax,`+errString(results["_spf.example.com"].Node.Err)+`,1,1
+redirect.example,v=spf1 redirect=example.com,2,redirect,,,1,1
+multiple.example,,,,multiple records,"filter found multiple spf records (permerror) for multiple.example: ""v=spf1 -all"", ""v=spf1 +all""",1,1
+bad..example,,,,invalid domain,domain has empty label,0,1
+`, buf.String())
+
+	_, err = WriteScanResults(NewCSVScanWriter(failingWriter{}), scanWriterInput(results))
+	require.EqualError(t, err, "disk full")
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
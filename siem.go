@@ -0,0 +1,167 @@
+package spf
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SIEM product identification in CEF and LEEF headers.
+const (
+	siemVendor  = "mailspire"
+	siemProduct = "spf"
+	siemVersion = "1"
+)
+
+// SIEMEvent is one SPF check as shipped to a SIEM: the client and its
+// identities, when the check ran and its result.
+type SIEMEvent struct {
+	Sample
+	Time   time.Time
+	Result CheckHostResult
+}
+
+// siemSeverity rates results on the 0-10 scale shared by CEF and LEEF.
+var siemSeverity = map[Result]int{
+	Pass:      1,
+	None:      3,
+	Neutral:   3,
+	TempError: 4,
+	SoftFail:  5,
+	PermError: 6,
+	Fail:      7,
+}
+
+// severity returns the severity of the result of ev, that of the result
+// before the Policy when it mapped the result onto a local code.
+func (ev SIEMEvent) severity() int {
+	if sev, ok := siemSeverity[ev.Result.Code]; ok {
+		return sev
+	}
+
+	return siemSeverity[ev.Result.Unmapped]
+}
+
+// siemField is one key-value pair of the extension of an event.
+type siemField struct {
+	key, value string
+}
+
+// fields returns the extension of ev with the keys of the format: CEF keys
+// are used where CEF defines them, LEEF ones where leef is set.
+func (ev SIEMEvent) fields(leef bool) []siemField {
+	var fields []siemField
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, siemField{key, value})
+		}
+	}
+	key := func(cefKey, leefKey string) string {
+		if leef {
+			return leefKey
+		}
+		return cefKey
+	}
+
+	if !ev.Time.IsZero() {
+		add(key("rt", "devTime"), strconv.FormatInt(ev.Time.UnixMilli(), 10))
+	}
+	if ev.IP != nil {
+		src := "src"
+		if ev.IP.To4() == nil {
+			src = key("c6a2", "src") // the src of CEF is IPv4 only
+		}
+		add(src, ev.IP.String())
+	}
+	add(key("suser", "usrName"), ev.MailFrom)
+	add(key("shost", "identHostName"), ev.HELO)
+	add(key("act", "spfResult"), string(ev.Result.Code))
+	if ev.Result.Unmapped != "" {
+		add(key("cs3", "spfUnmappedResult"), string(ev.Result.Unmapped))
+	}
+	add(key("cs1", "spfDomain"), ev.Result.Domain)
+	add(key("cs4", "spfScope"), string(ev.Result.Scope))
+	if m := ev.Result.Match; m != nil {
+		add(key("cs2", "spfMechanism"), m.Term)
+	}
+	add(key("cn1", "spfLookups"), strconv.Itoa(ev.Result.Stats.Lookups))
+	if ev.Result.Cause != nil {
+		add("reason", ev.Result.Cause.Error())
+	}
+	for _, k := range slices.Sorted(maps.Keys(ev.Result.Attributes)) {
+		add(k, ev.Result.Attributes[k])
+	}
+
+	return fields
+}
+
+// cefLabels names the custom CEF fields.
+var cefLabels = map[string]string{
+	"c6a2": "clientIPv6",
+	"cs1":  "spfDomain",
+	"cs2":  "spfMechanism",
+	"cs3":  "spfUnmappedResult",
+	"cs4":  "spfScope",
+	"cn1":  "spfLookups",
+}
+
+// FormatCEF renders ev as an ArcSight Common Event Format line, with the
+// result as event class ID ("spf-fail") and the severity ranked by result.
+// Custom fields carry their labels, e.g. "cs1Label=spfDomain cs1=example.com".
+func FormatCEF(ev SIEMEvent) string {
+	var b strings.Builder
+	code := string(ev.Result.Code)
+	b.WriteString("CEF:0|")
+	for _, h := range []string{siemVendor, siemProduct, siemVersion, "spf-" + code, "SPF " + code} {
+		b.WriteString(cefHeaderEscaper.Replace(h))
+		b.WriteByte('|')
+	}
+	b.WriteString(strconv.Itoa(ev.severity()))
+	b.WriteByte('|')
+
+	for i, f := range ev.fields(false) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if label, ok := cefLabels[f.key]; ok {
+			b.WriteString(f.key + "Label=" + label + " ")
+		}
+		b.WriteString(f.key + "=" + cefValueEscaper.Replace(f.value))
+	}
+
+	return b.String()
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`)
+)
+
+// FormatLEEF renders ev as an IBM QRadar Log Event Extended Format 1.0 line
+// with tab-separated attributes.  The result is the event ID ("spf-fail");
+// devTime is in milliseconds since the epoch, the format assumed when no
+// devTimeFormat is given.
+func FormatLEEF(ev SIEMEvent) string {
+	var b strings.Builder
+	b.WriteString("LEEF:1.0|")
+	for _, h := range []string{siemVendor, siemProduct, siemVersion, "spf-" + string(ev.Result.Code)} {
+		b.WriteString(cefHeaderEscaper.Replace(h))
+		b.WriteByte('|')
+	}
+
+	fields := ev.fields(true)
+	fields = append(fields, siemField{"sev", strconv.Itoa(ev.severity())})
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		b.WriteString(f.key + "=" + leefValueEscaper.Replace(f.value))
+	}
+
+	return b.String()
+}
+
+// leefValueEscaper keeps values from breaking the tab-separated attributes.
+var leefValueEscaper = strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ", "\r", " ")
@@ -0,0 +1,39 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatCEF(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(optionsZone()))
+	sample := Sample{IP: net.ParseIP("192.0.2.1"), MailFrom: "alice@example.com", HELO: "mail.example.com"}
+	res, err := ch.CheckHost(context.Background(), sample.IP, "example.com", sample.MailFrom)
+	require.NoError(t, err)
+	ev := SIEMEvent{Sample: sample, Time: time.UnixMilli(1700000000123), Result: res}
+
+	assert.Equal(t, "CEF:0|mailspire|spf|1|spf-pass|SPF pass|1|rt=1700000000123 src=192.0.2.1 suser=alice@example.com shost=mail.example.com act=pass "+
+		"cs1Label=spfDomain cs1=example.com cs4Label=spfScope cs4=mfrom cs2Label=spfMechanism cs2=ip4:192.0.2.0/24 cn1Label=spfLookups cn1=2", FormatCEF(ev))
+
+	ev = SIEMEvent{
+		Sample: Sample{IP: net.ParseIP("2001:db8::1")},
+		Result: CheckHostResult{Code: PermError, Cause: errors.New("bad term a=b\\c\nnext")},
+	}
+	assert.Equal(t, `CEF:0|mailspire|spf|1|spf-permerror|SPF permerror|6|c6a2Label=clientIPv6 c6a2=2001:db8::1 act=permerror cn1Label=spfLookups cn1=0 reason=bad term a\=b\\c\nnext`, FormatCEF(ev))
+}
+
+func TestFormatLEEF(t *testing.T) {
+	ev := SIEMEvent{
+		Sample: Sample{IP: net.ParseIP("2001:db8::1"), MailFrom: "<>", HELO: "mail.example.com"},
+		Time:   time.UnixMilli(1700000000123),
+		Result: CheckHostResult{Code: Result("reject"), Unmapped: SoftFail, Domain: "mail.example.com", Scope: ScopeHELO, Cause: errors.New("a\tb")},
+	}
+	assert.Equal(t, "LEEF:1.0|mailspire|spf|1|spf-reject|devTime=1700000000123\tsrc=2001:db8::1\tusrName=<>\tidentHostName=mail.example.com\t"+
+		"spfResult=reject\tspfUnmappedResult=softfail\tspfDomain=mail.example.com\tspfScope=helo\tspfLookups=0\treason=a b\tsev=5", FormatLEEF(ev))
+}
@@ -0,0 +1,38 @@
+package spf
+
+import "context"
+
+// simulationKey is the context key of the records injected with Simulate.
+type simulationKey struct{}
+
+// Simulate returns a context under which evaluations use records[domain] as
+// the SPF record of domain instead of querying DNS, anywhere in the include
+// and redirect tree.  It answers "what happens if we change
+// _spf.corp.example" before the change is published:
+//
+//	ctx := spf.Simulate(ctx, map[string]string{
+//		"_spf.corp.example": "v=spf1 ip4:192.0.2.0/24 include:mail.vendor.example -all",
+//	})
+//	res, err := ch.CheckHost(ctx, ip, "corp.example", sender)
+//
+// Injected records take precedence over those of WithRecordOverrides.  An
+// empty text makes the domain behave as publishing no record.  Domain names
+// are matched case-insensitively and without a trailing dot.  Nested calls
+// add to the records of the outer ones.
+func Simulate(ctx context.Context, records map[string]string) context.Context {
+	outer := simulatedFrom(ctx)
+	merged := make(map[string]string, len(outer)+len(records))
+	for domain, text := range outer {
+		merged[domain] = text
+	}
+	for domain, text := range records {
+		merged[overrideKey(domain)] = text
+	}
+
+	return context.WithValue(ctx, simulationKey{}, merged)
+}
+
+func simulatedFrom(ctx context.Context) map[string]string {
+	records, _ := ctx.Value(simulationKey{}).(map[string]string)
+	return records
+}
@@ -0,0 +1,55 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulate(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(optionsZone()),
+		WithRecordOverrides(map[string]string{"_spf.example.com": "v=spf1 ip4:203.0.113.0/24 -all"}))
+	ip := net.ParseIP("192.0.2.1")
+
+	res, err := ch.CheckHost(context.Background(), ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+
+	ctx := Simulate(context.Background(), map[string]string{"_SPF.example.com.": "v=spf1 ip4:192.0.2.0/24 -all"})
+	res, err = ch.CheckHost(ctx, ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Equal(t, 2, res.Stats.Queries, "no TXT query for _spf.example.com")
+
+	// nested simulations add records
+	nested := Simulate(ctx, map[string]string{"example.com": "v=spf1 redirect=new.example.com", "new.example.com": "v=spf1 ~all"})
+	res, err = ch.CheckHost(nested, ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, SoftFail, res.Code)
+
+	out, err := ch.Explain(ctx, ip, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []ChainStep{
+		{Domain: "example.com", Term: "include:_spf.example.com"},
+		{Domain: "_spf.example.com", Term: "ip4:192.0.2.0/24"},
+	}, out.Chain)
+}
+
+func TestSimulate_MixedCase(t *testing.T) {
+	zone := optionsZone()
+	zone.txt["_spf.example.com"] = []string{"v=spf1 IP4:192.0.2.0/24 -ALL"}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ip := net.ParseIP("192.0.2.1")
+
+	live, err := ch.CheckHost(context.Background(), ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, live.Code)
+
+	ctx := Simulate(context.Background(), map[string]string{"_spf.example.com": "v=spf1 IP4:192.0.2.0/24 -ALL"})
+	res, err := ch.CheckHost(ctx, ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, live.Code, res.Code)
+}
@@ -0,0 +1,123 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ClassicUDPSize is the UDP message limit of DNS without EDNS0 (RFC 1035
+// section 2.3.4).  ResponseSize.Warnings checks answers against it and
+// against DefaultUDPSize, the EDNS0 payload size resolvers commonly accept.
+const ClassicUDPSize = 512
+
+// ResponseSize is the estimated wire size of the answer to the TXT query for
+// a domain, which carries every TXT record at the name and not only the SPF
+// record (RFC 7208 section 3.4).
+type ResponseSize struct {
+	Domain  string
+	Records int // TXT records at the name
+	Bytes   int // estimated size of the DNS message, EDNS0 OPT record included
+}
+
+// EstimateResponseSize returns the size of the answer to the TXT query for
+// domain holding txts.  Records longer than 255 octets are counted as split
+// into character-strings of that size; the owner names of the answers are
+// assumed to be compressed.
+func EstimateResponseSize(domain string, txts []string) (ResponseSize, error) {
+	name, err := dnsmessage.NewName(fqdn(domain))
+	if err != nil {
+		return ResponseSize{}, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return ResponseSize{}, err
+	}
+	q := dnsmessage.Question{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}
+	if err := b.Question(q); err != nil {
+		return ResponseSize{}, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return ResponseSize{}, err
+	}
+	hdr := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET}
+	for _, txt := range txts {
+		if err := b.TXTResource(hdr, dnsmessage.TXTResource{TXT: characterStrings(txt)}); err != nil {
+			return ResponseSize{}, err
+		}
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return ResponseSize{}, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(DefaultUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return ResponseSize{}, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return ResponseSize{}, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return ResponseSize{}, err
+	}
+
+	return ResponseSize{Domain: domain, Records: len(txts), Bytes: len(msg)}, nil
+}
+
+// characterStrings splits txt into the character-strings of a TXT record,
+// at most 255 octets each.
+func characterStrings(txt string) []string {
+	var out []string
+	for len(txt) > 255 {
+		out = append(out, txt[:255])
+		txt = txt[255:]
+	}
+
+	return append(out, txt)
+}
+
+// CheckResponseSize looks up the TXT records of domain and estimates the size
+// of the answer.  A domain without TXT records yields a zero Records count
+// and only a context or lookup error is returned.
+func CheckResponseSize(ctx context.Context, r TXTResolver, domain string) (ResponseSize, error) {
+	txts, err := lookupTXTs(ctx, r, domain)
+	if err != nil {
+		return ResponseSize{}, err
+	}
+
+	return EstimateResponseSize(domain, txts)
+}
+
+// lookupTXTs returns every TXT record of domain, none when it does not exist.
+func lookupTXTs(ctx context.Context, r TXTResolver, domain string) ([]string, error) {
+	txts, err := r.LookupTXT(ctx, queryName(domain))
+	if err != nil {
+		if err = classifyDNSError(err); !errors.Is(err, ErrNoDNSrecord) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return txts, nil
+}
+
+// Warnings returns warnings when the answer is likely to be truncated over
+// UDP.  Truncated answers are retried over TCP, which fails where port 53/tcp
+// is filtered and makes the SPF result a temperror for some receivers only.
+func (s ResponseSize) Warnings() []Warning {
+	switch {
+	case s.Bytes > DefaultUDPSize:
+		return []Warning{{Message: fmt.Sprintf(
+			"the TXT answer for %s is about %d octets with %d records, more than the %d-octet EDNS0 payload most resolvers accept over UDP; remove unused TXT records or split the SPF record",
+			s.Domain, s.Bytes, s.Records, DefaultUDPSize)}}
+	case s.Bytes > ClassicUDPSize:
+		return []Warning{{Message: fmt.Sprintf(
+			"the TXT answer for %s is about %d octets with %d records, more than the %d octets of DNS over UDP without EDNS0",
+			s.Domain, s.Bytes, s.Records, ClassicUDPSize)}}
+	}
+
+	return nil
+}
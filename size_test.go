@@ -0,0 +1,57 @@
+package spf
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateResponseSize(t *testing.T) {
+	small, err := EstimateResponseSize("example.com", []string{"v=spf1 -all"})
+	require.NoError(t, err)
+	// header 12, question 13+4, answer 2+10+1+11, OPT 11
+	assert.Equal(t, ResponseSize{Domain: "example.com", Records: 1, Bytes: 12 + 17 + 24 + 11}, small)
+	assert.Empty(t, small.Warnings())
+
+	long := "v=spf1 " + strings.Repeat("ip4:192.0.2.1 ", 30) + "-all"
+	split, err := EstimateResponseSize("example.com", []string{long})
+	require.NoError(t, err)
+	assert.Equal(t, small.Bytes-12+len(long)+2, split.Bytes, "two character-strings")
+
+	_, err = EstimateResponseSize("bad..example", nil)
+	require.Error(t, err)
+}
+
+func TestCheckResponseSize(t *testing.T) {
+	verification := strings.Repeat("x", 200)
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com": {"v=spf1 -all", "google-site-verification=" + verification, "ms=" + verification},
+		"big.example.com": {
+			"v=spf1 -all", verification, verification, verification, verification, verification, verification,
+		},
+	}}
+	ctx := context.Background()
+
+	s, err := CheckResponseSize(ctx, zone, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 3, s.Records)
+	require.Len(t, s.Warnings(), 1)
+	assert.Contains(t, s.Warnings()[0].Message, "without EDNS0")
+
+	s, err = CheckResponseSize(ctx, zone, "big.example.com")
+	require.NoError(t, err)
+	require.Len(t, s.Warnings(), 1)
+	assert.Contains(t, s.Warnings()[0].Message, "1232-octet EDNS0 payload")
+
+	s, err = CheckResponseSize(ctx, zone, "missing.example.com")
+	require.NoError(t, err)
+	assert.Zero(t, s.Records)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = CheckResponseSize(cancelled, zone, "example.com")
+	require.ErrorIs(t, err, context.Canceled)
+}
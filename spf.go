@@ -8,11 +8,30 @@ package spf
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/mailspire/spf/parser"
+	"golang.org/x/net/idna"
+	"io"
 	"net"
+	"net/netip"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrNoPolicy is the Cause of the result for a domain that publishes no SPF
+// record or does not exist, when the Checker is configured with
+// WithNoRecordResult.
+var ErrNoPolicy = errors.New("no SPF policy published")
+
+// ErrClosed is returned by a Checker after Close.
+var ErrClosed = errors.New("checker closed")
+
+// ErrInvalidIP is returned when the client address is missing or cannot be
+// parsed.  It is a caller error rather than an SPF result.
+var ErrInvalidIP = errors.New("invalid client IP address")
+
 // Result is the outcome of an SPF evaluation (RFC 7208 section 2.6).
 type Result string
 
@@ -32,22 +51,99 @@ const (
 	MaxVoidLookups = 2  // DNS look‑ups returning no usable data
 )
 
-// Checker implements a full RFC 7208–compliant SPF policy evaluator.
+// Checker implements a full RFC 7208–compliant SPF policy evaluator.  It is
+// configured once with options passed to NewChecker and is safe for
+// concurrent use afterwards.
 type Checker struct {
-	Resolver       TXTResolver
-	MaxLookups     int
-	MaxVoidLookups int
-	// Future fields may allow customization of evaluation behaviour.
+	resolver       TXTResolver
+	maxLookups     int
+	maxVoidLookups int
+	// allowSingleLabel makes CheckHost evaluate single-label domains such as
+	// "localhost" instead of returning None for them as malformed (RFC 7208
+	// section 4.3).
+	allowSingleLabel bool
+	localPart        LocalPartMode
+	idnaProfile      *idna.Profile // nil means idna.Lookup
+	clock            func() time.Time
+	receiver         string
+	timeout          time.Duration
+	tracer           func(TraceEvent)
+	auditSinks       []auditSink
+	evidence         func(Evidence)
+	policy           Policy
+	noRecord         Result // result for domains without policy, "" = legacy
+	expLimits        ExplanationLimits
+	parseLimits      parser.Limits
+	complexity       ComplexityLimits
+	voidPolicy       VoidPolicy
+	local            atomic.Pointer[localPolicy] // swapped by SetLocalPolicy
+	allowlist        []netip.Prefix
+	denylist         []netip.Prefix
+	multipleRecords  MultipleRecordPolicy
+	enrichers        []Enricher
+	// mechanisms and modifiers handle site-specific terms by lower-case name.
+	mechanisms map[string]Extension
+	modifiers  map[string]Extension
+	// middleware wraps every DNS query of resolver, outermost first.
+	middleware []queryMiddleware
+
+	closers   []io.Closer // components owned by the Checker
+	closed    atomic.Bool
+	closeOnce sync.Once
+	closeErr  error
 }
 
-// NewChecker returns a Checker that uses the given TXTResolver.
-func NewChecker(r TXTResolver) *Checker {
-	return &Checker{
-		Resolver:       r,
-		MaxLookups:     MaxDNSLookups,
-		MaxVoidLookups: MaxVoidLookups,
+// NewChecker returns a Checker that uses the given TXTResolver, configured by
+// opts.  Without options it enforces the limits of RFC 7208 section 4.6.4.
+// Options wrapping DNS queries, such as WithCache and WithLogger, apply in the
+// order given with the first one outermost.
+func NewChecker(r TXTResolver, opts ...Option) *Checker {
+	c := &Checker{
+		resolver:       r,
+		maxLookups:     MaxDNSLookups,
+		maxVoidLookups: MaxVoidLookups,
+		expLimits:      DefaultExplanationLimits,
+		parseLimits:    parser.DefaultLimits,
+		voidPolicy:     DefaultVoidPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if closer, ok := r.(io.Closer); ok {
+		c.closers = append(c.closers, closer)
+	}
+	if r != nil && len(c.middleware) > 0 {
+		c.resolver = &middlewareResolver{r: r, mw: c.middleware}
 	}
 
+	return c
+}
+
+// Close releases the components owned by the Checker: the resolver passed to
+// NewChecker and the cache passed to WithCache, when they implement
+// io.Closer.  Callers must not share such components with other Checkers.
+// Evaluations started after Close fail with ErrClosed; Close does not wait for
+// running ones.  Calling Close more than once returns the first result.
+func (c *Checker) Close() error {
+	c.closeOnce.Do(func() {
+		c.closed.Store(true)
+		var errs []error
+		for i := len(c.closers) - 1; i >= 0; i-- {
+			errs = append(errs, c.closers[i].Close())
+		}
+		c.closeErr = errors.Join(errs...)
+	})
+
+	return c.closeErr
+}
+
+// now returns the current time according to the configured clock.
+func (c *Checker) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+
+	return time.Now()
 }
 
 // CheckHostResult contains the result code and optional cause returned by
@@ -55,10 +151,90 @@ func NewChecker(r TXTResolver) *Checker {
 type CheckHostResult struct {
 	Code  Result
 	Cause error
+	// Explanation is the expanded exp= text (RFC 7208 section 6.2) of the
+	// record that produced a Fail result, or empty when none is available.
+	Explanation string
+	// Unmapped is the result of the evaluation when a Policy replaced it in
+	// Code, and empty otherwise.
+	Unmapped Result
+	Stats    EvalStats
+	// Match describes the mechanism that produced a Pass, Fail, SoftFail or
+	// Neutral result; it is nil for the default Neutral and for other
+	// results.
+	Match *MatchInfo
+	// Bypass is set when an address list of the Checker decided the result
+	// before any DNS query, e.g. WithAllowlist.
+	Bypass *Bypass
+	// TTL is the minimum TTL of the DNS answers used by the evaluation, the
+	// time the result remains valid for a verdict cache.  It is zero when no
+	// answer reported one through ReportTTL.
+	TTL time.Duration
+	// Domain is the domain check_host() was evaluated for, in the A-label
+	// form used for lookups, and Scope the identity it was taken from.
+	// Together with the result before any Policy (Unmapped when set) they
+	// are the SPF authentication result of RFC 7489 section 4.1 that a DMARC
+	// evaluator aligns with the RFC5322.From domain.  CheckHost sets
+	// ScopeMailFrom when domain is the domain of sender and leaves Scope
+	// empty otherwise; CheckHELO sets ScopeHELO.
+	Domain string
+	Scope  Scope
+	// Records lists the SPF records fetched and evaluated, in evaluation
+	// order: the record of Domain followed by those of include and redirect
+	// targets, so that audits can show the published data a result was
+	// derived from.
+	Records []FetchedRecord
+	// Partial lists the terms evaluated before the context was canceled or
+	// its deadline expired.  The result is then TempError, returned together
+	// with the context error, and Stats counts the lookups completed.
+	Partial []TraceEvent
+	// Attributes holds the data attached by the enrichers installed with
+	// WithEnricher, nil without any.
+	Attributes map[string]string
+}
+
+// Scope is the SPF identity scope reported in DMARC aggregate reports (RFC
+// 7489 appendix C).
+type Scope string
+
+const (
+	ScopeMailFrom Scope = "mfrom" // the MAIL FROM identity, RFC 7208 section 2.4
+	ScopeHELO     Scope = "helo"  // the HELO identity, RFC 7208 section 2.3
+)
+
+// EvalStats describes the work done for one evaluation.
+type EvalStats struct {
+	Duration    time.Duration // wall time of the evaluation
+	Queries     int           // DNS queries sent to the resolver, cache hits included
+	CacheHits   int           // queries answered by the cache of WithCache
+	Lookups     int           // terms counted against the limit of RFC 7208 section 4.6.4
+	VoidLookups int           // lookups that returned no answers
 }
 
 // defaultChecker backs the package-level CheckHost convenience function.
-var defaultChecker = NewChecker(NewDNSResolver())
+// It is swapped atomically by SetDefault and nil until first needed, so that
+// importing the package builds no resolver.
+var defaultChecker atomic.Pointer[Checker]
+
+// Default returns the Checker used by the package-level CheckHost.  Unless
+// SetDefault installed one, it is created on first use with NewDNSResolver.
+func Default() *Checker {
+	if c := defaultChecker.Load(); c != nil {
+		return c
+	}
+	// concurrent first calls all return the Checker stored first
+	defaultChecker.CompareAndSwap(nil, NewChecker(NewDNSResolver()))
+
+	return defaultChecker.Load()
+}
+
+// SetDefault installs c as the Checker used by the package-level CheckHost,
+// e.g. one configured with WithCache.  It is safe to call while other
+// goroutines are checking; evaluations already running finish with the
+// previous Checker.  A nil c restores a Checker using NewDNSResolver, created
+// when next needed.
+func SetDefault(c *Checker) {
+	defaultChecker.Store(c)
+}
 
 // CheckHost implements the "check_host" algorithm from RFC 7208 section 4.6.
 // The domain parameter is the name where SPF evaluation begins.  Typically this
@@ -66,107 +242,177 @@ var defaultChecker = NewChecker(NewDNSResolver())
 // the full MAIL FROM address ("<>" for bounces) and is used only for macro
 // expansion.
 func (c *Checker) CheckHost(ctx context.Context, ip net.IP, domain, sender string) (CheckHostResult, error) {
-	valDomain, err := parser.ValidateDomain(domain)
+	return c.check(ctx, ip, domain, sender, "")
+}
+
+// CheckHostWithHELO is CheckHost with the HELO/EHLO name of the session,
+// which the %{h} macro expands to (RFC 7208 section 7.2), for callers that
+// choose the checked domain themselves.
+func (c *Checker) CheckHostWithHELO(ctx context.Context, ip net.IP, domain, sender, helo string) (CheckHostResult, error) {
+	return c.check(ctx, ip, domain, sender, strings.TrimSpace(helo))
+}
+
+// check validates domain and runs check_host() with the given identities.
+// helo is only used for the %{h} macro and may be empty.
+func (c *Checker) check(ctx context.Context, ip net.IP, domain, sender, helo string) (CheckHostResult, error) {
+	return c.checkScope(ctx, "", ip, domain, sender, helo)
+}
+
+// checkScope is check for the identity scope, ScopeMailFrom when empty and
+// domain is that of sender.  The outcome is sent to the audit sinks.
+func (c *Checker) checkScope(ctx context.Context, scope Scope, ip net.IP, domain, sender, helo string) (CheckHostResult, error) {
+	e := c.newEvaluation(ip, sender)
+	e.helo = helo
+	res, err := c.run(ctx, e, domain)
+	if senderDomain, ok := getSenderDomain(sender); ok && scope == "" && strings.EqualFold(senderDomain, domain) {
+		scope = ScopeMailFrom
+	}
+	if res.Code != "" {
+		res.Scope = scope
+	}
+	c.audit(ctx, e, domain, res, err)
+
+	return res, err
+}
+
+// run validates the client address and domain of e and evaluates domain.
+func (c *Checker) run(ctx context.Context, e *evaluation, domain string) (CheckHostResult, error) {
+	if c.closed.Load() {
+		return CheckHostResult{}, ErrClosed
+	}
+	if e.ip == nil {
+		return CheckHostResult{}, ErrInvalidIP
+	}
+	valDomain, err := parser.ValidateDomainWith(domain, parser.ValidateOptions{
+		AllowSingleLabel: c.allowSingleLabel,
+		Profile:          c.idnaProfile,
+	})
 	if err != nil {
 		// RFC 7208 section 4.3 malformed domain results to none
-		return CheckHostResult{Code: None, Cause: err}, nil
+		return c.enrich(ctx, e.ip, c.policy.apply(CheckHostResult{Code: None, Cause: err, Domain: domain})), nil
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
 	}
-	domain = valDomain
-	lp := localPart(sender)
-	// Perform the SPF record lookup per RFC 7208 section 4.4.
-	spfRecord, err := getSPFRecord(ctx, domain, c.Resolver)
 
-	// Apply the record-selection logic from RFC 7208 section 4.5.
-	switch {
-	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
-		// Context errors are outside the scope of RFC 7208.
-		return CheckHostResult{}, err
-	case errors.Is(err, ErrNoDNSrecord):
-		return CheckHostResult{Code: None, Cause: err}, err
-	case errors.Is(err, ErrTempfail):
-		return CheckHostResult{Code: TempError, Cause: err}, nil
-	case errors.Is(err, ErrPermfail), errors.Is(err, ErrMultipleSPF):
-		return CheckHostResult{Code: PermError, Cause: err}, nil
-	case err != nil:
-		return CheckHostResult{}, err
+	if res, ok := c.bypass(e); ok {
+		res.Domain = valDomain
+		return c.enrich(ctx, e.ip, c.policy.apply(res)), nil
 	}
 
-	if spfRecord == "" {
-		return CheckHostResult{}, err
+	e.simulated, e.correlationID = simulatedFrom(ctx), CorrelationID(ctx)
+	e.interruptible = ctx.Done() != nil
+	var evidence *evidenceRecorder
+	if c.evidence != nil {
+		evidence = &evidenceRecorder{}
+		ctx = context.WithValue(ctx, evidenceKey{}, evidence)
+	}
+	start := time.Now()
+	evalCtx := withTTL(withStats(ctx, &e.stats), &e.ttl)
+	res, err := e.checkHost(evalCtx, valDomain)
+	if err == nil {
+		res, err = e.applyLocalMechanisms(evalCtx, valDomain, res)
+	}
+	e.stats.Duration = time.Since(start)
+	e.stats.Lookups, e.stats.VoidLookups = e.lookups, e.voids
+	if err != nil && ctx.Err() != nil {
+		res = CheckHostResult{Code: TempError, Cause: err, Partial: e.partial()}
+	}
+	if c.noRecord != "" {
+		res, err = c.noPolicy(res, err)
+	}
+	res.Stats = e.stats
+	switch res.Code {
+	case Pass, Fail, SoftFail, Neutral:
+		res.Match = e.matched
+	}
+	res.TTL = e.ttl.ttl
+	res.Domain = valDomain
+	res.Records = e.records
+	if evidence != nil {
+		c.evidence(evidence.bundle(e, valDomain, res.Code))
+	}
+	if err != nil {
+		return c.enrich(ctx, e.ip, res), err
 	}
 
-	return c.evaluate(ctx, ip, valDomain, spfRecord, lp)
+	return c.enrich(ctx, e.ip, c.policy.apply(res)), nil
+}
+
+// CheckHostAddr is CheckHost for a netip.Addr.  Any IPv6 zone is ignored and
+// IPv4-mapped addresses are evaluated as IPv4.
+func (c *Checker) CheckHostAddr(ctx context.Context, addr netip.Addr, domain, sender string) (CheckHostResult, error) {
+	if !addr.IsValid() {
+		return CheckHostResult{}, ErrInvalidIP
+	}
 
+	return c.CheckHost(ctx, net.IP(addr.WithZone("").AsSlice()), domain, sender)
 }
 
-// CheckHost is a convenience wrapper around Checker.CheckHost for callers that
-// do not require custom configuration.
-func CheckHost(ip net.IP, domain, sender string) (CheckHostResult, error) {
-	return defaultChecker.CheckHost(context.Background(), ip, domain, sender)
+// CheckHostString is CheckHost for a textual client address such as
+// "192.0.2.1", "2001:db8::1" or "fe80::1%eth0".  Unparseable input returns
+// ErrInvalidIP instead of evaluating with a nil address.
+func (c *Checker) CheckHostString(ctx context.Context, ip, domain, sender string) (CheckHostResult, error) {
+	addr, err := parseClientIP(ip)
+	if err != nil {
+		return CheckHostResult{}, err
+	}
+
+	return c.CheckHostAddr(ctx, addr, domain, sender)
 }
 
-// evaluate walks the SPF decision tree for the given record.  It is a
-// placeholder for the logic described in RFC 7208 section 4.6 and currently
-// returns Neutral for all inputs.
-func (c *Checker) evaluate(ctx context.Context, ip net.IP, domain, spf, localPart string) (CheckHostResult, error) {
-	rec, err := parser.Parse(spf)
+// parseClientIP parses a client address, accepting an optional IPv6 zone.
+func parseClientIP(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
 	if err != nil {
-		return CheckHostResult{Code: PermError, Cause: err}, nil
-	}
-
-	// Walk mechanisms in order as required by RFC 7208 section 4.6.  Only
-	// "ip4" (section 5.2) and "all" (section 5.1) are currently supported.
-	for _, mech := range rec.Mechs {
-		switch mech.Kind {
-		case "ip4":
-			if ip4 := ip.To4(); ip4 != nil && mech.Net.Contains(ip4) {
-				return CheckHostResult{Code: resultFromQualifier(mech.Qual)}, nil
-			}
-		case "all":
-			return CheckHostResult{Code: resultFromQualifier(mech.Qual)}, nil
-		}
+		return netip.Addr{}, fmt.Errorf("%w: %w", ErrInvalidIP, err)
 	}
 
-	return CheckHostResult{Code: Neutral, Cause: errors.New("policy exists but no assertion")}, nil
+	return addr, nil
+}
+
+// CheckHost is a convenience wrapper around Checker.CheckHost for callers that
+// do not require custom configuration.  It cannot be canceled; servers should
+// use CheckHostContext.
+func CheckHost(ip net.IP, domain, sender string) (CheckHostResult, error) {
+	return CheckHostContext(context.Background(), ip, domain, sender)
+}
+
+// CheckHostContext is CheckHost with ctx bounding the evaluation, as for
+// Checker.CheckHost.
+func CheckHostContext(ctx context.Context, ip net.IP, domain, sender string) (CheckHostResult, error) {
+	return Default().CheckHost(ctx, ip, domain, sender)
 }
 
+// resultFromQualifier returns the result of a mechanism with qualifier q
+// matching.
 func resultFromQualifier(q parser.Qualifier) Result {
-	switch q {
-	case parser.QPlus:
-		return Pass
-	case parser.QMinus:
-		return Fail
-	case parser.QTilde:
-		return SoftFail
-	case parser.QMark:
-		return Neutral
-	default:
-		return Neutral
-	}
+	return Result(q.Result())
 }
 
 // getSenderDomain extracts the domain part of a MAIL FROM address as described
-// in RFC 7208 section 4.1. It returns the substring after the first '@' and ok
-// set to true when an '@' is present. If sender lacks an '@', it returns ("",
-// false).
+// in RFC 7208 section 4.1. The address is split with ParseReversePath so that
+// quoted local parts and source routes are handled.  If sender has no domain,
+// it returns ("", false).
 func getSenderDomain(sender string) (string, bool) {
-	numofParts := 2
-	parts := strings.SplitN(sender, "@", numofParts)
-	if len(parts) == numofParts {
-		return parts[1], true
+	_, domain, err := ParseReversePath(sender)
+	if err != nil || domain == "" {
+		return "", false
 	}
 
-	return "", false
+	return domain, true
 }
 
-// localPart extracts the string before '@'.  If the input lacks '@', RFC 7208
-// section 4.1 requires that "postmaster" be used instead.
+// localPart extracts the local part of sender with ParseReversePath.  If the
+// input lacks a local part or a domain, RFC 7208 section 4.3 requires that
+// "postmaster" be used instead.
 func localPart(sender string) string {
-	// strip surrounding angle brackets that MTAs sometimes keep.
-	sender = strings.Trim(sender, "<>")
-	if at := strings.IndexByte(sender, '@'); at > 0 {
-		return sender[:at] // real local part
+	local, domain, err := ParseReversePath(sender)
+	if err != nil || local == "" || domain == "" {
+		return "postmaster"
 	}
 
-	return "postmaster"
+	return local
 }
@@ -7,7 +7,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"net"
+	"net/netip"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGetSenderDomain(t *testing.T) {
@@ -18,6 +21,8 @@ func TestGetSenderDomain(t *testing.T) {
 	}{
 		{"apps@gmail.com", "gmail.com"},
 		{"apps@yahoo.com", "yahoo.com"},
+		{`"a@b"@example.com`, "example.com"},
+		{"<@relay.example:apps@example.org>", "example.org"},
 	}
 
 	for _, c := range tc {
@@ -33,6 +38,9 @@ func TestLocalPart(t *testing.T) {
 		{"<alice@example.com>", "alice"},
 		{"<>", "postmaster"},
 		{"", "postmaster"},
+		{`"a@b"@example.com`, `"a@b"`},
+		{"<@relay.example:bob@example.org>", "bob"},
+		{"@example.com", "postmaster"},
 	}
 
 	for _, c := range tc {
@@ -174,3 +182,170 @@ func TestChecker_EvaluateIP4(t *testing.T) {
 		})
 	}
 }
+
+func TestChecker_AllowSingleLabel(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{"localhost.": {"v=spf1 a -all"}},
+		ip:  map[string][]string{"localhost.": {"127.0.0.1"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("127.0.0.1"), "localhost", "")
+	require.NoError(t, err)
+	assert.Equal(t, None, res.Code)
+	require.ErrorIs(t, res.Cause, parser.ErrSingleLabel)
+
+	ch = NewChecker(NewCustomDNSResolver(zone), WithSingleLabel())
+	res, err = ch.CheckHost(context.Background(), net.ParseIP("127.0.0.1"), "localhost", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	res, err = ch.CheckHost(context.Background(), net.ParseIP("127.0.0.2"), "localhost", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+}
+
+func TestChecker_CheckHostString(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com": {"v=spf1 ip4:192.0.2.0/24 ip6:fe80::/64 -all"},
+	}}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ctx := context.Background()
+
+	cases := []struct {
+		ip   string
+		want Result
+	}{
+		{"192.0.2.1", Pass},
+		{" 192.0.2.1 ", Pass},
+		{"::ffff:192.0.2.1", Pass},
+		{"fe80::1%eth0", Pass},
+		{"198.51.100.1", Fail},
+	}
+	for _, tc := range cases {
+		res, err := ch.CheckHostString(ctx, tc.ip, "example.com", "user@example.com")
+		require.NoError(t, err, tc.ip)
+		assert.Equal(t, tc.want, res.Code, tc.ip)
+	}
+
+	for _, bad := range []string{"", "192.0.2", "not-an-ip"} {
+		_, err := ch.CheckHostString(ctx, bad, "example.com", "user@example.com")
+		require.ErrorIs(t, err, ErrInvalidIP, bad)
+	}
+
+	res, err := ch.CheckHostAddr(ctx, netip.MustParseAddr("192.0.2.7"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	_, err = ch.CheckHostAddr(ctx, netip.Addr{}, "example.com", "")
+	require.ErrorIs(t, err, ErrInvalidIP)
+
+	_, err = ch.CheckHost(ctx, nil, "example.com", "")
+	require.ErrorIs(t, err, ErrInvalidIP)
+}
+
+func TestChecker_Clock(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{
+			"example.com":         {"v=spf1 -all exp=explain.example.com"},
+			"explain.example.com": {"checked at %{t}"},
+		},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone), WithClock(func() time.Time { return time.Unix(1700000000, 0) }))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Fail, res.Code)
+	assert.Equal(t, "checked at 1700000000", res.Explanation)
+
+	ch = NewChecker(NewCustomDNSResolver(zone), WithClock(func() time.Time { return time.Unix(1700000001, 0) }))
+	res, err = ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, "checked at 1700000001", res.Explanation)
+}
+
+func TestSetDefault(t *testing.T) {
+	orig := Default()
+	t.Cleanup(func() { SetDefault(orig) })
+
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24 -all"}}}
+	custom := NewChecker(NewCustomDNSResolver(zone))
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				SetDefault(custom)
+				return
+			}
+			_ = Default()
+		}()
+	}
+	wg.Wait()
+
+	assert.Same(t, custom, Default())
+	res, err := CheckHost(net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	SetDefault(nil)
+	require.NotNil(t, Default())
+	assert.NotSame(t, custom, Default())
+}
+
+func TestDefault_Lazy(t *testing.T) {
+	orig := Default()
+	t.Cleanup(func() { SetDefault(orig) })
+
+	SetDefault(nil)
+	assert.Nil(t, defaultChecker.Load(), "no Checker until first use")
+
+	checkers := make([]*Checker, 8)
+	var wg sync.WaitGroup
+	for i := range checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkers[i] = Default()
+		}()
+	}
+	wg.Wait()
+	for _, c := range checkers {
+		assert.Same(t, checkers[0], c)
+	}
+}
+
+func TestCheckHostContext(t *testing.T) {
+	orig := Default()
+	t.Cleanup(func() { SetDefault(orig) })
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24 -all"}}}
+	SetDefault(NewChecker(NewCustomDNSResolver(zone)))
+
+	res, err := CheckHostContext(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = CheckHostContext(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCheckHostWithHELO(t *testing.T) {
+	zone := &zoneResolver{
+		txt: map[string][]string{"example.com": {"v=spf1 exists:%{h}._helo.example.com -all"}},
+		ip:  map[string][]string{"mx.example.net._helo.example.com": {"127.0.0.2"}},
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	ip := net.ParseIP("192.0.2.1")
+
+	res, err := ch.CheckHostWithHELO(context.Background(), ip, "example.com", "alice@example.com", " mx.example.net ")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Equal(t, ScopeMailFrom, res.Scope)
+
+	res, _ = ch.CheckHost(context.Background(), ip, "example.com", "alice@example.com")
+	assert.NotEqual(t, Pass, res.Code, "%{h} has no name to expand to without HELO")
+}
@@ -0,0 +1,122 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// DefaultMaxRecordSize is the record size SplitRecord aims for when no other
+// size is given.  It keeps a TXT answer with some overhead within the
+// 512-octet UDP limit (RFC 7208 section 3.4).
+const DefaultMaxRecordSize = 450
+
+// ErrRecordTooLarge is returned by SplitRecord when the terms that must stay
+// in the top record do not fit on their own.
+var ErrRecordTooLarge = errors.New("record too large to split")
+
+// PublishedRecord is a TXT record to publish in DNS.
+type PublishedRecord struct {
+	Name string
+	Text string
+}
+
+// SplitRecord publishes record at domain, moving runs of terms into include
+// sub-records named _spf1.<namespace>, _spf2.<namespace> and so on until no
+// record is longer than maxSize octets (DefaultMaxRecordSize when 0).  The
+// top record comes first and is returned alone when it already fits.
+//
+// Only "+" mechanisms without macros are moved, since a non-pass result of
+// an include is not a match (RFC 7208 section 5.2) and %{d} would expand to
+// the sub-record's name; "a", "mx" and "ptr" without a target are rewritten
+// to name domain.  The include chain costs one lookup per sub-record, and
+// SplitRecord fails with ErrTooManyLookups when that pushes the record's own
+// lookups over MaxDNSLookups.
+func SplitRecord(domain, record, namespace string, maxSize int) ([]PublishedRecord, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxRecordSize
+	}
+	node := nodeFromRecord(domain, record)
+	if node.Err != nil {
+		return nil, node.Err
+	}
+	if len(record) <= maxSize {
+		return []PublishedRecord{{Name: domain, Text: record}}, nil
+	}
+	namespace, err := parser.ValidateDomainSpec(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("namespace: %w", err)
+	}
+
+	var subs []PublishedRecord
+	var top, chunk, mods []string
+	flush := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		name := fmt.Sprintf("_spf%d.%s", len(subs)+1, namespace)
+		subs = append(subs, PublishedRecord{Name: name, Text: strings.Join(chunk, " ")})
+		top = append(top, "include:"+name)
+		chunk = nil
+	}
+	for _, term := range strings.Fields(record)[1:] {
+		movable, rewritten := splitTerm(term, domain)
+		switch {
+		case strings.Contains(term, "="):
+			mods = append(mods, term)
+		case !movable:
+			flush()
+			top = append(top, term)
+		default:
+			if len(chunk) > 0 && len(strings.Join(chunk, " "))+1+len(rewritten) > maxSize {
+				flush()
+			}
+			if len(chunk) == 0 {
+				chunk = []string{"v=spf1"}
+			}
+			chunk = append(chunk, rewritten)
+		}
+	}
+	flush()
+
+	if n := node.Lookups + len(subs); n > MaxDNSLookups {
+		return nil, fmt.Errorf("%w: split record needs %d", ErrTooManyLookups, n)
+	}
+	text := strings.Join(append(append([]string{"v=spf1"}, top...), mods...), " ")
+	if len(text) > maxSize {
+		return nil, fmt.Errorf("%w: top record has %d octets", ErrRecordTooLarge, len(text))
+	}
+	for _, s := range subs {
+		if len(s.Text) > maxSize {
+			return nil, fmt.Errorf("%w: term in %s", ErrRecordTooLarge, s.Name)
+		}
+	}
+
+	return append([]PublishedRecord{{Name: domain, Text: text}}, subs...), nil
+}
+
+// splitTerm reports whether a mechanism may move into a sub-record of the
+// record of domain and returns it in the form to use there.
+func splitTerm(term, domain string) (bool, string) {
+	rest := term
+	switch term[0] {
+	case '+':
+		rest = term[1:]
+	case '-', '~', '?':
+		return false, term
+	}
+	if strings.Contains(rest, "%") {
+		return false, term
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	switch strings.ToLower(name) {
+	case "all":
+		return false, term
+	case "a", "mx", "ptr":
+		return true, name + ":" + domain + strings.TrimPrefix(rest, name)
+	}
+
+	return true, rest
+}
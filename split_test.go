@@ -0,0 +1,61 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitRecord(t *testing.T) {
+	short := "v=spf1 ip4:192.0.2.1 -all"
+	recs, err := SplitRecord("example.com", short, "example.com", 0)
+	require.NoError(t, err)
+	assert.Equal(t, []PublishedRecord{{Name: "example.com", Text: short}}, recs)
+
+	recs, err = SplitRecord("example.com", "v=spf1 -ip4:192.0.2.9 ip4:192.0.2.1 +ip4:192.0.2.2 a/24 mx ~all exp=exp.example.com", "example.com", 80)
+	require.NoError(t, err)
+	assert.Equal(t, []PublishedRecord{
+		{Name: "example.com", Text: "v=spf1 -ip4:192.0.2.9 include:_spf1.example.com ~all exp=exp.example.com"},
+		{Name: "_spf1.example.com", Text: "v=spf1 ip4:192.0.2.1 ip4:192.0.2.2 a:example.com/24 mx:example.com"},
+	}, recs)
+}
+
+func TestSplitRecord_Evaluation(t *testing.T) {
+	var terms []string
+	for i := range 60 {
+		terms = append(terms, fmt.Sprintf("ip4:198.51.100.%d", i))
+	}
+	record := "v=spf1 " + strings.Join(terms, " ") + " -all"
+	recs, err := SplitRecord("example.com", record, "spf.example.com", 0)
+	require.NoError(t, err)
+	require.Greater(t, len(recs), 2)
+
+	zone := &zoneResolver{txt: map[string][]string{}}
+	for _, r := range recs {
+		assert.LessOrEqual(t, len(r.Text), DefaultMaxRecordSize, r.Name)
+		zone.txt[r.Name] = []string{r.Text}
+	}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+	for ip, want := range map[string]Result{"198.51.100.0": Pass, "198.51.100.59": Pass, "198.51.100.60": Fail} {
+		res, err := ch.CheckHost(context.Background(), net.ParseIP(ip), "example.com", "")
+		require.NoError(t, err)
+		assert.Equal(t, want, res.Code, ip)
+	}
+}
+
+func TestSplitRecord_Errors(t *testing.T) {
+	_, err := SplitRecord("example.com", "v=spf1 ip4:192.0.2.300", "example.com", 0)
+	require.Error(t, err)
+
+	record := "v=spf1 a:1.example.com a:2.example.com a:3.example.com a:4.example.com a:5.example.com a:6.example.com a:7.example.com a:8.example.com a:9.example.com -all"
+	_, err = SplitRecord("example.com", record, "example.com", 60)
+	require.ErrorIs(t, err, ErrTooManyLookups)
+
+	_, err = SplitRecord("example.com", "v=spf1 -ip4:192.0.2.1 -ip4:192.0.2.2 -all", "example.com", 20)
+	require.ErrorIs(t, err, ErrRecordTooLarge)
+}
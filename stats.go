@@ -0,0 +1,165 @@
+package spf
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// Error categories counted by ScanStats.
+const (
+	CategoryInvalidDomain = "invalid domain"
+	CategoryNoRecord      = "no record"
+	CategoryMultiple      = "multiple records"
+	CategoryTempError     = "temperror"
+	CategorySyntax        = "syntax"
+	CategoryOther         = "other"
+)
+
+// ScanStats tallies scan results for large-scale studies.  Add results as
+// they arrive from Scanner.Scan; a ScanStats is not safe for concurrent use.
+type ScanStats struct {
+	Domains    int            // results added
+	WithRecord int            // results with a parsed record
+	Errors     map[string]int // results per error category
+	// All counts records by the qualifier of their "all" mechanism ("-all",
+	// "~all", "?all", "+all") or "redirect" / "none" when they have none.
+	All map[string]int
+	// Lookups is a histogram of DNS lookup counts, over the whole tree when
+	// the result has a Graph and over the record alone otherwise.
+	Lookups map[int]int
+	// Mechanisms counts the records using each mechanism kind at least once,
+	// as well as the "redirect" and "exp" modifiers.
+	Mechanisms map[string]int
+	// Includes counts the records including each target domain.
+	Includes map[string]int
+}
+
+// Count is a key with its number of occurrences.
+type Count struct {
+	Key string
+	N   int
+}
+
+// NewScanStats returns an empty ScanStats.
+func NewScanStats() *ScanStats {
+	return &ScanStats{
+		Errors:     make(map[string]int),
+		All:        make(map[string]int),
+		Lookups:    make(map[int]int),
+		Mechanisms: make(map[string]int),
+		Includes:   make(map[string]int),
+	}
+}
+
+// Add tallies one result.
+func (s *ScanStats) Add(res ScanResult) {
+	s.Domains++
+	if res.Err != nil {
+		s.Errors[errorCategory(res.Err, true)]++
+		return
+	}
+	if res.Node.Err != nil {
+		s.Errors[errorCategory(res.Node.Err, false)]++
+		return
+	}
+	rec := res.Node.Record
+	s.WithRecord++
+
+	s.All[allQualifier(rec)]++
+	if res.Graph != nil {
+		s.Lookups[res.Graph.TotalLookups()]++
+	} else {
+		s.Lookups[res.Node.Lookups]++
+	}
+
+	used := make(map[string]bool)
+	for _, m := range rec.Mechs {
+		used[m.Kind] = true
+	}
+	if rec.Redirect != nil {
+		used["redirect"] = true
+	}
+	if rec.Exp != nil {
+		used["exp"] = true
+	}
+	for kind := range used {
+		s.Mechanisms[kind]++
+	}
+
+	targets := make(map[string]bool)
+	for _, e := range res.Node.Edges {
+		if e.Kind == "include" && !e.Macro {
+			targets[e.Target] = true
+		}
+	}
+	for t := range targets {
+		s.Includes[t]++
+	}
+}
+
+// errorCategory maps a scan error to one of the Category constants.
+func errorCategory(err error, domain bool) string {
+	switch {
+	case domain:
+		return CategoryInvalidDomain
+	case errors.Is(err, ErrNoDNSrecord), errors.Is(err, ErrMissingRecord):
+		return CategoryNoRecord
+	case errors.Is(err, ErrMultipleSPF):
+		return CategoryMultiple
+	case errors.Is(err, ErrTempfail):
+		return CategoryTempError
+	case errors.Is(err, ErrPermfail), errors.Is(err, ErrUnsupported):
+		return CategoryOther
+	default:
+		return CategorySyntax
+	}
+}
+
+// allQualifier describes how rec ends.
+func allQualifier(rec *parser.Record) string {
+	for _, m := range rec.Mechs {
+		if m.Kind == "all" {
+			return m.Qual.String() + "all"
+		}
+	}
+	if rec.Redirect != nil {
+		return "redirect"
+	}
+
+	return "none"
+}
+
+// ErrorRate returns the share of results that have no usable record.
+func (s *ScanStats) ErrorRate() float64 {
+	if s.Domains == 0 {
+		return 0
+	}
+
+	return float64(s.Domains-s.WithRecord) / float64(s.Domains)
+}
+
+// TopIncludes returns the n most included domains, most frequent first and
+// ties in lexical order.  n <= 0 returns all of them.
+func (s *ScanStats) TopIncludes(n int) []Count {
+	return topCounts(s.Includes, n)
+}
+
+func topCounts(m map[string]int, n int) []Count {
+	out := make([]Count, 0, len(m))
+	for k, v := range m {
+		out = append(out, Count{Key: k, N: v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].N != out[j].N {
+			return out[i].N > out[j].N
+		}
+		return out[i].Key < out[j].Key
+	})
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+
+	return out
+}
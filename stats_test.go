@@ -0,0 +1,39 @@
+package spf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanStats(t *testing.T) {
+	zone := graphZone()
+	zone.txt["multi.example.com"] = []string{"v=spf1 -all", "v=spf1 ~all"}
+	zone.txt["other.example.com"] = []string{"v=spf1 include:vendor.example.net ip4:192.0.2.0/24 exp=explain.example.com"}
+	s := NewScanner(zone)
+	s.FollowIncludes = true
+
+	stats := NewScanStats()
+	for _, res := range scanAll(t, s, "example.com", "_spf.example.com", "_spf2.example.com", "other.example.com",
+		"broken.example.com", "multi.example.com", "none.example.com", "bad..example") {
+		stats.Add(res)
+	}
+
+	assert.Equal(t, 8, stats.Domains)
+	assert.Equal(t, 4, stats.WithRecord)
+	assert.InDelta(t, 0.5, stats.ErrorRate(), 1e-9)
+	assert.Equal(t, map[string]int{
+		CategoryInvalidDomain: 1,
+		CategoryNoRecord:      1,
+		CategoryMultiple:      1,
+		CategorySyntax:        1,
+	}, stats.Errors)
+	assert.Equal(t, map[string]int{"-all": 1, "?all": 1, "redirect": 1, "none": 1}, stats.All)
+	assert.Equal(t, map[int]int{9: 2, 10: 1, 11: 1}, stats.Lookups)
+	assert.Equal(t, 3, stats.Mechanisms["include"])
+	assert.Equal(t, 1, stats.Mechanisms["exp"])
+	assert.Equal(t, 2, stats.Mechanisms["ip4"])
+	assert.Equal(t, []Count{{"vendor.example.net", 3}}, stats.TopIncludes(1))
+	assert.Len(t, stats.TopIncludes(0), 2)
+	assert.Zero(t, NewScanStats().ErrorRate())
+}
@@ -0,0 +1,232 @@
+package spf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoSnapshot is returned by a SnapshotStore when no snapshot matches.
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// Snapshot is the resolved policy of a domain at one point in time, stored
+// as the raw records of its Graph.
+type Snapshot struct {
+	Domain  string            `json:"domain"`
+	Time    time.Time         `json:"time"`
+	Records map[string]string `json:"records"`          // selected record per domain
+	Errors  map[string]string `json:"errors,omitempty"` // lookup error per domain
+	// Findings are the alert findings of the Watcher that took the
+	// snapshot, so that conditions already alerted on do not alert again
+	// after a restart.
+	Findings []AlertFinding `json:"findings,omitempty"`
+}
+
+// NewSnapshot captures g at time t.
+func NewSnapshot(g *Graph, t time.Time) Snapshot {
+	s := Snapshot{Domain: g.Root, Time: t, Records: make(map[string]string)}
+	for d, n := range g.Nodes {
+		if n.Raw != "" {
+			s.Records[d] = n.Raw
+			continue
+		}
+		if n.Err != nil {
+			if s.Errors == nil {
+				s.Errors = make(map[string]string)
+			}
+			s.Errors[d] = n.Err.Error()
+		}
+	}
+
+	return s
+}
+
+// Graph rebuilds the Graph of the snapshot.  Lookup errors are restored by
+// message only and no longer match their sentinel errors with errors.Is.
+func (s Snapshot) Graph() *Graph {
+	g := &Graph{Root: s.Domain, Nodes: make(map[string]*Node, len(s.Records)+len(s.Errors))}
+	for d, raw := range s.Records {
+		g.Nodes[d] = nodeFromRecord(d, raw)
+	}
+	for d, msg := range s.Errors {
+		g.Nodes[d] = &Node{Domain: d, Err: errors.New(msg)}
+	}
+
+	return g
+}
+
+// SnapshotStore persists snapshots over time.  Implementations must be safe
+// for concurrent use.
+type SnapshotStore interface {
+	// Save stores s.
+	Save(ctx context.Context, s Snapshot) error
+	// At returns the latest snapshot of domain taken at or before t, or
+	// ErrNoSnapshot.
+	At(ctx context.Context, domain string, t time.Time) (Snapshot, error)
+	// History returns the snapshots of domain taken in [from, to], oldest
+	// first.
+	History(ctx context.Context, domain string, from, to time.Time) ([]Snapshot, error)
+}
+
+// MemoryStore is a SnapshotStore keeping snapshots in memory.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	snapshots map[string][]Snapshot // per domain, ordered by time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{snapshots: make(map[string][]Snapshot)}
+}
+
+// Save implements SnapshotStore.
+func (m *MemoryStore) Save(_ context.Context, s Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots[s.Domain] = insertSnapshot(m.snapshots[s.Domain], s)
+
+	return nil
+}
+
+// At implements SnapshotStore.
+func (m *MemoryStore) At(_ context.Context, domain string, t time.Time) (Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return snapshotAt(m.snapshots[domain], domain, t)
+}
+
+// History implements SnapshotStore.
+func (m *MemoryStore) History(_ context.Context, domain string, from, to time.Time) ([]Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return snapshotsBetween(m.snapshots[domain], from, to), nil
+}
+
+// FileStore is a SnapshotStore writing one JSON Lines file per domain below
+// a directory.  Files are read in full on every query, which suits the
+// modest number of changes a policy sees.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore returns a FileStore in dir, creating the directory if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(domain string) string {
+	return filepath.Join(f.dir, filepath.Base(domain)+".jsonl")
+}
+
+// Save implements SnapshotStore.
+func (f *FileStore) Save(_ context.Context, s Snapshot) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path(s.Domain), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// At implements SnapshotStore.
+func (f *FileStore) At(_ context.Context, domain string, t time.Time) (Snapshot, error) {
+	all, err := f.load(domain)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return snapshotAt(all, domain, t)
+}
+
+// History implements SnapshotStore.
+func (f *FileStore) History(_ context.Context, domain string, from, to time.Time) ([]Snapshot, error) {
+	all, err := f.load(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotsBetween(all, from, to), nil
+}
+
+// load reads every snapshot of domain, ordered by time.
+func (f *FileStore) load(domain string) ([]Snapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.Open(f.path(domain))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var all []Snapshot
+	sc := bufio.NewScanner(file)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		var s Snapshot
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", f.path(domain), n, err)
+		}
+		all = insertSnapshot(all, s)
+	}
+
+	return all, sc.Err()
+}
+
+// insertSnapshot adds s to snapshots, keeping them ordered by time.
+func insertSnapshot(snapshots []Snapshot, s Snapshot) []Snapshot {
+	i := sort.Search(len(snapshots), func(i int) bool { return snapshots[i].Time.After(s.Time) })
+	snapshots = append(snapshots, Snapshot{})
+	copy(snapshots[i+1:], snapshots[i:])
+	snapshots[i] = s
+
+	return snapshots
+}
+
+func snapshotAt(snapshots []Snapshot, domain string, t time.Time) (Snapshot, error) {
+	i := sort.Search(len(snapshots), func(i int) bool { return snapshots[i].Time.After(t) })
+	if i == 0 {
+		return Snapshot{}, fmt.Errorf("%w: %s at %s", ErrNoSnapshot, domain, t.Format(time.RFC3339))
+	}
+
+	return snapshots[i-1], nil
+}
+
+func snapshotsBetween(snapshots []Snapshot, from, to time.Time) []Snapshot {
+	var out []Snapshot
+	for _, s := range snapshots {
+		if !s.Time.Before(from) && !s.Time.After(to) {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,89 @@
+package spf
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_Graph(t *testing.T) {
+	g, err := BuildGraph(context.Background(), networkZone(), "example.com")
+	require.NoError(t, err)
+
+	s := NewSnapshot(g, time.Unix(100, 0))
+	assert.Equal(t, "example.com", s.Domain)
+	assert.Len(t, s.Records, 4)
+	assert.Len(t, s.Errors, 1)
+
+	restored := s.Graph()
+	assert.Equal(t, g.Domains(), restored.Domains())
+	assert.Empty(t, DiffGraphs(g, restored))
+	require.EqualError(t, restored.Nodes["missing.example.com"].Err, g.Nodes["missing.example.com"].Err.Error())
+}
+
+func TestSnapshotStores(t *testing.T) {
+	files, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	stores := map[string]SnapshotStore{"memory": NewMemoryStore(), "file": files}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, sec := range []int64{300, 100, 200} {
+				s := Snapshot{Domain: "example.com", Time: time.Unix(sec, 0).UTC(), Records: map[string]string{"example.com": "v=spf1 -all"}}
+				require.NoError(t, store.Save(ctx, s))
+			}
+
+			s, err := store.At(ctx, "example.com", time.Unix(250, 0))
+			require.NoError(t, err)
+			assert.Equal(t, int64(200), s.Time.Unix())
+			assert.Equal(t, "v=spf1 -all", s.Records["example.com"])
+
+			_, err = store.At(ctx, "example.com", time.Unix(50, 0))
+			require.ErrorIs(t, err, ErrNoSnapshot)
+			_, err = store.At(ctx, "other.example", time.Unix(500, 0))
+			require.ErrorIs(t, err, ErrNoSnapshot)
+
+			all, err := store.History(ctx, "example.com", time.Unix(100, 0), time.Unix(200, 0))
+			require.NoError(t, err)
+			require.Len(t, all, 2)
+			assert.Equal(t, int64(100), all[0].Time.Unix())
+		})
+	}
+}
+
+func TestWatcher_Store(t *testing.T) {
+	ctx := context.Background()
+	zone := graphZone()
+	store := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	w := NewWatcher(zone, time.Hour, "example.com")
+	w.Clock = func() time.Time { return now }
+	w.Store = store
+
+	_, err := w.Poll(ctx)
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	_, err = w.Poll(ctx)
+	require.NoError(t, err)
+	all, err := store.History(ctx, "example.com", time.Time{}, now)
+	require.NoError(t, err)
+	assert.Len(t, all, 1, "unchanged polls are not stored")
+
+	// a new watcher picks up the stored baseline
+	zone.txt["_spf2.example.com"] = []string{"v=spf1 include:vendor.example.net -all"}
+	w2 := NewWatcher(zone, time.Hour, "example.com")
+	w2.Clock = w.Clock
+	w2.Store = store
+	events, err := w2.Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "_spf2.example.com", events[0].Changes[0].Domain)
+
+	old, err := store.At(ctx, "example.com", time.Unix(1500, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "v=spf1 include:vendor.example.net ?all", old.Records["_spf2.example.com"])
+}
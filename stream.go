@@ -0,0 +1,314 @@
+package spf
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultMaxConns is the number of persistent connections an
+// UpstreamResolver opens per server unless configured otherwise.
+const DefaultMaxConns = 2
+
+// Transport selects how an UpstreamResolver carries queries.
+type Transport int
+
+const (
+	TransportUDP Transport = iota // UDP, with TCP for truncated answers
+	TransportTCP                  // TCP only (RFC 7766)
+	TransportTLS                  // DNS over TLS (RFC 7858)
+)
+
+var transportNames = []string{"udp", "tcp", "tls"}
+
+// UnmarshalText decodes the name of a transport: "udp", "tcp" or "tls".
+func (t *Transport) UnmarshalText(text []byte) error {
+	i := slices.Index(transportNames, strings.ToLower(string(text)))
+	if i < 0 {
+		return fmt.Errorf("unknown transport %q", text)
+	}
+	*t = Transport(i)
+
+	return nil
+}
+
+// streamRoundTrip sends query to server over a persistent TCP, TLS or unix
+// stream connection, opening one when none is free.  A reused connection
+// the server has closed in the meantime is replaced by a new one.
+func (u *UpstreamResolver) streamRoundTrip(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	maxConns := u.MaxConns
+	if maxConns <= 0 {
+		maxConns = DefaultMaxConns
+	}
+	for {
+		sc, reused := u.streams.get(server, maxConns), true
+		if sc == nil {
+			var err error
+			sc, reused, err = u.streams.open(server, maxConns, func() (net.Conn, error) {
+				return u.dialStream(ctx, network, server)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		msg, err := sc.exchange(ctx, query)
+		if err != nil && reused && sc.broken() && ctx.Err() == nil {
+			continue
+		}
+
+		return msg, err
+	}
+}
+
+// dialStream connects to server over network, "tcp", "tls" or "unix".
+func (u *UpstreamResolver) dialStream(ctx context.Context, network, server string) (net.Conn, error) {
+	if network != "tls" {
+		var d net.Dialer
+		return d.DialContext(ctx, network, server)
+	}
+
+	config := &tls.Config{}
+	if u.TLSConfig != nil {
+		config = u.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	d := tls.Dialer{Config: config}
+
+	return d.DialContext(ctx, "tcp", server)
+}
+
+// streamConn is a persistent stream connection carrying pipelined queries
+// (RFC 7766 section 6.2.1.1): queries are written as they come and a reader
+// goroutine hands every answer to the query with its ID, in whatever order
+// the server answers.
+type streamConn struct {
+	conn net.Conn
+	wmu  sync.Mutex // serialises writes
+
+	mu      sync.Mutex
+	pending map[uint16]chan []byte // by ID on the wire
+	err     error                  // why the connection broke
+}
+
+func newStreamConn(conn net.Conn) *streamConn {
+	sc := &streamConn{conn: conn, pending: make(map[uint16]chan []byte)}
+	go sc.read()
+
+	return sc
+}
+
+// read dispatches answers until the connection breaks.  Answers to queries
+// given up on are dropped.
+func (sc *streamConn) read() {
+	for {
+		var n [2]byte
+		if _, err := io.ReadFull(sc.conn, n[:]); err != nil {
+			sc.fail(err)
+			return
+		}
+		answer := make([]byte, binary.BigEndian.Uint16(n[:]))
+		if _, err := io.ReadFull(sc.conn, answer); err != nil {
+			sc.fail(err)
+			return
+		}
+		if len(answer) < 2 {
+			continue
+		}
+		id := binary.BigEndian.Uint16(answer)
+		sc.mu.Lock()
+		ch, ok := sc.pending[id]
+		delete(sc.pending, id)
+		sc.mu.Unlock()
+		if ok {
+			ch <- answer
+		}
+	}
+}
+
+// exchange writes query with its length prefix and waits for the answer.
+// Queries in flight get IDs unique on the connection; the answer carries the
+// ID of query again.
+func (sc *streamConn) exchange(ctx context.Context, query []byte) (*dnsmessage.Message, error) {
+	sc.mu.Lock()
+	if sc.err != nil {
+		sc.mu.Unlock()
+		return nil, sc.err
+	}
+	id := binary.BigEndian.Uint16(query)
+	wireID := id
+	for sc.pending[wireID] != nil {
+		wireID = uint16(rand.N(1 << 16))
+	}
+	ch := make(chan []byte, 1)
+	sc.pending[wireID] = ch
+	sc.mu.Unlock()
+
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	frame = binary.BigEndian.AppendUint16(frame, wireID)
+	frame = append(frame, query[2:]...)
+	sc.wmu.Lock()
+	deadline, _ := ctx.Deadline()
+	err := sc.conn.SetWriteDeadline(deadline)
+	if err == nil {
+		_, err = sc.conn.Write(frame)
+	}
+	sc.wmu.Unlock()
+	if err != nil {
+		// a partly written query leaves the connection out of step
+		sc.fail(err)
+		return nil, err
+	}
+
+	var answer []byte
+	select {
+	case answer = <-ch:
+	case <-ctx.Done():
+		sc.mu.Lock()
+		delete(sc.pending, wireID)
+		sc.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if answer == nil {
+		return nil, sc.failure()
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(answer); err != nil {
+		return nil, err
+	}
+	msg.ID = id
+
+	return &msg, nil
+}
+
+// fail closes the connection for err and fails the queries in flight.
+func (sc *streamConn) fail(err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.err != nil {
+		return
+	}
+	sc.err = err
+	sc.conn.Close()
+	for id, ch := range sc.pending {
+		close(ch)
+		delete(sc.pending, id)
+	}
+}
+
+// failure returns why the connection broke, nil while it works.
+func (sc *streamConn) failure() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return sc.err
+}
+
+func (sc *streamConn) broken() bool {
+	return sc.failure() != nil
+}
+
+// inFlight returns the number of queries waiting for an answer.
+func (sc *streamConn) inFlight() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return len(sc.pending)
+}
+
+// streamPool keeps the persistent connections per server.
+type streamPool struct {
+	mu      sync.Mutex
+	conns   map[string][]*streamConn
+	dialing map[string]*sync.Mutex // serialises the dials per server
+}
+
+// get returns the connection to server with the fewest queries in flight,
+// or nil when a new one should be opened: there is none, or all are busy
+// and fewer than limit are open.  Broken connections are dropped.
+func (p *streamPool) get(server string, limit int) *streamConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.conns[server][:0]
+	var best *streamConn
+	bestLoad := 0
+	for _, sc := range p.conns[server] {
+		if sc.broken() {
+			continue
+		}
+		conns = append(conns, sc)
+		if load := sc.inFlight(); best == nil || load < bestLoad {
+			best, bestLoad = sc, load
+		}
+	}
+	if p.conns != nil {
+		p.conns[server] = conns
+	}
+	if best == nil || bestLoad > 0 && len(conns) < limit {
+		return nil
+	}
+
+	return best
+}
+
+// open returns a connection to server like get does, or else one opened
+// with dial and kept for reuse; reused reports which.  Dials to one server
+// are serialised, so that a burst of queries waits for the first new
+// connection instead of opening one each.
+func (p *streamPool) open(server string, limit int, dial func() (net.Conn, error)) (sc *streamConn, reused bool, err error) {
+	p.mu.Lock()
+	if p.dialing == nil {
+		p.dialing = make(map[string]*sync.Mutex)
+	}
+	mu := p.dialing[server]
+	if mu == nil {
+		mu = &sync.Mutex{}
+		p.dialing[server] = mu
+	}
+	p.mu.Unlock()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sc := p.get(server, limit); sc != nil {
+		return sc, true, nil
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, false, err
+	}
+	sc = newStreamConn(conn)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		p.conns = make(map[string][]*streamConn)
+	}
+	p.conns[server] = append(p.conns[server], sc)
+
+	return sc, false, nil
+}
+
+func (p *streamPool) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conns := range p.conns {
+		for _, sc := range conns {
+			sc.fail(net.ErrClosed)
+		}
+	}
+	p.conns = nil
+}
@@ -0,0 +1,156 @@
+package spf
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestUpstreamResolver_Pipelining(t *testing.T) {
+	// the server answers only once all queries are in flight
+	const n = 5
+	var inFlight sync.WaitGroup
+	inFlight.Add(n)
+	srv := newDNSServer(t, func(q dnsmessage.Message, tcp bool) dnsmessage.Message {
+		assert.True(t, tcp)
+		inFlight.Done()
+		inFlight.Wait()
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 include:"+q.Questions[0].Name.String()+" -all")}}
+	})
+	u := NewUpstreamResolver(srv.addr)
+	u.Transport = TransportTCP
+	u.MaxConns = 1
+
+	var lookups sync.WaitGroup
+	for i := range n {
+		lookups.Add(1)
+		go func() {
+			defer lookups.Done()
+			name := string(rune('a'+i)) + ".example.com"
+			txts, err := u.LookupTXT(context.Background(), name)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"v=spf1 include:" + name + ". -all"}, txts)
+		}()
+	}
+	lookups.Wait()
+	assert.Equal(t, 1, srv.dropTCP(), "all queries on one connection")
+	require.NoError(t, u.Close())
+}
+
+func TestStreamPool(t *testing.T) {
+	var p streamPool
+	assert.Nil(t, p.get("192.0.2.53:53", 2))
+
+	client, server := net.Pipe()
+	defer server.Close()
+	dials := 0
+	dial := func() (net.Conn, error) {
+		dials++
+		return client, nil
+	}
+	sc, reused, err := p.open("192.0.2.53:53", 1, dial)
+	require.NoError(t, err)
+	assert.False(t, reused)
+	assert.Same(t, sc, p.get("192.0.2.53:53", 1))
+	again, reused, err := p.open("192.0.2.53:53", 1, dial)
+	require.NoError(t, err)
+	assert.True(t, reused)
+	assert.Same(t, sc, again)
+	assert.Equal(t, 1, dials)
+
+	// busy connections get company while below the limit
+	sc.mu.Lock()
+	sc.pending[1] = make(chan []byte, 1)
+	sc.mu.Unlock()
+	assert.Nil(t, p.get("192.0.2.53:53", 2))
+	assert.Same(t, sc, p.get("192.0.2.53:53", 1))
+
+	// broken connections are dropped and fail their queries
+	p.closeAll()
+	assert.True(t, sc.broken())
+	require.ErrorIs(t, sc.failure(), net.ErrClosed)
+	assert.Nil(t, p.get("192.0.2.53:53", 1))
+}
+
+// listenTLS makes s also answer DNS over TLS for serverName and returns the
+// address and the pool trusting its certificate.
+func (s *dnsServer) listenTLS(t *testing.T, serverName string) (string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.accepted = append(s.accepted, conn)
+			s.mu.Unlock()
+			go s.serveTCP(conn)
+		}
+	}()
+
+	return ln.Addr().String(), roots
+}
+
+func TestUpstreamResolver_TLS(t *testing.T) {
+	srv := newDNSServer(t, func(q dnsmessage.Message, tcp bool) dnsmessage.Message {
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 -all")}}
+	})
+	addr, roots := srv.listenTLS(t, "dns.example.net")
+	ctx := context.Background()
+
+	u := NewUpstreamResolver(addr)
+	u.Transport = TransportTLS
+	u.TLSConfig = &tls.Config{RootCAs: roots, ServerName: "dns.example.net"}
+	for range 3 {
+		txts, err := u.LookupTXT(ctx, "example.com")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"v=spf1 -all"}, txts)
+	}
+	assert.Equal(t, 1, srv.dropTCP())
+	require.NoError(t, u.Close())
+
+	// the server name defaults to the host, which the certificate lacks
+	u = NewUpstreamResolver(addr)
+	u.Transport = TransportTLS
+	u.TLSConfig = &tls.Config{RootCAs: roots}
+	_, err := u.LookupTXT(ctx, "example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.Temporary())
+	assert.Contains(t, dnsErr.Err, "certificate")
+}
@@ -0,0 +1,174 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// TraceEvent describes one mechanism evaluated by a Checker configured with
+// WithTrace.
+type TraceEvent struct {
+	Domain  string // domain whose record contains the term
+	Term    string
+	Matched bool
+	Err     error // error that aborted the evaluation at this term
+	// CorrelationID is the ID of the evaluation's context, see
+	// ContextWithCorrelationID.
+	CorrelationID string
+}
+
+// evaluatedTerm is a mechanism evaluated so far, kept unformatted for the
+// partial result of an interrupted evaluation.
+type evaluatedTerm struct {
+	domain  string
+	mech    *parser.Mechanism
+	matched bool
+	err     error
+}
+
+// traceTerm reports an evaluated mechanism to the Checker's tracer and, when
+// the evaluation can be interrupted, keeps it for the partial result.
+func (e *evaluation) traceTerm(domain string, mech *parser.Mechanism, matched bool, err error) {
+	if e.interruptible {
+		e.evaluated = append(e.evaluated, evaluatedTerm{domain, mech, matched, err})
+	}
+	if e.checker.tracer == nil {
+		return
+	}
+	e.checker.tracer(e.traceEvent(domain, mech, matched, err))
+}
+
+func (e *evaluation) traceEvent(domain string, mech *parser.Mechanism, matched bool, err error) TraceEvent {
+	return TraceEvent{Domain: domain, Term: mech.String(), Matched: matched, Err: err, CorrelationID: e.correlationID}
+}
+
+// partial returns the terms evaluated before the evaluation was interrupted.
+func (e *evaluation) partial() []TraceEvent {
+	var events []TraceEvent
+	for _, t := range e.evaluated {
+		events = append(events, e.traceEvent(t.domain, t.mech, t.matched, t.err))
+	}
+
+	return events
+}
+
+// ChainStep is one term on the path from the queried domain to the term that
+// decided the result.
+type ChainStep struct {
+	Domain string // domain whose record contains the term
+	Term   string // the term as written, e.g. "include:_spf.vendor.example"
+}
+
+// FetchedRecord is an SPF record evaluated by check_host(), as published
+// when it was fetched.
+type FetchedRecord struct {
+	Domain string // domain the record was fetched for
+	// Text is the record as published, in its original case, without the
+	// white space around it, e.g. "v=spf1 mx -all".
+	Text string
+	// Override is set when Text came from WithRecordOverrides or Simulate
+	// instead of DNS.
+	Override bool
+	Time     time.Time // when the record was fetched
+	// Discarded lists the other SPF records of Domain, set aside under
+	// WithMultipleRecords.
+	Discarded []string
+}
+
+// ExplainResult reports why a client address gets its result.
+type ExplainResult struct {
+	Result CheckHostResult
+	// Chain lists the include and redirect terms followed from the queried
+	// domain and, last, the mechanism that matched.  It is empty when no
+	// mechanism matched or the evaluation ended in an error.
+	Chain []ChainStep
+}
+
+// Authorized reports whether the client is authorized, i.e. the result is
+// Pass.
+func (r ExplainResult) Authorized() bool {
+	return r.Result.Code == Pass
+}
+
+// String renders the chain as "include:_spf.vendor.example → ip4:203.0.113.0/24",
+// or states that no term matched.
+func (r ExplainResult) String() string {
+	if len(r.Chain) == 0 {
+		return "no term matched: " + string(r.Result.Code)
+	}
+	terms := make([]string, len(r.Chain))
+	for i, step := range r.Chain {
+		terms[i] = step.Term
+	}
+
+	return strings.Join(terms, " → ") + ": " + string(r.Result.Code)
+}
+
+// Explain evaluates the policy of domain for ip like CheckHost with an empty
+// sender and reports the chain of terms that produced the result.  It answers
+// questions such as "which include authorizes this address".
+func (c *Checker) Explain(ctx context.Context, ip net.IP, domain string) (ExplainResult, error) {
+	e := c.newEvaluation(ip, "")
+	e.recordChain = true
+	res, err := c.run(ctx, e, domain)
+	if err != nil {
+		return ExplainResult{}, err
+	}
+
+	out := ExplainResult{Result: res}
+	switch res.Code {
+	case Pass, Fail, SoftFail, Neutral:
+		out.Chain = e.chain
+	}
+
+	return out, nil
+}
+
+// recordMatch records that mech of domain matched.  The chain built by a
+// nested evaluation is kept behind a matching include, as is the MatchInfo
+// of the mechanism that matched there.
+func (e *evaluation) recordMatch(domain string, mech *parser.Mechanism) {
+	if mech.Kind != "include" {
+		e.matched = &MatchInfo{Domain: domain, Term: mech.String(), Depth: e.depth, Prefix: e.prefix}
+	}
+	if !e.recordChain {
+		return
+	}
+	step := ChainStep{Domain: domain, Term: mech.String()}
+	if mech.Kind != "include" {
+		e.chain = []ChainStep{step}
+		return
+	}
+	e.chain = append([]ChainStep{step}, e.chain...)
+}
+
+// recordRedirect prepends the redirect of domain to the chain of its target,
+// unless no mechanism matched there.
+func (e *evaluation) recordRedirect(domain string, mod *parser.Modifier) {
+	if !e.recordChain || len(e.chain) == 0 {
+		return
+	}
+	step := ChainStep{Domain: domain, Term: "redirect=" + mod.Value}
+	e.chain = append([]ChainStep{step}, e.chain...)
+}
+
+// MatchInfo describes the mechanism that determined a result and how
+// specific its match was, so that a Pass from "+ip4:0.0.0.0/1" deep in an
+// include chain can be weighted differently from a tight one.
+type MatchInfo struct {
+	Domain string // domain whose record contains the mechanism
+	Term   string // the mechanism, e.g. "ip4:192.0.2.0/24"
+	// Depth counts the include and redirect hops from the queried domain to
+	// Domain; 0 is the queried domain's own record.
+	Depth int
+	// Prefix is the matched network containing the client: the ip4 or ip6
+	// network, an A or AAAA address widened by the mechanism's CIDR length,
+	// or the /0 of the client's family for "all".  It is invalid for ptr and
+	// exists, which match by name.
+	Prefix netip.Prefix
+}
@@ -0,0 +1,111 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func traceZone() *zoneResolver {
+	return &zoneResolver{txt: map[string][]string{
+		"example.com":          {"v=spf1 include:_spf.example.com include:_spf.vendor.example -all"},
+		"_spf.example.com":     {"v=spf1 ip4:192.0.2.0/24 -all"},
+		"_spf.vendor.example":  {"v=spf1 redirect=_spf2.vendor.example"},
+		"_spf2.vendor.example": {"v=spf1 ip4:203.0.113.0/24 ?all"},
+		"neutral.example.com":  {"v=spf1 ip4:192.0.2.1"},
+		"broken.example.com":   {"v=spf1 include:missing.example.com"},
+	}}
+}
+
+func TestChecker_Explain(t *testing.T) {
+	cases := []struct {
+		name   string
+		domain string
+		ip     string
+		want   Result
+		chain  []ChainStep
+		text   string
+	}{
+		{
+			"direct include", "example.com", "192.0.2.7", Pass,
+			[]ChainStep{
+				{"example.com", "include:_spf.example.com"},
+				{"_spf.example.com", "ip4:192.0.2.0/24"},
+			},
+			"include:_spf.example.com → ip4:192.0.2.0/24: pass",
+		},
+		{
+			"include and redirect", "example.com", "203.0.113.9", Pass,
+			[]ChainStep{
+				{"example.com", "include:_spf.vendor.example"},
+				{"_spf.vendor.example", "redirect=_spf2.vendor.example"},
+				{"_spf2.vendor.example", "ip4:203.0.113.0/24"},
+			},
+			"include:_spf.vendor.example → redirect=_spf2.vendor.example → ip4:203.0.113.0/24: pass",
+		},
+		{
+			"not authorized", "example.com", "198.51.100.1", Fail,
+			[]ChainStep{{"example.com", "-all"}},
+			"-all: fail",
+		},
+		{"default neutral", "neutral.example.com", "198.51.100.1", Neutral, nil, "no term matched: neutral"},
+		{"error", "broken.example.com", "198.51.100.1", PermError, nil, "no term matched: permerror"},
+	}
+
+	ch := NewChecker(NewCustomDNSResolver(traceZone()))
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := ch.Explain(context.Background(), net.ParseIP(tc.ip), tc.domain)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, res.Result.Code)
+			assert.Equal(t, tc.chain, res.Chain)
+			assert.Equal(t, tc.want == Pass, res.Authorized())
+			assert.Equal(t, tc.text, res.String())
+		})
+	}
+}
+
+func TestChecker_ExplainInvalidIP(t *testing.T) {
+	ch := NewChecker(NewCustomDNSResolver(traceZone()))
+	_, err := ch.Explain(context.Background(), nil, "example.com")
+	require.ErrorIs(t, err, ErrInvalidIP)
+}
+
+func TestCheckHostResult_Records(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":       {"v=spf1 include:_spf.example.com redirect=moved.example.com"},
+		"_spf.example.com":  {"v=spf1 ip4:192.0.2.0/24"},
+		"moved.example.com": {"v=spf1 -all"},
+	}}
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	ch := NewChecker(NewCustomDNSResolver(zone), WithClock(func() time.Time { return now }),
+		WithRecordOverrides(map[string]string{"moved.example.com": "v=spf1 ~all"}))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("203.0.113.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, SoftFail, res.Code)
+	assert.Equal(t, []FetchedRecord{
+		{Domain: "example.com", Text: "v=spf1 include:_spf.example.com redirect=moved.example.com", Time: now},
+		{Domain: "_spf.example.com", Text: "v=spf1 ip4:192.0.2.0/24", Time: now},
+		{Domain: "moved.example.com", Text: "v=spf1 ~all", Override: true, Time: now},
+	}, res.Records)
+
+	res, err = ch.CheckHost(context.Background(), net.ParseIP("203.0.113.1"), "none.example.com", "")
+	require.ErrorIs(t, err, ErrNoDNSrecord)
+	assert.Empty(t, res.Records)
+}
+
+func TestCheckHostResult_RecordsAsPublished(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{"example.com": {"  V=SPF1 IP4:192.0.2.0/24 Exists:%{L}.x.example -All "}}}
+	ch := NewChecker(NewCustomDNSResolver(zone))
+
+	res, err := ch.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	require.Len(t, res.Records, 1)
+	assert.Equal(t, "V=SPF1 IP4:192.0.2.0/24 Exists:%{L}.x.example -All", res.Records[0].Text)
+}
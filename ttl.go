@@ -0,0 +1,39 @@
+package spf
+
+import (
+	"context"
+	"time"
+)
+
+// ReportTTL records the TTL of an answer returned by a resolver.  Resolvers
+// that know the TTLs of their answers, unlike the Go standard library, call
+// it from their lookup methods with the context they were given; for NXDOMAIN
+// and empty answers the negative caching TTL of RFC 2308 applies.  The
+// minimum over an evaluation is reported in CheckHostResult.TTL.  Calls
+// outside an evaluation are ignored.
+func ReportTTL(ctx context.Context, ttl time.Duration) {
+	if rec, ok := ctx.Value(ttlKey{}).(*ttlRecorder); ok {
+		rec.add(ttl)
+	}
+}
+
+// ttlKey is the context key under which an evaluation or the cache
+// middleware collects the TTLs reported by ReportTTL.
+type ttlKey struct{}
+
+func withTTL(ctx context.Context, rec *ttlRecorder) context.Context {
+	return context.WithValue(ctx, ttlKey{}, rec)
+}
+
+// ttlRecorder keeps the minimum of the reported TTLs.
+type ttlRecorder struct {
+	ttl   time.Duration
+	known bool
+}
+
+func (r *ttlRecorder) add(ttl time.Duration) {
+	ttl = max(ttl, 0)
+	if !r.known || ttl < r.ttl {
+		r.ttl, r.known = ttl, true
+	}
+}
@@ -0,0 +1,70 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ttlResolver reports a TTL per name for the answers of zone.
+type ttlResolver struct {
+	*zoneResolver
+	ttl map[string]time.Duration
+}
+
+func (r *ttlResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	if ttl, ok := r.ttl[domain]; ok {
+		ReportTTL(ctx, ttl)
+	}
+
+	return r.zoneResolver.LookupTXT(ctx, domain)
+}
+
+func (r *ttlResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ttl, ok := r.ttl[host]; ok {
+		ReportTTL(ctx, ttl)
+	}
+
+	return r.zoneResolver.LookupIP(ctx, network, host)
+}
+
+func TestChecker_TTL(t *testing.T) {
+	r := &ttlResolver{
+		zoneResolver: &zoneResolver{
+			txt: map[string][]string{
+				"example.com":      {"v=spf1 include:_spf.example.com -all"},
+				"_spf.example.com": {"v=spf1 a:mail.example.com -all"},
+			},
+			ip: map[string][]string{"mail.example.com": {"192.0.2.1"}},
+		},
+		ttl: map[string]time.Duration{
+			"example.com":      time.Hour,
+			"_spf.example.com": 10 * time.Minute,
+			"mail.example.com": 5 * time.Minute,
+		},
+	}
+	ip := net.ParseIP("192.0.2.1")
+
+	res, err := NewChecker(NewCustomDNSResolver(r)).CheckHost(context.Background(), ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Equal(t, 5*time.Minute, res.TTL)
+
+	res, err = NewChecker(NewCustomDNSResolver(r.zoneResolver)).CheckHost(context.Background(), ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Zero(t, res.TTL, "resolver reporting no TTLs")
+
+	// cached answers report their remaining TTL
+	ch := NewChecker(NewCustomDNSResolver(r), WithCache(NewMemoryCache(time.Hour)))
+	_, err = ch.CheckHost(context.Background(), ip, "example.com", "")
+	require.NoError(t, err)
+	res, err = ch.CheckHost(context.Background(), ip, "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.Stats.CacheHits)
+	assert.Greater(t, res.TTL, 4*time.Minute)
+	assert.LessOrEqual(t, res.TTL, 5*time.Minute)
+}
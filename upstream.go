@@ -0,0 +1,456 @@
+package spf
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultUDPSize is the EDNS0 UDP payload size advertised by an
+// UpstreamResolver unless configured otherwise, the size recommended by the
+// DNS flag day 2020 to avoid IP fragmentation.
+const DefaultUDPSize = 1232
+
+// ednsClientSubnet is the EDNS0 option code of RFC 7871.
+const ednsClientSubnet = 8
+
+// errTruncated reports a UDP answer with the TC bit set, retried over TCP.
+var errTruncated = errors.New("truncated answer")
+
+// UpstreamResolver sends queries straight to recursive DNS servers instead of
+// going through the system resolver, which gives control over the EDNS0
+// options of the queries and reports the TTL of every answer with ReportTTL.
+// It implements TXTResolver, IPResolver, MXResolver and PTRResolver.
+//
+// Servers are tried in the order given, or of Family, until one answers;
+// truncated UDP answers are retried over TCP.  Stream connections, whether
+// TCP, TLS or unix, are kept open and carry concurrent queries pipelined, so
+// high query volumes do not pay a handshake per query.  Configure the
+// exported fields before the first lookup.
+type UpstreamResolver struct {
+	// Servers lists the recursive servers as "host:port" or, for local
+	// caching daemons such as unbound or dnsdist in sandboxed deployments, as
+	// "unix:/path/to/socket".  Unix sockets carry DNS with the TCP framing of
+	// RFC 7766.
+	Servers []string
+	// UDPSize is the EDNS0 UDP payload size advertised in queries (RFC 6891),
+	// DefaultUDPSize when zero.  Large flattened records need larger values
+	// to avoid the TCP fallback.
+	UDPSize uint16
+	// ClientSubnet, when valid, is sent as EDNS0 client-subnet option (RFC
+	// 7871) for includes that answer according to the client location.  Only
+	// its masked network is sent; keep the prefix short to protect privacy.
+	ClientSubnet netip.Prefix
+	// Timeout bounds each attempt at one server, DefaultDialTimeout when
+	// zero.
+	Timeout time.Duration
+	// Family orders or restricts the addresses of Servers by address
+	// family, e.g. OnlyIPv6 on hosts without IPv4 connectivity.  Servers
+	// given by name are resolved with the system resolver for every query.
+	Family FamilyPreference
+	// QuarantineAfter is the number of consecutive failures, timeouts or
+	// answers such as SERVFAIL, after which a server is left out of
+	// rotation for QuarantineFor (DefaultQuarantine when zero), so that a
+	// broken server stops costing every lookup a timeout.  Zero disables
+	// quarantine.  See also RunHealthChecks.
+	QuarantineAfter int
+	QuarantineFor   time.Duration
+	// ProbeName is the name whose NS records health probes ask for, the
+	// root when empty.
+	ProbeName string
+	// Transport selects UDP, TCP or DNS over TLS for the queries to the
+	// servers not given as unix sockets.  DNS over TLS servers usually
+	// listen on port 853, which must then be part of Servers.
+	Transport Transport
+	// TLSConfig configures the connections of TransportTLS.  Its ServerName
+	// defaults to the host of the server; nil verifies the server with the
+	// system roots.
+	TLSConfig *tls.Config
+	// MaxConns bounds the persistent connections per server,
+	// DefaultMaxConns when zero.  A further connection is opened only while
+	// all open ones have queries in flight.
+	MaxConns int
+	// NoCookies stops sending DNS cookies (RFC 7873).  Cookies let servers
+	// that support them prove their answers come from the server queried,
+	// not from an off-path spoofer; servers without support ignore them.
+	NoCookies bool
+
+	health  healthTable
+	streams streamPool
+	cookies cookieJar
+	clock   func() time.Time // nil means time.Now
+}
+
+// FamilyPreference selects the address family used to reach upstream
+// servers.
+type FamilyPreference int
+
+const (
+	FamilyAny  FamilyPreference = iota // the order of Servers
+	PreferIPv6                         // IPv6 addresses first
+	PreferIPv4                         // IPv4 addresses first
+	OnlyIPv6                           // IPv6 addresses only
+	OnlyIPv4                           // IPv4 addresses only
+)
+
+// rank returns the position of addr in the order of f, or -1 when f
+// excludes it.
+func (f FamilyPreference) rank(addr netip.Addr) int {
+	v6 := addr.Is6()
+	switch {
+	case f == FamilyAny:
+		return 0
+	case f == OnlyIPv6 && !v6, f == OnlyIPv4 && v6:
+		return -1
+	case f == PreferIPv6 && !v6, f == PreferIPv4 && v6:
+		return 1
+	}
+
+	return 0
+}
+
+// NewUpstreamResolver returns an UpstreamResolver for servers, given as
+// "host:port", as bare addresses using port 53 or as "unix:" followed by the
+// path of a unix stream socket.
+func NewUpstreamResolver(servers ...string) *UpstreamResolver {
+	u := &UpstreamResolver{}
+	for _, s := range servers {
+		if _, ok := unixSocket(s); ok {
+			u.Servers = append(u.Servers, s)
+			continue
+		}
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(strings.Trim(s, "[]"), "53")
+		}
+		u.Servers = append(u.Servers, s)
+	}
+
+	return u
+}
+
+// LookupTXT returns the TXT records of domain, each with its strings joined.
+func (u *UpstreamResolver) LookupTXT(ctx context.Context, domain string) ([]string, error) {
+	return rrLookup(u.lookup).txt(ctx, domain)
+}
+
+// LookupSPFType returns the records of the SPF RR type for domain, each
+// joined from its character-strings like TXT records.
+func (u *UpstreamResolver) LookupSPFType(ctx context.Context, domain string) ([]string, error) {
+	return rrLookup(u.lookup).spfType(ctx, domain)
+}
+
+// LookupIP returns the A ("ip4"), AAAA ("ip6") or both ("ip") records of
+// host.
+func (u *UpstreamResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return rrLookup(u.lookup).ip(ctx, network, host)
+}
+
+// LookupMX returns the MX records of name.  Hosts are fully qualified with a
+// trailing dot, like those of net.Resolver.
+func (u *UpstreamResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return rrLookup(u.lookup).mx(ctx, name)
+}
+
+// LookupAddr returns the PTR names of addr, with a trailing dot.
+func (u *UpstreamResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return rrLookup(u.lookup).addr(ctx, addr)
+}
+
+// lookup returns the answer records of type t for name.  NXDOMAIN and empty
+// answers are reported as not found, like net.Resolver does.
+func (u *UpstreamResolver) lookup(ctx context.Context, name string, t dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+	servers, err := u.servers(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &net.DNSError{Err: err.Error(), Name: name, IsTemporary: true}
+	}
+
+	var lastErr error
+	for _, server := range u.health.available(servers, u.now()) {
+		msg, err := u.exchange(ctx, server, qname, t)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err == nil {
+			err = rcodeError(msg, name, server)
+		}
+		u.recordHealth(server, err)
+		if err != nil {
+			// another server may do better
+			lastErr = err
+			continue
+		}
+
+		return answers(ctx, msg, name, server, t)
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(lastErr, &dnsErr) {
+		return nil, dnsErr
+	}
+
+	return nil, &net.DNSError{Err: lastErr.Error(), Name: name, IsTemporary: true}
+}
+
+// servers returns the "address:port" of the upstream servers in the order
+// of u.Family.
+func (u *UpstreamResolver) servers(ctx context.Context) ([]string, error) {
+	type target struct {
+		addr netip.AddrPort
+		rank int
+		unix string // set for unix sockets, which have no family
+	}
+	var targets []target
+	var lastErr error
+	for _, server := range u.Servers {
+		if _, ok := unixSocket(server); ok {
+			targets = append(targets, target{rank: 0, unix: server})
+			continue
+		}
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		portNum, err := net.LookupPort("udp", port)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addrs := []netip.Addr{}
+		if addr, err := netip.ParseAddr(host); err == nil {
+			addrs = append(addrs, addr)
+		} else if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+			lastErr = err
+			continue
+		}
+		for _, addr := range addrs {
+			addr = addr.Unmap()
+			if rank := u.Family.rank(addr); rank >= 0 {
+				targets = append(targets, target{addr: netip.AddrPortFrom(addr, uint16(portNum)), rank: rank})
+			}
+		}
+	}
+	sort.SliceStable(targets, func(i, j int) bool { return targets[i].rank < targets[j].rank })
+
+	out := make([]string, len(targets))
+	for i, t := range targets {
+		out[i] = t.addr.String()
+		if t.unix != "" {
+			out[i] = t.unix
+		}
+	}
+	switch {
+	case len(out) > 0:
+		return out, nil
+	case lastErr != nil:
+		return nil, lastErr
+	case len(u.Servers) == 0:
+		return nil, errors.New("no upstream servers configured")
+	}
+
+	return nil, errors.New("no upstream server of the configured address family")
+}
+
+// exchange sends one query to server over u.Transport, retrying UDP queries
+// over TCP when the answer is truncated and once with a fresh server cookie
+// when the server rejects the one sent.
+func (u *UpstreamResolver) exchange(ctx context.Context, server string, qname dnsmessage.Name, t dnsmessage.Type) (*dnsmessage.Message, error) {
+	for attempt := 0; ; attempt++ {
+		id := uint16(rand.N(1 << 16))
+		query, err := u.buildQuery(id, qname, t, server)
+		if err != nil {
+			return nil, err
+		}
+		msg, err := u.send(ctx, server, query)
+		if err != nil {
+			return nil, err
+		}
+		if msg.ID != id || len(msg.Questions) != 1 || msg.Questions[0].Type != t || !strings.EqualFold(msg.Questions[0].Name.String(), qname.String()) {
+			return nil, fmt.Errorf("mismatched answer from %s", server)
+		}
+		if u.NoCookies {
+			return msg, nil
+		}
+		badCookie, err := u.cookies.update(server, msg)
+		switch {
+		case err != nil:
+			return nil, err
+		case badCookie && attempt == 0:
+			continue
+		case badCookie:
+			return nil, &net.DNSError{Err: "server misbehaving: BADCOOKIE", Name: qname.String(), Server: server, IsTemporary: true}
+		}
+
+		return msg, nil
+	}
+}
+
+// send sends query to server over u.Transport and reads the answer.
+func (u *UpstreamResolver) send(ctx context.Context, server string, query []byte) (*dnsmessage.Message, error) {
+	timeout := u.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	if path, ok := unixSocket(server); ok {
+		return u.roundTrip(ctx, "unix", path, query, timeout)
+	}
+	switch u.Transport {
+	case TransportTCP:
+		return u.roundTrip(ctx, "tcp", server, query, timeout)
+	case TransportTLS:
+		return u.roundTrip(ctx, "tls", server, query, timeout)
+	}
+	msg, err := u.roundTrip(ctx, "udp", server, query, timeout)
+	if errors.Is(err, errTruncated) {
+		return u.roundTrip(ctx, "tcp", server, query, timeout)
+	}
+
+	return msg, err
+}
+
+// buildQuery packs a recursive query to server with an EDNS0 OPT record.
+func (u *UpstreamResolver) buildQuery(id uint16, qname dnsmessage.Name, t dnsmessage.Type, server string) ([]byte, error) {
+	size := u.UDPSize
+	if size == 0 {
+		size = DefaultUDPSize
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(int(size), dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	var options []dnsmessage.Option
+	if u.ClientSubnet.IsValid() {
+		options = append(options, clientSubnetOption(u.ClientSubnet))
+	}
+	if !u.NoCookies {
+		options = append(options, u.cookies.option(server))
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: t, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{Options: options}); err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+// clientSubnetOption encodes p as the EDNS0 client-subnet option of RFC 7871
+// section 6, with the address truncated to the bytes covered by the prefix.
+func clientSubnetOption(p netip.Prefix) dnsmessage.Option {
+	p = p.Masked()
+	family, addr := uint16(2), p.Addr().AsSlice()
+	if p.Addr().Is4() {
+		family = 1
+	}
+	data := binary.BigEndian.AppendUint16(nil, family)
+	data = append(data, byte(p.Bits()), 0)
+	data = append(data, addr[:(p.Bits()+7)/8]...)
+
+	return dnsmessage.Option{Code: ednsClientSubnet, Data: data}
+}
+
+// roundTrip sends query to server over network, "udp", "tcp", "tls" or
+// "unix", and reads the answer.
+func (u *UpstreamResolver) roundTrip(ctx context.Context, network, server string, query []byte, timeout time.Duration) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if network != "udp" {
+		return u.streamRoundTrip(ctx, network, server, query)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := setDeadline(ctx, conn); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	// like stub resolvers, skip datagrams that do not answer the query,
+	// late or spoofed, until the deadline
+	answer := make([]byte, 65535)
+	for {
+		n, err := conn.Read(answer)
+		if err != nil {
+			return nil, err
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(answer[:n]); err != nil || !answersQuery(&msg, query) {
+			continue
+		}
+		if msg.Truncated {
+			return nil, errTruncated
+		}
+
+		return &msg, nil
+	}
+}
+
+// answersQuery reports whether msg is a response with the ID and question of
+// query.
+func answersQuery(msg *dnsmessage.Message, query []byte) bool {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return false
+	}
+	q, err := p.Question()
+	if err != nil {
+		return false
+	}
+
+	return msg.Response && msg.ID == h.ID && len(msg.Questions) == 1 && msg.Questions[0].Type == q.Type &&
+		msg.Questions[0].Class == q.Class && strings.EqualFold(msg.Questions[0].Name.String(), q.Name.String())
+}
+
+func setDeadline(ctx context.Context, conn net.Conn) error {
+	deadline, _ := ctx.Deadline()
+
+	return conn.SetDeadline(deadline)
+}
+
+// Close closes the persistent connections of u.  A Checker closes its
+// resolver in Checker.Close.
+func (u *UpstreamResolver) Close() error {
+	u.streams.closeAll()
+
+	return nil
+}
+
+// isNotFound reports whether err is a not found DNS error.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
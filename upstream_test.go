@@ -0,0 +1,411 @@
+package spf
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsServer answers DNS queries over UDP and TCP on one local port.
+type dnsServer struct {
+	addr   string
+	answer func(q dnsmessage.Message, tcp bool) dnsmessage.Message
+
+	mu       sync.Mutex
+	queries  []dnsmessage.Message
+	accepted []net.Conn // TCP connections
+}
+
+func newDNSServer(t *testing.T, answer func(q dnsmessage.Message, tcp bool) dnsmessage.Message) *dnsServer {
+	t.Helper()
+	s := &dnsServer{answer: answer}
+	var pc net.PacketConn
+	var ln net.Listener
+	for range 10 {
+		var err error
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		if ln, err = net.Listen("tcp", pc.LocalAddr().String()); err == nil {
+			break
+		}
+		pc.Close()
+	}
+	require.NotNil(t, ln)
+	t.Cleanup(func() {
+		pc.Close()
+		ln.Close()
+		s.dropTCP()
+	})
+	s.addr = pc.LocalAddr().String()
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if out := s.handle(buf[:n], false); out != nil {
+				_, _ = pc.WriteTo(out, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.accepted = append(s.accepted, conn)
+			s.mu.Unlock()
+			go s.serveTCP(conn)
+		}
+	}()
+
+	return s
+}
+
+// serveTCP answers the queries sent on conn until it is closed.  Queries
+// are answered concurrently, in the order their answers become ready.
+func (s *dnsServer) serveTCP(conn net.Conn) {
+	defer conn.Close()
+	var wmu sync.Mutex
+	for {
+		var n [2]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(n[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		go func() {
+			out := s.handle(query, true)
+			wmu.Lock()
+			defer wmu.Unlock()
+			_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(out))), out...))
+		}()
+	}
+}
+
+func (s *dnsServer) handle(query []byte, tcp bool) []byte {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	s.queries = append(s.queries, q)
+	s.mu.Unlock()
+	resp := s.answer(q, tcp)
+	resp.ID, resp.Response, resp.Questions = q.ID, true, q.Questions
+	out, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+
+	return out
+}
+
+// dropTCP closes the accepted TCP connections, as servers do with idle
+// ones, and returns their number.
+func (s *dnsServer) dropTCP() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.accepted {
+		conn.Close()
+	}
+
+	return len(s.accepted)
+}
+
+func (s *dnsServer) received() []dnsmessage.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]dnsmessage.Message(nil), s.queries...)
+}
+
+func txtAnswer(q dnsmessage.Message, ttl uint32, txt ...string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   &dnsmessage.TXTResource{TXT: txt},
+	}
+}
+
+func TestUpstreamResolver_Lookups(t *testing.T) {
+	srv := newDNSServer(t, func(q dnsmessage.Message, _ bool) dnsmessage.Message {
+		name := q.Questions[0].Name
+		hdr := dnsmessage.ResourceHeader{Name: name, Type: q.Questions[0].Type, Class: dnsmessage.ClassINET, TTL: 300}
+		switch {
+		case q.Questions[0].Type == dnsmessage.TypeTXT && name.String() == "example.com.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 600, "v=spf1 ", "mx -all"), txtAnswer(q, 300, "other")}}
+		case q.Questions[0].Type == typeSPF && name.String() == "example.com.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.UnknownResource{Type: typeSPF, Data: []byte("\x07v=spf1 \x04-all")}}}}
+		case q.Questions[0].Type == dnsmessage.TypeA && name.String() == "mail.example.com.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}}}}
+		case q.Questions[0].Type == dnsmessage.TypeMX && name.String() == "example.com.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mail.example.com.")}}}}
+		case q.Questions[0].Type == dnsmessage.TypePTR && name.String() == "1.2.0.192.in-addr.arpa.":
+			return dnsmessage.Message{Answers: []dnsmessage.Resource{{Header: hdr, Body: &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("mail.example.com.")}}}}
+		case strings.HasPrefix(name.String(), "missing."):
+			soa := dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+				Body:   &dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns.example.com."), MBox: dnsmessage.MustNewName("hostmaster.example.com."), MinTTL: 60},
+			}
+			return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError}, Authorities: []dnsmessage.Resource{soa}}
+		}
+		return dnsmessage.Message{}
+	})
+	u := NewUpstreamResolver(srv.addr)
+	ctx := context.Background()
+
+	txts, err := u.LookupTXT(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=spf1 mx -all", "other"}, txts)
+
+	ips, err := u.LookupIP(ctx, "ip4", "mail.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("192.0.2.1").To4()}, ips)
+	_, err = u.LookupIP(ctx, "ip6", "mail.example.com")
+	assert.True(t, isNotFound(err), "empty answer is not found")
+
+	mxs, err := u.LookupMX(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []*net.MX{{Host: "mail.example.com.", Pref: 10}}, mxs)
+
+	names, err := u.LookupAddr(ctx, "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mail.example.com."}, names)
+
+	_, err = u.LookupTXT(ctx, "missing.example.com")
+	assert.True(t, isNotFound(err))
+
+	records, err := u.LookupSPFType(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=spf1 -all"}, records)
+	_, err = u.LookupSPFType(ctx, "mail.example.com")
+	assert.True(t, isNotFound(err))
+
+	// answers report their TTLs to evaluations
+	ch := NewChecker(u)
+	res, err := ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "example.com", "")
+	require.NoError(t, err)
+	assert.Equal(t, Pass, res.Code)
+	assert.Equal(t, 300*time.Second, res.TTL)
+	res, _ = ch.CheckHost(ctx, net.ParseIP("192.0.2.1"), "missing.example.com", "")
+	assert.Equal(t, time.Minute, res.TTL, "negative TTL from the SOA")
+}
+
+func TestUpstreamResolver_EDNS0(t *testing.T) {
+	srv := newDNSServer(t, func(q dnsmessage.Message, tcp bool) dnsmessage.Message {
+		if !tcp {
+			return dnsmessage.Message{Header: dnsmessage.Header{Truncated: true}}
+		}
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, strings.Repeat("x", 255), strings.Repeat("y", 255))}}
+	})
+	u := NewUpstreamResolver(srv.addr)
+	u.UDPSize = 4096
+	u.ClientSubnet = netip.MustParsePrefix("198.51.100.77/24")
+
+	txts, err := u.LookupTXT(context.Background(), "big.example.com")
+	require.NoError(t, err)
+	require.Len(t, txts, 1)
+	assert.Len(t, txts[0], 510)
+
+	queries := srv.received()
+	require.Len(t, queries, 2, "UDP then TCP")
+	for _, q := range queries {
+		require.Len(t, q.Additionals, 1)
+		opt := q.Additionals[0]
+		assert.Equal(t, dnsmessage.TypeOPT, opt.Header.Type)
+		assert.Equal(t, dnsmessage.Class(4096), opt.Header.Class, "UDP payload size")
+		options := opt.Body.(*dnsmessage.OPTResource).Options
+		require.Len(t, options, 2, "client subnet and cookie")
+		assert.Equal(t, dnsmessage.Option{Code: 8, Data: []byte{0, 1, 24, 0, 198, 51, 100}}, options[0])
+	}
+	assert.Equal(t, []byte{0, 2, 44, 0, 0x20, 0x01, 0x0d, 0xb8, 0x12, 0x30}, clientSubnetOption(netip.MustParsePrefix("2001:db8:1234::/44")).Data)
+}
+
+func TestUpstreamResolver_Failover(t *testing.T) {
+	broken := newDNSServer(t, func(dnsmessage.Message, bool) dnsmessage.Message {
+		return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}}
+	})
+	good := newDNSServer(t, func(q dnsmessage.Message, _ bool) dnsmessage.Message {
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 -all")}}
+	})
+
+	txts, err := NewUpstreamResolver(broken.addr, good.addr).LookupTXT(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=spf1 -all"}, txts)
+
+	_, err = NewUpstreamResolver(broken.addr).LookupTXT(context.Background(), "example.com")
+	require.Error(t, err)
+	assert.ErrorIs(t, classifyDNSError(err), ErrTempfail)
+}
+
+func TestUpstreamResolver_IgnoresMismatchedDatagrams(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if err := q.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			// a spoofed ID, another question and garbage precede the answer
+			other := q.Questions[0]
+			other.Name = dnsmessage.MustNewName("other.example.com.")
+			for _, resp := range []dnsmessage.Message{
+				{Header: dnsmessage.Header{ID: q.ID + 1, Response: true}, Questions: q.Questions, Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 +all")}},
+				{Header: dnsmessage.Header{ID: q.ID, Response: true}, Questions: []dnsmessage.Question{other}},
+				{Header: dnsmessage.Header{ID: q.ID, Response: true}, Questions: q.Questions, Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 -all")}},
+			} {
+				out, err := resp.Pack()
+				if err != nil {
+					return
+				}
+				_, _ = pc.WriteTo(out, from)
+				if resp.Questions[0] == other {
+					_, _ = pc.WriteTo([]byte{0xde, 0xad}, from)
+				}
+			}
+		}
+	}()
+
+	u := NewUpstreamResolver(pc.LocalAddr().String())
+	u.NoCookies = true
+	txts, err := u.LookupTXT(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=spf1 -all"}, txts)
+}
+
+func TestReverseName(t *testing.T) {
+	assert.Equal(t, "1.2.0.192.in-addr.arpa.", reverseName(netip.MustParseAddr("::ffff:192.0.2.1")))
+	assert.Equal(t, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", reverseName(netip.MustParseAddr("2001:db8::1")))
+}
+
+func TestUpstreamResolver_Family(t *testing.T) {
+	u := NewUpstreamResolver("192.0.2.53", "2001:db8::53", "[2001:db8::54]:5353", "[::ffff:198.51.100.53]:53")
+	assert.Equal(t, []string{"192.0.2.53:53", "[2001:db8::53]:53", "[2001:db8::54]:5353", "[::ffff:198.51.100.53]:53"}, u.Servers)
+
+	tests := []struct {
+		family FamilyPreference
+		want   []string
+	}{
+		{FamilyAny, []string{"192.0.2.53:53", "[2001:db8::53]:53", "[2001:db8::54]:5353", "198.51.100.53:53"}},
+		{PreferIPv6, []string{"[2001:db8::53]:53", "[2001:db8::54]:5353", "192.0.2.53:53", "198.51.100.53:53"}},
+		{PreferIPv4, []string{"192.0.2.53:53", "198.51.100.53:53", "[2001:db8::53]:53", "[2001:db8::54]:5353"}},
+		{OnlyIPv6, []string{"[2001:db8::53]:53", "[2001:db8::54]:5353"}},
+		{OnlyIPv4, []string{"192.0.2.53:53", "198.51.100.53:53"}},
+	}
+	for _, tt := range tests {
+		u.Family = tt.family
+		got, err := u.servers(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, "family %d", tt.family)
+	}
+
+	// an IPv6-only host never tries the IPv4 server
+	srv := newDNSServer(t, func(q dnsmessage.Message, _ bool) dnsmessage.Message {
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 -all")}}
+	})
+	u = NewUpstreamResolver(srv.addr)
+	u.Family = OnlyIPv6
+	_, err := u.LookupTXT(context.Background(), "example.com")
+	require.ErrorContains(t, err, "no upstream server of the configured address family")
+	assert.Empty(t, srv.received())
+}
+
+func TestUpstreamResolver_TCPReuse(t *testing.T) {
+	srv := newDNSServer(t, func(q dnsmessage.Message, tcp bool) dnsmessage.Message {
+		if !tcp {
+			return dnsmessage.Message{Header: dnsmessage.Header{Truncated: true}}
+		}
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 ", strings.Repeat("ip4:192.0.2.1 ", 18), strings.Repeat("ip4:192.0.2.1 ", 18), strings.Repeat("ip4:192.0.2.1 ", 18))}}
+	})
+	u := NewUpstreamResolver(srv.addr)
+	ctx := context.Background()
+
+	for range 3 {
+		txts, err := u.LookupTXT(ctx, "big.example.com")
+		require.NoError(t, err)
+		assert.Len(t, txts[0], 7+3*252)
+	}
+	assert.Equal(t, 1, srv.dropTCP(), "one connection for all TCP retries")
+
+	// a connection closed by the server is replaced
+	_, err := u.LookupTXT(ctx, "big.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2, srv.dropTCP())
+
+	require.NoError(t, u.Close())
+	_, err = u.LookupTXT(ctx, "big.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, 3, srv.dropTCP())
+}
+
+// listenUnix makes s also answer on a unix stream socket and returns its
+// path.
+func (s *dnsServer) listenUnix(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "spf")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "dns.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+		os.RemoveAll(dir)
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveTCP(conn)
+		}
+	}()
+
+	return path
+}
+
+func TestUnixSocketServers(t *testing.T) {
+	srv := newDNSServer(t, func(q dnsmessage.Message, tcp bool) dnsmessage.Message {
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{txtAnswer(q, 60, "v=spf1 -all")}}
+	})
+	path := srv.listenUnix(t)
+	ctx := context.Background()
+
+	u := NewUpstreamResolver("unix:" + path)
+	u.Family = OnlyIPv6
+	txts, err := u.LookupTXT(ctx, "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=spf1 -all"}, txts)
+
+	for _, server := range []string{"unix:" + path, srv.addr} {
+		txts, err = NewDNSResolverAt(server).LookupTXT(ctx, "example.com")
+		require.NoError(t, err, server)
+		assert.Equal(t, []string{"v=spf1 -all"}, txts, server)
+	}
+	assert.Len(t, srv.received(), 3)
+}
@@ -0,0 +1,115 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mailspire/spf/parser"
+)
+
+// SkipTargets is returned by a WalkFunc to leave the include and redirect
+// targets of the record it was called for unvisited, unless they are reached
+// through another record.
+var SkipTargets = errors.New("skip the targets of this record")
+
+// ErrWalkLimit is reported in Node.Err for records that a Walker did not
+// fetch because the walk reached its MaxDepth or MaxNodes.
+var ErrWalkLimit = errors.New("walk limit reached")
+
+// Default limits of a Walker.  An evaluation performs at most MaxDNSLookups
+// lookups, so a larger tree is never evaluated in full; the margin keeps
+// broken policies reportable.
+const (
+	DefaultWalkMaxDepth = 2 * MaxDNSLookups
+	DefaultWalkMaxNodes = 10 * MaxDNSLookups
+)
+
+// WalkFunc is called by Walker.Walk for each record of the tree, with depth
+// the number of include and redirect hops from the root.  The record, its
+// references and any lookup, selection or parse error are in node.
+type WalkFunc func(node *Node, depth int) error
+
+// Walker traverses the tree of SPF records reachable from a domain through
+// include mechanisms and redirect modifiers, without evaluating any client
+// address.  It is the traversal under BuildGraph.
+type Walker struct {
+	Resolver TXTResolver
+	// Follow selects the references followed; nil follows every include
+	// and effective redirect whose target contains no macros.
+	Follow func(from *Node, edge Edge) bool
+	// MaxDepth and MaxNodes bound the walk of zones that generate names on
+	// the fly: records more than MaxDepth hops from the root, or reached
+	// after MaxNodes records were fetched, are passed to fn unfetched, with
+	// an error matching ErrWalkLimit.  Zero means DefaultWalkMaxDepth and
+	// DefaultWalkMaxNodes.
+	MaxDepth int
+	MaxNodes int
+}
+
+// NewWalker returns a Walker following every reference, using r.
+func NewWalker(r TXTResolver) *Walker {
+	return &Walker{Resolver: r}
+}
+
+// Walk visits the record of domain and the records it references, breadth
+// first, calling fn once per domain: a domain reached again, e.g. through an
+// include loop, is not revisited, so depth is its shortest distance from
+// domain.  Walk stops at the first error of fn other than SkipTargets and
+// returns it.  Per-record problems, including the limits of w, are passed
+// to fn in Node.Err; only an invalid domain or a context error is returned
+// otherwise.
+func (w *Walker) Walk(ctx context.Context, domain string, fn WalkFunc) error {
+	root, err := parser.ValidateDomainSpec(domain)
+	if err != nil {
+		return err
+	}
+
+	type visit struct {
+		domain string
+		depth  int
+	}
+	maxDepth, maxNodes := w.MaxDepth, w.MaxNodes
+	if maxDepth <= 0 {
+		maxDepth = DefaultWalkMaxDepth
+	}
+	if maxNodes <= 0 {
+		maxNodes = DefaultWalkMaxNodes
+	}
+
+	seen := map[string]bool{root: true}
+	fetched := 0
+	queue := []visit{{root, 0}}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+
+		var node *Node
+		switch {
+		case v.depth > maxDepth:
+			node = &Node{Domain: v.domain, Err: fmt.Errorf("%w: more than %d hops", ErrWalkLimit, maxDepth)}
+		case fetched >= maxNodes:
+			node = &Node{Domain: v.domain, Err: fmt.Errorf("%w: more than %d records", ErrWalkLimit, maxNodes)}
+		default:
+			fetched++
+			if node, err = buildNode(ctx, w.Resolver, v.domain); err != nil {
+				return err
+			}
+		}
+		switch err := fn(node, v.depth); {
+		case errors.Is(err, SkipTargets):
+			continue
+		case err != nil:
+			return err
+		}
+		for _, edge := range node.Edges {
+			if edge.Macro || seen[edge.Target] || w.Follow != nil && !w.Follow(node, edge) {
+				continue
+			}
+			seen[edge.Target] = true
+			queue = append(queue, visit{edge.Target, v.depth + 1})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalker(t *testing.T) {
+	zone := &zoneResolver{txt: map[string][]string{
+		"example.com":         {"v=spf1 include:_spf.example.com include:vendor.example redirect=policy.example -all"},
+		"_spf.example.com":    {"v=spf1 include:example.com include:%{i}.macro.example ip4:192.0.2.0/24 ~all"},
+		"vendor.example":      {"v=spf1 redirect=_spf.vendor.example"},
+		"_spf.vendor.example": {"v=spf1 include:gone.example -all"},
+		"policy.example":      {"v=spf1 -all"},
+	}}
+	type visit struct {
+		domain string
+		depth  int
+		err    error
+	}
+	walk := func(w *Walker, fn func(*Node, int) error) ([]visit, error) {
+		var visits []visit
+		err := w.Walk(context.Background(), "Example.com.", func(node *Node, depth int) error {
+			visits = append(visits, visit{node.Domain, depth, node.Err})
+			if fn != nil {
+				return fn(node, depth)
+			}
+			return nil
+		})
+		return visits, err
+	}
+
+	visits, err := walk(NewWalker(zone), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []visit{
+		{"example.com", 0, nil},
+		{"_spf.example.com", 1, nil},
+		{"vendor.example", 1, nil},
+		{"_spf.vendor.example", 2, nil},
+		{"gone.example", 3, ErrNoDNSrecord},
+	}, visits, "the loop back to example.com is not followed, nor the redirect next to -all")
+
+	visits, err = walk(NewWalker(zone), func(node *Node, _ int) error {
+		if node.Domain == "vendor.example" {
+			return SkipTargets
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, visits, 3)
+
+	stop := errors.New("stop")
+	visits, err = walk(NewWalker(zone), func(*Node, int) error { return stop })
+	require.ErrorIs(t, err, stop)
+	assert.Len(t, visits, 1)
+
+	w := &Walker{Resolver: zone, Follow: func(_ *Node, edge Edge) bool { return edge.Kind == "redirect" }}
+	visits, err = walk(w, nil)
+	require.NoError(t, err)
+	assert.Len(t, visits, 1)
+
+	require.Error(t, NewWalker(zone).Walk(context.Background(), "bad..example", func(*Node, int) error { return nil }))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, NewWalker(zone).Walk(ctx, "example.com", func(*Node, int) error { return nil }), context.Canceled)
+}
+
+// generatingResolver publishes a record for every name, including two new
+// names below it.
+type generatingResolver struct{ queries int }
+
+func (g *generatingResolver) LookupTXT(_ context.Context, domain string) ([]string, error) {
+	g.queries++
+	return []string{"v=spf1 include:a." + domain + " include:b." + domain + " -all"}, nil
+}
+
+func TestWalker_Limits(t *testing.T) {
+	r := &generatingResolver{}
+	g, err := BuildGraph(context.Background(), r, "evil.example")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultWalkMaxNodes, r.queries)
+	cut := 0
+	for _, n := range g.Nodes {
+		if errors.Is(n.Err, ErrWalkLimit) {
+			cut++
+		}
+	}
+	assert.Equal(t, DefaultWalkMaxNodes+1, cut, "the targets of the last fetched records")
+
+	r = &generatingResolver{}
+	w := &Walker{Resolver: r, MaxDepth: 2, MaxNodes: 100}
+	var depths []int
+	err = w.Walk(context.Background(), "evil.example", func(node *Node, depth int) error {
+		if depth == 3 {
+			assert.ErrorIs(t, node.Err, ErrWalkLimit)
+			assert.EqualError(t, node.Err, "walk limit reached: more than 2 hops")
+		}
+		depths = append(depths, depth)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 7, r.queries, "depths 0 to 2")
+	assert.Len(t, depths, 15)
+}
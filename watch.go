@@ -0,0 +1,167 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WatchEvent reports that the resolved policy of a watched domain changed,
+// raised alerts or could not be resolved.
+type WatchEvent struct {
+	Domain  string
+	Time    time.Time
+	Graph   *Graph   // the new snapshot, nil when Err is set
+	Changes []Change // differences to the previous snapshot
+	Alerts  []Alert  // alerts raised for this snapshot
+	Err     error
+}
+
+// Watcher periodically rebuilds the Graph of a set of domains and reports
+// changes anywhere in their include and redirect trees, e.g. when a vendor
+// alters an included record.  The first poll of a domain records a baseline
+// and only reports alerts.  A Watcher is not safe for concurrent use.
+type Watcher struct {
+	Resolver TXTResolver
+	Domains  []string
+	Interval time.Duration
+	// Clock stamps events; nil means time.Now.
+	Clock func() time.Time
+	// Rules are checked against every snapshot, including the first one,
+	// and new findings are sent to Sinks.
+	Rules []AlertRule
+	Sinks []AlertSink
+	// Store, when set, receives a snapshot whenever a domain's policy or
+	// its alert findings are first seen or change, and provides the
+	// baseline of both after a restart.
+	Store SnapshotStore
+
+	last     map[string]*Graph
+	findings map[string]map[AlertFinding]bool // rule findings per domain
+}
+
+// NewWatcher returns a Watcher polling domains through r every interval.
+func NewWatcher(r TXTResolver, interval time.Duration, domains ...string) *Watcher {
+	return &Watcher{
+		Resolver: r,
+		Domains:  domains,
+		Interval: interval,
+		last:     make(map[string]*Graph),
+	}
+}
+
+// Poll rebuilds every domain once and returns the resulting events.  It stops
+// early and returns the context error when ctx is done.
+func (w *Watcher) Poll(ctx context.Context) ([]WatchEvent, error) {
+	if w.last == nil {
+		w.last = make(map[string]*Graph)
+	}
+
+	var events []WatchEvent
+	for _, domain := range w.Domains {
+		g, err := BuildGraph(ctx, w.Resolver, domain)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return events, ctxErr
+		}
+		if err != nil {
+			events = append(events, WatchEvent{Domain: domain, Time: w.now(), Err: err})
+			continue
+		}
+
+		ev := WatchEvent{Domain: domain, Time: w.now(), Graph: g}
+		prev, err := w.previous(ctx, domain)
+		if prev != nil {
+			ev.Changes = DiffGraphs(prev, g)
+		}
+		w.last[domain] = g
+		var alertErr, saveErr error
+		var findingsChanged bool
+		ev.Alerts, findingsChanged, alertErr = w.alert(ctx, domain, g)
+		if w.Store != nil && (prev == nil || len(ev.Changes) > 0 || findingsChanged) {
+			s := NewSnapshot(g, ev.Time)
+			s.Findings = w.storedFindings(domain)
+			saveErr = w.Store.Save(ctx, s)
+		}
+		ev.Err = errors.Join(err, alertErr, saveErr)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return events, ctxErr
+		}
+		if len(ev.Changes) > 0 || len(ev.Alerts) > 0 || ev.Err != nil {
+			events = append(events, ev)
+		}
+	}
+
+	return events, nil
+}
+
+// previous returns the last snapshot of domain, from memory or from Store,
+// restoring the alert findings stored with it.
+func (w *Watcher) previous(ctx context.Context, domain string) (*Graph, error) {
+	if g, ok := w.last[domain]; ok {
+		return g, nil
+	}
+	if w.Store == nil {
+		return nil, nil
+	}
+	s, err := w.Store.At(ctx, domain, w.now())
+	switch {
+	case errors.Is(err, ErrNoSnapshot):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("snapshot store: %w", err)
+	}
+	if _, ok := w.findings[domain]; !ok {
+		w.restoreFindings(domain, s.Findings)
+	}
+
+	return s.Graph(), nil
+}
+
+// Run polls immediately and then every Interval, passing each event to fn,
+// until ctx is done.  It returns the context error.
+func (w *Watcher) Run(ctx context.Context, fn func(WatchEvent)) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		events, err := w.Poll(ctx)
+		for _, ev := range events {
+			fn(ev)
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Events runs the Watcher in a new goroutine and delivers its events on the
+// returned channel, which is closed once ctx is done.
+func (w *Watcher) Events(ctx context.Context) <-chan WatchEvent {
+	ch := make(chan WatchEvent)
+	go func() {
+		defer close(ch)
+		_ = w.Run(ctx, func(ev WatchEvent) {
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+			}
+		})
+	}()
+
+	return ch
+}
+
+func (w *Watcher) now() time.Time {
+	if w.Clock != nil {
+		return w.Clock()
+	}
+
+	return time.Now()
+}
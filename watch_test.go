@@ -0,0 +1,60 @@
+package spf
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_Poll(t *testing.T) {
+	ctx := context.Background()
+	zone := graphZone()
+	stamp := time.Unix(1700000000, 0)
+	w := NewWatcher(zone, time.Hour, "example.com", "bad..example")
+	w.Clock = func() time.Time { return stamp }
+
+	events, err := w.Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1, "baseline only reports errors")
+	assert.Equal(t, "bad..example", events[0].Domain)
+	require.Error(t, events[0].Err)
+
+	w.Domains = w.Domains[:1]
+	events, err = w.Poll(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// the vendor silently widens its range
+	zone.txt["vendor.example.net"] = []string{"v=spf1 exists:%{i}.x.example.net ip4:0.0.0.0/1 include:example.com -all"}
+	events, err = w.Poll(ctx)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, stamp, events[0].Time)
+	assert.Equal(t, []Change{{Kind: Added, Domain: "vendor.example.net", Term: "ip4:0.0.0.0/1"}}, events[0].Changes)
+	require.NotNil(t, events[0].Graph)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = w.Poll(cancelled)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestWatcher_Events(t *testing.T) {
+	zone := graphZone()
+	w := NewWatcher(zone, time.Millisecond, "example.com")
+	_, err := w.Poll(context.Background())
+	require.NoError(t, err)
+	zone.txt["_spf2.example.com"] = []string{"v=spf1 include:vendor.example.net -all"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := w.Events(ctx)
+	ev := <-events
+	assert.Equal(t, []Change{{Kind: QualifierChanged, Domain: "_spf2.example.com", Term: "all", Old: '?', New: '-'}}, ev.Changes)
+
+	cancel()
+	for range events {
+	}
+}
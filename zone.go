@@ -0,0 +1,69 @@
+package spf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxTXTString is the size limit of one character-string in a TXT record
+// (RFC 1035 section 3.3).
+const maxTXTString = 255
+
+// TXTStrings splits record into the character-strings of one TXT record, each
+// at most 255 octets long.  Receivers concatenate them without separator
+// (RFC 7208 section 3.3).
+func TXTStrings(record string) []string {
+	if record == "" {
+		return []string{""}
+	}
+	var out []string
+	for len(record) > maxTXTString {
+		out = append(out, record[:maxTXTString])
+		record = record[maxTXTString:]
+	}
+
+	return append(out, record)
+}
+
+// ZoneLine returns the BIND zone file line publishing record as the TXT
+// record of name, e.g.
+//
+//	example.com. 3600 IN TXT "v=spf1 ip4:192.0.2.0/24 -all"
+//
+// Records longer than 255 octets are split into several quoted strings.
+// name is written fully qualified; a ttl of 0 omits the TTL so that the
+// zone's $TTL applies.
+func ZoneLine(name string, ttl uint32, record string) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(name, ".") + ".")
+	if ttl > 0 {
+		fmt.Fprintf(&b, " %d", ttl)
+	}
+	b.WriteString(" IN TXT")
+	for _, s := range TXTStrings(record) {
+		b.WriteString(" " + zoneQuote(s))
+	}
+
+	return b.String()
+}
+
+// zoneQuote quotes s as a zone file character-string, escaping quotes and
+// backslashes and writing other bytes outside printable ASCII as \DDD.
+func zoneQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
@@ -0,0 +1,37 @@
+package spf
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTXTStrings(t *testing.T) {
+	assert.Equal(t, []string{""}, TXTStrings(""))
+	assert.Equal(t, []string{"v=spf1 -all"}, TXTStrings("v=spf1 -all"))
+
+	long := strings.Repeat("a", 600)
+	parts := TXTStrings(long)
+	assert.Len(t, parts, 3)
+	assert.Len(t, parts[0], 255)
+	assert.Len(t, parts[2], 90)
+	assert.Equal(t, long, strings.Join(parts, ""))
+}
+
+func TestZoneLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    uint32
+		record string
+		want   string
+	}{
+		{"example.com", 3600, "v=spf1 -all", `example.com. 3600 IN TXT "v=spf1 -all"`},
+		{"_spf.example.com.", 0, "v=spf1 -all", `_spf.example.com. IN TXT "v=spf1 -all"`},
+		{"example.com", 300, `say "hi" \ bye` + "\x7f", `example.com. 300 IN TXT "say \"hi\" \\ bye\127"`},
+		{"example.com", 300, strings.Repeat("x", 256), `example.com. 300 IN TXT "` + strings.Repeat("x", 255) + `" "x"`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, ZoneLine(tt.name, tt.ttl, tt.record))
+	}
+}